@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bundeck/internal/api"
+	"bundeck/internal/bundle"
+	"bundeck/internal/db"
+	"bundeck/internal/plugin"
+	"bundeck/internal/templates"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// command is a subcommand of the bundeck binary. Commands other than serve
+// work on plugins.db in the data directory directly, run talks to a running
+// instance with --url.
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func(args []string, stdout io.Writer) error
+}
+
+var commands []command
+
+func init() {
+	// Assigned in init because help refers back to commands
+	commands = []command{
+		{"serve", "[--headless] [--pid-file file]", "start the server, the default without a command", nil},
+		{"list", "[--json]", "list the plugins", listCommand},
+		{"run", "[--input json] [--preset name] [--url url] <id|name>", "run a plugin and print its output", runPluginCommand},
+		{"export", "[--format json|zip] [--plugins ids] [--pages ids] [-o file]", "export the deck or selected plugins and pages", exportCommand},
+		{"import", "[--mode merge|replace] [--on-conflict skip|rename] [--dry-run] <file>", "import a bundle", importCommand},
+		{"create", "--from-template <id> [--var name=value]... [--page id]", "create a plugin from a template", createCommand},
+		{"config", "", "print the effective configuration as JSON", configCommand},
+		{"migrate", "", "apply pending database migrations", migrateCommand},
+		{"db", "backup <file>", "write a consistent copy of the database", dbCommand},
+		{"help", "", "show this help", helpCommand},
+	}
+}
+
+// usageError is returned for invalid arguments, which exit with status 2
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+// exitError carries the exit code of a failed plugin run
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// runCommand runs the named command with its arguments
+func runCommand(args []string, stdout io.Writer) error {
+	for _, cmd := range commands {
+		if cmd.name == args[0] && cmd.run != nil {
+			return cmd.run(args[1:], stdout)
+		}
+	}
+	return &usageError{fmt.Sprintf("unknown command %q, see bundeck help", args[0])}
+}
+
+// exitCode reports a command error on stderr and returns the exit status
+func exitCode(err error) int {
+	// The flag set already printed the usage of the command
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "bundeck: %v\n", err)
+	var usage *usageError
+	var failed *exitError
+	switch {
+	case errors.As(err, &usage):
+		return 2
+	case errors.As(err, &failed) && failed.code > 0:
+		return failed.code
+	}
+	return 1
+}
+
+// usage prints the commands and the flags shared by all of them
+func usage() {
+	helpCommand(nil, flag.CommandLine.Output())
+}
+
+func helpCommand(args []string, stdout io.Writer) error {
+	fmt.Fprintf(stdout, "Usage: bundeck [flags] [command] [command flags]\n\nCommands:\n")
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.usage)
+	}
+	w.Flush()
+	fmt.Fprintf(stdout, "\nFlags, before the command or after serve:\n")
+	flag.CommandLine.SetOutput(stdout)
+	flag.PrintDefaults()
+	return nil
+}
+
+// newFlagSet returns the flag set of a command, which reports errors
+// instead of exiting
+func newFlagSet(name string, stdout io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet("bundeck "+name, flag.ContinueOnError)
+	flags.SetOutput(stdout)
+	return flags
+}
+
+// parseFlags parses the flags of a command and checks the number of
+// positional arguments
+func parseFlags(flags *flag.FlagSet, args []string, positional int) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{err.Error()}
+	}
+	if flags.NArg() != positional {
+		return &usageError{fmt.Sprintf("%s takes %d argument(s), got %d", flags.Name(), positional, flags.NArg())}
+	}
+	return nil
+}
+
+// withDeck opens the deck for a command and closes it afterwards
+func withDeck(fn func(deck *deck) error) error {
+	deck, err := openDeck(config.Settings)
+	if err != nil {
+		closeDeck()
+		return err
+	}
+	defer closeDeck()
+	return fn(deck)
+}
+
+// withStore opens only the plugin store for commands that read the deck,
+// without the runner, scheduler and event hub of withDeck
+func withStore(fn func(store *db.PluginStore) error) error {
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	if err := db.InitDB(database); err != nil {
+		return err
+	}
+	return fn(db.NewPluginStore(database))
+}
+
+// findPlugin looks up a plugin by ID or by its name, which must be unique
+func findPlugin(plugins []db.Plugin, ref string) (*db.Plugin, error) {
+	id, err := strconv.Atoi(ref)
+	var found *db.Plugin
+	for i := range plugins {
+		p := &plugins[i]
+		if (err == nil && p.ID == id) || (err != nil && p.Name == ref) {
+			if found != nil {
+				return nil, fmt.Errorf("several plugins are named %q, use the ID instead", ref)
+			}
+			found = p
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("plugin %q not found", ref)
+	}
+	return found, nil
+}
+
+// listedPlugin is a plugin as printed by list --json
+type listedPlugin struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	PageID   int    `json:"page_id"`
+	Runtime  string `json:"runtime"`
+	Schedule string `json:"schedule"`
+}
+
+// schedule describes when a plugin runs on its own, empty when it does not
+func schedule(p *db.Plugin) string {
+	switch {
+	case !p.RunContinuously:
+		return ""
+	case p.CronExpression != "":
+		return p.CronExpression
+	}
+	return fmt.Sprintf("every %s", time.Duration(p.IntervalSeconds)*time.Second)
+}
+
+func listCommand(args []string, stdout io.Writer) error {
+	flags := newFlagSet("list", stdout)
+	asJSON := flags.Bool("json", false, "print the plugins as JSON")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	return withStore(func(store *db.PluginStore) error {
+		plugins, err := store.GetAll()
+		if err != nil {
+			return err
+		}
+
+		listed := []listedPlugin{}
+		for i := range plugins {
+			p := &plugins[i]
+			listed = append(listed, listedPlugin{
+				ID:       p.ID,
+				Name:     p.Name,
+				Kind:     p.Kind,
+				PageID:   p.PageID,
+				Runtime:  p.Runtime,
+				Schedule: schedule(p),
+			})
+		}
+		if *asJSON {
+			encoder := json.NewEncoder(stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(listed)
+		}
+
+		w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tKIND\tPAGE\tRUNTIME\tSCHEDULE")
+		for _, p := range listed {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n", p.ID, p.Name, p.Kind, p.PageID, p.Runtime, p.Schedule)
+		}
+		return w.Flush()
+	})
+}
+
+func runPluginCommand(args []string, stdout io.Writer) error {
+	flags := newFlagSet("run", stdout)
+	input := flags.String("input", "", "JSON input passed to the plugin")
+	preset := flags.String("preset", "", "run with a saved preset, --input overrides its keys")
+	serverURL := flags.String("url", "", "run on the instance at this URL instead of in this process")
+	token := flags.String("token", "", "device token for an instance on another machine")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	if *input != "" && !json.Valid([]byte(*input)) {
+		return &usageError{"--input must be valid JSON"}
+	}
+	options := api.RunOptions{Preset: *preset}
+	if *input != "" {
+		options.Input = json.RawMessage(*input)
+	}
+
+	// Ctrl+C cancels the run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *serverURL != "" {
+		client := &remote{url: strings.TrimSuffix(*serverURL, "/"), token: *token}
+		return client.run(ctx, flags.Arg(0), options, stdout)
+	}
+
+	return withDeck(func(deck *deck) error {
+		plugins, err := deck.store.GetAll()
+		if err != nil {
+			return err
+		}
+		row, err := findPlugin(plugins, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		runInput := options.Input
+		if options.Preset != "" {
+			preset, err := deck.store.GetPreset(row.ID, options.Preset)
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("preset %q not found", options.Preset)
+			} else if err != nil {
+				return err
+			}
+			runInput = preset.WithInput(options.Input)
+		}
+
+		req := plugin.NewRequest(row, plugin.TriggerManual)
+		req.Input = runInput
+		result, err := runner.Execute(ctx, req)
+		if result == nil {
+			return err
+		}
+		fmt.Fprint(stdout, result.Output)
+		switch {
+		case err == nil:
+			return nil
+		case result.ExitCode > 0:
+			// The error repeats the output printed above
+			return &exitError{code: result.ExitCode, err: fmt.Errorf("plugin exited with code %d", result.ExitCode)}
+		}
+		return err
+	})
+}
+
+// remote talks to the API of a running instance
+type remote struct {
+	url   string
+	token string
+}
+
+func (r *remote) do(ctx context.Context, method string, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, r.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// responseError reads the error message of a failed API request
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error    string `json:"error"`
+		ExitCode int    `json:"exit_code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+		return fmt.Errorf("request failed with status %s", resp.Status)
+	}
+	return &exitError{code: body.ExitCode, err: errors.New(body.Error)}
+}
+
+// run runs the plugin on the instance, looking up names in its plugins
+func (r *remote) run(ctx context.Context, ref string, options api.RunOptions, stdout io.Writer) error {
+	resp, err := r.do(ctx, http.MethodGet, "/api/plugins", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	var listed []api.PluginResponse
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		return fmt.Errorf("failed to read plugins: %w", err)
+	}
+	plugins := make([]db.Plugin, len(listed))
+	for i, p := range listed {
+		plugins[i] = db.Plugin{ID: p.ID, Name: p.Name}
+	}
+	row, err := findPlugin(plugins, ref)
+	if err != nil {
+		return err
+	}
+
+	// An empty body runs the plugin without input
+	var body []byte
+	if len(options.Input) > 0 || options.Preset != "" {
+		fields := map[string]any{}
+		if len(options.Input) > 0 {
+			fields["input"] = options.Input
+		}
+		if options.Preset != "" {
+			fields["preset"] = options.Preset
+		}
+		if body, err = json.Marshal(fields); err != nil {
+			return err
+		}
+	}
+	resp, err = r.do(ctx, http.MethodPost, "/api/plugins/"+url.PathEscape(strconv.Itoa(row.ID))+"/run", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	_, err = io.Copy(stdout, resp.Body)
+	return err
+}
+
+// idList is a flag holding comma separated IDs, it may be repeated
+type idList []int
+
+func (l *idList) String() string {
+	return fmt.Sprint([]int(*l))
+}
+
+func (l *idList) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return fmt.Errorf("invalid ID %q", part)
+		}
+		*l = append(*l, id)
+	}
+	return nil
+}
+
+func exportCommand(args []string, stdout io.Writer) error {
+	flags := newFlagSet("export", stdout)
+	format := flags.String("format", "", "json or zip, zip when the output file ends in .zip")
+	output := flags.String("o", "", "write to this file instead of stdout")
+	var selection bundle.Selection
+	flags.Var((*idList)(&selection.PluginIDs), "plugins", "export only these plugin IDs")
+	flags.Var((*idList)(&selection.PageIDs), "pages", "export only these page IDs")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = "json"
+		if strings.EqualFold(filepath.Ext(*output), ".zip") {
+			*format = "zip"
+		}
+	}
+	if *format != "json" && *format != "zip" {
+		return &usageError{"--format must be json or zip"}
+	}
+
+	var data bytes.Buffer
+	err := withDeck(func(deck *deck) error {
+		b, err := deck.bundles.Export(selection)
+		if err != nil {
+			return err
+		}
+		if *format == "zip" {
+			return bundle.WriteZip(&data, b)
+		}
+		return bundle.WriteJSON(&data, b)
+	})
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = stdout.Write(data.Bytes())
+		return err
+	}
+	return os.WriteFile(*output, data.Bytes(), 0644)
+}
+
+func importCommand(args []string, stdout io.Writer) error {
+	flags := newFlagSet("import", stdout)
+	var options bundle.ImportOptions
+	flags.StringVar(&options.Mode, "mode", bundle.ModeMerge, "merge into the deck or replace it")
+	flags.StringVar(&options.OnConflict, "on-conflict", bundle.ConflictSkip, "skip or rename plugins whose name is taken on their page")
+	flags.BoolVar(&options.DryRun, "dry-run", false, "report what would change without importing")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+
+	// - reads the bundle from stdin
+	var data []byte
+	var err error
+	if path := flags.Arg(0); path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	b, err := bundle.Read(data)
+	if err != nil {
+		return err
+	}
+
+	return withDeck(func(deck *deck) error {
+		report, err := deck.bundles.Import(b, options)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			encoder := json.NewEncoder(stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(report)
+		}
+
+		verb := "Imported"
+		if report.DryRun {
+			verb = "Would import"
+		}
+		fmt.Fprintf(stdout, "%s %d plugins, %d pages and %d profiles\n", verb, report.PluginsCreated, report.PagesCreated, report.ProfilesCreated)
+		if report.PluginsRemoved > 0 {
+			fmt.Fprintf(stdout, "Removed %d plugins\n", report.PluginsRemoved)
+		}
+		for _, conflict := range report.Conflicts {
+			if conflict.RenamedTo != "" {
+				fmt.Fprintf(stdout, "Renamed %q on %q to %q\n", conflict.Plugin, conflict.Page, conflict.RenamedTo)
+			} else {
+				fmt.Fprintf(stdout, "Skipped %q on %q, the name is taken\n", conflict.Plugin, conflict.Page)
+			}
+		}
+		return nil
+	})
+}
+
+// variables is a flag holding template variable values, it may be repeated
+type variables map[string]string
+
+func (v variables) String() string {
+	return fmt.Sprint(map[string]string(v))
+}
+
+func (v variables) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	v[name] = val
+	return nil
+}
+
+func createCommand(args []string, stdout io.Writer) error {
+	flags := newFlagSet("create", stdout)
+	templateID := flags.String("from-template", "", "ID of the template to create the plugin from")
+	pageID := flags.Int("page", 0, "page to add the plugin to, the first page by default")
+	values := variables{}
+	flags.Var(values, "var", "template variable as name=value, JSON for numbers, booleans and arrays")
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if *templateID == "" {
+		return &usageError{"--from-template is required"}
+	}
+
+	return withDeck(func(deck *deck) error {
+		template, ok := api.Templates.Get(*templateID)
+		if !ok {
+			return fmt.Errorf("template %q not found", *templateID)
+		}
+
+		// Strings are taken as they are, other types are written as JSON
+		parsed := map[string]any{}
+		for name, value := range values {
+			variable, ok := template.Variables[name]
+			if !ok || variable.IsSecret() || variable.Type == templates.TypeString {
+				parsed[name] = value
+				continue
+			}
+			var v any
+			if err := json.Unmarshal([]byte(value), &v); err != nil {
+				return fmt.Errorf("invalid value for %s: %w", name, err)
+			}
+			parsed[name] = v
+		}
+
+		p := &db.Plugin{PageID: *pageID}
+		if err := deck.handlers.CreateFromTemplate(template, parsed, p); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Created plugin %d %q\n", p.ID, p.Name)
+		return nil
+	})
+}
+
+func configCommand(args []string, stdout io.Writer) error {
+	flags := newFlagSet("config", stdout)
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(config)
+}
+
+func migrateCommand(args []string, stdout io.Writer) error {
+	flags := newFlagSet("migrate", stdout)
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	before, err := db.SchemaVersion(database)
+	if err != nil {
+		return err
+	}
+	if err := db.InitDB(database); err != nil {
+		return err
+	}
+	after, err := db.SchemaVersion(database)
+	if err != nil {
+		return err
+	}
+
+	if before == after {
+		fmt.Fprintf(stdout, "Database is up to date at version %d\n", after)
+	} else {
+		fmt.Fprintf(stdout, "Migrated the database from version %d to %d\n", before, after)
+	}
+	return nil
+}
+
+func dbCommand(args []string, stdout io.Writer) error {
+	if len(args) == 0 || args[0] != "backup" {
+		return &usageError{"usage: bundeck db backup <file>"}
+	}
+	flags := newFlagSet("db backup", stdout)
+	if err := parseFlags(flags, args[1:], 1); err != nil {
+		return err
+	}
+
+	database, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	path := flags.Arg(0)
+	if err := db.Backup(database, path); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Backed up %s to %s\n", dbPath, path)
+	return nil
+}
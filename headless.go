@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bundeck/internal/settings"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// runHeadless serves the deck without the system tray until the process is
+// interrupted or terminated. It returns the error when the server cannot
+// listen or stops on its own.
+func runHeadless(settings *settings.Settings) error {
+	server = newApp(settings)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- listen(settings)
+	}()
+	slog.Info("bundeck: running headless", "address", listenAddress(settings), "tls", settings.TLS())
+
+	var err error
+	select {
+	case err = <-listenErr:
+		err = fmt.Errorf("server stopped: %w", err)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process if shutting down takes too long
+	stop()
+	shutdown()
+	return err
+}
+
+// writePIDFile records the ID of this process, replacing a file left behind
+// by an earlier run
+func writePIDFile(path string) error {
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	return nil
+}
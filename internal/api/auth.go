@@ -0,0 +1,300 @@
+package api
+
+import (
+	"bundeck/internal/auth"
+	"bundeck/internal/db"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TokenCookie is the cookie holding the device token of a paired browser
+const TokenCookie = "bundeck_token"
+
+// Authenticator interface for device pairing and token checks
+type Authenticator interface {
+	CreatePairing(role string) (auth.Pairing, error)
+	Pair(code string, name string) (string, *db.Device, error)
+	Authenticate(token string) (*db.Device, error)
+	Devices() ([]db.Device, error)
+	Revoke(id int) error
+}
+
+// pressRoutes are the only API routes available to devices with RolePress
+var pressRoutes = []string{
+	"GET /api/auth/me",
+	"DELETE /api/auth/me",
+	"GET /api/plugins",
+	"GET /api/plugins/:id/image",
+	"GET /api/plugins/:id/stream",
+	"GET /api/plugins/:id/runs",
+	"GET /api/plugins/:id/schedule",
+	"GET /api/schedule",
+	"GET /api/profiles",
+	"GET /api/profiles/active",
+	"GET /api/profiles/:id/pages",
+	"GET /api/pages/:id",
+	"POST /api/plugins/:id/run",
+	"POST /api/plugins/:id/cancel",
+}
+
+// publicRoutes are available without a token
+var publicRoutes = []string{
+	"POST /api/auth/pair",
+	// Webhooks are authenticated by the token in the URL and their signature
+	"POST /api/hooks/:token",
+}
+
+// RequireAuth protects the API. Requests from the local machine are trusted,
+// everything else needs the token of a paired device, either as a bearer
+// token or in the cookie set during pairing.
+func (h *Handlers) RequireAuth(c *fiber.Ctx) error {
+	if matchRoute(publicRoutes, c.Method(), c.Path()) {
+		return c.Next()
+	}
+
+	if isLocalRequest(c) {
+		c.Locals("role", auth.RoleAdmin)
+		return c.Next()
+	}
+
+	device, err := h.auth.Authenticate(requestToken(c))
+	if err != nil {
+		if errors.Is(err, auth.ErrUnauthorized) {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Pair this device with BunDeck to continue",
+			})
+		}
+		if errors.Is(err, auth.ErrRemoteDisabled) {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{
+				"error": "Remote access is disabled in the settings",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if device.Role != auth.RoleAdmin && !matchRoute(pressRoutes, c.Method(), c.Path()) {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"error": "This device can only press buttons",
+		})
+	}
+
+	c.Locals("role", device.Role)
+	c.Locals("device", device)
+	return c.Next()
+}
+
+// CreatePairing creates a one-time pairing code for a new device
+func (h *Handlers) CreatePairing(c *fiber.Ctx) error {
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if body.Role == "" {
+		body.Role = auth.RolePress
+	}
+
+	pairing, err := h.auth.CreatePairing(body.Role)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidRole) {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(pairing)
+}
+
+// Pair exchanges a pairing code for a device token. The token is returned
+// once and also stored in a cookie so browsers stay signed in.
+func (h *Handlers) Pair(c *fiber.Ctx) error {
+	var body struct {
+		Code string `json:"code"`
+		Name string `json:"name"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	token, device, err := h.auth.Pair(body.Code, body.Name)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPairing) {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if errors.Is(err, auth.ErrRemoteDisabled) {
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{
+				"error": "Remote access is disabled in the settings",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     TokenCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().AddDate(10, 0, 0),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+
+	return c.JSON(fiber.Map{
+		"token":  token,
+		"device": device,
+	})
+}
+
+// GetCurrentDevice returns the role of the caller and its device, which is
+// null for the local machine
+func (h *Handlers) GetCurrentDevice(c *fiber.Ctx) error {
+	device, _ := c.Locals("device").(*db.Device)
+	return c.JSON(fiber.Map{
+		"role":   c.Locals("role"),
+		"device": device,
+	})
+}
+
+// RevokeCurrentDevice signs the caller out by revoking its own token
+func (h *Handlers) RevokeCurrentDevice(c *fiber.Ctx) error {
+	device, ok := c.Locals("device").(*db.Device)
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "The local machine is not a paired device",
+		})
+	}
+
+	if err := h.auth.Revoke(device.ID); err != nil && err != sql.ErrNoRows {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	c.ClearCookie(TokenCookie)
+
+	return c.SendStatus(http.StatusOK)
+}
+
+func (h *Handlers) GetDevices(c *fiber.Ctx) error {
+	devices, err := h.auth.Devices()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if devices == nil {
+		devices = []db.Device{}
+	}
+
+	return c.JSON(devices)
+}
+
+// RevokeDevice removes a paired device, its token stops working immediately
+func (h *Handlers) RevokeDevice(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid device ID",
+		})
+	}
+
+	if err := h.auth.Revoke(id); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Device not found",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
+
+// requestToken reads the device token from the Authorization header or cookie
+func requestToken(c *fiber.Ctx) string {
+	if token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return c.Cookies(TokenCookie)
+}
+
+// isLocalRequest reports whether a request comes from a browser on this
+// machine. The Host and Origin headers must name the loopback interface too,
+// so other websites cannot reach the API through the user's browser.
+func isLocalRequest(c *fiber.Ctx) bool {
+	if ip := net.ParseIP(c.IP()); ip == nil || !ip.IsLoopback() {
+		return false
+	}
+	if !isLoopbackHost(c.Hostname()) {
+		return false
+	}
+	if origin := c.Get(fiber.HeaderOrigin); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !isLoopbackHost(u.Host) {
+			return false
+		}
+	}
+	return true
+}
+
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// matchRoute reports whether method and path match one of routes, where
+// segments starting with ':' match any value
+func matchRoute(routes []string, method string, path string) bool {
+	segments := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	for _, route := range routes {
+		routeMethod, routePath, _ := strings.Cut(route, " ")
+		if routeMethod != method {
+			continue
+		}
+		parts := strings.Split(routePath, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, part := range parts {
+			if !strings.HasPrefix(part, ":") && part != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
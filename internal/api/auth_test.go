@@ -0,0 +1,245 @@
+package api
+
+import (
+	"bundeck/internal/auth"
+	"bundeck/internal/db"
+	"database/sql"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type mockDeviceStore struct {
+	devices map[int]*db.Device
+	nextID  int
+}
+
+func newMockDeviceStore() *mockDeviceStore {
+	return &mockDeviceStore{devices: make(map[int]*db.Device)}
+}
+
+func (m *mockDeviceStore) Create(device *db.Device) error {
+	m.nextID++
+	device.ID = m.nextID
+	device.CreatedAt = time.Now()
+	stored := *device
+	m.devices[device.ID] = &stored
+	return nil
+}
+
+func (m *mockDeviceStore) GetByTokenHash(hash string) (*db.Device, error) {
+	for _, device := range m.devices {
+		if device.TokenHash == hash {
+			found := *device
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *mockDeviceStore) List() ([]db.Device, error) {
+	var list []db.Device
+	for id := 1; id <= m.nextID; id++ {
+		if device, ok := m.devices[id]; ok {
+			list = append(list, *device)
+		}
+	}
+	return list, nil
+}
+
+func (m *mockDeviceStore) Touch(id int, seen time.Time) error {
+	if device, ok := m.devices[id]; ok {
+		device.LastSeenAt = &seen
+	}
+	return nil
+}
+
+func (m *mockDeviceStore) Delete(id int) error {
+	if _, ok := m.devices[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.devices, id)
+	return nil
+}
+
+// setupSecuredApp puts the auth middleware in front of the test routes
+func setupSecuredApp(deps *testDeps) *fiber.App {
+	app := fiber.New()
+	app.Use("/api", deps.handlers.RequireAuth)
+	app.Mount("/", deps.app)
+	return app
+}
+
+// pairDevice pairs a new device with role and returns its token
+func pairDevice(t *testing.T, app *fiber.App, devices *auth.Manager, role string) string {
+	t.Helper()
+
+	pairing, err := devices.CreatePairing(role)
+	if err != nil {
+		t.Fatalf("Failed to create pairing: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/api/auth/pair", strings.NewReader(`{"code":"`+pairing.Code+`","name":"Phone"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to test request: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected status %d pairing, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+
+	cookie := false
+	for _, c := range resp.Cookies() {
+		if c.Name == TokenCookie && c.HttpOnly {
+			cookie = true
+		}
+	}
+	if !cookie {
+		t.Error("Expected pairing to set an HttpOnly token cookie")
+	}
+
+	var result struct {
+		Token  string    `json:"token"`
+		Device db.Device `json:"device"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Token == "" || result.Device.Role != role {
+		t.Fatalf("Unexpected pairing result: %+v", result)
+	}
+	return result.Token
+}
+
+func TestHandlers_RequireAuth(t *testing.T) {
+	deps := setupTestDeps()
+	app := setupSecuredApp(deps)
+	deps.store.plugins[1] = &db.Plugin{ID: 1, Name: "Test", Code: "console.log('test')"}
+
+	request := func(method string, path string, token string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	t.Run("No Token", func(t *testing.T) {
+		if status := request("GET", "/api/plugins", ""); status != fiber.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", fiber.StatusUnauthorized, status)
+		}
+	})
+
+	t.Run("Invalid Token", func(t *testing.T) {
+		if status := request("GET", "/api/plugins", "nope"); status != fiber.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", fiber.StatusUnauthorized, status)
+		}
+	})
+
+	t.Run("Invalid Pairing Code", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/auth/pair", strings.NewReader(`{"code":"nope"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", fiber.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("Press Role", func(t *testing.T) {
+		token := pairDevice(t, app, deps.devices, auth.RolePress)
+
+		if status := request("GET", "/api/plugins", token); status != fiber.StatusOK {
+			t.Errorf("Expected press device to list plugins, got %d", status)
+		}
+		if status := request("POST", "/api/plugins/1/run", token); status != fiber.StatusOK {
+			t.Errorf("Expected press device to run plugins, got %d", status)
+		}
+		if status := request("DELETE", "/api/plugins/1", token); status != fiber.StatusForbidden {
+			t.Errorf("Expected press device to be forbidden from deleting, got %d", status)
+		}
+		if status := request("GET", "/api/devices", token); status != fiber.StatusForbidden {
+			t.Errorf("Expected press device to be forbidden from listing devices, got %d", status)
+		}
+	})
+
+	t.Run("Admin Role And Revocation", func(t *testing.T) {
+		token := pairDevice(t, app, deps.devices, auth.RoleAdmin)
+
+		if status := request("GET", "/api/devices", token); status != fiber.StatusOK {
+			t.Errorf("Expected admin device to list devices, got %d", status)
+		}
+
+		devices, _ := deps.devices.Devices()
+		id := devices[len(devices)-1].ID
+		if status := request("DELETE", "/api/devices/"+strconv.Itoa(id), token); status != fiber.StatusOK {
+			t.Errorf("Expected revocation to succeed, got %d", status)
+		}
+		if status := request("GET", "/api/plugins", token); status != fiber.StatusUnauthorized {
+			t.Errorf("Expected revoked token to be rejected, got %d", status)
+		}
+	})
+
+	t.Run("Revoke Self", func(t *testing.T) {
+		token := pairDevice(t, app, deps.devices, auth.RolePress)
+
+		if status := request("DELETE", "/api/auth/me", token); status != fiber.StatusOK {
+			t.Errorf("Expected device to revoke itself, got %d", status)
+		}
+		if status := request("GET", "/api/auth/me", token); status != fiber.StatusUnauthorized {
+			t.Errorf("Expected revoked token to be rejected, got %d", status)
+		}
+	})
+}
+
+func TestMatchRoute(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{"GET", "/api/plugins", true},
+		{"GET", "/api/plugins/", true},
+		{"POST", "/api/plugins/12/run", true},
+		{"POST", "/api/plugins", false},
+		{"PUT", "/api/plugins/12/code", false},
+		{"GET", "/api/plugins/12/run/extra", false},
+		{"GET", "/api/secrets", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchRoute(pressRoutes, tt.method, tt.path); got != tt.want {
+			t.Errorf("matchRoute(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestIsLoopbackHost(t *testing.T) {
+	tests := map[string]bool{
+		"localhost:3004":    true,
+		"127.0.0.1:3004":    true,
+		"[::1]:3004":        true,
+		"localhost":         true,
+		"192.168.1.10:3004": false,
+		"evil.example:3004": false,
+	}
+
+	for host, want := range tests {
+		if got := isLoopbackHost(host); got != want {
+			t.Errorf("isLoopbackHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
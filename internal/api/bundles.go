@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bundeck/internal/bundle"
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// parseIDs parses a comma separated list of IDs
+func parseIDs(value string) ([]int, error) {
+	var ids []int
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID %q", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ExportDeck downloads the deck as a bundle. The plugins and pages query
+// parameters limit the export, format=zip stores code and images as files.
+func (h *Handlers) ExportDeck(c *fiber.Ctx) error {
+	var selection bundle.Selection
+	var err error
+	if selection.PluginIDs, err = parseIDs(c.Query("plugins")); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if selection.PageIDs, err = parseIDs(c.Query("pages")); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	format := c.Query("format", "json")
+	if format != "json" && format != "zip" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Format must be json or zip",
+		})
+	}
+
+	b, err := h.bundles.Export(selection)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var data bytes.Buffer
+	if format == "zip" {
+		err = bundle.WriteZip(&data, b)
+		c.Set(fiber.HeaderContentType, "application/zip")
+	} else {
+		err = bundle.WriteJSON(&data, b)
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	c.Attachment(fmt.Sprintf("bundeck-%s.%s", b.ExportedAt.Format("2006-01-02"), format))
+	return c.Send(data.Bytes())
+}
+
+// ImportDeck reads a JSON or zip bundle from the request body or the bundle
+// field of a multipart form. The mode, on_conflict and dry_run query
+// parameters control the import, see bundle.ImportOptions.
+func (h *Handlers) ImportDeck(c *fiber.Ctx) error {
+	data := c.Body()
+	if form, err := c.MultipartForm(); err == nil {
+		files := form.File["bundle"]
+		if len(files) == 0 {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "Missing bundle file",
+			})
+		}
+		f, err := files[0].Open()
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to read bundle file",
+			})
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(f); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to read bundle file",
+			})
+		}
+	}
+
+	b, err := bundle.Read(data)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	options := bundle.ImportOptions{
+		Mode:       c.Query("mode", bundle.ModeMerge),
+		OnConflict: c.Query("on_conflict", bundle.ConflictSkip),
+		DryRun:     c.QueryBool("dry_run"),
+	}
+	report, err := h.bundles.Import(b, options)
+	if err != nil {
+		if errors.Is(err, bundle.ErrInvalidBundle) || errors.Is(err, bundle.ErrInvalidOptions) {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if !report.DryRun {
+		for _, id := range report.Removed {
+			h.scheduler.Reload(id)
+			h.runner.StopResident(id)
+		}
+	}
+
+	return c.JSON(report)
+}
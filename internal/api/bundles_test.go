@@ -0,0 +1,160 @@
+package api
+
+import (
+	"bundeck/internal/bundle"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type mockBundler struct {
+	selection bundle.Selection
+	imported  *bundle.Bundle
+	options   bundle.ImportOptions
+	report    bundle.Report
+}
+
+func (m *mockBundler) Export(selection bundle.Selection) (*bundle.Bundle, error) {
+	m.selection = selection
+	for _, id := range selection.PageIDs {
+		if id == 99 {
+			return nil, fmt.Errorf("page %d: %w", id, sql.ErrNoRows)
+		}
+	}
+	return &bundle.Bundle{
+		Format:   bundle.Format,
+		Version:  bundle.Version,
+		Profiles: []bundle.Profile{{ID: 1, Name: "Default", Active: true}},
+		Pages:    []bundle.Page{{ID: 1, ProfileID: 1, Name: "Home"}},
+		Plugins:  []bundle.Plugin{{ID: 1, PageID: 1, Name: "Test", Code: "console.log(1)"}},
+	}, nil
+}
+
+func (m *mockBundler) Import(b *bundle.Bundle, options bundle.ImportOptions) (*bundle.Report, error) {
+	if options.Mode != bundle.ModeMerge && options.Mode != bundle.ModeReplace {
+		return nil, fmt.Errorf("%w: mode", bundle.ErrInvalidOptions)
+	}
+	m.imported = b
+	m.options = options
+	report := m.report
+	report.Mode = options.Mode
+	report.DryRun = options.DryRun
+	return &report, nil
+}
+
+func TestHandlers_Export(t *testing.T) {
+	deps := setupTestDeps()
+
+	t.Run("JSON", func(t *testing.T) {
+		resp, err := deps.app.Test(httptest.NewRequest("GET", "/api/export?plugins=1,2&pages=3", nil))
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Content-Disposition"); got == "" {
+			t.Error("Expected the export to be sent as an attachment")
+		}
+		if len(deps.bundles.selection.PluginIDs) != 2 || deps.bundles.selection.PageIDs[0] != 3 {
+			t.Errorf("Unexpected selection: %+v", deps.bundles.selection)
+		}
+
+		data, _ := io.ReadAll(resp.Body)
+		if _, err := bundle.Read(data); err != nil {
+			t.Errorf("Expected a readable bundle, got %v", err)
+		}
+	})
+
+	t.Run("Zip", func(t *testing.T) {
+		resp, err := deps.app.Test(httptest.NewRequest("GET", "/api/export?format=zip", nil))
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+		if resp.Header.Get("Content-Type") != "application/zip" {
+			t.Errorf("Expected a zip file, got %q", resp.Header.Get("Content-Type"))
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if _, err := bundle.Read(data); err != nil {
+			t.Errorf("Expected a readable bundle, got %v", err)
+		}
+	})
+
+	t.Run("Invalid Selection", func(t *testing.T) {
+		if status, _ := doJSON(t, deps.app, "GET", "/api/export?plugins=a", ""); status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", fiber.StatusBadRequest, status)
+		}
+		if status, _ := doJSON(t, deps.app, "GET", "/api/export?pages=99", ""); status != fiber.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", fiber.StatusNotFound, status)
+		}
+	})
+}
+
+func TestHandlers_Import(t *testing.T) {
+	deps := setupTestDeps()
+
+	exported, _ := (&mockBundler{}).Export(bundle.Selection{})
+	var data bytes.Buffer
+	if err := bundle.WriteJSON(&data, exported); err != nil {
+		t.Fatalf("Failed to write bundle: %v", err)
+	}
+
+	t.Run("Body", func(t *testing.T) {
+		deps.bundles.report = bundle.Report{
+			Conflicts: []bundle.Conflict{{Plugin: "Test", Page: "Home", Resolution: bundle.ConflictSkip}},
+		}
+		status, body := doJSON(t, deps.app, "POST", "/api/import?dry_run=true", data.String())
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusOK, status, body)
+		}
+		var report bundle.Report
+		if err := json.Unmarshal(body, &report); err != nil {
+			t.Fatalf("Failed to decode report: %v", err)
+		}
+		if report.Mode != bundle.ModeMerge || !report.DryRun || len(report.Conflicts) != 1 {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+	})
+
+	t.Run("Multipart Replace", func(t *testing.T) {
+		deps.bundles.report = bundle.Report{Removed: []int{4}}
+
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("bundle", "deck.json")
+		part.Write(data.Bytes())
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/import?mode=replace", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		resp, err := deps.app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+		}
+		if deps.bundles.options.Mode != bundle.ModeReplace || deps.bundles.imported.Plugins[0].Name != "Test" {
+			t.Errorf("Unexpected import: %+v", deps.bundles.options)
+		}
+		if len(deps.runner.stopped) == 0 || deps.runner.stopped[len(deps.runner.stopped)-1] != 4 {
+			t.Errorf("Expected removed plugins to be stopped, got %v", deps.runner.stopped)
+		}
+	})
+
+	t.Run("Invalid Bundle", func(t *testing.T) {
+		if status, _ := doJSON(t, deps.app, "POST", "/api/import", `{"format":"other"}`); status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", fiber.StatusBadRequest, status)
+		}
+		if status, _ := doJSON(t, deps.app, "POST", "/api/import?mode=append", data.String()); status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", fiber.StatusBadRequest, status)
+		}
+	})
+}
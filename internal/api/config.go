@@ -0,0 +1,30 @@
+package api
+
+import (
+	"bundeck/internal/settings"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SettingsManager interface for reading and changing the settings
+type SettingsManager interface {
+	Config() *settings.Config
+	State() *settings.State
+	Update(s *settings.Settings) (*settings.State, error)
+}
+
+// Settings manages the configuration, set at startup. The configuration
+// names the data directory and where each setting came from: the default,
+// the settings file, a BUNDECK_* environment variable or a flag.
+var Settings SettingsManager
+
+// GetConfig returns the effective configuration
+func (h *Handlers) GetConfig(c *fiber.Ctx) error {
+	if Settings == nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Configuration not loaded",
+		})
+	}
+	return c.JSON(Settings.Config())
+}
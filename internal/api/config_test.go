@@ -0,0 +1,45 @@
+package api
+
+import (
+	"bundeck/internal/settings"
+	"encoding/json"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// setupTestSettings sets Settings to a manager of a settings file in a
+// temporary data directory
+func setupTestSettings(t *testing.T) *settings.Manager {
+	t.Setenv("BUNDECK_DATA_DIR", t.TempDir())
+	t.Setenv("BUNDECK_PORT", "8080")
+	config, err := settings.Load(nil)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	manager := settings.NewManager(config)
+	Settings = manager
+	t.Cleanup(func() { Settings = nil })
+	return manager
+}
+
+func TestHandlers_GetConfig(t *testing.T) {
+	deps := setupTestDeps()
+
+	if status, _ := doJSON(t, deps.app, "GET", "/api/config", ""); status != fiber.StatusNotFound {
+		t.Errorf("Expected status %d before the configuration is loaded, got %d", fiber.StatusNotFound, status)
+	}
+
+	manager := setupTestSettings(t)
+	status, body := doJSON(t, deps.app, "GET", "/api/config", "")
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status %d, got %d", fiber.StatusOK, status)
+	}
+	var config settings.Config
+	if err := json.Unmarshal(body, &config); err != nil {
+		t.Fatalf("Failed to decode config: %v", err)
+	}
+	if config.DataDir != manager.Config().DataDir || config.Settings.Port != 8080 || config.Sources["port"] != settings.SourceEnv {
+		t.Errorf("Unexpected config: %+v", config)
+	}
+}
@@ -1,68 +1,195 @@
 package api
 
 import (
+	"bufio"
+	"bundeck/internal/bundle"
 	"bundeck/internal/db"
+	"bundeck/internal/events"
+	"bundeck/internal/plugin"
+	"bundeck/internal/scheduler"
+	"bundeck/internal/secrets"
+	"bundeck/internal/templates"
+	"bundeck/internal/webhooks"
+	"context"
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/fs"
+	"mime/multipart"
 	"net/http"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// PluginsFS is the embedded filesystem from main package that contains plugin templates
-var PluginsFS fs.FS
-
-// readPluginFile attempts to read a file from the embedded filesystem
-func readPluginFile(path string) ([]byte, error) {
-	return fs.ReadFile(PluginsFS, path)
-}
+// Templates holds the plugin templates of the user template directories and
+// the embedded defaults
+var Templates = templates.NewCatalog()
 
 // PluginStore interface for database operations
 type PluginStore interface {
 	Create(plugin *db.Plugin) error
 	GetAll() ([]db.Plugin, error)
 	GetByID(id int) (*db.Plugin, error)
-	UpdateCode(id int, code string, image []byte, imageType string, name string, runContinuously bool, intervalSeconds int) error
-	UpdateOrder(orders []struct {
-		ID       int `json:"id"`
-		OrderNum int `json:"order_num"`
-	}) error
+	GetByPage(pageID int) ([]db.Plugin, error)
+	UpdateCode(id int, update db.PluginUpdate) error
+	SetRunContinuously(id int, runContinuously bool) error
+	ListRevisions(pluginID int) ([]db.PluginRevision, error)
+	GetRevision(pluginID int, revision int) (*db.PluginRevision, error)
+	RestoreRevision(pluginID int, revision int) (*db.PluginRevision, error)
+	UpdateTemplate(id int, code string, templateVersion int, variables json.RawMessage) error
+	UpdateOrder(orders []db.OrderUpdate) error
 	Delete(id int) error
+	CreateMacro(plugin *db.Plugin, steps []db.MacroStep) error
+	ListSteps(pluginID int) ([]db.MacroStep, error)
+	ReplaceSteps(pluginID int, steps []db.MacroStep) error
+	ListPresets(pluginID int) ([]db.Preset, error)
+	GetPreset(pluginID int, name string) (*db.Preset, error)
+	SavePreset(preset *db.Preset) error
+	DeletePreset(pluginID int, id int) error
 }
 
 type PluginResponse struct {
-	ID              int     `json:"id"`
-	Name            string  `json:"name"`
-	Code            string  `json:"code"`
-	OrderNum        int     `json:"order_num"`
-	Image           *string `json:"image"`
-	ImageType       *string `json:"image_type"`
-	RunContinuously bool    `json:"run_continuously"`
-	IntervalSeconds int     `json:"interval_seconds"`
+	ID                int             `json:"id"`
+	Name              string          `json:"name"`
+	Kind              string          `json:"kind"`
+	Code              string          `json:"code"`
+	OrderNum          int             `json:"order_num"`
+	PageID            int             `json:"page_id"`
+	Image             *string         `json:"image"`
+	ImageType         *string         `json:"image_type"`
+	RunContinuously   bool            `json:"run_continuously"`
+	IntervalSeconds   int             `json:"interval_seconds"`
+	CronExpression    string          `json:"cron_expression"`
+	TimeZone          string          `json:"time_zone"`
+	MissedRunPolicy   string          `json:"missed_run_policy"`
+	TimeoutSeconds    int             `json:"timeout_seconds"`
+	ConcurrencyPolicy string          `json:"concurrency_policy"`
+	Runtime           string          `json:"runtime"`
+	Resident          bool            `json:"resident"`
+	State             json.RawMessage `json:"state"`
+	TemplateID        *string         `json:"template_id"`
+	TemplateVersion   int             `json:"template_version"`
+	// TemplateUpdateAvailable is set when the plugin's template has a newer
+	// version than the one its code was rendered from
+	TemplateUpdateAvailable bool `json:"template_update_available"`
 }
 
 // Runner interface for plugin execution
 type Runner interface {
-	Run(id int, code string) (string, error)
+	Execute(ctx context.Context, req plugin.Request) (*plugin.Result, error)
+	Cancel(id int) bool
+	StopResident(id int) bool
+	ResidentStatus(id int) (plugin.ResidentStatus, bool)
+}
+
+// RunStore interface for reading run history
+type RunStore interface {
+	ListByPlugin(pluginID int, limit int, offset int) ([]db.PluginRun, int, error)
+}
+
+// Scheduler interface for periodic plugin execution
+type Scheduler interface {
+	Start(id int) error
+	Stop(id int) error
+	Reload(id int)
+	Status(id int) scheduler.Status
+	StatusAll() []scheduler.Status
+}
+
+// EventHub interface for subscribing to live run events
+type EventHub interface {
+	Subscribe(pluginID int) (<-chan events.Event, func())
+}
+
+// Bundler interface for exporting and importing decks
+type Bundler interface {
+	Export(selection bundle.Selection) (*bundle.Bundle, error)
+	Import(b *bundle.Bundle, options bundle.ImportOptions) (*bundle.Report, error)
+}
+
+// SecretManager interface for managing encrypted secrets
+type SecretManager interface {
+	List() ([]secrets.Info, error)
+	Set(name string, value string) error
+	SetForPlugin(pluginID int, name string, value string) error
+	Delete(name string) error
+	PluginSecrets(pluginID int) ([]string, error)
+	SetPluginSecrets(pluginID int, names []string) error
+}
+
+// WebhookManager interface for the webhooks that run plugins
+type WebhookManager interface {
+	Create(pluginID int, name string, mode string, signed bool) (*webhooks.Credentials, error)
+	List(pluginID int) ([]webhooks.Webhook, error)
+	Get(id int) (*webhooks.Webhook, error)
+	Update(id int, name string, mode string) (*webhooks.Webhook, error)
+	Rotate(id int, signed bool) (*webhooks.Credentials, error)
+	Delete(id int) error
+	Authenticate(token string, body []byte, signature string) (*db.Webhook, error)
 }
 
 type Handlers struct {
-	store  PluginStore
-	runner Runner
+	store     PluginStore
+	runner    Runner
+	scheduler Scheduler
+	runs      RunStore
+	events    EventHub
+	secrets   SecretManager
+	auth      Authenticator
+	pages     PageStore
+	bundles   Bundler
+	webhooks  WebhookManager
 }
 
-func NewHandlers(store PluginStore, runner Runner) *Handlers {
+func NewHandlers(store PluginStore, runner Runner, scheduler Scheduler, runs RunStore, events EventHub, secrets SecretManager, auth Authenticator, pages PageStore, bundles Bundler, webhooks WebhookManager) *Handlers {
 	return &Handlers{
-		store:  store,
-		runner: runner,
+		store:     store,
+		runner:    runner,
+		scheduler: scheduler,
+		runs:      runs,
+		events:    events,
+		secrets:   secrets,
+		auth:      auth,
+		pages:     pages,
+		bundles:   bundles,
+		webhooks:  webhooks,
+	}
+}
+
+// errPageNotFound is returned for a plugin created on a page that does not
+// exist
+var errPageNotFound = errors.New("page not found")
+
+// checkPage looks up the page a plugin is created on, zero is the first page
+// of the active profile
+func (h *Handlers) checkPage(pageID int) error {
+	if pageID == 0 {
+		return nil
+	}
+	if _, err := h.pages.GetPage(pageID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errPageNotFound
+		}
+		return err
 	}
+	return nil
+}
+
+// checkPageError reports a page that a plugin cannot be created on
+func checkPageError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errPageNotFound) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Page not found",
+		})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
 }
 
 func (h *Handlers) CreatePlugin(c *fiber.Ctx) error {
@@ -73,65 +200,34 @@ func (h *Handlers) CreatePlugin(c *fiber.Ctx) error {
 			"error": "Invalid form data",
 		})
 	}
-
-	// Get form fields
-	name := form.Value["name"][0]
-	code := form.Value["code"][0]
-	orderNum, _ := strconv.Atoi(form.Value["order_num"][0])
-
-	// Get run continuously and interval fields
-	runContinuously := false
-	if len(form.Value["run_continuously"]) > 0 {
-		runContinuously, _ = strconv.ParseBool(form.Value["run_continuously"][0])
-	}
-
-	intervalSeconds := 0
-	if len(form.Value["interval_seconds"]) > 0 {
-		intervalSeconds, _ = strconv.Atoi(form.Value["interval_seconds"][0])
+	values, err := pluginFormValues(form)
+	if err != nil {
+		return pluginFormError(c, err)
 	}
+	orderNum, _ := strconv.Atoi(formValue(form, "order_num"))
 
-	var imageData []byte
-	var imageType string
-
-	// Handle image upload if present
-	if files := form.File["image"]; len(files) > 0 {
-		file := files[0]
-
-		// Validate file type
-		if !strings.HasPrefix(file.Header.Get("Content-Type"), "image/") {
-			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid file type. Only images are allowed.",
-			})
-		}
-
-		// Open uploaded file
-		f, err := file.Open()
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to process image",
-			})
-		}
-		defer f.Close()
-
-		// Read file data
-		imageData, err = io.ReadAll(f)
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to read image data",
-			})
-		}
-
-		imageType = file.Header.Get("Content-Type")
+	// Get the page, zero puts the plugin on the first page of the active profile
+	pageID, _ := strconv.Atoi(formValue(form, "page_id"))
+	if err := h.checkPage(pageID); err != nil {
+		return checkPageError(c, err)
 	}
 
 	plugin := &db.Plugin{
-		Name:            name,
-		Code:            code,
-		OrderNum:        orderNum,
-		Image:           imageData,
-		ImageType:       &imageType,
-		RunContinuously: runContinuously,
-		IntervalSeconds: intervalSeconds,
+		Name:              values.Name,
+		Code:              values.Code,
+		OrderNum:          orderNum,
+		PageID:            pageID,
+		Image:             values.Image,
+		ImageType:         &values.ImageType,
+		RunContinuously:   values.RunContinuously,
+		IntervalSeconds:   values.IntervalSeconds,
+		CronExpression:    values.CronExpression,
+		TimeZone:          values.TimeZone,
+		MissedRunPolicy:   values.MissedRunPolicy,
+		TimeoutSeconds:    values.TimeoutSeconds,
+		ConcurrencyPolicy: values.ConcurrencyPolicy,
+		Runtime:           values.Runtime,
+		Resident:          values.Resident,
 	}
 
 	if err := h.store.Create(plugin); err != nil {
@@ -139,49 +235,82 @@ func (h *Handlers) CreatePlugin(c *fiber.Ctx) error {
 			"error": err.Error(),
 		})
 	}
+	h.scheduler.Reload(plugin.ID)
 
 	return c.Status(http.StatusCreated).JSON(plugin)
 }
 
+// GetAllPlugins lists every plugin, or only those on one page when the
+// page_id query parameter is set
 func (h *Handlers) GetAllPlugins(c *fiber.Ctx) error {
-	dbPlugins, err := h.store.GetAll()
+	var dbPlugins []db.Plugin
+	var err error
+	if pageID := c.QueryInt("page_id"); pageID > 0 {
+		dbPlugins, err = h.store.GetByPage(pageID)
+	} else {
+		dbPlugins, err = h.store.GetAll()
+	}
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
+
+	return c.JSON(toPluginResponses(dbPlugins))
+}
+
+// toPluginResponses converts plugins for JSON, with images as data URLs
+func toPluginResponses(dbPlugins []db.Plugin) []PluginResponse {
 	var plugins []PluginResponse
+	var versions map[string]int
 
 	// Convert image data to base64 for JSON response
 	for i := range dbPlugins {
+		response := PluginResponse{
+			ID:                dbPlugins[i].ID,
+			Name:              dbPlugins[i].Name,
+			Kind:              dbPlugins[i].Kind,
+			Code:              dbPlugins[i].Code,
+			OrderNum:          dbPlugins[i].OrderNum,
+			PageID:            dbPlugins[i].PageID,
+			RunContinuously:   dbPlugins[i].RunContinuously,
+			IntervalSeconds:   dbPlugins[i].IntervalSeconds,
+			CronExpression:    dbPlugins[i].CronExpression,
+			TimeZone:          dbPlugins[i].TimeZone,
+			MissedRunPolicy:   dbPlugins[i].MissedRunPolicy,
+			TimeoutSeconds:    dbPlugins[i].TimeoutSeconds,
+			ConcurrencyPolicy: dbPlugins[i].ConcurrencyPolicy,
+			Runtime:           dbPlugins[i].Runtime,
+			Resident:          dbPlugins[i].Resident,
+			State:             dbPlugins[i].State,
+			TemplateID:        dbPlugins[i].TemplateID,
+			TemplateVersion:   dbPlugins[i].TemplateVersion,
+		}
+		if templateID := dbPlugins[i].TemplateID; templateID != nil {
+			if versions == nil {
+				versions = templateVersions()
+			}
+			response.TemplateUpdateAvailable = versions[*templateID] > dbPlugins[i].TemplateVersion
+		}
 		if len(dbPlugins[i].Image) > 0 {
 			base := base64.StdEncoding.EncodeToString(dbPlugins[i].Image)
 			dataUrl := fmt.Sprintf("data:%s;base64,%s", *dbPlugins[i].ImageType, base)
-			plugins = append(plugins, PluginResponse{
-				ID:              dbPlugins[i].ID,
-				Name:            dbPlugins[i].Name,
-				Code:            dbPlugins[i].Code,
-				OrderNum:        dbPlugins[i].OrderNum,
-				Image:           &dataUrl,
-				ImageType:       dbPlugins[i].ImageType,
-				RunContinuously: dbPlugins[i].RunContinuously,
-				IntervalSeconds: dbPlugins[i].IntervalSeconds,
-			})
-		} else {
-			plugins = append(plugins, PluginResponse{
-				ID:              dbPlugins[i].ID,
-				Name:            dbPlugins[i].Name,
-				Code:            dbPlugins[i].Code,
-				OrderNum:        dbPlugins[i].OrderNum,
-				Image:           nil,
-				ImageType:       nil,
-				RunContinuously: dbPlugins[i].RunContinuously,
-				IntervalSeconds: dbPlugins[i].IntervalSeconds,
-			})
+			response.Image = &dataUrl
+			response.ImageType = dbPlugins[i].ImageType
 		}
+		plugins = append(plugins, response)
 	}
 
-	return c.JSON(plugins)
+	return plugins
+}
+
+// templateVersions returns the current version of every template
+func templateVersions() map[string]int {
+	versions := map[string]int{}
+	for _, template := range Templates.List() {
+		versions[template.ID] = template.Version
+	}
+	return versions
 }
 
 // Add a new handler to serve plugin images
@@ -235,56 +364,12 @@ func (h *Handlers) UpdatePluginData(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get form fields
-	code := form.Value["code"][0]
-	name := form.Value["name"][0]
-
-	// Get run continuously and interval fields
-	runContinuously := false
-	if len(form.Value["run_continuously"]) > 0 {
-		runContinuously, _ = strconv.ParseBool(form.Value["run_continuously"][0])
-	}
-
-	intervalSeconds := 0
-	if len(form.Value["interval_seconds"]) > 0 {
-		intervalSeconds, _ = strconv.Atoi(form.Value["interval_seconds"][0])
-	}
-
-	var imageData []byte
-	var imageType string
-
-	// Handle image upload if present
-	if files := form.File["image"]; len(files) > 0 {
-		file := files[0]
-
-		// Validate file type
-		if !strings.HasPrefix(file.Header.Get("Content-Type"), "image/") {
-			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid file type. Only images are allowed.",
-			})
-		}
-
-		// Open uploaded file
-		f, err := file.Open()
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to process image",
-			})
-		}
-		defer f.Close()
-
-		// Read file data
-		imageData, err = io.ReadAll(f)
-		if err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to read image data",
-			})
-		}
-
-		imageType = file.Header.Get("Content-Type")
+	values, err := pluginFormValues(form)
+	if err != nil {
+		return pluginFormError(c, err)
 	}
 
-	if err := h.store.UpdateCode(id, code, imageData, imageType, name, runContinuously, intervalSeconds); err != nil {
+	if err := h.store.UpdateCode(id, values); err != nil {
 		if err == sql.ErrNoRows {
 			return c.Status(http.StatusNotFound).JSON(fiber.Map{
 				"error": "Plugin not found",
@@ -295,6 +380,10 @@ func (h *Handlers) UpdatePluginData(c *fiber.Ctx) error {
 		})
 	}
 
+	h.scheduler.Reload(id)
+	// The next press starts a process with the new code and settings
+	h.runner.StopResident(id)
+
 	row, err := h.store.GetByID(id)
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
@@ -305,11 +394,10 @@ func (h *Handlers) UpdatePluginData(c *fiber.Ctx) error {
 	return c.Status(http.StatusOK).JSON(row)
 }
 
+// UpdatePluginOrder sets the grid position of plugins. Entries with a page_id
+// also move the plugin to that page.
 func (h *Handlers) UpdatePluginOrder(c *fiber.Ctx) error {
-	var orders []struct {
-		ID       int `json:"id"`
-		OrderNum int `json:"order_num"`
-	}
+	var orders []db.OrderUpdate
 	if err := c.BodyParser(&orders); err != nil {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid request body",
@@ -317,6 +405,11 @@ func (h *Handlers) UpdatePluginOrder(c *fiber.Ctx) error {
 	}
 
 	if err := h.store.UpdateOrder(orders); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "Page not found",
+			})
+		}
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
@@ -343,6 +436,8 @@ func (h *Handlers) DeletePlugin(c *fiber.Ctx) error {
 			"error": err.Error(),
 		})
 	}
+	h.scheduler.Reload(id)
+	h.runner.StopResident(id)
 
 	return c.SendStatus(http.StatusOK)
 }
@@ -355,7 +450,7 @@ func (h *Handlers) RunPlugin(c *fiber.Ctx) error {
 		})
 	}
 
-	plugin, err := h.store.GetByID(id)
+	row, err := h.store.GetByID(id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.Status(http.StatusNotFound).JSON(fiber.Map{
@@ -367,245 +462,613 @@ func (h *Handlers) RunPlugin(c *fiber.Ctx) error {
 		})
 	}
 
-	result, err := h.runner.Run(id, plugin.Code)
+	// The body may pass an input or name a preset of the plugin
+	input, err := h.runInput(c.Body(), id)
+	if err != nil {
+		return presetError(c, err, "Preset not found")
+	}
+
+	req := plugin.NewRequest(row, plugin.TriggerManual)
+	req.Input = input
+	result, err := h.runner.Execute(c.UserContext(), req)
+	if err != nil {
+		return runError(c, result, err)
+	}
+
+	return c.SendString(result.Output)
+}
+
+// runError responds with the outcome of a failed run
+func runError(c *fiber.Ctx, result *plugin.Result, err error) error {
+	status := http.StatusInternalServerError
+	if errors.Is(err, plugin.ErrTimeout) {
+		status = http.StatusGatewayTimeout
+	} else if errors.Is(err, plugin.ErrCancelled) || errors.Is(err, plugin.ErrSkipped) {
+		status = http.StatusConflict
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error":     err.Error(),
+		"status":    result.Status,
+		"run_id":    result.RunID,
+		"exit_code": result.ExitCode,
+	})
+}
+
+// GetPluginRuns returns a page of the run history of a plugin, newest first
+func (h *Handlers) GetPluginRuns(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+
+	limit := c.QueryInt("limit", 20)
+	offset := c.QueryInt("offset", 0)
+	if limit < 1 || limit > 100 || offset < 0 {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "limit must be between 1 and 100 and offset must not be negative",
+		})
+	}
+
+	if _, err := h.store.GetByID(id); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Plugin not found",
+			})
+		}
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
-	return c.SendString(result)
+	runs, total, err := h.runs.ListByPlugin(id, limit, offset)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"runs":   runs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
 }
 
-// GetPluginTemplates returns the list of available plugin templates
-func (h *Handlers) GetPluginTemplates(c *fiber.Ctx) error {
-	// Read templates from plugins/list.json
-	templatesPath := "list.json"
-	data, err := readPluginFile(templatesPath)
+// StreamPlugin pushes run-started, output-line and run-finished events of a
+// plugin to the client as server-sent events
+func (h *Handlers) StreamPlugin(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to read plugin templates",
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
 		})
 	}
 
-	// Parse templates - now structured by category
-	var categorizedTemplates map[string]map[string]interface{}
-	if err := json.Unmarshal(data, &categorizedTemplates); err != nil {
+	if _, err := h.store.GetByID(id); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Plugin not found",
+			})
+		}
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to parse plugin templates",
+			"error": err.Error(),
 		})
 	}
 
-	// Convert to flat array as expected by frontend
-	templates := []map[string]interface{}{}
-	for _, categoryData := range categorizedTemplates {
-		if plugins, ok := categoryData["plugins"].([]interface{}); ok {
-			for _, plugin := range plugins {
-				if pluginMap, ok := plugin.(map[string]interface{}); ok {
-					templates = append(templates, pluginMap)
-				}
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	ch, unsubscribe := h.events.Subscribe(id)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+		writeEvents(w, ch)
+	})
+
+	return nil
+}
+
+// sseKeepAlive is how often a comment is sent to idle streams so that
+// disconnected clients are noticed
+const sseKeepAlive = 15 * time.Second
+
+// writeEvents writes events as server-sent events until the client disconnects
+// or the channel is closed
+func writeEvents(w *bufio.Writer, ch <-chan events.Event) {
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+
+	// Flush the headers straight away so clients know the stream is open
+	fmt.Fprint(w, ": connected\n\n")
+	if err := w.Flush(); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
 			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		if err := w.Flush(); err != nil {
+			return
 		}
 	}
-
-	return c.JSON(templates)
 }
 
-// CreatePluginFromTemplate creates a new plugin from a template
-func (h *Handlers) CreatePluginFromTemplate(c *fiber.Ctx) error {
-	// Parse request body
-	var body struct {
-		TemplateID string                 `json:"templateId"`
-		Variables  map[string]interface{} `json:"variables"`
+// CancelPlugin aborts all in-flight runs of a plugin
+func (h *Handlers) CancelPlugin(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
 	}
-	if err := c.BodyParser(&body); err != nil {
+
+	if !h.runner.Cancel(id) {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Plugin is not running",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "cancelled",
+	})
+}
+
+// GetResidentStatus reports the long-lived process of a resident plugin
+func (h *Handlers) GetResidentStatus(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
+			"error": "Invalid plugin ID",
 		})
 	}
 
-	// Read templates
-	templatesPath := "list.json"
-	data, err := readPluginFile(templatesPath)
+	status, _ := h.runner.ResidentStatus(id)
+	return c.JSON(status)
+}
+
+// GetRuntimes lists the runtimes plugins can declare and whether each one is
+// installed, so only installed runtimes are offered
+func (h *Handlers) GetRuntimes(c *fiber.Ctx) error {
+	return c.JSON(plugin.DetectRuntimes(c.UserContext()))
+}
+
+// StartSchedule turns on continuous running of a plugin and starts its
+// schedule on the server
+func (h *Handlers) StartSchedule(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to read plugin templates",
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
 		})
 	}
 
-	// Parse templates - now structured by category
-	var categorizedTemplates map[string]map[string]interface{}
-	if err := json.Unmarshal(data, &categorizedTemplates); err != nil {
+	if err := h.startSchedule(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Plugin not found",
+			})
+		}
+		if errors.Is(err, scheduler.ErrNotSchedulable) {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to parse plugin templates",
+			"error": err.Error(),
 		})
 	}
 
-	// Find the requested template
-	var selectedTemplate map[string]interface{}
-	templateFound := false
+	return c.JSON(h.scheduler.Status(id))
+}
 
-	for _, categoryData := range categorizedTemplates {
-		if plugins, ok := categoryData["plugins"].([]interface{}); ok {
-			for _, plugin := range plugins {
-				if pluginMap, ok := plugin.(map[string]interface{}); ok {
-					if id, ok := pluginMap["id"].(string); ok && id == body.TemplateID {
-						selectedTemplate = pluginMap
-						templateFound = true
-						break
-					}
-				}
-			}
-			if templateFound {
-				break
-			}
+// startSchedule switches on continuous running of a plugin and starts its
+// schedule. Plugins that cannot be scheduled are switched off again.
+func (h *Handlers) startSchedule(id int) error {
+	row, err := h.store.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if row.RunContinuously {
+		return h.scheduler.Start(id)
+	}
+
+	if err := h.store.SetRunContinuously(id, true); err != nil {
+		return err
+	}
+	if err := h.scheduler.Start(id); err != nil {
+		return errors.Join(err, h.store.SetRunContinuously(id, false))
+	}
+	return nil
+}
+
+// StopSchedule turns off continuous running of a plugin and stops its
+// schedule, so it stays stopped after the plugin is reloaded or the server
+// restarts
+func (h *Handlers) StopSchedule(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+
+	if err := h.store.SetRunContinuously(id, false); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Plugin not found",
+			})
 		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := h.scheduler.Stop(id); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
 	}
 
-	if !templateFound {
-		return c.Status(http.StatusNotFound).JSON(fiber.Map{
-			"error": "Template not found",
+	return c.JSON(h.scheduler.Status(id))
+}
+
+// GetScheduleStatus returns the schedule state and latest result of a plugin
+func (h *Handlers) GetScheduleStatus(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
 		})
 	}
 
-	// Read the template source file
-	sourcePath := selectedTemplate["file"].(string)
-	sourceContent, err := readPluginFile(sourcePath)
+	return c.JSON(h.scheduler.Status(id))
+}
+
+// GetAllScheduleStatus returns the schedule state of every running plugin
+func (h *Handlers) GetAllScheduleStatus(c *fiber.Ctx) error {
+	return c.JSON(h.scheduler.StatusAll())
+}
+
+// formError is a plugin form field that failed validation, the message is
+// shown to the user
+type formError string
+
+func (e formError) Error() string {
+	return string(e)
+}
+
+// pluginFormError responds with the reason a plugin form was rejected
+func pluginFormError(c *fiber.Ctx, err error) error {
+	var invalid formError
+	if errors.As(err, &invalid) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// formValue returns the first value of a form field, empty when it is missing
+func formValue(form *multipart.Form, key string) string {
+	if len(form.Value[key]) > 0 {
+		return form.Value[key][0]
+	}
+	return ""
+}
+
+// pluginFormValues reads and validates the fields shared by the forms
+// creating and editing a plugin. Invalid fields are returned as formError.
+func pluginFormValues(form *multipart.Form) (db.PluginUpdate, error) {
+	values := db.PluginUpdate{
+		Name: formValue(form, "name"),
+		Code: formValue(form, "code"),
+	}
+
+	// Get run continuously and interval fields
+	values.RunContinuously, _ = strconv.ParseBool(formValue(form, "run_continuously"))
+	values.IntervalSeconds, _ = strconv.Atoi(formValue(form, "interval_seconds"))
+	if values.IntervalSeconds < 0 {
+		return values, formError("Interval must not be negative")
+	}
+
+	// Get the cron schedule, which replaces the interval when set. The missed
+	// run policy defaults to skip.
+	values.CronExpression = strings.TrimSpace(formValue(form, "cron_expression"))
+	values.TimeZone = strings.TrimSpace(formValue(form, "time_zone"))
+	values.MissedRunPolicy = strings.TrimSpace(formValue(form, "missed_run_policy"))
+	if values.MissedRunPolicy == "" {
+		values.MissedRunPolicy = scheduler.MissedSkip
+	}
+	if !scheduler.ValidMissedPolicy(values.MissedRunPolicy) {
+		return values, formError("missed run policy must be one of skip or run_once")
+	}
+	if values.CronExpression != "" {
+		if _, err := scheduler.ParseCron(values.CronExpression, values.TimeZone); err != nil {
+			return values, formError(fmt.Sprintf("invalid cron expression: %v", err))
+		}
+	}
+
+	// Get the execution timeout, zero uses the runner default
+	values.TimeoutSeconds, _ = strconv.Atoi(formValue(form, "timeout_seconds"))
+	if values.TimeoutSeconds < 0 {
+		return values, formError("Timeout must not be negative")
+	}
+
+	// Get the concurrency policy, applied when the plugin is started while running
+	values.ConcurrencyPolicy = formValue(form, "concurrency_policy")
+	if values.ConcurrencyPolicy == "" {
+		values.ConcurrencyPolicy = plugin.PolicyParallel
+	}
+	if !plugin.ValidPolicy(values.ConcurrencyPolicy) {
+		return values, formError("Concurrency policy must be one of parallel, skip, queue or restart")
+	}
+
+	// Get the runtime running the code
+	values.Runtime = formValue(form, "runtime")
+	if values.Runtime == "" {
+		values.Runtime = plugin.DefaultRuntime
+	}
+	rt, err := plugin.LookupRuntime(values.Runtime)
 	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to read template source",
-		})
-	}
-
-	// Replace variables in the source content
-	content := string(sourceContent)
-
-	// Process the variables
-	for key, value := range body.Variables {
-		var stringValue string
-
-		// Handle different variable types
-		switch v := value.(type) {
-		case []interface{}:
-			// Handle various array types
-			if len(v) > 0 {
-				items := make([]string, len(v))
-
-				// Determine the type of array based on the first element
-				switch v[0].(type) {
-				case bool:
-					// Boolean array
-					for i, item := range v {
-						boolVal, ok := item.(bool)
-						if !ok {
-							return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-								"error": fmt.Sprintf("Invalid boolean value in array for variable %s", key),
-							})
-						}
-						items[i] = fmt.Sprintf("%v", boolVal)
-					}
-					stringValue = fmt.Sprintf("[%s]", strings.Join(items, ", "))
-				case float64:
-					// Number array (JSON numbers come as float64)
-					for i, item := range v {
-						numVal, ok := item.(float64)
-						if !ok {
-							return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-								"error": fmt.Sprintf("Invalid number value in array for variable %s", key),
-							})
-						}
-
-						// Use integer format if it's a whole number
-						if numVal == float64(int(numVal)) {
-							items[i] = fmt.Sprintf("%d", int(numVal))
-						} else {
-							items[i] = fmt.Sprintf("%g", numVal)
-						}
-					}
-					stringValue = fmt.Sprintf("[%s]", strings.Join(items, ", "))
-				default:
-					// String array (or mixed, default to strings)
-					for i, item := range v {
-						strVal, ok := item.(string)
-						if !ok {
-							// Convert to string if it's not already
-							strVal = fmt.Sprintf("%v", item)
-						}
-						items[i] = fmt.Sprintf("%q", strVal)
-					}
-					stringValue = fmt.Sprintf("[%s]", strings.Join(items, ", "))
-				}
-			} else {
-				// Empty array
-				stringValue = "[]"
-			}
-		case bool:
-			// Boolean value
-			stringValue = fmt.Sprintf("%v", v)
-		case string:
-			// String value
-			stringValue = fmt.Sprintf("%q", v)
-		case float64:
-			// Number value (JSON numbers are decoded as float64)
-			if float64(int(v)) == v {
-				// If it's a whole number, format as integer
-				stringValue = fmt.Sprintf("%d", int(v))
-			} else {
-				stringValue = fmt.Sprintf("%g", v)
-			}
-		default:
-			// Other types
-			stringValue = fmt.Sprintf("%v", v)
+		return values, formError("Unknown runtime")
+	}
+
+	// Resident plugins keep one process alive and receive presses over stdin
+	values.Resident, _ = strconv.ParseBool(formValue(form, "resident"))
+	if values.Resident && !rt.Resident {
+		return values, formError(rt.Name + " plugins cannot be resident")
+	}
+
+	// Handle image upload if present
+	if files := form.File["image"]; len(files) > 0 {
+		file := files[0]
+
+		// Validate file type
+		if !strings.HasPrefix(file.Header.Get("Content-Type"), "image/") {
+			return values, formError("Invalid file type. Only images are allowed.")
 		}
 
-		// Create a more precise regex pattern that matches the exact variable declaration
-		pattern := fmt.Sprintf(`(const\s+%s\s*=\s*)([^;]+)(;)`, regexp.QuoteMeta(key))
-		re := regexp.MustCompile(pattern)
-		if !re.MatchString(content) {
-			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Variable %s not found in template", key),
-			})
+		f, err := file.Open()
+		if err != nil {
+			return values, fmt.Errorf("failed to process image: %w", err)
+		}
+		defer f.Close()
+
+		values.Image, err = io.ReadAll(f)
+		if err != nil {
+			return values, fmt.Errorf("failed to read image data: %w", err)
 		}
-		content = re.ReplaceAllString(content, "${1}"+stringValue+"${3}")
+		values.ImageType = file.Header.Get("Content-Type")
 	}
 
-	// Get the run_continuously and interval_seconds values if they were provided
-	runContinuously := false
-	intervalSeconds := 0
-	if c.Get("run_continuously") == "true" {
-		runContinuously = true
+	return values, nil
+}
+
+// PreviewCron returns the next run times of a cron expression so the editor
+// can show them before the plugin is saved
+func (h *Handlers) PreviewCron(c *fiber.Ctx) error {
+	cron, err := scheduler.ParseCron(c.Query("expression"), c.Query("time_zone"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
 	}
-	if intervalVal, err := strconv.Atoi(c.Get("interval_seconds")); err == nil {
-		intervalSeconds = intervalVal
+
+	count := c.QueryInt("count", 5)
+	if count < 1 || count > 50 {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Count must be between 1 and 50",
+		})
 	}
 
-	// Create a new plugin
-	plugin := &db.Plugin{}
+	return c.JSON(fiber.Map{
+		"time_zone": cron.Location.String(),
+		"next_runs": cron.NextRuns(time.Now(), count),
+	})
+}
+
+// GetPluginTemplates returns the templates of all template roots. Each
+// template names the root it came from in its source field.
+func (h *Handlers) GetPluginTemplates(c *fiber.Ctx) error {
+	return c.JSON(Templates.List())
+}
 
-	// Safely get the title
-	if title, ok := selectedTemplate["title"].(string); ok {
-		plugin.Name = title
-	} else if name, ok := selectedTemplate["name"].(string); ok {
-		plugin.Name = name
-	} else {
-		// Fallback to ID if neither title nor name is available
-		if id, ok := selectedTemplate["id"].(string); ok {
-			plugin.Name = id
-		} else {
-			plugin.Name = "Plugin from template"
+// storedSecrets returns the secret variables of the template that are
+// stored for the plugin
+func (h *Handlers) storedSecrets(template *templates.Template, pluginID int) (map[string]bool, error) {
+	infos, err := h.secrets.List()
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, info := range infos {
+		names[info.Name] = true
+	}
+	stored := map[string]bool{}
+	for key, variable := range template.Variables {
+		if variable.IsSecret() && names[template.SecretName(key, pluginID)] {
+			stored[key] = true
+		}
+	}
+	return stored, nil
+}
+
+// storeTemplateSecrets stores the values of secret variables encrypted
+// instead of in the plugin code, which reads them from its environment.
+// Every plugin has its own secrets granted to it, empty values keep the
+// stored secret.
+func (h *Handlers) storeTemplateSecrets(template *templates.Template, values map[string]any, pluginID int) error {
+	for key, variable := range template.Variables {
+		if !variable.IsSecret() {
+			continue
+		}
+		if value, ok := values[key].(string); ok && value != "" {
+			name := template.SecretName(key, pluginID)
+			if err := h.secrets.SetForPlugin(pluginID, name, value); err != nil {
+				return fmt.Errorf("failed to store secret %s: %w", name, err)
+			}
 		}
 	}
+	return nil
+}
+
+// templateError reports invalid variable values with the reason for each
+// field, and templates that do not declare one of their variables
+func templateError(c *fiber.Ctx, err error) error {
+	var invalid *templates.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error":  err.Error(),
+			"fields": invalid.Fields,
+		})
+	case errors.Is(err, templates.ErrNoDeclaration):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Variable not found in template: %v", err),
+		})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+		"error": fmt.Sprintf("Failed to render template: %v", err),
+	})
+}
+
+// CreateFromTemplate renders the template with the variable values and
+// stores the result as the plugin, which may set its page and schedule.
+// Secret values are stored encrypted instead of in the code.
+func (h *Handlers) CreateFromTemplate(template *templates.Template, values map[string]any, plugin *db.Plugin) error {
+	if err := h.checkPage(plugin.PageID); err != nil {
+		return err
+	}
+	source, err := template.ReadFile()
+	if err != nil {
+		return fmt.Errorf("failed to read template source: %w", err)
+	}
+
+	// A new plugin has no stored secrets yet
+	if err := template.Validate(values, nil); err != nil {
+		return err
+	}
+	variables, err := templateValues(template, values)
+	if err != nil {
+		return err
+	}
 
+	// The secrets are named after the plugin, so the code reading them is
+	// rendered again once the plugin has its ID
+	content, err := template.Render(string(source), values, 0)
+	if err != nil {
+		return fmt.Errorf("failed to render template: %w", err)
+	}
+	plugin.Name = template.DisplayName()
 	plugin.Code = content
+	plugin.TemplateID = &template.ID
+	plugin.TemplateVersion = template.Version
+	plugin.TemplateVariables = variables
 	plugin.OrderNum = -1 // Will be last in order
-	plugin.RunContinuously = runContinuously
-	plugin.IntervalSeconds = intervalSeconds
+	plugin.MissedRunPolicy = scheduler.MissedSkip
 
 	if err := h.store.Create(plugin); err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"error": err.Error(),
+		return err
+	}
+	if err := h.finishTemplatePlugin(template, string(source), values, plugin); err != nil {
+		return errors.Join(err, h.removeTemplatePlugin(template, plugin.ID))
+	}
+	h.scheduler.Reload(plugin.ID)
+	return nil
+}
+
+// finishTemplatePlugin stores the secrets of a plugin created from the
+// template and renders its code again with the secrets named after it
+func (h *Handlers) finishTemplatePlugin(template *templates.Template, source string, values map[string]any, plugin *db.Plugin) error {
+	if err := h.storeTemplateSecrets(template, values, plugin.ID); err != nil {
+		return err
+	}
+	content, err := template.Render(source, values, plugin.ID)
+	if err != nil {
+		return fmt.Errorf("failed to render template: %w", err)
+	}
+	if content == plugin.Code {
+		return nil
+	}
+	if err := h.store.UpdateTemplate(plugin.ID, content, template.Version, plugin.TemplateVariables); err != nil {
+		return err
+	}
+	plugin.Code = content
+	return nil
+}
+
+// removeTemplatePlugin deletes a plugin whose creation from the template
+// failed halfway, along with the secrets stored for it
+func (h *Handlers) removeTemplatePlugin(template *templates.Template, pluginID int) error {
+	var errs []error
+	for key, variable := range template.Variables {
+		if !variable.IsSecret() {
+			continue
+		}
+		name := template.SecretName(key, pluginID)
+		if err := h.secrets.Delete(name); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			errs = append(errs, fmt.Errorf("failed to delete secret %s: %w", name, err))
+		}
+	}
+	if err := h.store.Delete(pluginID); err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete plugin %d: %w", pluginID, err))
+	}
+	return errors.Join(errs...)
+}
+
+// CreatePluginFromTemplate creates a new plugin from a template
+func (h *Handlers) CreatePluginFromTemplate(c *fiber.Ctx) error {
+	// Parse request body
+	var body struct {
+		TemplateID string                 `json:"templateId"`
+		Variables  map[string]interface{} `json:"variables"`
+		PageID     int                    `json:"pageId"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
 		})
 	}
 
+	selectedTemplate, ok := Templates.Get(body.TemplateID)
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Template not found",
+		})
+	}
+
+	// Get the run_continuously and interval_seconds values if they were provided
+	plugin := &db.Plugin{PageID: body.PageID}
+	if c.Get("run_continuously") == "true" {
+		plugin.RunContinuously = true
+	}
+	if intervalVal, err := strconv.Atoi(c.Get("interval_seconds")); err == nil {
+		plugin.IntervalSeconds = intervalVal
+	}
+
+	if err := h.CreateFromTemplate(selectedTemplate, body.Variables, plugin); err != nil {
+		var invalid *templates.ValidationError
+		if errors.As(err, &invalid) || errors.Is(err, templates.ErrNoDeclaration) {
+			return templateError(c, err)
+		}
+		return checkPageError(c, err)
+	}
+
 	return c.Status(http.StatusCreated).JSON(plugin)
 }
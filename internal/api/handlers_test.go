@@ -1,10 +1,19 @@
 package api
 
 import (
+	"bufio"
+	"bundeck/internal/auth"
 	"bundeck/internal/db"
+	"bundeck/internal/events"
+	pluginpkg "bundeck/internal/plugin"
+	"bundeck/internal/scheduler"
+	"bundeck/internal/templates"
+	"bundeck/internal/webhooks"
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -16,19 +25,27 @@ import (
 	"strings"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 type mockPluginStore struct {
-	plugins map[int]*db.Plugin
-	nextID  int
+	plugins   map[int]*db.Plugin
+	revisions map[int][]db.PluginRevision
+	steps     map[int][]db.MacroStep
+	presets   []db.Preset
+	nextID    int
+	// templateErr is returned by UpdateTemplate
+	templateErr error
 }
 
 func newMockPluginStore() *mockPluginStore {
 	return &mockPluginStore{
-		plugins: make(map[int]*db.Plugin),
-		nextID:  1,
+		plugins:   make(map[int]*db.Plugin),
+		revisions: make(map[int][]db.PluginRevision),
+		steps:     make(map[int][]db.MacroStep),
+		nextID:    1,
 	}
 }
 
@@ -36,6 +53,7 @@ func (m *mockPluginStore) Create(plugin *db.Plugin) error {
 	plugin.ID = m.nextID
 	m.nextID++
 	m.plugins[plugin.ID] = plugin
+	m.recordRevision(plugin.ID, nil)
 	return nil
 }
 
@@ -55,37 +73,144 @@ func (m *mockPluginStore) GetByID(id int) (*db.Plugin, error) {
 	return plugin, nil
 }
 
-func (m *mockPluginStore) UpdateCode(id int, code string, image []byte, imageType string, name string, runContinuously bool, intervalSeconds int) error {
+func (m *mockPluginStore) UpdateCode(id int, update db.PluginUpdate) error {
 	plugin, ok := m.plugins[id]
 	if !ok {
 		return sql.ErrNoRows
 	}
-	plugin.Code = code
-	plugin.Image = image
-	if imageType != "" {
-		plugin.ImageType = &imageType
+	plugin.Code = update.Code
+	plugin.Image = update.Image
+	if update.ImageType != "" {
+		plugin.ImageType = &update.ImageType
+	}
+	plugin.Name = update.Name
+	plugin.RunContinuously = update.RunContinuously
+	plugin.IntervalSeconds = update.IntervalSeconds
+	plugin.CronExpression = update.CronExpression
+	plugin.TimeZone = update.TimeZone
+	plugin.MissedRunPolicy = update.MissedRunPolicy
+	plugin.TimeoutSeconds = update.TimeoutSeconds
+	plugin.ConcurrencyPolicy = update.ConcurrencyPolicy
+	plugin.Runtime = update.Runtime
+	plugin.Resident = update.Resident
+	m.recordRevision(id, nil)
+	return nil
+}
+
+func (m *mockPluginStore) SetRunContinuously(id int, runContinuously bool) error {
+	plugin, ok := m.plugins[id]
+	if !ok {
+		return sql.ErrNoRows
 	}
-	plugin.Name = name
 	plugin.RunContinuously = runContinuously
-	plugin.IntervalSeconds = intervalSeconds
+	m.recordRevision(id, nil)
 	return nil
 }
 
-func (m *mockPluginStore) UpdateOrder(orders []struct {
-	ID       int `json:"id"`
-	OrderNum int `json:"order_num"`
-},
-) error {
+func (m *mockPluginStore) GetByPage(pageID int) ([]db.Plugin, error) {
+	var plugins []db.Plugin
+	for _, p := range m.plugins {
+		if p.PageID == pageID {
+			plugins = append(plugins, *p)
+		}
+	}
+	return plugins, nil
+}
+
+func (m *mockPluginStore) UpdateOrder(orders []db.OrderUpdate) error {
 	for _, order := range orders {
 		plugin, ok := m.plugins[order.ID]
 		if !ok {
 			return fmt.Errorf("plugin not found: %d", order.ID)
 		}
 		plugin.OrderNum = order.OrderNum
+		if order.PageID != nil {
+			if *order.PageID <= 0 {
+				return fmt.Errorf("page %d: %w", *order.PageID, sql.ErrNoRows)
+			}
+			plugin.PageID = *order.PageID
+		}
 	}
 	return nil
 }
 
+func (m *mockPluginStore) CreateMacro(plugin *db.Plugin, steps []db.MacroStep) error {
+	plugin.Kind = db.PluginKindMacro
+	if err := m.Create(plugin); err != nil {
+		return err
+	}
+	return m.ReplaceSteps(plugin.ID, steps)
+}
+
+func (m *mockPluginStore) ListSteps(pluginID int) ([]db.MacroStep, error) {
+	return append([]db.MacroStep{}, m.steps[pluginID]...), nil
+}
+
+func (m *mockPluginStore) ReplaceSteps(pluginID int, steps []db.MacroStep) error {
+	plugin, ok := m.plugins[pluginID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if plugin.Kind != db.PluginKindMacro {
+		return db.ErrNotMacro
+	}
+	for i := range steps {
+		steps[i].PluginID = pluginID
+		steps[i].Position = i
+	}
+	m.steps[pluginID] = steps
+	return nil
+}
+
+func (m *mockPluginStore) ListPresets(pluginID int) ([]db.Preset, error) {
+	presets := []db.Preset{}
+	for _, p := range m.presets {
+		if p.PluginID == pluginID {
+			presets = append(presets, p)
+		}
+	}
+	return presets, nil
+}
+
+func (m *mockPluginStore) GetPreset(pluginID int, name string) (*db.Preset, error) {
+	for _, p := range m.presets {
+		if p.PluginID == pluginID && p.Name == name {
+			return &p, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *mockPluginStore) SavePreset(preset *db.Preset) error {
+	for _, p := range m.presets {
+		if p.PluginID == preset.PluginID && p.Name == preset.Name && p.ID != preset.ID {
+			return db.ErrPresetExists
+		}
+	}
+	if preset.ID == 0 {
+		preset.ID = len(m.presets) + 1
+		m.presets = append(m.presets, *preset)
+		return nil
+	}
+	for i, p := range m.presets {
+		if p.ID == preset.ID && p.PluginID == preset.PluginID {
+			m.presets[i] = *preset
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *mockPluginStore) DeletePreset(pluginID int, id int) error {
+	for i, p := range m.presets {
+		if p.ID == id && p.PluginID == pluginID {
+			m.presets = append(m.presets[:i], m.presets[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
 func (m *mockPluginStore) Delete(id int) error {
 	if _, ok := m.plugins[id]; !ok {
 		return sql.ErrNoRows
@@ -95,21 +220,148 @@ func (m *mockPluginStore) Delete(id int) error {
 }
 
 type mockRunner struct {
-	output string
-	err    error
+	output   string
+	err      error
+	status   string
+	timeout  time.Duration
+	trigger  string
+	policy   string
+	runtime  string
+	resident bool
+	input    []byte
+	running  map[int]bool
+	stopped  []int
 }
 
-func (m *mockRunner) Run(id int, code string) (string, error) {
+func (m *mockRunner) Execute(ctx context.Context, req pluginpkg.Request) (*pluginpkg.Result, error) {
+	m.timeout = req.Timeout
+	m.trigger = req.Trigger
+	m.policy = req.Policy
+	m.runtime = req.Runtime
+	m.resident = req.Resident
+	m.input = req.Input
 	if m.err != nil {
-		return "", m.err
+		return &pluginpkg.Result{RunID: 7, Status: m.status, ExitCode: 1}, m.err
+	}
+	if req.OnStart != nil {
+		req.OnStart(&pluginpkg.Run{ID: 7, PluginID: req.PluginID, Trigger: req.Trigger})
 	}
-	return m.output, nil
+	return &pluginpkg.Result{RunID: 7, Status: pluginpkg.StatusSuccess, Output: m.output}, nil
+}
+
+func (m *mockRunner) Cancel(id int) bool {
+	return m.running[id]
+}
+
+func (m *mockRunner) StopResident(id int) bool {
+	m.stopped = append(m.stopped, id)
+	return true
+}
+
+func (m *mockRunner) ResidentStatus(id int) (pluginpkg.ResidentStatus, bool) {
+	return pluginpkg.ResidentStatus{PluginID: id, Running: m.running[id]}, m.running[id]
+}
+
+type mockScheduler struct {
+	running  map[int]bool
+	reloaded []int
+	err      error
+}
+
+func newMockScheduler() *mockScheduler {
+	return &mockScheduler{running: make(map[int]bool)}
+}
+
+func (m *mockScheduler) Start(id int) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.running[id] = true
+	return nil
+}
+
+func (m *mockScheduler) Stop(id int) error {
+	delete(m.running, id)
+	return nil
+}
+
+func (m *mockScheduler) Reload(id int) {
+	m.reloaded = append(m.reloaded, id)
+}
+
+func (m *mockScheduler) Status(id int) scheduler.Status {
+	return scheduler.Status{PluginID: id, Running: m.running[id]}
+}
+
+func (m *mockScheduler) StatusAll() []scheduler.Status {
+	statuses := []scheduler.Status{}
+	for id := range m.running {
+		statuses = append(statuses, m.Status(id))
+	}
+	return statuses
+}
+
+type mockRunStore struct {
+	runs []db.PluginRun
+}
+
+func (m *mockRunStore) ListByPlugin(pluginID int, limit int, offset int) ([]db.PluginRun, int, error) {
+	runs := []db.PluginRun{}
+	for _, r := range m.runs {
+		if r.PluginID == pluginID {
+			runs = append(runs, r)
+		}
+	}
+	total := len(runs)
+	if offset > total {
+		offset = total
+	}
+	runs = runs[offset:]
+	if len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, total, nil
+}
+
+type mockEventHub struct {
+	ch chan events.Event
+}
+
+func (m *mockEventHub) Subscribe(pluginID int) (<-chan events.Event, func()) {
+	return m.ch, func() {}
+}
+
+type testDeps struct {
+	app       *fiber.App
+	handlers  *Handlers
+	store     *mockPluginStore
+	runner    *mockRunner
+	scheduler *mockScheduler
+	runs      *mockRunStore
+	secrets   *mockSecretManager
+	devices   *auth.Manager
+	pages     *mockPageStore
+	bundles   *mockBundler
+	webhooks  *webhooks.Manager
 }
 
 func setupTest() (*fiber.App, *mockPluginStore, *mockRunner) {
+	deps := setupTestDeps()
+	return deps.app, deps.store, deps.runner
+}
+
+func setupTestDeps() *testDeps {
 	store := newMockPluginStore()
 	runner := &mockRunner{output: "test output"}
-	handlers := NewHandlers(store, runner)
+	sched := newMockScheduler()
+	runs := &mockRunStore{}
+	hub := &mockEventHub{ch: make(chan events.Event, 1)}
+	secrets := newMockSecretManager()
+	devices := auth.NewManager(newMockDeviceStore())
+	pages := newMockPageStore()
+	bundles := &mockBundler{}
+	hooks := webhooks.NewManager(newMockWebhookStore(), plainCipher{})
+	handlers := NewHandlers(store, runner, sched, runs, hub, secrets, devices, pages, bundles, hooks)
 
 	// Create a mock FS with list.json and a sample plugin file
 	mockListJSON := `{
@@ -133,11 +385,11 @@ export default {
 	}
 };`
 
-	// Initialize PluginsFS with mock files
-	PluginsFS = fstest.MapFS{
+	// Initialize the templates with mock files
+	Templates = templates.NewCatalog(templates.Root{Source: templates.SourceBuiltin, FS: fstest.MapFS{
 		"list.json":      &fstest.MapFile{Data: []byte(mockListJSON)},
 		"test-plugin.ts": &fstest.MapFile{Data: []byte(mockPluginContent)},
-	}
+	}})
 
 	app := fiber.New()
 	app.Post("/api/plugins", handlers.CreatePlugin)
@@ -147,8 +399,78 @@ export default {
 	app.Put("/api/plugins/:id/code", handlers.UpdatePluginData)
 	app.Delete("/api/plugins/:id", handlers.DeletePlugin)
 	app.Post("/api/plugins/:id/run", handlers.RunPlugin)
-
-	return app, store, runner
+	app.Post("/api/plugins/:id/cancel", handlers.CancelPlugin)
+	app.Get("/api/schedule", handlers.GetAllScheduleStatus)
+	app.Get("/api/schedule/cron", handlers.PreviewCron)
+	app.Get("/api/plugins/:id/schedule", handlers.GetScheduleStatus)
+	app.Post("/api/plugins/:id/schedule/start", handlers.StartSchedule)
+	app.Post("/api/plugins/:id/schedule/stop", handlers.StopSchedule)
+	app.Get("/api/plugins/:id/runs", handlers.GetPluginRuns)
+	app.Get("/api/plugins/:id/stream", handlers.StreamPlugin)
+	app.Get("/api/plugins/:id/resident", handlers.GetResidentStatus)
+	app.Get("/api/plugins/:id/revisions", handlers.GetPluginRevisions)
+	app.Get("/api/plugins/:id/revisions/diff", handlers.GetPluginRevisionDiff)
+	app.Get("/api/plugins/:id/revisions/:revision", handlers.GetPluginRevision)
+	app.Post("/api/plugins/:id/revisions/:revision/restore", handlers.RestorePluginRevision)
+	app.Get("/api/plugins/:id/variables", handlers.GetPluginVariables)
+	app.Put("/api/plugins/:id/variables", handlers.UpdatePluginVariables)
+	app.Get("/api/plugins/:id/presets", handlers.GetPluginPresets)
+	app.Post("/api/plugins/:id/presets", handlers.CreatePluginPreset)
+	app.Put("/api/plugins/:id/presets/:preset", handlers.UpdatePluginPreset)
+	app.Delete("/api/plugins/:id/presets/:preset", handlers.DeletePluginPreset)
+	app.Post("/api/macros", handlers.CreateMacro)
+	app.Get("/api/plugins/:id/steps", handlers.GetMacroSteps)
+	app.Put("/api/plugins/:id/steps", handlers.UpdateMacroSteps)
+	app.Get("/api/plugins/:id/webhooks", handlers.GetPluginWebhooks)
+	app.Post("/api/plugins/:id/webhooks", handlers.CreatePluginWebhook)
+	app.Put("/api/webhooks/:id", handlers.UpdateWebhook)
+	app.Post("/api/webhooks/:id/rotate", handlers.RotateWebhook)
+	app.Delete("/api/webhooks/:id", handlers.DeleteWebhook)
+	app.Post("/api/hooks/:token", handlers.CallWebhook)
+	app.Get("/api/runtimes", handlers.GetRuntimes)
+	app.Get("/api/config", handlers.GetConfig)
+	app.Get("/api/settings", handlers.GetSettings)
+	app.Put("/api/settings", handlers.UpdateSettings)
+	app.Get("/api/plugins/templates", handlers.GetPluginTemplates)
+	app.Post("/api/plugins/templates/create", handlers.CreatePluginFromTemplate)
+	app.Get("/api/export", handlers.ExportDeck)
+	app.Post("/api/import", handlers.ImportDeck)
+	app.Get("/api/secrets", handlers.GetSecrets)
+	app.Put("/api/secrets/:name", handlers.PutSecret)
+	app.Delete("/api/secrets/:name", handlers.DeleteSecret)
+	app.Get("/api/plugins/:id/secrets", handlers.GetPluginSecrets)
+	app.Put("/api/plugins/:id/secrets", handlers.UpdatePluginSecrets)
+	app.Post("/api/auth/pairings", handlers.CreatePairing)
+	app.Post("/api/auth/pair", handlers.Pair)
+	app.Get("/api/auth/me", handlers.GetCurrentDevice)
+	app.Delete("/api/auth/me", handlers.RevokeCurrentDevice)
+	app.Get("/api/devices", handlers.GetDevices)
+	app.Delete("/api/devices/:id", handlers.RevokeDevice)
+	app.Get("/api/profiles", handlers.GetProfiles)
+	app.Post("/api/profiles", handlers.CreateProfile)
+	app.Get("/api/profiles/active", handlers.GetActiveProfile)
+	app.Put("/api/profiles/:id", handlers.UpdateProfile)
+	app.Delete("/api/profiles/:id", handlers.DeleteProfile)
+	app.Post("/api/profiles/:id/activate", handlers.ActivateProfile)
+	app.Get("/api/profiles/:id/pages", handlers.GetProfilePages)
+	app.Post("/api/pages", handlers.CreatePage)
+	app.Get("/api/pages/:id", handlers.GetPage)
+	app.Put("/api/pages/:id", handlers.UpdatePage)
+	app.Delete("/api/pages/:id", handlers.DeletePage)
+
+	return &testDeps{
+		app:       app,
+		handlers:  handlers,
+		store:     store,
+		runner:    runner,
+		scheduler: sched,
+		runs:      runs,
+		secrets:   secrets,
+		devices:   devices,
+		pages:     pages,
+		bundles:   bundles,
+		webhooks:  hooks,
+	}
 }
 
 // Create a small PNG file (1x1 transparent pixel)
@@ -232,6 +554,176 @@ func TestHandlers_CreatePlugin(t *testing.T) {
 		if stored.Name != fields["name"] {
 			t.Errorf("Stored plugin name mismatch: expected %s, got %s", fields["name"], stored.Name)
 		}
+		if stored.ConcurrencyPolicy != pluginpkg.PolicyParallel {
+			t.Errorf("Expected default concurrency policy %q, got %q", pluginpkg.PolicyParallel, stored.ConcurrencyPolicy)
+		}
+	})
+
+	t.Run("Invalid Concurrency Policy", func(t *testing.T) {
+		fields := map[string]string{
+			"name":               "Test Plugin",
+			"code":               "console.log('test')",
+			"order_num":          "1",
+			"concurrency_policy": "sometimes",
+		}
+		body, contentType := createMultipartRequest(t, fields, nil)
+
+		req := httptest.NewRequest("POST", "/api/plugins", body)
+		req.Header.Set("Content-Type", contentType)
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("Unknown Page", func(t *testing.T) {
+		fields := map[string]string{
+			"name":      "Test Plugin",
+			"code":      "console.log('test')",
+			"order_num": "1",
+			"page_id":   "99",
+		}
+		body, contentType := createMultipartRequest(t, fields, nil)
+
+		req := httptest.NewRequest("POST", "/api/plugins", body)
+		req.Header.Set("Content-Type", contentType)
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != fiber.StatusBadRequest || !strings.Contains(string(respBody), "Page not found") {
+			t.Errorf("Expected status %d with Page not found, got %d. Response: %s", fiber.StatusBadRequest, resp.StatusCode, respBody)
+		}
+	})
+
+	t.Run("Runtime", func(t *testing.T) {
+		fields := map[string]string{
+			"name":      "Backup",
+			"code":      "echo backup",
+			"order_num": "1",
+			"runtime":   pluginpkg.RuntimeShell,
+		}
+		body, contentType := createMultipartRequest(t, fields, nil)
+
+		req := httptest.NewRequest("POST", "/api/plugins", body)
+		req.Header.Set("Content-Type", contentType)
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusCreated {
+			respBody, _ := io.ReadAll(resp.Body)
+			t.Fatalf("Expected status %d, got %d. Response: %s", fiber.StatusCreated, resp.StatusCode, string(respBody))
+		}
+
+		var plugin db.Plugin
+		if err := json.NewDecoder(resp.Body).Decode(&plugin); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if stored, _ := store.GetByID(plugin.ID); stored.Runtime != pluginpkg.RuntimeShell {
+			t.Errorf("Expected runtime %q, got %q", pluginpkg.RuntimeShell, stored.Runtime)
+		}
+	})
+
+	t.Run("Invalid Runtime", func(t *testing.T) {
+		for _, invalid := range []map[string]string{
+			{"runtime": "ruby"},
+			{"runtime": pluginpkg.RuntimePython, "resident": "true"},
+		} {
+			fields := map[string]string{
+				"name":      "Test Plugin",
+				"code":      "print('test')",
+				"order_num": "1",
+			}
+			for key, value := range invalid {
+				fields[key] = value
+			}
+			body, contentType := createMultipartRequest(t, fields, nil)
+
+			req := httptest.NewRequest("POST", "/api/plugins", body)
+			req.Header.Set("Content-Type", contentType)
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to test request: %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Errorf("Expected status %d for %v, got %d", fiber.StatusBadRequest, invalid, resp.StatusCode)
+			}
+		}
+	})
+
+	t.Run("Cron Schedule", func(t *testing.T) {
+		fields := map[string]string{
+			"name":             "Recording",
+			"code":             "console.log('test')",
+			"order_num":        "1",
+			"run_continuously": "true",
+			"cron_expression":  "0 9 * * MON-FRI",
+			"time_zone":        "Europe/Berlin",
+		}
+		body, contentType := createMultipartRequest(t, fields, nil)
+
+		req := httptest.NewRequest("POST", "/api/plugins", body)
+		req.Header.Set("Content-Type", contentType)
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusCreated {
+			respBody, _ := io.ReadAll(resp.Body)
+			t.Fatalf("Expected status %d, got %d. Response: %s", fiber.StatusCreated, resp.StatusCode, string(respBody))
+		}
+
+		var plugin db.Plugin
+		if err := json.NewDecoder(resp.Body).Decode(&plugin); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		stored, err := store.GetByID(plugin.ID)
+		if err != nil {
+			t.Fatalf("Failed to get stored plugin: %v", err)
+		}
+		if stored.CronExpression != fields["cron_expression"] || stored.TimeZone != fields["time_zone"] || stored.MissedRunPolicy != scheduler.MissedSkip {
+			t.Errorf("Expected the cron schedule with the default missed run policy, got %q in %q with %q", stored.CronExpression, stored.TimeZone, stored.MissedRunPolicy)
+		}
+	})
+
+	t.Run("Invalid Cron Schedule", func(t *testing.T) {
+		for _, invalid := range []map[string]string{
+			{"cron_expression": "0 25 * * *"},
+			{"cron_expression": "0 9 * * *", "time_zone": "Nowhere/City"},
+			{"cron_expression": "0 9 * * *", "missed_run_policy": "all"},
+		} {
+			fields := map[string]string{
+				"name":      "Test Plugin",
+				"code":      "console.log('test')",
+				"order_num": "1",
+			}
+			for key, value := range invalid {
+				fields[key] = value
+			}
+			body, contentType := createMultipartRequest(t, fields, nil)
+
+			req := httptest.NewRequest("POST", "/api/plugins", body)
+			req.Header.Set("Content-Type", contentType)
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to test request: %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Errorf("Expected status %d for %v, got %d", fiber.StatusBadRequest, invalid, resp.StatusCode)
+			}
+		}
 	})
 
 	t.Run("Invalid Form Data", func(t *testing.T) {
@@ -254,7 +746,7 @@ func TestHandlers_GetAllPlugins(t *testing.T) {
 
 	// Add test plugins
 	store.Create(&db.Plugin{Name: "Plugin 1", Code: "code1", OrderNum: 1})
-	store.Create(&db.Plugin{Name: "Plugin 2", Code: "code2", OrderNum: 2})
+	store.Create(&db.Plugin{Name: "Plugin 2", Code: "code2", OrderNum: 2, State: json.RawMessage(`{"badge":"42%"}`)})
 
 	req := httptest.NewRequest("GET", "/api/plugins", nil)
 	resp, err := app.Test(req)
@@ -275,6 +767,16 @@ func TestHandlers_GetAllPlugins(t *testing.T) {
 	if len(plugins) != 2 {
 		t.Errorf("Expected 2 plugins, got %d", len(plugins))
 	}
+
+	states := 0
+	for _, p := range plugins {
+		if string(p.State) == `{"badge":"42%"}` {
+			states++
+		}
+	}
+	if states != 1 {
+		t.Errorf("Expected the plugin state to be returned, got %v", plugins)
+	}
 }
 
 func TestHandlers_GetPluginImage(t *testing.T) {
@@ -372,10 +874,42 @@ func TestHandlers_UpdatePluginData(t *testing.T) {
 			t.Errorf("Expected code %s, got %s", fields["code"], updated.Code)
 		}
 	})
+
+	t.Run("Invalid Settings", func(t *testing.T) {
+		for _, invalid := range []map[string]string{
+			{"interval_seconds": "-5"},
+			{"timeout_seconds": "-1"},
+			{"concurrency_policy": "sometimes"},
+			{"runtime": "ruby"},
+		} {
+			fields := map[string]string{
+				"name": "Updated Plugin",
+				"code": "newer code",
+			}
+			for key, value := range invalid {
+				fields[key] = value
+			}
+			body, contentType := createMultipartRequest(t, fields, nil)
+
+			req := httptest.NewRequest("PUT", fmt.Sprintf("/api/plugins/%d/code", plugin.ID), body)
+			req.Header.Set("Content-Type", contentType)
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to test request: %v", err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Errorf("Expected status %d for %v, got %d", fiber.StatusBadRequest, invalid, resp.StatusCode)
+			}
+		}
+		if updated, _ := store.GetByID(plugin.ID); updated.Code == "newer code" {
+			t.Error("Expected invalid settings to leave the plugin unchanged")
+		}
+	})
 }
 
 func TestHandlers_DeletePlugin(t *testing.T) {
-	app, store, _ := setupTest()
+	app, store, runner := setupTest()
 
 	// Create test plugin
 	plugin := &db.Plugin{
@@ -400,6 +934,9 @@ func TestHandlers_DeletePlugin(t *testing.T) {
 		if err != sql.ErrNoRows {
 			t.Error("Plugin was not deleted")
 		}
+		if len(runner.stopped) != 1 || runner.stopped[0] != plugin.ID {
+			t.Errorf("Expected the resident process of plugin %d to be stopped, got %v", plugin.ID, runner.stopped)
+		}
 	})
 
 	t.Run("Invalid Plugin ID", func(t *testing.T) {
@@ -446,6 +983,10 @@ func TestHandlers_RunPlugin(t *testing.T) {
 		if string(body) != expectedOutput {
 			t.Errorf("Expected output %q, got %q", expectedOutput, string(body))
 		}
+
+		if runner.trigger != pluginpkg.TriggerManual {
+			t.Errorf("Expected trigger %q, got %q", pluginpkg.TriggerManual, runner.trigger)
+		}
 	})
 
 	t.Run("Run Error", func(t *testing.T) {
@@ -461,6 +1002,440 @@ func TestHandlers_RunPlugin(t *testing.T) {
 		if resp.StatusCode != fiber.StatusInternalServerError {
 			t.Errorf("Expected status %d, got %d", fiber.StatusInternalServerError, resp.StatusCode)
 		}
+
+		var result map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if result["run_id"] != float64(7) || result["exit_code"] != float64(1) {
+			t.Errorf("Expected run ID and exit code in error response, got %v", result)
+		}
+	})
+
+	t.Run("Plugin Timeout", func(t *testing.T) {
+		plugin.TimeoutSeconds = 5
+		runner.err = fmt.Errorf("%w after 5s", pluginpkg.ErrTimeout)
+		runner.status = pluginpkg.StatusTimedOut
+		defer func() { runner.err = nil }()
+
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/plugins/%d/run", plugin.ID), nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusGatewayTimeout {
+			t.Errorf("Expected status %d, got %d", fiber.StatusGatewayTimeout, resp.StatusCode)
+		}
+		if runner.timeout != 5*time.Second {
+			t.Errorf("Expected timeout 5s to be passed to runner, got %s", runner.timeout)
+		}
+
+		var result map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if result["status"] != "timed out" {
+			t.Errorf("Expected status %q, got %v", "timed out", result["status"])
+		}
+	})
+
+	t.Run("Plugin Cancelled", func(t *testing.T) {
+		runner.err = pluginpkg.ErrCancelled
+		runner.status = pluginpkg.StatusCancelled
+		defer func() { runner.err = nil }()
+
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/plugins/%d/run", plugin.ID), nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusConflict {
+			t.Errorf("Expected status %d, got %d", fiber.StatusConflict, resp.StatusCode)
+		}
+	})
+
+	t.Run("Plugin Skipped", func(t *testing.T) {
+		plugin.ConcurrencyPolicy = pluginpkg.PolicySkip
+		runner.err = pluginpkg.ErrSkipped
+		runner.status = pluginpkg.StatusSkipped
+		defer func() { runner.err = nil }()
+
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/plugins/%d/run", plugin.ID), nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusConflict {
+			t.Errorf("Expected status %d, got %d", fiber.StatusConflict, resp.StatusCode)
+		}
+		if runner.policy != pluginpkg.PolicySkip {
+			t.Errorf("Expected policy %q to be passed to runner, got %q", pluginpkg.PolicySkip, runner.policy)
+		}
+	})
+}
+
+func TestHandlers_GetPluginRuns(t *testing.T) {
+	deps := setupTestDeps()
+	app, store, runs := deps.app, deps.store, deps.runs
+
+	plugin := &db.Plugin{Name: "Test Plugin", Code: "console.log('test')"}
+	store.Create(plugin)
+	for i := 0; i < 5; i++ {
+		runs.runs = append(runs.runs, db.PluginRun{ID: int64(5 - i), PluginID: plugin.ID, Status: pluginpkg.StatusSuccess})
+	}
+
+	t.Run("Paging", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/plugins/%d/runs?limit=2&offset=1", plugin.ID), nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+		}
+
+		var result struct {
+			Runs  []db.PluginRun `json:"runs"`
+			Total int            `json:"total"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if result.Total != 5 {
+			t.Errorf("Expected total 5, got %d", result.Total)
+		}
+		if len(result.Runs) != 2 || result.Runs[0].ID != 4 {
+			t.Errorf("Expected runs 4 and 3, got %v", result.Runs)
+		}
+	})
+
+	t.Run("Invalid Limit", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/plugins/%d/runs?limit=0", plugin.ID), nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("Unknown Plugin", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/plugins/999/runs", nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", fiber.StatusNotFound, resp.StatusCode)
+		}
+	})
+}
+
+func TestHandlers_StreamPlugin(t *testing.T) {
+	app, _, _ := setupTest()
+
+	req := httptest.NewRequest("GET", "/api/plugins/999/stream", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to test request: %v", err)
+	}
+
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", fiber.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestWriteEvents(t *testing.T) {
+	ch := make(chan events.Event, 2)
+	ch <- events.Event{Type: events.TypeRunStarted, PluginID: 1, RunID: 3}
+	ch <- events.Event{Type: events.TypeOutputLine, PluginID: 1, RunID: 3, Stream: "stdout", Line: "hello"}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeEvents(bufio.NewWriter(pw), ch)
+	}()
+
+	reader := bufio.NewReader(pr)
+	var lines []string
+	for len(lines) < 5 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read stream: %v", err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	// Closing the reader makes the next flush fail, ending the stream
+	pr.Close()
+	ch <- events.Event{Type: events.TypeRunFinished}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stream did not stop after the client disconnected")
+	}
+
+	expected := []string{
+		": connected",
+		"event: run-started",
+		`data: {"type":"run-started","plugin_id":1,"run_id":3,"time":"0001-01-01T00:00:00Z"}`,
+		"event: output-line",
+	}
+	for i, want := range expected {
+		if lines[i] != want {
+			t.Errorf("Line %d: expected %q, got %q", i, want, lines[i])
+		}
+	}
+	if !strings.Contains(lines[4], `"line":"hello"`) {
+		t.Errorf("Expected output line data, got %q", lines[4])
+	}
+}
+
+func TestWriteEvents_Closed(t *testing.T) {
+	ch := make(chan events.Event)
+	close(ch)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeEvents(bufio.NewWriter(io.Discard), ch)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stream did not stop after the channel was closed")
+	}
+}
+
+func TestHandlers_CancelPlugin(t *testing.T) {
+	app, _, runner := setupTest()
+	runner.running = map[int]bool{1: true}
+
+	t.Run("Running Plugin", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/plugins/1/cancel", nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusOK {
+			t.Errorf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+		}
+	})
+
+	t.Run("Idle Plugin", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/plugins/2/cancel", nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", fiber.StatusNotFound, resp.StatusCode)
+		}
+	})
+}
+
+func TestHandlers_GetResidentStatus(t *testing.T) {
+	app, _, runner := setupTest()
+	runner.running = map[int]bool{3: true}
+
+	req := httptest.NewRequest("GET", "/api/plugins/3/resident", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to test request: %v", err)
+	}
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+	}
+
+	var status pluginpkg.ResidentStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if status.PluginID != 3 || !status.Running {
+		t.Errorf("Expected running process of plugin 3, got %+v", status)
+	}
+}
+
+func TestHandlers_GetRuntimes(t *testing.T) {
+	deps := setupTestDeps()
+
+	status, body := doJSON(t, deps.app, "GET", "/api/runtimes", "")
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status %d, got %d", fiber.StatusOK, status)
+	}
+
+	var runtimes []pluginpkg.RuntimeStatus
+	if err := json.Unmarshal(body, &runtimes); err != nil {
+		t.Fatalf("Failed to decode runtimes: %v", err)
+	}
+	available := map[string]bool{}
+	for _, rt := range runtimes {
+		available[rt.ID] = rt.Available
+	}
+	if len(runtimes) != len(pluginpkg.Runtimes()) || !available[pluginpkg.RuntimeShell] {
+		t.Errorf("Expected every runtime with its availability, got %+v", runtimes)
+	}
+}
+
+func TestHandlers_Schedule(t *testing.T) {
+	deps := setupTestDeps()
+	app, store, sched := deps.app, deps.store, deps.scheduler
+
+	plugin := &db.Plugin{
+		Name:            "Continuous Plugin",
+		Code:            "console.log('tick')",
+		RunContinuously: true,
+		IntervalSeconds: 5,
+	}
+	store.Create(plugin)
+
+	t.Run("Start", func(t *testing.T) {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/plugins/%d/schedule/start", plugin.ID), nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusOK {
+			t.Errorf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+		}
+
+		var status scheduler.Status
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if !status.Running {
+			t.Error("Expected plugin to be running")
+		}
+	})
+
+	t.Run("Status All", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/schedule", nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		var statuses []scheduler.Status
+		if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(statuses) != 1 || statuses[0].PluginID != plugin.ID {
+			t.Errorf("Expected one running plugin, got %v", statuses)
+		}
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/plugins/%d/schedule/stop", plugin.ID), nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusOK {
+			t.Errorf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+		}
+		if sched.running[plugin.ID] {
+			t.Error("Expected plugin to be stopped")
+		}
+		// The stop outlasts reloads and restarts
+		if row, _ := store.GetByID(plugin.ID); row.RunContinuously {
+			t.Error("Expected continuous running to be switched off")
+		}
+	})
+
+	t.Run("Start After Stop", func(t *testing.T) {
+		status, _ := doJSON(t, app, "POST", fmt.Sprintf("/api/plugins/%d/schedule/start", plugin.ID), "")
+		if status != fiber.StatusOK {
+			t.Errorf("Expected status %d, got %d", fiber.StatusOK, status)
+		}
+		if row, _ := store.GetByID(plugin.ID); !row.RunContinuously {
+			t.Error("Expected continuous running to be switched on")
+		}
+		doJSON(t, app, "POST", fmt.Sprintf("/api/plugins/%d/schedule/stop", plugin.ID), "")
+	})
+
+	t.Run("Stop Missing Plugin", func(t *testing.T) {
+		status, _ := doJSON(t, app, "POST", "/api/plugins/999/schedule/stop", "")
+		if status != fiber.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", fiber.StatusNotFound, status)
+		}
+	})
+
+	t.Run("Not Schedulable", func(t *testing.T) {
+		sched.err = scheduler.ErrNotSchedulable
+		defer func() { sched.err = nil }()
+
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/plugins/%d/schedule/start", plugin.ID), nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+		}
+		if row, _ := store.GetByID(plugin.ID); row.RunContinuously {
+			t.Error("Expected a plugin that cannot be scheduled to stay switched off")
+		}
+	})
+
+	t.Run("Reload On Delete", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", fmt.Sprintf("/api/plugins/%d", plugin.ID), nil)
+		if _, err := app.Test(req); err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if len(sched.reloaded) == 0 || sched.reloaded[len(sched.reloaded)-1] != plugin.ID {
+			t.Errorf("Expected scheduler reload for plugin %d, got %v", plugin.ID, sched.reloaded)
+		}
+	})
+}
+
+func TestHandlers_PreviewCron(t *testing.T) {
+	deps := setupTestDeps()
+
+	t.Run("Next Runs", func(t *testing.T) {
+		status, data := doJSON(t, deps.app, "GET", "/api/schedule/cron?expression=0+9+*+*+MON-FRI&time_zone=America/New_York&count=3", "")
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusOK, status, data)
+		}
+
+		var preview struct {
+			TimeZone string      `json:"time_zone"`
+			NextRuns []time.Time `json:"next_runs"`
+		}
+		if err := json.Unmarshal(data, &preview); err != nil {
+			t.Fatalf("Failed to decode preview: %v", err)
+		}
+		if preview.TimeZone != "America/New_York" || len(preview.NextRuns) != 3 {
+			t.Fatalf("Expected 3 runs in New York, got %s", data)
+		}
+		for _, run := range preview.NextRuns {
+			if run.Hour() != 9 || run.Minute() != 0 || run.Weekday() == time.Saturday || run.Weekday() == time.Sunday {
+				t.Errorf("Expected weekdays at 09:00, got %s", run)
+			}
+		}
+	})
+
+	t.Run("Invalid Expression", func(t *testing.T) {
+		status, data := doJSON(t, deps.app, "GET", "/api/schedule/cron?expression=0+9+*", "")
+		if status != fiber.StatusBadRequest || !strings.Contains(string(data), "5 fields") {
+			t.Errorf("Expected a validation error, got %d: %s", status, data)
+		}
 	})
 }
 
@@ -508,6 +1483,45 @@ func TestHandlers_UpdatePluginOrder(t *testing.T) {
 		}
 	})
 
+	t.Run("Move Between Pages", func(t *testing.T) {
+		body := fmt.Sprintf(`[{"id":%d,"order_num":0,"page_id":7}]`, plugin1.ID)
+		req := httptest.NewRequest("PUT", "/api/plugins/reorder", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusOK {
+			t.Errorf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+		}
+
+		p1, _ := store.GetByID(plugin1.ID)
+		p2, _ := store.GetByID(plugin2.ID)
+		if p1.PageID != 7 || p1.OrderNum != 0 {
+			t.Errorf("Expected plugin moved to page 7 at position 0, got page %d position %d", p1.PageID, p1.OrderNum)
+		}
+		if p2.PageID != 0 {
+			t.Errorf("Expected other plugin to stay on its page, got page %d", p2.PageID)
+		}
+	})
+
+	t.Run("Unknown Page", func(t *testing.T) {
+		body := fmt.Sprintf(`[{"id":%d,"order_num":0,"page_id":-1}]`, plugin1.ID)
+		req := httptest.NewRequest("PUT", "/api/plugins/reorder", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
 	t.Run("Invalid Request Body", func(t *testing.T) {
 		req := httptest.NewRequest("PUT", "/api/plugins/reorder", strings.NewReader("invalid"))
 		req.Header.Set("Content-Type", "application/json")
@@ -553,6 +1567,11 @@ func TestGetPluginTemplates(t *testing.T) {
 							"default":     []string{"item1", "item2"},
 							"description": "A test array",
 						},
+						"TEST_SECRET": map[string]interface{}{
+							"type":        "secret",
+							"default":     "",
+							"description": "A test secret",
+						},
 					},
 				},
 			},
@@ -565,22 +1584,28 @@ func TestGetPluginTemplates(t *testing.T) {
 	sourceFile := filepath.Join(tempDir, "test.ts")
 	sourceContent := []byte(`const TEST_VAR = "default";
 const TEST_NUM = 1234;
-const TEST_ARRAY = ["default1", "default2"];`)
+const TEST_ARRAY = ["default1", "default2"];
+const TEST_SECRET = "default";`)
 	os.WriteFile(sourceFile, sourceContent, 0644)
 
 	// Setup test app
 	app := fiber.New()
-	store := &mockPluginStore{
-		plugins: make(map[int]*db.Plugin),
-	}
+	store := newMockPluginStore()
 	runner := &mockRunner{}
-	handlers := NewHandlers(store, runner)
-
-	// Override the PluginsFS with a test directory
-	originalFS := PluginsFS
-	PluginsFS = os.DirFS(tempDir)
-	defer func() { PluginsFS = originalFS }()
-
+	secrets := newMockSecretManager()
+	handlers := NewHandlers(store, runner, newMockScheduler(), &mockRunStore{}, &mockEventHub{}, secrets, auth.NewManager(newMockDeviceStore()), newMockPageStore(), &mockBundler{}, webhooks.NewManager(newMockWebhookStore(), plainCipher{}))
+
+	// Override the templates with a test directory
+	originalTemplates := Templates
+	Templates = templates.NewCatalog(templates.Root{Source: tempDir, FS: os.DirFS(tempDir)})
+	defer func() { Templates = originalTemplates }()
+
+	app.Get("/api/plugins/:id/webhooks", handlers.GetPluginWebhooks)
+	app.Post("/api/plugins/:id/webhooks", handlers.CreatePluginWebhook)
+	app.Put("/api/webhooks/:id", handlers.UpdateWebhook)
+	app.Post("/api/webhooks/:id/rotate", handlers.RotateWebhook)
+	app.Delete("/api/webhooks/:id", handlers.DeleteWebhook)
+	app.Post("/api/hooks/:token", handlers.CallWebhook)
 	app.Get("/api/plugins/templates", handlers.GetPluginTemplates)
 	app.Post("/api/plugins/templates/create", handlers.CreatePluginFromTemplate)
 
@@ -645,6 +1670,10 @@ const TEST_ARRAY = ["default1", "default2"];`)
 			t.Errorf("expected description %q, got %q", expectedTemplate["description"], result[0]["description"])
 		}
 
+		if result[0]["source"] != tempDir {
+			t.Errorf("expected source %q, got %q", tempDir, result[0]["source"])
+		}
+
 		if result[0]["file"] != expectedTemplate["file"] {
 			t.Errorf("expected file %q, got %q", expectedTemplate["file"], result[0]["file"])
 		}
@@ -659,9 +1688,10 @@ const TEST_ARRAY = ["default1", "default2"];`)
 		body := map[string]interface{}{
 			"templateId": "test-template",
 			"variables": map[string]interface{}{
-				"TEST_VAR":   "new value",
-				"TEST_NUM":   9999,
-				"TEST_ARRAY": []interface{}{"new1", "new2", "new3"},
+				"TEST_VAR":    "new value",
+				"TEST_NUM":    9999,
+				"TEST_ARRAY":  []interface{}{"new1", "new2", "new3"},
+				"TEST_SECRET": "hunter2",
 			},
 		}
 		bodyData, _ := json.Marshal(body)
@@ -690,12 +1720,19 @@ const TEST_ARRAY = ["default1", "default2"];`)
 				`const TEST_VAR = "new value"`,
 				`const TEST_NUM = 9999`,
 				`const TEST_ARRAY = ["new1", "new2", "new3"]`,
+				`const TEST_SECRET = process.env.TEST_TEMPLATE_1_TEST_SECRET`,
 			}
 			for _, expected := range expectedValues {
 				if !strings.Contains(code, expected) {
 					t.Errorf("expected code to contain %q", expected)
 				}
 			}
+			if strings.Contains(code, "hunter2") {
+				t.Error("expected the secret value to be kept out of the code")
+			}
+			if secrets.values["TEST_TEMPLATE_1_TEST_SECRET"] != "hunter2" {
+				t.Errorf("expected the secret to be stored for the plugin, got %v", secrets.values)
+			}
 		} else {
 			t.Errorf("code field missing or not a string in response: %v", result)
 		}
@@ -729,4 +1766,22 @@ const TEST_ARRAY = ["default1", "default2"];`)
 			t.Errorf("expected error message to mention the invalid variable")
 		}
 	})
+
+	t.Run("Create from template that fails after the plugin is created", func(t *testing.T) {
+		store.templateErr = errors.New("disk is full")
+		defer func() { store.templateErr = nil }()
+		plugins, stored := len(store.plugins), len(secrets.values)
+
+		status, body := doJSON(t, app, "POST", "/api/plugins/templates/create",
+			`{"templateId":"test-template","variables":{"TEST_SECRET":"hunter2"}}`)
+		if status != http.StatusInternalServerError {
+			t.Errorf("expected status %d, got %d: %s", http.StatusInternalServerError, status, body)
+		}
+		if len(store.plugins) != plugins {
+			t.Errorf("expected the half-created plugin to be deleted, got %d plugins", len(store.plugins))
+		}
+		if len(secrets.values) != stored {
+			t.Errorf("expected the secret stored for the plugin to be deleted, got %v", secrets.values)
+		}
+	})
 }
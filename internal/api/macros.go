@@ -0,0 +1,161 @@
+package api
+
+import (
+	"bundeck/internal/db"
+	"bundeck/internal/macro"
+	"bundeck/internal/plugin"
+	"bundeck/internal/scheduler"
+	"bundeck/internal/settings"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// macroError maps errors of the macro routes to HTTP responses
+func macroError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Plugin not found",
+		})
+	case errors.Is(err, macro.ErrInvalidMacro), errors.Is(err, db.ErrNotMacro):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// macroTimeout is how long a macro may run, its own timeout or else the
+// default timeout of the settings
+func macroTimeout(timeoutSeconds int) time.Duration {
+	if timeoutSeconds > 0 {
+		return time.Duration(timeoutSeconds) * time.Second
+	}
+	if Settings != nil {
+		return Settings.Config().Settings.DefaultTimeout()
+	}
+	return settings.Defaults().DefaultTimeout()
+}
+
+// CreateMacro adds a macro plugin, which runs other plugins as ordered steps
+func (h *Handlers) CreateMacro(c *fiber.Ctx) error {
+	var body struct {
+		Name              string         `json:"name"`
+		PageID            int            `json:"page_id"`
+		TimeoutSeconds    int            `json:"timeout_seconds"`
+		ConcurrencyPolicy string         `json:"concurrency_policy"`
+		Steps             []db.MacroStep `json:"steps"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if body.Name == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name is required",
+		})
+	}
+	if body.TimeoutSeconds < 0 {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Timeout must not be negative",
+		})
+	}
+	if body.ConcurrencyPolicy == "" {
+		body.ConcurrencyPolicy = plugin.PolicySkip
+	}
+	if !plugin.ValidPolicy(body.ConcurrencyPolicy) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Concurrency policy must be one of parallel, skip, queue or restart",
+		})
+	}
+	if err := h.checkPage(body.PageID); err != nil {
+		return checkPageError(c, err)
+	}
+	if err := macro.Validate(h.store, body.Steps, macroTimeout(body.TimeoutSeconds)); err != nil {
+		return macroError(c, err)
+	}
+
+	row := &db.Plugin{
+		Name:              body.Name,
+		PageID:            body.PageID,
+		TimeoutSeconds:    body.TimeoutSeconds,
+		ConcurrencyPolicy: body.ConcurrencyPolicy,
+		MissedRunPolicy:   scheduler.MissedSkip,
+	}
+	if err := h.store.CreateMacro(row, body.Steps); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"plugin": row,
+		"steps":  body.Steps,
+	})
+}
+
+// GetMacroSteps lists the steps of a macro in order
+func (h *Handlers) GetMacroSteps(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+
+	row, err := h.store.GetByID(id)
+	if err != nil {
+		return macroError(c, err)
+	}
+	if row.Kind != db.PluginKindMacro {
+		return macroError(c, db.ErrNotMacro)
+	}
+
+	steps, err := h.store.ListSteps(id)
+	if err != nil {
+		return macroError(c, err)
+	}
+	return c.JSON(steps)
+}
+
+// UpdateMacroSteps replaces the steps of a macro. Runs that already started
+// keep the steps they were started with.
+func (h *Handlers) UpdateMacroSteps(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+
+	var body struct {
+		Steps []db.MacroStep `json:"steps"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	row, err := h.store.GetByID(id)
+	if err != nil {
+		return macroError(c, err)
+	}
+	if err := macro.Validate(h.store, body.Steps, macroTimeout(row.TimeoutSeconds)); err != nil {
+		return macroError(c, err)
+	}
+	if err := h.store.ReplaceSteps(id, body.Steps); err != nil {
+		return macroError(c, err)
+	}
+
+	return c.JSON(body.Steps)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bundeck/internal/db"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestHandlers_Macros(t *testing.T) {
+	deps := setupTestDeps()
+	app := deps.app
+
+	deps.store.Create(&db.Plugin{Name: "Scene", Code: "scene()", Kind: db.PluginKindScript})
+
+	var macroID int
+	t.Run("Create", func(t *testing.T) {
+		status, body := doJSON(t, app, "POST", "/api/macros", `{
+			"name": "Go Live",
+			"steps": [
+				{"action": "run", "target_plugin_id": 1, "input": {"scene": "live"}},
+				{"action": "delay", "delay_ms": 500},
+				{"action": "run", "target_plugin_id": 1, "condition": "success"}
+			]
+		}`)
+		if status != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusCreated, status, body)
+		}
+
+		var created struct {
+			Plugin db.Plugin      `json:"plugin"`
+			Steps  []db.MacroStep `json:"steps"`
+		}
+		if err := json.Unmarshal(body, &created); err != nil {
+			t.Fatalf("Failed to decode macro: %v", err)
+		}
+		if created.Plugin.Kind != db.PluginKindMacro || created.Plugin.ConcurrencyPolicy != "skip" {
+			t.Errorf("Expected a macro that skips presses while running, got %+v", created.Plugin)
+		}
+		if len(created.Steps) != 3 || created.Steps[2].Position != 2 {
+			t.Errorf("Expected 3 numbered steps, got %+v", created.Steps)
+		}
+		macroID = created.Plugin.ID
+	})
+
+	t.Run("Invalid Steps", func(t *testing.T) {
+		tests := []struct {
+			name string
+			body string
+		}{
+			{"No Name", `{"steps":[{"action":"delay","delay_ms":1}]}`},
+			{"No Steps", `{"name":"Empty","steps":[]}`},
+			{"Missing Plugin", `{"name":"Broken","steps":[{"action":"run","target_plugin_id":42}]}`},
+			{"Unknown Page", `{"name":"Lost","page_id":99,"steps":[{"action":"delay","delay_ms":1}]}`},
+			{"Nested Macro", fmt.Sprintf(`{"name":"Nested","steps":[{"action":"run","target_plugin_id":%d}]}`, macroID)},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if status, body := doJSON(t, app, "POST", "/api/macros", tt.body); status != fiber.StatusBadRequest {
+					t.Errorf("Expected status %d, got %d: %s", fiber.StatusBadRequest, status, body)
+				}
+			})
+		}
+	})
+
+	t.Run("Long Delays", func(t *testing.T) {
+		// The delays must fit in the default timeout unless the macro has its own
+		steps := `"steps":[{"action":"delay","delay_ms":50000},{"action":"delay","delay_ms":40000}]`
+		if status, body := doJSON(t, app, "POST", "/api/macros", `{"name":"Slow",`+steps+`}`); status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d: %s", fiber.StatusBadRequest, status, body)
+		}
+		if status, body := doJSON(t, app, "POST", "/api/macros", `{"name":"Slow","timeout_seconds":120,`+steps+`}`); status != fiber.StatusCreated {
+			t.Errorf("Expected status %d, got %d: %s", fiber.StatusCreated, status, body)
+		}
+		path := fmt.Sprintf("/api/plugins/%d/steps", macroID)
+		if status, body := doJSON(t, app, "PUT", path, `{`+steps+`}`); status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d: %s", fiber.StatusBadRequest, status, body)
+		}
+	})
+
+	t.Run("Update Steps", func(t *testing.T) {
+		path := fmt.Sprintf("/api/plugins/%d/steps", macroID)
+		status, body := doJSON(t, app, "PUT", path, `{"steps":[{"action":"run","target_plugin_id":1,"continue_on_failure":true}]}`)
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusOK, status, body)
+		}
+
+		status, body = doJSON(t, app, "GET", path, "")
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusOK, status)
+		}
+		var steps []db.MacroStep
+		if err := json.Unmarshal(body, &steps); err != nil {
+			t.Fatalf("Failed to decode steps: %v", err)
+		}
+		if len(steps) != 1 || !steps[0].ContinueOnFailure {
+			t.Errorf("Expected the replaced step, got %+v", steps)
+		}
+	})
+
+	t.Run("Script Has No Steps", func(t *testing.T) {
+		status, body := doJSON(t, app, "PUT", "/api/plugins/1/steps", `{"steps":[{"action":"delay","delay_ms":1}]}`)
+		if status != fiber.StatusBadRequest || !strings.Contains(string(body), "not a macro") {
+			t.Errorf("Expected status %d for a script, got %d: %s", fiber.StatusBadRequest, status, body)
+		}
+		if status, _ := doJSON(t, app, "GET", "/api/plugins/99/steps", ""); status != fiber.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", fiber.StatusNotFound, status)
+		}
+	})
+
+	t.Run("Listed With Kind", func(t *testing.T) {
+		_, body := doJSON(t, app, "GET", "/api/plugins", "")
+		var plugins []PluginResponse
+		if err := json.Unmarshal(body, &plugins); err != nil {
+			t.Fatalf("Failed to decode plugins: %v", err)
+		}
+		for _, p := range plugins {
+			if p.ID == macroID && p.Kind != db.PluginKindMacro {
+				t.Errorf("Expected the macro to be listed as one, got %q", p.Kind)
+			}
+		}
+	})
+}
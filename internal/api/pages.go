@@ -0,0 +1,310 @@
+package api
+
+import (
+	"bundeck/internal/db"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PageStore interface for profiles, pages and folders
+type PageStore interface {
+	ListProfiles() ([]db.Profile, error)
+	GetProfile(id int) (*db.Profile, error)
+	ActiveProfile() (*db.Profile, error)
+	CreateProfile(profile *db.Profile) error
+	RenameProfile(id int, name string) error
+	ActivateProfile(id int) error
+	DeleteProfile(id int) error
+	ListPages(profileID int) ([]db.Page, error)
+	GetPage(id int) (*db.Page, error)
+	CreatePage(page *db.Page) error
+	UpdatePage(id int, name string, parentID *int, orderNum int) error
+	DeletePage(id int) error
+}
+
+// PageResponse is a page with the buttons shown on it
+type PageResponse struct {
+	Page    db.Page          `json:"page"`
+	Plugins []PluginResponse `json:"plugins"`
+	// Folders are the sub-pages shown as buttons on this page
+	Folders []db.Page `json:"folders"`
+	// Path lists the pages from the top-level page down to this one
+	Path []db.Page `json:"path"`
+}
+
+// pageError maps page store errors to HTTP responses
+func pageError(c *fiber.Ctx, err error, notFound string) error {
+	switch {
+	case err == sql.ErrNoRows:
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": notFound,
+		})
+	case errors.Is(err, db.ErrInvalidParent):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, db.ErrActiveProfile), errors.Is(err, db.ErrPageNotEmpty), errors.Is(err, db.ErrLastPage):
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+func (h *Handlers) GetProfiles(c *fiber.Ctx) error {
+	profiles, err := h.pages.ListProfiles()
+	if err != nil {
+		return pageError(c, err, "Profile not found")
+	}
+	if profiles == nil {
+		profiles = []db.Profile{}
+	}
+
+	return c.JSON(profiles)
+}
+
+// GetActiveProfile returns the active profile and all of its pages
+func (h *Handlers) GetActiveProfile(c *fiber.Ctx) error {
+	profile, err := h.pages.ActiveProfile()
+	if err != nil {
+		return pageError(c, err, "No active profile")
+	}
+
+	pages, err := h.pages.ListPages(profile.ID)
+	if err != nil {
+		return pageError(c, err, "Profile not found")
+	}
+
+	return c.JSON(fiber.Map{
+		"profile": profile,
+		"pages":   pages,
+	})
+}
+
+func (h *Handlers) CreateProfile(c *fiber.Ctx) error {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := c.BodyParser(&body); err != nil || strings.TrimSpace(body.Name) == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Profile name is required",
+		})
+	}
+
+	profile := &db.Profile{Name: strings.TrimSpace(body.Name)}
+	if err := h.pages.CreateProfile(profile); err != nil {
+		return pageError(c, err, "Profile not found")
+	}
+
+	return c.Status(http.StatusCreated).JSON(profile)
+}
+
+func (h *Handlers) UpdateProfile(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid profile ID",
+		})
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := c.BodyParser(&body); err != nil || strings.TrimSpace(body.Name) == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Profile name is required",
+		})
+	}
+
+	if err := h.pages.RenameProfile(id, strings.TrimSpace(body.Name)); err != nil {
+		return pageError(c, err, "Profile not found")
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
+
+// ActivateProfile switches the deck to another profile
+func (h *Handlers) ActivateProfile(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid profile ID",
+		})
+	}
+
+	if err := h.pages.ActivateProfile(id); err != nil {
+		return pageError(c, err, "Profile not found")
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
+
+func (h *Handlers) DeleteProfile(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid profile ID",
+		})
+	}
+
+	if err := h.pages.DeleteProfile(id); err != nil {
+		return pageError(c, err, "Profile not found")
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
+
+func (h *Handlers) GetProfilePages(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid profile ID",
+		})
+	}
+
+	if _, err := h.pages.GetProfile(id); err != nil {
+		return pageError(c, err, "Profile not found")
+	}
+	pages, err := h.pages.ListPages(id)
+	if err != nil {
+		return pageError(c, err, "Profile not found")
+	}
+	if pages == nil {
+		pages = []db.Page{}
+	}
+
+	return c.JSON(pages)
+}
+
+// GetPage returns a page with its plugins, folders and breadcrumb path
+func (h *Handlers) GetPage(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid page ID",
+		})
+	}
+
+	page, err := h.pages.GetPage(id)
+	if err != nil {
+		return pageError(c, err, "Page not found")
+	}
+
+	plugins, err := h.store.GetByPage(id)
+	if err != nil {
+		return pageError(c, err, "Page not found")
+	}
+
+	pages, err := h.pages.ListPages(page.ProfileID)
+	if err != nil {
+		return pageError(c, err, "Page not found")
+	}
+
+	byID := make(map[int]db.Page, len(pages))
+	folders := []db.Page{}
+	for _, p := range pages {
+		byID[p.ID] = p
+		if p.ParentID != nil && *p.ParentID == id {
+			folders = append(folders, p)
+		}
+	}
+
+	path := []db.Page{*page}
+	for parent := page.ParentID; parent != nil; {
+		p, ok := byID[*parent]
+		if !ok {
+			break
+		}
+		path = append([]db.Page{p}, path...)
+		parent = p.ParentID
+	}
+
+	responses := toPluginResponses(plugins)
+	if responses == nil {
+		responses = []PluginResponse{}
+	}
+
+	return c.JSON(PageResponse{
+		Page:    *page,
+		Plugins: responses,
+		Folders: folders,
+		Path:    path,
+	})
+}
+
+// CreatePage adds a top-level page to a profile, or a folder when parent_id
+// is set
+func (h *Handlers) CreatePage(c *fiber.Ctx) error {
+	var body struct {
+		ProfileID int    `json:"profile_id"`
+		ParentID  *int   `json:"parent_id"`
+		Name      string `json:"name"`
+		OrderNum  int    `json:"order_num"`
+	}
+	if err := c.BodyParser(&body); err != nil || strings.TrimSpace(body.Name) == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Page name is required",
+		})
+	}
+
+	page := &db.Page{
+		ProfileID: body.ProfileID,
+		ParentID:  body.ParentID,
+		Name:      strings.TrimSpace(body.Name),
+		OrderNum:  body.OrderNum,
+	}
+	if err := h.pages.CreatePage(page); err != nil {
+		return pageError(c, err, "Profile not found")
+	}
+
+	return c.Status(http.StatusCreated).JSON(page)
+}
+
+// UpdatePage renames a page or moves it to another parent and position
+func (h *Handlers) UpdatePage(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid page ID",
+		})
+	}
+
+	var body struct {
+		ParentID *int   `json:"parent_id"`
+		Name     string `json:"name"`
+		OrderNum int    `json:"order_num"`
+	}
+	if err := c.BodyParser(&body); err != nil || strings.TrimSpace(body.Name) == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Page name is required",
+		})
+	}
+
+	if err := h.pages.UpdatePage(id, strings.TrimSpace(body.Name), body.ParentID, body.OrderNum); err != nil {
+		return pageError(c, err, "Page not found")
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
+
+func (h *Handlers) DeletePage(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid page ID",
+		})
+	}
+
+	if err := h.pages.DeletePage(id); err != nil {
+		return pageError(c, err, "Page not found")
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"bundeck/internal/db"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type mockPageStore struct {
+	profiles map[int]*db.Profile
+	pages    map[int]*db.Page
+	nextID   int
+}
+
+// newMockPageStore starts with an active profile holding page 1
+func newMockPageStore() *mockPageStore {
+	home := &db.Page{ID: 1, ProfileID: 1, Name: "Home"}
+	return &mockPageStore{
+		profiles: map[int]*db.Profile{1: {ID: 1, Name: "Default", Active: true}},
+		pages:    map[int]*db.Page{1: home},
+		nextID:   2,
+	}
+}
+
+func (m *mockPageStore) ListProfiles() ([]db.Profile, error) {
+	var list []db.Profile
+	for id := 1; id < m.nextID; id++ {
+		if p, ok := m.profiles[id]; ok {
+			list = append(list, *p)
+		}
+	}
+	return list, nil
+}
+
+func (m *mockPageStore) GetProfile(id int) (*db.Profile, error) {
+	if p, ok := m.profiles[id]; ok {
+		return p, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *mockPageStore) ActiveProfile() (*db.Profile, error) {
+	for _, p := range m.profiles {
+		if p.Active {
+			return p, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *mockPageStore) CreateProfile(profile *db.Profile) error {
+	profile.ID = m.nextID
+	m.nextID++
+	m.profiles[profile.ID] = profile
+	m.pages[m.nextID] = &db.Page{ID: m.nextID, ProfileID: profile.ID, Name: "Home"}
+	m.nextID++
+	return nil
+}
+
+func (m *mockPageStore) RenameProfile(id int, name string) error {
+	p, ok := m.profiles[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	p.Name = name
+	return nil
+}
+
+func (m *mockPageStore) ActivateProfile(id int) error {
+	if _, ok := m.profiles[id]; !ok {
+		return sql.ErrNoRows
+	}
+	for _, p := range m.profiles {
+		p.Active = p.ID == id
+	}
+	return nil
+}
+
+func (m *mockPageStore) DeleteProfile(id int) error {
+	p, ok := m.profiles[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if p.Active {
+		return db.ErrActiveProfile
+	}
+	delete(m.profiles, id)
+	return nil
+}
+
+func (m *mockPageStore) ListPages(profileID int) ([]db.Page, error) {
+	var list []db.Page
+	for id := 1; id < m.nextID; id++ {
+		if p, ok := m.pages[id]; ok && p.ProfileID == profileID {
+			list = append(list, *p)
+		}
+	}
+	return list, nil
+}
+
+func (m *mockPageStore) GetPage(id int) (*db.Page, error) {
+	if p, ok := m.pages[id]; ok {
+		return p, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *mockPageStore) CreatePage(page *db.Page) error {
+	if page.ParentID != nil {
+		parent, ok := m.pages[*page.ParentID]
+		if !ok {
+			return db.ErrInvalidParent
+		}
+		page.ProfileID = parent.ProfileID
+	}
+	page.ID = m.nextID
+	m.nextID++
+	m.pages[page.ID] = page
+	return nil
+}
+
+func (m *mockPageStore) UpdatePage(id int, name string, parentID *int, orderNum int) error {
+	p, ok := m.pages[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if parentID != nil && *parentID == id {
+		return db.ErrInvalidParent
+	}
+	p.Name = name
+	p.ParentID = parentID
+	p.OrderNum = orderNum
+	return nil
+}
+
+func (m *mockPageStore) DeletePage(id int) error {
+	if _, ok := m.pages[id]; !ok {
+		return sql.ErrNoRows
+	}
+	if id == 1 {
+		return db.ErrLastPage
+	}
+	delete(m.pages, id)
+	return nil
+}
+
+// doJSON sends a JSON request and returns the status and raw response body
+func doJSON(t *testing.T, app *fiber.App, method string, path string, body string) (int, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to test request: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, data
+}
+
+func TestHandlers_Pages(t *testing.T) {
+	deps := setupTestDeps()
+	app := deps.app
+
+	var folder db.Page
+	t.Run("Create Folder", func(t *testing.T) {
+		status, body := doJSON(t, app, "POST", "/api/pages", `{"parent_id":1,"name":"Audio"}`)
+		if status != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusCreated, status)
+		}
+		if err := json.Unmarshal(body, &folder); err != nil {
+			t.Fatalf("Failed to decode page: %v", err)
+		}
+		if folder.ProfileID != 1 {
+			t.Errorf("Expected folder in profile 1, got %d", folder.ProfileID)
+		}
+
+		if status, _ := doJSON(t, app, "POST", "/api/pages", `{"parent_id":1}`); status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d for a missing name, got %d", fiber.StatusBadRequest, status)
+		}
+		if status, _ := doJSON(t, app, "POST", "/api/pages", `{"parent_id":99,"name":"Lost"}`); status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d for an unknown parent, got %d", fiber.StatusBadRequest, status)
+		}
+	})
+
+	t.Run("Get Page", func(t *testing.T) {
+		deps.store.plugins[1] = &db.Plugin{ID: 1, Name: "Mute", PageID: folder.ID}
+
+		status, body := doJSON(t, app, "GET", "/api/pages/"+strconv.Itoa(folder.ID), "")
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusOK, status)
+		}
+		var page PageResponse
+		if err := json.Unmarshal(body, &page); err != nil {
+			t.Fatalf("Failed to decode page: %v", err)
+		}
+		if len(page.Plugins) != 1 || page.Plugins[0].Name != "Mute" {
+			t.Errorf("Expected the folder's plugin, got %+v", page.Plugins)
+		}
+		if len(page.Path) != 2 || page.Path[0].ID != 1 || page.Path[1].ID != folder.ID {
+			t.Errorf("Expected path Home > Audio, got %+v", page.Path)
+		}
+
+		status, body = doJSON(t, app, "GET", "/api/pages/1", "")
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusOK, status)
+		}
+		page = PageResponse{}
+		if err := json.Unmarshal(body, &page); err != nil {
+			t.Fatalf("Failed to decode page: %v", err)
+		}
+		if len(page.Folders) != 1 || page.Folders[0].ID != folder.ID {
+			t.Errorf("Expected the folder on the home page, got %+v", page.Folders)
+		}
+
+		if status, _ := doJSON(t, app, "GET", "/api/pages/99", ""); status != fiber.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", fiber.StatusNotFound, status)
+		}
+	})
+
+	t.Run("Update Page", func(t *testing.T) {
+		if status, _ := doJSON(t, app, "PUT", "/api/pages/"+strconv.Itoa(folder.ID), `{"name":"Sound","parent_id":`+strconv.Itoa(folder.ID)+`}`); status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d moving a folder into itself, got %d", fiber.StatusBadRequest, status)
+		}
+		if status, _ := doJSON(t, app, "PUT", "/api/pages/"+strconv.Itoa(folder.ID), `{"name":"Sound","parent_id":1,"order_num":4}`); status != fiber.StatusOK {
+			t.Errorf("Expected status %d, got %d", fiber.StatusOK, status)
+		}
+		if deps.pages.pages[folder.ID].Name != "Sound" {
+			t.Errorf("Expected folder renamed, got %q", deps.pages.pages[folder.ID].Name)
+		}
+	})
+
+	t.Run("Delete Last Page", func(t *testing.T) {
+		if status, _ := doJSON(t, app, "DELETE", "/api/pages/1", ""); status != fiber.StatusConflict {
+			t.Errorf("Expected status %d, got %d", fiber.StatusConflict, status)
+		}
+	})
+}
+
+func TestHandlers_Profiles(t *testing.T) {
+	deps := setupTestDeps()
+	app := deps.app
+
+	status, body := doJSON(t, app, "POST", "/api/profiles", `{"name":"Streaming"}`)
+	if status != fiber.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", fiber.StatusCreated, status)
+	}
+	var profile db.Profile
+	if err := json.Unmarshal(body, &profile); err != nil {
+		t.Fatalf("Failed to decode profile: %v", err)
+	}
+
+	if status, _ := doJSON(t, app, "POST", "/api/profiles/"+strconv.Itoa(profile.ID)+"/activate", ""); status != fiber.StatusOK {
+		t.Fatalf("Expected status %d activating, got %d", fiber.StatusOK, status)
+	}
+
+	status, body = doJSON(t, app, "GET", "/api/profiles/active", "")
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status %d, got %d", fiber.StatusOK, status)
+	}
+	var active struct {
+		Profile db.Profile `json:"profile"`
+		Pages   []db.Page  `json:"pages"`
+	}
+	if err := json.Unmarshal(body, &active); err != nil {
+		t.Fatalf("Failed to decode active profile: %v", err)
+	}
+	if active.Profile.ID != profile.ID || len(active.Pages) != 1 {
+		t.Errorf("Expected new profile with one page to be active, got %+v", active)
+	}
+
+	if status, _ := doJSON(t, app, "DELETE", "/api/profiles/"+strconv.Itoa(profile.ID), ""); status != fiber.StatusConflict {
+		t.Errorf("Expected status %d deleting the active profile, got %d", fiber.StatusConflict, status)
+	}
+	if status, _ := doJSON(t, app, "POST", "/api/profiles/99/activate", ""); status != fiber.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown profile, got %d", fiber.StatusNotFound, status)
+	}
+	if status, _ := doJSON(t, app, "POST", "/api/profiles", `{"name":" "}`); status != fiber.StatusBadRequest {
+		t.Errorf("Expected status %d for a blank name, got %d", fiber.StatusBadRequest, status)
+	}
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bundeck/internal/db"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RunOptions is the optional body of a run request. Input is handed to the
+// plugin, on top of the input of the named preset when both are objects.
+type RunOptions struct {
+	Input  json.RawMessage `json:"input"`
+	Preset string          `json:"preset"`
+}
+
+// errInvalidRunOptions is returned for a run request body that is not JSON
+var errInvalidRunOptions = errors.New("invalid run options")
+
+// runInput returns the input of a run of the plugin. An empty body runs the
+// plugin without input.
+func (h *Handlers) runInput(body []byte, pluginID int) ([]byte, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	var options RunOptions
+	if err := json.Unmarshal(body, &options); err != nil {
+		return nil, errInvalidRunOptions
+	}
+	if options.Preset == "" {
+		return options.Input, nil
+	}
+
+	preset, err := h.store.GetPreset(pluginID, options.Preset)
+	if err != nil {
+		return nil, err
+	}
+	return preset.WithInput(options.Input), nil
+}
+
+// presetError maps errors of the preset routes to HTTP responses
+func presetError(c *fiber.Ctx, err error, notFound string) error {
+	switch {
+	case err == sql.ErrNoRows:
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": notFound,
+		})
+	case errors.Is(err, errInvalidRunOptions):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	case errors.Is(err, db.ErrPresetExists):
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "A preset with this name already exists",
+		})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// presetBody reads the name and input of a preset from the request
+func presetBody(c *fiber.Ctx) (*db.Preset, error) {
+	var body struct {
+		Name  string          `json:"name"`
+		Input json.RawMessage `json:"input"`
+	}
+	if err := json.Unmarshal(c.Body(), &body); err != nil {
+		return nil, errors.New("invalid request body")
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	if len(body.Input) == 0 || string(body.Input) == "null" {
+		return nil, errors.New("input is required")
+	}
+
+	var input bytes.Buffer
+	if err := json.Compact(&input, body.Input); err != nil {
+		return nil, errors.New("input must be valid JSON")
+	}
+	return &db.Preset{Name: body.Name, Input: input.Bytes()}, nil
+}
+
+// GetPluginPresets lists the presets of a plugin by name
+func (h *Handlers) GetPluginPresets(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+	if _, err := h.store.GetByID(id); err != nil {
+		return presetError(c, err, "Plugin not found")
+	}
+
+	presets, err := h.store.ListPresets(id)
+	if err != nil {
+		return presetError(c, err, "Plugin not found")
+	}
+	return c.JSON(presets)
+}
+
+// CreatePluginPreset adds a named input to a plugin
+func (h *Handlers) CreatePluginPreset(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+
+	preset, err := presetBody(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if _, err := h.store.GetByID(id); err != nil {
+		return presetError(c, err, "Plugin not found")
+	}
+
+	preset.PluginID = id
+	if err := h.store.SavePreset(preset); err != nil {
+		return presetError(c, err, "Plugin not found")
+	}
+	return c.Status(http.StatusCreated).JSON(preset)
+}
+
+// UpdatePluginPreset renames a preset or changes its input
+func (h *Handlers) UpdatePluginPreset(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+	presetID, err := strconv.Atoi(c.Params("preset"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid preset ID",
+		})
+	}
+
+	preset, err := presetBody(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	preset.ID = presetID
+	preset.PluginID = id
+	if err := h.store.SavePreset(preset); err != nil {
+		return presetError(c, err, "Preset not found")
+	}
+	return c.JSON(preset)
+}
+
+// DeletePluginPreset removes a preset of a plugin
+func (h *Handlers) DeletePluginPreset(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+	presetID, err := strconv.Atoi(c.Params("preset"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid preset ID",
+		})
+	}
+
+	if err := h.store.DeletePreset(id, presetID); err != nil {
+		return presetError(c, err, "Preset not found")
+	}
+	return c.SendStatus(http.StatusOK)
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"bundeck/internal/db"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestHandlers_RunPluginInput(t *testing.T) {
+	deps := setupTestDeps()
+	app, store, runner := deps.app, deps.store, deps.runner
+
+	plugin := &db.Plugin{Name: "Scene", Code: "console.log(1)"}
+	store.Create(plugin)
+	store.SavePreset(&db.Preset{PluginID: plugin.ID, Name: "Intro", Input: []byte(`{"scene":"intro","fade":500}`)})
+	path := fmt.Sprintf("/api/plugins/%d/run", plugin.ID)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		input  string
+	}{
+		{"No Body", "", fiber.StatusOK, ""},
+		{"Input", `{"input":{"scene":"outro"}}`, fiber.StatusOK, `{"scene":"outro"}`},
+		{"Preset", `{"preset":"Intro"}`, fiber.StatusOK, `{"scene":"intro","fade":500}`},
+		{"Preset With Input", `{"preset":"Intro","input":{"fade":0}}`, fiber.StatusOK, `{"fade":0,"scene":"intro"}`},
+		{"Unknown Preset", `{"preset":"Missing"}`, fiber.StatusNotFound, ""},
+		{"Invalid Body", `{"input":`, fiber.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner.input = nil
+			status, body := doJSON(t, app, "POST", path, tt.body)
+			if status != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, status, body)
+			}
+			if string(runner.input) != tt.input {
+				t.Errorf("Expected input %q, got %q", tt.input, runner.input)
+			}
+		})
+	}
+}
+
+func TestHandlers_Presets(t *testing.T) {
+	deps := setupTestDeps()
+	app, store := deps.app, deps.store
+
+	plugin := &db.Plugin{Name: "Scene", Code: "console.log(1)"}
+	store.Create(plugin)
+	path := fmt.Sprintf("/api/plugins/%d/presets", plugin.ID)
+
+	var preset db.Preset
+	t.Run("Create", func(t *testing.T) {
+		status, body := doJSON(t, app, "POST", path, `{"name":" Intro ","input":{ "scene": "intro" }}`)
+		if status != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusCreated, status, body)
+		}
+		if err := json.Unmarshal(body, &preset); err != nil {
+			t.Fatalf("Failed to decode preset: %v", err)
+		}
+		if preset.Name != "Intro" || string(preset.Input) != `{"scene":"intro"}` {
+			t.Errorf("Expected a trimmed name and compact input, got %+v", preset)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			body   string
+			status int
+		}{
+			{"No Name", `{"input":{}}`, fiber.StatusBadRequest},
+			{"No Input", `{"name":"Outro"}`, fiber.StatusBadRequest},
+			{"Duplicate Name", `{"name":"Intro","input":1}`, fiber.StatusConflict},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if status, body := doJSON(t, app, "POST", path, tt.body); status != tt.status {
+					t.Errorf("Expected status %d, got %d: %s", tt.status, status, body)
+				}
+			})
+		}
+		if status, _ := doJSON(t, app, "POST", "/api/plugins/99/presets", `{"name":"A","input":1}`); status != fiber.StatusNotFound {
+			t.Errorf("Expected status %d for a missing plugin, got %d", fiber.StatusNotFound, status)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		status, body := doJSON(t, app, "PUT", fmt.Sprintf("%s/%d", path, preset.ID), `{"name":"Opening","input":{"scene":"opening"}}`)
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusOK, status, body)
+		}
+
+		_, body = doJSON(t, app, "GET", path, "")
+		var presets []db.Preset
+		if err := json.Unmarshal(body, &presets); err != nil {
+			t.Fatalf("Failed to decode presets: %v", err)
+		}
+		if len(presets) != 1 || presets[0].Name != "Opening" || string(presets[0].Input) != `{"scene":"opening"}` {
+			t.Errorf("Expected the updated preset, got %+v", presets)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if status, _ := doJSON(t, app, "DELETE", fmt.Sprintf("%s/%d", path, preset.ID), ""); status != fiber.StatusOK {
+			t.Errorf("Expected status %d, got %d", fiber.StatusOK, status)
+		}
+		if status, _ := doJSON(t, app, "DELETE", fmt.Sprintf("%s/%d", path, preset.ID), ""); status != fiber.StatusNotFound {
+			t.Errorf("Expected status %d deleting twice, got %d", fiber.StatusNotFound, status)
+		}
+	})
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"bundeck/internal/db"
+	"bundeck/internal/diff"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// FieldChange is a name or setting that differs between two revisions
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// RevisionDiff compares two revisions of a plugin
+type RevisionDiff struct {
+	From db.PluginRevision `json:"from"`
+	To   db.PluginRevision `json:"to"`
+	// Diff is a unified diff of the code, empty when the code is unchanged
+	Diff    string        `json:"diff"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// revisionChanges lists the fields other than the code that differ
+func revisionChanges(from *db.PluginRevision, to *db.PluginRevision) []FieldChange {
+	fields := []FieldChange{
+		{"name", from.Name, to.Name},
+		{"run_continuously", from.RunContinuously, to.RunContinuously},
+		{"interval_seconds", from.IntervalSeconds, to.IntervalSeconds},
+		{"cron_expression", from.CronExpression, to.CronExpression},
+		{"time_zone", from.TimeZone, to.TimeZone},
+		{"missed_run_policy", from.MissedRunPolicy, to.MissedRunPolicy},
+		{"timeout_seconds", from.TimeoutSeconds, to.TimeoutSeconds},
+		{"concurrency_policy", from.ConcurrencyPolicy, to.ConcurrencyPolicy},
+		{"runtime", from.Runtime, to.Runtime},
+		{"resident", from.Resident, to.Resident},
+	}
+
+	changes := []FieldChange{}
+	for _, f := range fields {
+		if f.From != f.To {
+			changes = append(changes, f)
+		}
+	}
+	return changes
+}
+
+// revisionParam parses a route or query value holding a revision number
+func revisionParam(value string) (int, error) {
+	revision, err := strconv.Atoi(value)
+	if err != nil || revision < 1 {
+		return 0, fmt.Errorf("invalid revision %q", value)
+	}
+	return revision, nil
+}
+
+// GetPluginRevisions lists the saved revisions of a plugin, newest first
+func (h *Handlers) GetPluginRevisions(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+
+	if _, err := h.store.GetByID(id); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Plugin not found",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	revisions, err := h.store.ListRevisions(id)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if revisions == nil {
+		revisions = []db.PluginRevision{}
+	}
+
+	return c.JSON(revisions)
+}
+
+func (h *Handlers) GetPluginRevision(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+	revision, err := revisionParam(c.Params("revision"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	found, err := h.store.GetRevision(id, revision)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Revision not found",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(found)
+}
+
+// GetPluginRevisionDiff compares the revisions given by the from and to query
+// parameters. Without to, from is compared with the latest revision.
+func (h *Handlers) GetPluginRevisionDiff(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+	fromRevision, err := revisionParam(c.Query("from"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	from, err := h.store.GetRevision(id, fromRevision)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Revision not found",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var to *db.PluginRevision
+	if c.Query("to") == "" {
+		revisions, err := h.store.ListRevisions(id)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		to = &revisions[0]
+	} else {
+		toRevision, err := revisionParam(c.Query("to"))
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		to, err = h.store.GetRevision(id, toRevision)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(http.StatusNotFound).JSON(fiber.Map{
+					"error": "Revision not found",
+				})
+			}
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	return c.JSON(RevisionDiff{
+		From: *from,
+		To:   *to,
+		Diff: diff.Unified(
+			fmt.Sprintf("revision %d", from.Revision),
+			fmt.Sprintf("revision %d", to.Revision),
+			from.Code,
+			to.Code,
+			diff.DefaultContext,
+		),
+		Changes: revisionChanges(from, to),
+	})
+}
+
+// RestorePluginRevision rolls a plugin back to an older revision. The
+// restored state is saved as a new revision so the rollback can be undone.
+func (h *Handlers) RestorePluginRevision(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+	revision, err := revisionParam(c.Params("revision"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	restored, err := h.store.RestoreRevision(id, revision)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Revision not found",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	h.scheduler.Reload(id)
+	// The next press starts a process with the restored code and settings
+	h.runner.StopResident(id)
+
+	return c.Status(http.StatusCreated).JSON(restored)
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bundeck/internal/db"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// recordRevision mirrors db.PluginStore by storing changed plugin state as
+// the next revision
+func (m *mockPluginStore) recordRevision(id int, restoredFrom *int) *db.PluginRevision {
+	p := m.plugins[id]
+	history := m.revisions[id]
+	if n := len(history); n > 0 && restoredFrom == nil && history[n-1].Code == p.Code && history[n-1].Name == p.Name {
+		return &history[n-1]
+	}
+
+	revision := db.PluginRevision{
+		PluginID:          id,
+		Revision:          len(history) + 1,
+		Name:              p.Name,
+		Code:              p.Code,
+		RunContinuously:   p.RunContinuously,
+		IntervalSeconds:   p.IntervalSeconds,
+		TimeoutSeconds:    p.TimeoutSeconds,
+		ConcurrencyPolicy: p.ConcurrencyPolicy,
+		Resident:          p.Resident,
+		RestoredFrom:      restoredFrom,
+	}
+	m.revisions[id] = append(history, revision)
+	return &revision
+}
+
+func (m *mockPluginStore) ListRevisions(pluginID int) ([]db.PluginRevision, error) {
+	var list []db.PluginRevision
+	history := m.revisions[pluginID]
+	for i := len(history) - 1; i >= 0; i-- {
+		list = append(list, history[i])
+	}
+	return list, nil
+}
+
+func (m *mockPluginStore) GetRevision(pluginID int, revision int) (*db.PluginRevision, error) {
+	history := m.revisions[pluginID]
+	if revision < 1 || revision > len(history) {
+		return nil, sql.ErrNoRows
+	}
+	found := history[revision-1]
+	return &found, nil
+}
+
+func (m *mockPluginStore) RestoreRevision(pluginID int, revision int) (*db.PluginRevision, error) {
+	old, err := m.GetRevision(pluginID, revision)
+	if err != nil {
+		return nil, err
+	}
+	p := m.plugins[pluginID]
+	p.Name = old.Name
+	p.Code = old.Code
+	return m.recordRevision(pluginID, &old.Revision), nil
+}
+
+func TestHandlers_Revisions(t *testing.T) {
+	deps := setupTestDeps()
+	plugin := &db.Plugin{Name: "Test", Code: "console.log(1)\n"}
+	if err := deps.store.Create(plugin); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+	if err := deps.store.UpdateCode(plugin.ID, db.PluginUpdate{Name: "Renamed", Code: "console.log(2)\n", MissedRunPolicy: "skip", Runtime: "bun"}); err != nil {
+		t.Fatalf("Failed to update plugin: %v", err)
+	}
+
+	t.Run("List", func(t *testing.T) {
+		status, body := doJSON(t, deps.app, "GET", "/api/plugins/1/revisions", "")
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusOK, status)
+		}
+		var revisions []db.PluginRevision
+		if err := json.Unmarshal(body, &revisions); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(revisions) != 2 || revisions[0].Revision != 2 {
+			t.Errorf("Unexpected revisions: %+v", revisions)
+		}
+	})
+
+	t.Run("Unknown Plugin", func(t *testing.T) {
+		if status, _ := doJSON(t, deps.app, "GET", "/api/plugins/99/revisions", ""); status != fiber.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", fiber.StatusNotFound, status)
+		}
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		status, body := doJSON(t, deps.app, "GET", "/api/plugins/1/revisions/diff?from=1&to=2", "")
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusOK, status, body)
+		}
+		var result RevisionDiff
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if !strings.Contains(result.Diff, "-console.log(1)\n+console.log(2)\n") {
+			t.Errorf("Unexpected diff:\n%s", result.Diff)
+		}
+		if len(result.Changes) != 1 || result.Changes[0].Field != "name" {
+			t.Errorf("Expected only the name to change, got %+v", result.Changes)
+		}
+	})
+
+	t.Run("Diff Against Latest", func(t *testing.T) {
+		status, body := doJSON(t, deps.app, "GET", "/api/plugins/1/revisions/diff?from=1", "")
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusOK, status)
+		}
+		var result RevisionDiff
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if result.To.Revision != 2 {
+			t.Errorf("Expected comparison with revision 2, got %d", result.To.Revision)
+		}
+	})
+
+	t.Run("Invalid Revision", func(t *testing.T) {
+		if status, _ := doJSON(t, deps.app, "GET", "/api/plugins/1/revisions/diff?from=abc", ""); status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", fiber.StatusBadRequest, status)
+		}
+		if status, _ := doJSON(t, deps.app, "GET", "/api/plugins/1/revisions/7", ""); status != fiber.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", fiber.StatusNotFound, status)
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		status, body := doJSON(t, deps.app, "POST", "/api/plugins/1/revisions/1/restore", "")
+		if status != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusCreated, status)
+		}
+		var restored db.PluginRevision
+		if err := json.Unmarshal(body, &restored); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if restored.Revision != 3 || restored.RestoredFrom == nil || *restored.RestoredFrom != 1 {
+			t.Errorf("Unexpected restored revision: %+v", restored)
+		}
+		if deps.store.plugins[1].Code != "console.log(1)\n" {
+			t.Errorf("Expected plugin code to be restored, got %q", deps.store.plugins[1].Code)
+		}
+		if len(deps.runner.stopped) == 0 || deps.runner.stopped[len(deps.runner.stopped)-1] != 1 {
+			t.Errorf("Expected the resident process to be stopped, got %v", deps.runner.stopped)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bundeck/internal/secrets"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetSecrets lists the names of all secrets, values are never returned
+func (h *Handlers) GetSecrets(c *fiber.Ctx) error {
+	list, err := h.secrets.List()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if list == nil {
+		list = []secrets.Info{}
+	}
+
+	return c.JSON(list)
+}
+
+// PutSecret creates or replaces the value of a secret
+func (h *Handlers) PutSecret(c *fiber.Ctx) error {
+	name := c.Params("name")
+
+	var body struct {
+		Value *string `json:"value"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Value == nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Request body must contain a value",
+		})
+	}
+
+	if err := h.secrets.Set(name, *body.Value); err != nil {
+		if errors.Is(err, secrets.ErrInvalidName) || errors.Is(err, secrets.ErrReservedName) {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"name": name,
+	})
+}
+
+func (h *Handlers) DeleteSecret(c *fiber.Ctx) error {
+	if err := h.secrets.Delete(c.Params("name")); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Secret not found",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.SendStatus(http.StatusOK)
+}
+
+// GetPluginSecrets lists the names of the secrets granted to a plugin
+func (h *Handlers) GetPluginSecrets(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+	if _, err := h.store.GetByID(id); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Plugin not found",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	names, err := h.secrets.PluginSecrets(id)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if names == nil {
+		names = []string{}
+	}
+
+	return c.JSON(fiber.Map{
+		"secrets": names,
+	})
+}
+
+// UpdatePluginSecrets replaces the secrets granted to a plugin. The plugin
+// only receives these secrets in its environment.
+func (h *Handlers) UpdatePluginSecrets(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+
+	var body struct {
+		Secrets []string `json:"secrets"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Secrets == nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Request body must contain a list of secrets",
+		})
+	}
+	if _, err := h.store.GetByID(id); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Plugin not found",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := h.secrets.SetPluginSecrets(id, body.Secrets); err != nil {
+		if errors.Is(err, secrets.ErrUnknownSecret) {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"secrets": body.Secrets,
+	})
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"bundeck/internal/db"
+	"bundeck/internal/secrets"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type mockSecretManager struct {
+	values map[string]string
+	grants map[int][]string
+}
+
+func newMockSecretManager() *mockSecretManager {
+	return &mockSecretManager{values: make(map[string]string), grants: make(map[int][]string)}
+}
+
+func (m *mockSecretManager) List() ([]secrets.Info, error) {
+	var list []secrets.Info
+	for name := range m.values {
+		list = append(list, secrets.Info{Name: name})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (m *mockSecretManager) Set(name string, value string) error {
+	if !secrets.ValidName(name) {
+		return secrets.ErrInvalidName
+	}
+	// Fiber reuses the memory of route parameters after the request
+	m.values[strings.Clone(name)] = value
+	return nil
+}
+
+func (m *mockSecretManager) SetForPlugin(pluginID int, name string, value string) error {
+	if err := m.Set(name, value); err != nil {
+		return err
+	}
+	if !slices.Contains(m.grants[pluginID], name) {
+		m.grants[pluginID] = append(m.grants[pluginID], name)
+	}
+	return nil
+}
+
+func (m *mockSecretManager) PluginSecrets(pluginID int) ([]string, error) {
+	return m.grants[pluginID], nil
+}
+
+func (m *mockSecretManager) SetPluginSecrets(pluginID int, names []string) error {
+	for _, name := range names {
+		if _, ok := m.values[name]; !ok {
+			return secrets.ErrUnknownSecret
+		}
+	}
+	m.grants[pluginID] = names
+	return nil
+}
+
+func (m *mockSecretManager) Delete(name string) error {
+	if _, ok := m.values[name]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.values, name)
+	return nil
+}
+
+func TestHandlers_Secrets(t *testing.T) {
+	deps := setupTestDeps()
+	app := deps.app
+
+	t.Run("Put Secret", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/api/secrets/OBS_PASSWORD", strings.NewReader(`{"value":"hunter2"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusOK {
+			t.Errorf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if strings.Contains(string(body), "hunter2") {
+			t.Errorf("Response must not contain the secret value: %s", body)
+		}
+		if deps.secrets.values["OBS_PASSWORD"] != "hunter2" {
+			t.Errorf("Expected secret to be stored, got %q", deps.secrets.values["OBS_PASSWORD"])
+		}
+	})
+
+	t.Run("Invalid Name", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/api/secrets/1-bad", strings.NewReader(`{"value":"x"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("Reserved Name", func(t *testing.T) {
+		for _, name := range []string{"PATH", "LD_PRELOAD", "NODE_OPTIONS", "BUNDECK_INPUT"} {
+			status, _ := doJSON(t, app, "PUT", "/api/secrets/"+name, `{"value":"x"}`)
+			if status != fiber.StatusBadRequest {
+				t.Errorf("%s: expected status %d, got %d", name, fiber.StatusBadRequest, status)
+			}
+		}
+	})
+
+	t.Run("Missing Value", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/api/secrets/OBS_PASSWORD", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", fiber.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("List Secrets", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/secrets", nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		if strings.Contains(string(body), "hunter2") {
+			t.Errorf("Response must not contain the secret value: %s", body)
+		}
+		var list []secrets.Info
+		if err := json.Unmarshal(body, &list); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(list) != 1 || list[0].Name != "OBS_PASSWORD" {
+			t.Errorf("Expected only OBS_PASSWORD, got %v", list)
+		}
+	})
+
+	t.Run("Plugin Secrets", func(t *testing.T) {
+		plugin := &db.Plugin{Name: "OBS", Code: "process.env.OBS_PASSWORD"}
+		deps.store.Create(plugin)
+		path := fmt.Sprintf("/api/plugins/%d/secrets", plugin.ID)
+
+		// Mentioning a secret in the code does not grant it
+		status, data := doJSON(t, app, "GET", path, "")
+		if status != fiber.StatusOK || string(data) != `{"secrets":[]}` {
+			t.Errorf("Expected no secrets, got %d %s", status, data)
+		}
+
+		status, data = doJSON(t, app, "PUT", path, `{"secrets":["OBS_PASSWORD"]}`)
+		if status != fiber.StatusOK {
+			t.Errorf("Expected status %d, got %d %s", fiber.StatusOK, status, data)
+		}
+		status, data = doJSON(t, app, "GET", path, "")
+		if status != fiber.StatusOK || string(data) != `{"secrets":["OBS_PASSWORD"]}` {
+			t.Errorf("Expected the granted secret, got %d %s", status, data)
+		}
+
+		if status, _ := doJSON(t, app, "PUT", path, `{"secrets":["MISSING"]}`); status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d for an unknown secret, got %d", fiber.StatusBadRequest, status)
+		}
+		if status, _ := doJSON(t, app, "PUT", path, `{}`); status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d without a list, got %d", fiber.StatusBadRequest, status)
+		}
+		if status, _ := doJSON(t, app, "GET", "/api/plugins/999/secrets", ""); status != fiber.StatusNotFound {
+			t.Errorf("Expected status %d for a missing plugin, got %d", fiber.StatusNotFound, status)
+		}
+	})
+
+	t.Run("Delete Secret", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/api/secrets/OBS_PASSWORD", nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			t.Errorf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+		}
+
+		req = httptest.NewRequest("DELETE", "/api/secrets/OBS_PASSWORD", nil)
+		resp, err = app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to test request: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", fiber.StatusNotFound, resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"bundeck/internal/settings"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetSettings returns the settings file, the settings overridden by the
+// environment or flags and the changes waiting for a restart
+func (h *Handlers) GetSettings(c *fiber.Ctx) error {
+	if Settings == nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Settings not loaded",
+		})
+	}
+	return c.JSON(Settings.State())
+}
+
+// UpdateSettings saves the settings file. Settings missing from the body
+// keep their current values, unknown ones are rejected.
+func (h *Handlers) UpdateSettings(c *fiber.Ctx) error {
+	if Settings == nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Settings not loaded",
+		})
+	}
+
+	s := *Settings.State().Settings
+	decoder := json.NewDecoder(bytes.NewReader(c.Body()))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	state, err := Settings.Update(&s)
+	if err != nil {
+		var invalid *settings.ValidationError
+		if errors.As(err, &invalid) {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error":  err.Error(),
+				"fields": invalid.Fields,
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(state)
+}
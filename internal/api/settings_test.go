@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bundeck/internal/settings"
+	"encoding/json"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestHandlers_GetSettings(t *testing.T) {
+	deps := setupTestDeps()
+
+	if status, _ := doJSON(t, deps.app, "GET", "/api/settings", ""); status != fiber.StatusNotFound {
+		t.Errorf("Expected status %d before the settings are loaded, got %d", fiber.StatusNotFound, status)
+	}
+
+	setupTestSettings(t)
+	status, body := doJSON(t, deps.app, "GET", "/api/settings", "")
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status %d, got %d", fiber.StatusOK, status)
+	}
+	var state settings.State
+	if err := json.Unmarshal(body, &state); err != nil {
+		t.Fatalf("Failed to decode settings: %v", err)
+	}
+	if state.Settings.Port != settings.DefaultPort || len(state.Overridden) != 1 || state.Overridden[0] != "port" {
+		t.Errorf("Expected the file's port overridden by the environment, got %+v", state)
+	}
+}
+
+func TestHandlers_UpdateSettings(t *testing.T) {
+	deps := setupTestDeps()
+	manager := setupTestSettings(t)
+
+	// Settings missing from the body keep their values
+	status, body := doJSON(t, deps.app, "PUT", "/api/settings", `{"bind_address": "127.0.0.1", "history": {"max_runs_per_plugin": 10, "max_age_days": 0}}`)
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", fiber.StatusOK, status, body)
+	}
+	var state settings.State
+	if err := json.Unmarshal(body, &state); err != nil {
+		t.Fatalf("Failed to decode settings: %v", err)
+	}
+	if state.Settings.BindAddress != "127.0.0.1" || state.Settings.History.MaxRunsPerPlugin != 10 || state.Settings.LogLevel != settings.LogInfo {
+		t.Errorf("Unexpected settings: %+v", state.Settings)
+	}
+	if len(state.RestartRequired) != 1 || state.RestartRequired[0] != "bind_address" {
+		t.Errorf("Expected the bind address to need a restart, got %v", state.RestartRequired)
+	}
+	if config := manager.Config(); config.Settings.BindAddress != "127.0.0.1" {
+		t.Errorf("Expected the bind address to be saved, got %q", config.Settings.BindAddress)
+	}
+
+	tests := []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{"Unknown Setting", `{"prot": 8080}`, ""},
+		{"Invalid Setting", `{"log_level": "verbose"}`, "log_level"},
+		{"Invalid Body", `{"port": "8080"}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := doJSON(t, deps.app, "PUT", "/api/settings", tt.body)
+			if status != fiber.StatusBadRequest {
+				t.Fatalf("Expected status %d, got %d: %s", fiber.StatusBadRequest, status, body)
+			}
+			var response struct {
+				Fields map[string]string `json:"fields"`
+			}
+			json.Unmarshal(body, &response)
+			if tt.field != "" && response.Fields[tt.field] == "" {
+				t.Errorf("Expected %s to be invalid, got %s", tt.field, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"bundeck/internal/db"
+	"bundeck/internal/templates"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PluginVariables are the template values of a plugin created from a
+// template
+type PluginVariables struct {
+	TemplateID      string `json:"template_id"`
+	TemplateVersion int    `json:"template_version"`
+	// Variables are the values the code was rendered with, without secrets
+	Variables map[string]any `json:"variables"`
+	// Template is the current version of the template, nil when it is no
+	// longer available
+	Template *templates.Template `json:"template"`
+	// UpdateAvailable is set when the template has a newer version than the
+	// one the plugin was rendered from
+	UpdateAvailable bool `json:"update_available"`
+}
+
+// templateValues returns the values kept on the plugin, leaving out secrets
+// which are stored encrypted
+func templateValues(template *templates.Template, values map[string]any) (json.RawMessage, error) {
+	kept := map[string]any{}
+	for name, value := range values {
+		if variable, ok := template.Variables[name]; ok && !variable.IsSecret() && value != nil {
+			kept[name] = value
+		}
+	}
+	return json.Marshal(kept)
+}
+
+// pluginVariables describes the template state of a plugin
+func pluginVariables(plugin *db.Plugin) (*PluginVariables, error) {
+	result := &PluginVariables{
+		TemplateID:      *plugin.TemplateID,
+		TemplateVersion: plugin.TemplateVersion,
+		Variables:       map[string]any{},
+	}
+	if len(plugin.TemplateVariables) > 0 {
+		if err := json.Unmarshal(plugin.TemplateVariables, &result.Variables); err != nil {
+			return nil, err
+		}
+	}
+	if template, ok := Templates.Get(result.TemplateID); ok {
+		result.Template = template
+		result.UpdateAvailable = template.Version > plugin.TemplateVersion
+	}
+	return result, nil
+}
+
+var (
+	errInvalidPluginID = errors.New("invalid plugin ID")
+	// errNotFromTemplate is returned for plugins that were not created from
+	// a template
+	errNotFromTemplate = errors.New("plugin was not created from a template")
+)
+
+// templatePlugin loads a plugin that was created from a template
+func (h *Handlers) templatePlugin(c *fiber.Ctx) (*db.Plugin, error) {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return nil, errInvalidPluginID
+	}
+	plugin, err := h.store.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if plugin.TemplateID == nil {
+		return nil, errNotFromTemplate
+	}
+	return plugin, nil
+}
+
+// variablesError maps errors of the variables routes to HTTP responses
+func variablesError(c *fiber.Ctx, err error) error {
+	switch {
+	case err == errInvalidPluginID:
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	case err == sql.ErrNoRows:
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Plugin not found",
+		})
+	case err == errNotFromTemplate:
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Plugin was not created from a template",
+		})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// GetPluginVariables returns the template values of a plugin together with
+// the current template, which tells whether an update is available
+func (h *Handlers) GetPluginVariables(c *fiber.Ctx) error {
+	plugin, err := h.templatePlugin(c)
+	if err != nil {
+		return variablesError(c, err)
+	}
+
+	result, err := pluginVariables(plugin)
+	if err != nil {
+		return variablesError(c, err)
+	}
+	return c.JSON(result)
+}
+
+// UpdatePluginVariables renders the plugin's code again from the current
+// version of its template with changed values. Changes made to the code by hand
+// are replaced, the previous code stays in the plugin's history.
+func (h *Handlers) UpdatePluginVariables(c *fiber.Ctx) error {
+	plugin, err := h.templatePlugin(c)
+	if err != nil {
+		return variablesError(c, err)
+	}
+
+	var body struct {
+		Variables map[string]any `json:"variables"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	template, ok := Templates.Get(*plugin.TemplateID)
+	if !ok {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "Template not found",
+		})
+	}
+
+	// Variables left out keep their stored value, null resets a variable to
+	// the template's default
+	values := map[string]any{}
+	if len(plugin.TemplateVariables) > 0 {
+		if err := json.Unmarshal(plugin.TemplateVariables, &values); err != nil {
+			return variablesError(c, err)
+		}
+	}
+	for name := range values {
+		if _, ok := template.Variables[name]; !ok {
+			// The variable was removed from the template
+			delete(values, name)
+		}
+	}
+	for name, value := range body.Variables {
+		values[name] = value
+	}
+
+	stored, err := h.storedSecrets(template, plugin.ID)
+	if err != nil {
+		return variablesError(c, err)
+	}
+	if err := template.Validate(values, stored); err != nil {
+		return templateError(c, err)
+	}
+	if err := h.storeTemplateSecrets(template, values, plugin.ID); err != nil {
+		return variablesError(c, err)
+	}
+
+	source, err := template.ReadFile()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read template source",
+		})
+	}
+	code, err := template.Render(string(source), values, plugin.ID)
+	if err != nil {
+		return templateError(c, err)
+	}
+	kept, err := templateValues(template, values)
+	if err != nil {
+		return variablesError(c, err)
+	}
+
+	if err := h.store.UpdateTemplate(plugin.ID, code, template.Version, kept); err != nil {
+		return variablesError(c, err)
+	}
+	h.scheduler.Reload(plugin.ID)
+	// The next press starts a process with the new code
+	h.runner.StopResident(plugin.ID)
+
+	updated, err := h.store.GetByID(plugin.ID)
+	if err != nil {
+		return variablesError(c, err)
+	}
+	result, err := pluginVariables(updated)
+	if err != nil {
+		return variablesError(c, err)
+	}
+	return c.JSON(result)
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"bundeck/internal/db"
+	"bundeck/internal/templates"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (m *mockPluginStore) UpdateTemplate(id int, code string, templateVersion int, variables json.RawMessage) error {
+	if m.templateErr != nil {
+		return m.templateErr
+	}
+	p, ok := m.plugins[id]
+	if !ok || p.TemplateID == nil {
+		return sql.ErrNoRows
+	}
+	p.Code = code
+	p.TemplateVersion = templateVersion
+	p.TemplateVariables = variables
+	m.recordRevision(id, nil)
+	return nil
+}
+
+// writeTemplate writes a template directory with a single OBS template of
+// the given version. Each version has a list.json of a different size so the
+// change is noticed even where modification times are coarse.
+func writeTemplate(t *testing.T, dir string, version int) {
+	t.Helper()
+	list := `{"OBS": {"plugins": [{
+		"id": "obs",
+		"title": "OBS",
+		"file": "obs.ts",
+		"description": "` + strings.Repeat("New. ", version-1) + `",
+		"version": ` + strconv.Itoa(version) + `,
+		"variables": {
+			"PORT": {"type": "number", "default": 4455, "required": true, "min": 1, "max": 65535},
+			"SCENE": {"type": "string", "default": "Main"},
+			"PASSWORD": {"type": "secret", "default": ""}
+		}
+	}]}}`
+	code := "const PORT = 4455;\nconst SCENE = \"Main\";\nconst PASSWORD = \"\";\n"
+	if version > 1 {
+		code += "// version 2\n"
+	}
+	if err := os.WriteFile(filepath.Join(dir, "list.json"), []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "obs.ts"), []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandlers_PluginVariables(t *testing.T) {
+	deps := setupTestDeps()
+	app := deps.app
+
+	dir := t.TempDir()
+	writeTemplate(t, dir, 1)
+	originalTemplates := Templates
+	Templates = templates.NewCatalog(templates.Root{Source: dir, FS: os.DirFS(dir)})
+	defer func() { Templates = originalTemplates }()
+
+	var plugin db.Plugin
+	t.Run("Create stores the template and values", func(t *testing.T) {
+		status, body := doJSON(t, app, "POST", "/api/plugins/templates/create", `{"templateId":"obs","variables":{"PORT":1234,"SCENE":"Live","PASSWORD":"hunter2"}}`)
+		if status != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusCreated, status, body)
+		}
+		if err := json.Unmarshal(body, &plugin); err != nil {
+			t.Fatalf("Failed to decode plugin: %v", err)
+		}
+		if plugin.TemplateID == nil || *plugin.TemplateID != "obs" || plugin.TemplateVersion != 1 {
+			t.Errorf("Expected template obs version 1, got %v %d", plugin.TemplateID, plugin.TemplateVersion)
+		}
+		if strings.Contains(string(plugin.TemplateVariables), "hunter2") {
+			t.Error("Expected the secret value to be left out of the stored variables")
+		}
+		if deps.secrets.values["OBS_1_PASSWORD"] != "hunter2" || !strings.Contains(plugin.Code, "process.env.OBS_1_PASSWORD") {
+			t.Errorf("Expected the plugin to read its own secret, got %v and %s", deps.secrets.values, plugin.Code)
+		}
+		if grants := deps.secrets.grants[1]; len(grants) != 1 || grants[0] != "OBS_1_PASSWORD" {
+			t.Errorf("Expected the secret to be granted to the plugin, got %v", grants)
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		status, body := doJSON(t, app, "GET", "/api/plugins/1/variables", "")
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusOK, status, body)
+		}
+		var result PluginVariables
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Fatalf("Failed to decode variables: %v", err)
+		}
+		if result.Variables["PORT"] != float64(1234) || result.Variables["SCENE"] != "Live" {
+			t.Errorf("Expected the stored values, got %v", result.Variables)
+		}
+		if _, ok := result.Variables["PASSWORD"]; ok {
+			t.Error("Expected no secret value")
+		}
+		if result.Template == nil || result.UpdateAvailable {
+			t.Errorf("Expected the current template without an update, got %+v", result)
+		}
+	})
+
+	t.Run("Invalid values", func(t *testing.T) {
+		status, body := doJSON(t, app, "PUT", "/api/plugins/1/variables", `{"variables":{"PORT":70000}}`)
+		if status != fiber.StatusBadRequest {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusBadRequest, status)
+		}
+		var result struct {
+			Fields map[string]string `json:"fields"`
+		}
+		json.Unmarshal(body, &result)
+		if result.Fields["PORT"] == "" {
+			t.Errorf("Expected a field error for PORT, got %s", body)
+		}
+	})
+
+	t.Run("Update re-renders the code", func(t *testing.T) {
+		// SCENE keeps its stored value
+		revisions := len(deps.store.revisions[1])
+		status, body := doJSON(t, app, "PUT", "/api/plugins/1/variables", `{"variables":{"PORT":5555}}`)
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusOK, status, body)
+		}
+		code := deps.store.plugins[1].Code
+		for _, expected := range []string{"const PORT = 5555;", `const SCENE = "Live";`, "const PASSWORD = process.env.OBS_1_PASSWORD;"} {
+			if !strings.Contains(code, expected) {
+				t.Errorf("Expected code to contain %q, got %s", expected, code)
+			}
+		}
+		if len(deps.store.revisions[1]) != revisions+1 {
+			t.Errorf("Expected the update to be recorded as a revision, got %d revisions", len(deps.store.revisions[1]))
+		}
+	})
+
+	t.Run("Upgrade", func(t *testing.T) {
+		writeTemplate(t, dir, 2)
+
+		status, body := doJSON(t, app, "GET", "/api/plugins/1/variables", "")
+		var result PluginVariables
+		json.Unmarshal(body, &result)
+		if status != fiber.StatusOK || !result.UpdateAvailable || result.Template.Version != 2 {
+			t.Fatalf("Expected an update to version 2, got %d %s", status, body)
+		}
+
+		_, body = doJSON(t, app, "GET", "/api/plugins", "")
+		var plugins []PluginResponse
+		json.Unmarshal(body, &plugins)
+		if len(plugins) != 1 || !plugins[0].TemplateUpdateAvailable {
+			t.Errorf("Expected the plugin list to show the update, got %s", body)
+		}
+
+		status, body = doJSON(t, app, "PUT", "/api/plugins/1/variables", `{"variables":{"SCENE":null}}`)
+		json.Unmarshal(body, &result)
+		if status != fiber.StatusOK || result.UpdateAvailable || result.TemplateVersion != 2 {
+			t.Fatalf("Expected the plugin to be upgraded, got %d %s", status, body)
+		}
+		code := deps.store.plugins[1].Code
+		if !strings.Contains(code, "// version 2") || !strings.Contains(code, "const PORT = 5555;") {
+			t.Errorf("Expected the code to be rendered from the new version, got %s", code)
+		}
+		if !strings.Contains(code, `const SCENE = "Main";`) {
+			t.Errorf("Expected null to reset SCENE to the template default, got %s", code)
+		}
+	})
+
+	t.Run("Plugin without template", func(t *testing.T) {
+		deps.store.Create(&db.Plugin{Name: "Manual", Code: "1"})
+		status, _ := doJSON(t, app, "GET", "/api/plugins/2/variables", "")
+		if status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", fiber.StatusBadRequest, status)
+		}
+		status, _ = doJSON(t, app, "GET", "/api/plugins/99/variables", "")
+		if status != fiber.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", fiber.StatusNotFound, status)
+		}
+	})
+
+	t.Run("Secrets of another instance", func(t *testing.T) {
+		status, body := doJSON(t, app, "POST", "/api/plugins/templates/create", `{"templateId":"obs","variables":{"PORT":4455,"SCENE":"Studio","PASSWORD":"swordfish"}}`)
+		if status != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusCreated, status, body)
+		}
+		var other db.Plugin
+		json.Unmarshal(body, &other)
+		name := fmt.Sprintf("OBS_%d_PASSWORD", other.ID)
+		if !strings.Contains(other.Code, "process.env."+name) {
+			t.Errorf("Expected the second plugin to read %s, got %s", name, other.Code)
+		}
+		if deps.secrets.values[name] != "swordfish" || deps.secrets.values["OBS_1_PASSWORD"] != "hunter2" {
+			t.Errorf("Expected each plugin to keep its own password, got %v", deps.secrets.values)
+		}
+
+		// Changing the first plugin's password leaves the second one alone
+		if status, body := doJSON(t, app, "PUT", "/api/plugins/1/variables", `{"variables":{"PASSWORD":"letmein"}}`); status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusOK, status, body)
+		}
+		if deps.secrets.values["OBS_1_PASSWORD"] != "letmein" || deps.secrets.values[name] != "swordfish" {
+			t.Errorf("Expected only the first password to change, got %v", deps.secrets.values)
+		}
+	})
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"bundeck/internal/plugin"
+	"bundeck/internal/webhooks"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// WebhookCredentials are returned once when a webhook is created or rotated
+type WebhookCredentials struct {
+	*webhooks.Credentials
+	// URL is the address other systems call to run the plugin
+	URL string `json:"url"`
+}
+
+func webhookCredentials(c *fiber.Ctx, credentials *webhooks.Credentials) WebhookCredentials {
+	return WebhookCredentials{
+		Credentials: credentials,
+		URL:         c.BaseURL() + "/api/hooks/" + credentials.Token,
+	}
+}
+
+// webhookError maps errors of the webhook routes to HTTP responses, using
+// notFound as the message for sql.ErrNoRows
+func webhookError(c *fiber.Ctx, err error, notFound string) error {
+	switch {
+	case err == sql.ErrNoRows:
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": notFound,
+		})
+	case errors.Is(err, webhooks.ErrInvalidMode):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// GetPluginWebhooks lists the webhooks of a plugin without their tokens
+func (h *Handlers) GetPluginWebhooks(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+	if _, err := h.store.GetByID(id); err != nil {
+		return webhookError(c, err, "Plugin not found")
+	}
+
+	list, err := h.webhooks.List(id)
+	if err != nil {
+		return webhookError(c, err, "Webhook not found")
+	}
+	if list == nil {
+		list = []webhooks.Webhook{}
+	}
+	return c.JSON(list)
+}
+
+// CreatePluginWebhook adds a webhook to a plugin. The token and signing
+// secret are only part of this response.
+func (h *Handlers) CreatePluginWebhook(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plugin ID",
+		})
+	}
+
+	var body struct {
+		Name   string `json:"name"`
+		Mode   string `json:"mode"`
+		Signed bool   `json:"signed"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if body.Mode == "" {
+		body.Mode = webhooks.ModeSync
+	}
+
+	if _, err := h.store.GetByID(id); err != nil {
+		return webhookError(c, err, "Plugin not found")
+	}
+
+	credentials, err := h.webhooks.Create(id, body.Name, body.Mode, body.Signed)
+	if err != nil {
+		return webhookError(c, err, "Webhook not found")
+	}
+	return c.Status(http.StatusCreated).JSON(webhookCredentials(c, credentials))
+}
+
+// UpdateWebhook renames a webhook and changes its response mode
+func (h *Handlers) UpdateWebhook(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid webhook ID",
+		})
+	}
+
+	var body struct {
+		Name string `json:"name"`
+		Mode string `json:"mode"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	webhook, err := h.webhooks.Update(id, body.Name, body.Mode)
+	if err != nil {
+		return webhookError(c, err, "Webhook not found")
+	}
+	return c.JSON(webhook)
+}
+
+// RotateWebhook replaces the token of a webhook so the old URL stops working,
+// together with its signing secret when the webhook stays signed
+func (h *Handlers) RotateWebhook(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid webhook ID",
+		})
+	}
+
+	var body struct {
+		Signed bool `json:"signed"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	credentials, err := h.webhooks.Rotate(id, body.Signed)
+	if err != nil {
+		return webhookError(c, err, "Webhook not found")
+	}
+	return c.JSON(webhookCredentials(c, credentials))
+}
+
+func (h *Handlers) DeleteWebhook(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid webhook ID",
+		})
+	}
+
+	if err := h.webhooks.Delete(id); err != nil {
+		return webhookError(c, err, "Webhook not found")
+	}
+	return c.SendStatus(http.StatusOK)
+}
+
+// CallWebhook runs the plugin of the webhook owning the token in the URL. It
+// needs no device token, signed webhooks check the signature of the body
+// instead. The body and query are passed to the plugin as its input.
+func (h *Handlers) CallWebhook(c *fiber.Ctx) error {
+	var signature string
+	for _, header := range webhooks.SignatureHeaders {
+		if signature = c.Get(header); signature != "" {
+			break
+		}
+	}
+
+	webhook, err := h.webhooks.Authenticate(c.Params("token"), c.Body(), signature)
+	if err != nil {
+		switch {
+		case errors.Is(err, webhooks.ErrUnknownToken):
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "Webhook not found",
+			})
+		case errors.Is(err, webhooks.ErrInvalidSignature):
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	row, err := h.store.GetByID(webhook.PluginID)
+	if err != nil {
+		return webhookError(c, err, "Plugin not found")
+	}
+
+	query := map[string][]string{}
+	c.Context().QueryArgs().VisitAll(func(key []byte, value []byte) {
+		query[string(key)] = append(query[string(key)], string(value))
+	})
+	input, err := webhooks.NewInput(c.Body(), c.Get(fiber.HeaderContentType), query)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	req := plugin.NewRequest(row, plugin.TriggerWebhook)
+	req.Input = input
+
+	if webhook.Mode == webhooks.ModeSync {
+		result, err := h.runner.Execute(c.UserContext(), req)
+		if err != nil {
+			return runError(c, result, err)
+		}
+		return c.SendString(result.Output)
+	}
+
+	// The run outlives the request, so answer as soon as it has an ID
+	started := make(chan int64, 1)
+	req.OnStart = func(run *plugin.Run) {
+		started <- run.ID
+	}
+	type outcome struct {
+		result *plugin.Result
+		err    error
+	}
+	finished := make(chan outcome, 1)
+	go func() {
+		result, err := h.runner.Execute(context.Background(), req)
+		finished <- outcome{result, err}
+	}()
+
+	select {
+	case runID := <-started:
+		return accepted(c, runID)
+	case done := <-finished:
+		select {
+		case runID := <-started:
+			// The run finished before the response was sent
+			return accepted(c, runID)
+		default:
+		}
+		if done.err != nil {
+			return runError(c, done.result, done.err)
+		}
+		return accepted(c, done.result.RunID)
+	}
+}
+
+func accepted(c *fiber.Ctx, runID int64) error {
+	return c.Status(http.StatusAccepted).JSON(fiber.Map{
+		"run_id": runID,
+		"status": plugin.StatusRunning,
+	})
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"bundeck/internal/db"
+	pluginpkg "bundeck/internal/plugin"
+	"bundeck/internal/webhooks"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type mockWebhookStore struct {
+	webhooks map[int]*db.Webhook
+	nextID   int
+}
+
+func newMockWebhookStore() *mockWebhookStore {
+	return &mockWebhookStore{webhooks: make(map[int]*db.Webhook)}
+}
+
+func (m *mockWebhookStore) Create(webhook *db.Webhook) error {
+	m.nextID++
+	webhook.ID = m.nextID
+	webhook.CreatedAt = time.Now()
+	stored := *webhook
+	m.webhooks[webhook.ID] = &stored
+	return nil
+}
+
+func (m *mockWebhookStore) GetByID(id int) (*db.Webhook, error) {
+	webhook, ok := m.webhooks[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	found := *webhook
+	return &found, nil
+}
+
+func (m *mockWebhookStore) GetByTokenHash(hash string) (*db.Webhook, error) {
+	for _, webhook := range m.webhooks {
+		if webhook.TokenHash == hash {
+			found := *webhook
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *mockWebhookStore) ListByPlugin(pluginID int) ([]db.Webhook, error) {
+	var list []db.Webhook
+	for id := 1; id <= m.nextID; id++ {
+		if webhook, ok := m.webhooks[id]; ok && webhook.PluginID == pluginID {
+			list = append(list, *webhook)
+		}
+	}
+	return list, nil
+}
+
+func (m *mockWebhookStore) Update(webhook *db.Webhook) error {
+	if _, ok := m.webhooks[webhook.ID]; !ok {
+		return sql.ErrNoRows
+	}
+	stored := *webhook
+	m.webhooks[webhook.ID] = &stored
+	return nil
+}
+
+func (m *mockWebhookStore) Touch(id int, used time.Time) error {
+	return nil
+}
+
+func (m *mockWebhookStore) Delete(id int) error {
+	if _, ok := m.webhooks[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.webhooks, id)
+	return nil
+}
+
+// plainCipher stores signing secrets as they are
+type plainCipher struct{}
+
+func (plainCipher) Seal(label string, value []byte) ([]byte, error) {
+	return value, nil
+}
+
+func (plainCipher) Open(label string, sealed []byte) ([]byte, error) {
+	return sealed, nil
+}
+
+// callWebhook posts body to a webhook URL without a device token
+func callWebhook(t *testing.T, app *fiber.App, url string, body string, signature string) (int, []byte) {
+	t.Helper()
+	req := httptest.NewRequest("POST", url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if signature != "" {
+		req.Header.Set("X-Hub-Signature-256", signature)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to test request: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, data
+}
+
+func TestHandlers_Webhooks(t *testing.T) {
+	deps := setupTestDeps()
+	// Webhook calls come from other machines without a device token
+	app := setupSecuredApp(deps)
+	deps.store.plugins[1] = &db.Plugin{ID: 1, Name: "Test", Code: "console.log('test')", TimeoutSeconds: 5}
+
+	create := func(t *testing.T, body string) WebhookCredentials {
+		t.Helper()
+		status, data := doJSON(t, deps.app, "POST", "/api/plugins/1/webhooks", body)
+		if status != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusCreated, status, data)
+		}
+		var created WebhookCredentials
+		if err := json.Unmarshal(data, &created); err != nil {
+			t.Fatalf("Failed to decode webhook: %v", err)
+		}
+		return created
+	}
+	path := func(created WebhookCredentials) string {
+		return "/api/hooks/" + created.Token
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		created := create(t, `{"name":"CI"}`)
+		if created.Token == "" || created.Mode != webhooks.ModeSync || created.Signed {
+			t.Errorf("Expected an unsigned sync webhook, got %+v", created.Credentials)
+		}
+		if !strings.HasSuffix(created.URL, path(created)) {
+			t.Errorf("Expected the URL to contain the token, got %q", created.URL)
+		}
+
+		status, _ := doJSON(t, deps.app, "POST", "/api/plugins/1/webhooks", `{"mode":"later"}`)
+		if status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d for an invalid mode, got %d", fiber.StatusBadRequest, status)
+		}
+		status, _ = doJSON(t, deps.app, "POST", "/api/plugins/99/webhooks", `{}`)
+		if status != fiber.StatusNotFound {
+			t.Errorf("Expected status %d for a missing plugin, got %d", fiber.StatusNotFound, status)
+		}
+	})
+
+	t.Run("List Hides Tokens", func(t *testing.T) {
+		status, data := doJSON(t, deps.app, "GET", "/api/plugins/1/webhooks", "")
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusOK, status)
+		}
+		if strings.Contains(string(data), "token") {
+			t.Errorf("Expected no tokens in the list, got %s", data)
+		}
+	})
+
+	t.Run("Sync Call", func(t *testing.T) {
+		created := create(t, `{"mode":"sync"}`)
+		status, data := callWebhook(t, app, path(created)+"?scene=intro", `{"ref":"main"}`, "")
+		if status != fiber.StatusOK || string(data) != "test output" {
+			t.Fatalf("Expected the output, got %d: %s", status, data)
+		}
+		if deps.runner.trigger != pluginpkg.TriggerWebhook || deps.runner.timeout != 5*time.Second {
+			t.Errorf("Expected a webhook run with the plugin's timeout, got %q and %s", deps.runner.trigger, deps.runner.timeout)
+		}
+		expected := `{"body":{"ref":"main"},"query":{"scene":"intro"}}`
+		if string(deps.runner.input) != expected {
+			t.Errorf("Expected input %s, got %s", expected, deps.runner.input)
+		}
+	})
+
+	t.Run("Async Call", func(t *testing.T) {
+		created := create(t, `{"mode":"async"}`)
+		status, data := callWebhook(t, app, path(created), `{}`, "")
+		if status != fiber.StatusAccepted {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusAccepted, status, data)
+		}
+		var result struct {
+			RunID int64 `json:"run_id"`
+		}
+		if err := json.Unmarshal(data, &result); err != nil || result.RunID != 7 {
+			t.Errorf("Expected run ID 7, got %s", data)
+		}
+	})
+
+	t.Run("Failed Run", func(t *testing.T) {
+		created := create(t, `{}`)
+		deps.runner.err = pluginpkg.ErrSkipped
+		deps.runner.status = pluginpkg.StatusSkipped
+		defer func() { deps.runner.err = nil }()
+
+		status, _ := callWebhook(t, app, path(created), `{}`, "")
+		if status != fiber.StatusConflict {
+			t.Errorf("Expected status %d for a skipped run, got %d", fiber.StatusConflict, status)
+		}
+	})
+
+	t.Run("Signed", func(t *testing.T) {
+		created := create(t, `{"signed":true}`)
+		if created.SigningSecret == "" || !created.Signed {
+			t.Fatalf("Expected a signing secret, got %+v", created.Credentials)
+		}
+
+		body := `{"action":"push"}`
+		if status, _ := callWebhook(t, app, path(created), body, ""); status != fiber.StatusUnauthorized {
+			t.Errorf("Expected status %d without a signature, got %d", fiber.StatusUnauthorized, status)
+		}
+		signature := webhooks.Sign([]byte(created.SigningSecret), []byte(body))
+		if status, data := callWebhook(t, app, path(created), body, signature); status != fiber.StatusOK {
+			t.Errorf("Expected status %d with a signature, got %d: %s", fiber.StatusOK, status, data)
+		}
+	})
+
+	t.Run("Rotate And Delete", func(t *testing.T) {
+		created := create(t, `{}`)
+		status, data := doJSON(t, deps.app, "POST", "/api/webhooks/"+strconv.Itoa(created.ID)+"/rotate", `{}`)
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", fiber.StatusOK, status, data)
+		}
+		var rotated WebhookCredentials
+		if err := json.Unmarshal(data, &rotated); err != nil {
+			t.Fatalf("Failed to decode webhook: %v", err)
+		}
+		if status, _ := callWebhook(t, app, path(created), `{}`, ""); status != fiber.StatusNotFound {
+			t.Errorf("Expected the old token to stop working, got %d", status)
+		}
+
+		status, data = doJSON(t, deps.app, "PUT", "/api/webhooks/"+strconv.Itoa(created.ID), `{"name":"Home","mode":"async"}`)
+		if status != fiber.StatusOK || !strings.Contains(string(data), `"mode":"async"`) {
+			t.Errorf("Expected the webhook to be updated, got %d: %s", status, data)
+		}
+
+		if status, _ := doJSON(t, deps.app, "DELETE", "/api/webhooks/"+strconv.Itoa(created.ID), ""); status != fiber.StatusOK {
+			t.Errorf("Expected status %d, got %d", fiber.StatusOK, status)
+		}
+		if status, _ := callWebhook(t, app, path(rotated), `{}`, ""); status != fiber.StatusNotFound {
+			t.Errorf("Expected a deleted webhook to stop working, got %d", status)
+		}
+		if status, _ := doJSON(t, deps.app, "DELETE", "/api/webhooks/"+strconv.Itoa(created.ID), ""); status != fiber.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", fiber.StatusNotFound, status)
+		}
+	})
+
+	t.Run("Admin Routes Need A Token", func(t *testing.T) {
+		status, _ := callWebhook(t, app, "/api/plugins/1/webhooks", `{}`, "")
+		if status != fiber.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", fiber.StatusUnauthorized, status)
+		}
+	})
+}
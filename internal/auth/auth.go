@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"bundeck/internal/db"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Roles decide what a paired device may do
+const (
+	// RoleAdmin may use the whole API, including creating and editing plugins
+	RoleAdmin = "admin"
+	// RolePress may only view buttons and press them
+	RolePress = "press"
+)
+
+// PairingTTL is how long a pairing code can be used after it was created
+// unless the options change it
+const PairingTTL = 5 * time.Minute
+
+// touchInterval limits how often a device's last seen time is written
+const touchInterval = time.Minute
+
+var (
+	// ErrInvalidPairing is returned for unknown, used or expired pairing codes
+	ErrInvalidPairing = errors.New("pairing code is invalid or expired")
+	// ErrUnauthorized is returned for unknown or revoked device tokens
+	ErrUnauthorized = errors.New("invalid or revoked token")
+	// ErrInvalidRole is returned for roles other than RoleAdmin and RolePress
+	ErrInvalidRole = errors.New("role must be admin or press")
+	// ErrRemoteDisabled is returned for devices while remote access is off
+	ErrRemoteDisabled = errors.New("remote access is disabled")
+)
+
+// Options are the auth settings, they can change while the server runs
+type Options struct {
+	// PairingTTL is how long new pairing codes can be used
+	PairingTTL time.Duration
+	// RemoteAccess lets paired devices use the API, without it only this
+	// machine can
+	RemoteAccess bool
+}
+
+// DefaultOptions allow paired devices with codes valid for PairingTTL
+var DefaultOptions = Options{PairingTTL: PairingTTL, RemoteAccess: true}
+
+// Store persists paired devices
+type Store interface {
+	Create(device *db.Device) error
+	GetByTokenHash(hash string) (*db.Device, error)
+	List() ([]db.Device, error)
+	Touch(id int, seen time.Time) error
+	Delete(id int) error
+}
+
+// Pairing is a one-time code a new device exchanges for a device token
+type Pairing struct {
+	Code      string    `json:"code"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Manager hands out pairing codes and authenticates device tokens
+type Manager struct {
+	store Store
+
+	mu       sync.Mutex
+	pairings map[string]Pairing
+	options  Options
+}
+
+func NewManager(store Store) *Manager {
+	return &Manager{
+		store:    store,
+		pairings: make(map[string]Pairing),
+		options:  DefaultOptions,
+	}
+}
+
+// SetOptions changes the auth settings. Pairing codes already handed out
+// keep their expiry, turning off remote access locks out paired devices
+// until it is turned on again.
+func (m *Manager) SetOptions(options Options) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.options = options
+}
+
+// remoteAccess reports whether paired devices may use the API
+func (m *Manager) remoteAccess() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.options.RemoteAccess
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	return role == RoleAdmin || role == RolePress
+}
+
+// CreatePairing returns a new one-time pairing code granting role
+func (m *Manager) CreatePairing(role string) (Pairing, error) {
+	if !ValidRole(role) {
+		return Pairing{}, ErrInvalidRole
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		return Pairing{}, err
+	}
+
+	pairing := Pairing{
+		Code: code,
+		Role: role,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pairing.ExpiresAt = time.Now().Add(m.options.PairingTTL)
+
+	for code, p := range m.pairings {
+		if time.Now().After(p.ExpiresAt) {
+			delete(m.pairings, code)
+		}
+	}
+	m.pairings[pairing.Code] = pairing
+	return pairing, nil
+}
+
+// Pair redeems a pairing code and registers a new device. The returned token
+// is only available here, the store keeps a hash of it.
+func (m *Manager) Pair(code string, name string) (string, *db.Device, error) {
+	if !m.remoteAccess() {
+		return "", nil, ErrRemoteDisabled
+	}
+
+	m.mu.Lock()
+	pairing, ok := m.pairings[code]
+	delete(m.pairings, code)
+	m.mu.Unlock()
+
+	if !ok || time.Now().After(pairing.ExpiresAt) {
+		return "", nil, ErrInvalidPairing
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Unnamed device"
+	}
+	device := &db.Device{
+		Name:      name,
+		Role:      pairing.Role,
+		TokenHash: hashToken(token),
+	}
+	if err := m.store.Create(device); err != nil {
+		return "", nil, err
+	}
+	return token, device, nil
+}
+
+// Authenticate returns the device owning token
+func (m *Manager) Authenticate(token string) (*db.Device, error) {
+	if !m.remoteAccess() {
+		return nil, ErrRemoteDisabled
+	}
+	if token == "" {
+		return nil, ErrUnauthorized
+	}
+
+	device, err := m.store.GetByTokenHash(hashToken(token))
+	if err == sql.ErrNoRows {
+		return nil, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if device.LastSeenAt == nil || now.Sub(*device.LastSeenAt) > touchInterval {
+		if err := m.store.Touch(device.ID, now); err != nil {
+			return nil, err
+		}
+		device.LastSeenAt = &now
+	}
+	return device, nil
+}
+
+// Devices returns all paired devices
+func (m *Manager) Devices() ([]db.Device, error) {
+	return m.store.List()
+}
+
+// Revoke removes a device so its token stops working, returning
+// sql.ErrNoRows when it does not exist
+func (m *Manager) Revoke(id int) error {
+	return m.store.Delete(id)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"bundeck/internal/db"
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func setupTestManager(t *testing.T) *Manager {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if err := db.InitDB(database); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	return NewManager(db.NewDeviceStore(database))
+}
+
+func TestManager_Pair(t *testing.T) {
+	manager := setupTestManager(t)
+
+	pairing, err := manager.CreatePairing(RolePress)
+	if err != nil {
+		t.Fatalf("Failed to create pairing: %v", err)
+	}
+
+	token, device, err := manager.Pair(pairing.Code, "  Phone ")
+	if err != nil {
+		t.Fatalf("Failed to pair: %v", err)
+	}
+	if device.Name != "Phone" || device.Role != RolePress {
+		t.Errorf("Unexpected device: %+v", device)
+	}
+	if device.TokenHash == token {
+		t.Error("Token stored in plain text")
+	}
+
+	// Pairing codes can only be used once
+	if _, _, err := manager.Pair(pairing.Code, "Another"); err != ErrInvalidPairing {
+		t.Errorf("Expected ErrInvalidPairing reusing a code, got %v", err)
+	}
+
+	got, err := manager.Authenticate(token)
+	if err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	if got.ID != device.ID {
+		t.Errorf("Expected device %d, got %d", device.ID, got.ID)
+	}
+	if got.LastSeenAt == nil {
+		t.Error("Expected last seen time to be recorded")
+	}
+}
+
+func TestManager_ExpiredPairing(t *testing.T) {
+	manager := setupTestManager(t)
+
+	pairing, err := manager.CreatePairing(RoleAdmin)
+	if err != nil {
+		t.Fatalf("Failed to create pairing: %v", err)
+	}
+	pairing.ExpiresAt = time.Now().Add(-time.Second)
+	manager.pairings[pairing.Code] = pairing
+
+	if _, _, err := manager.Pair(pairing.Code, "Laptop"); err != ErrInvalidPairing {
+		t.Errorf("Expected ErrInvalidPairing for expired code, got %v", err)
+	}
+}
+
+func TestManager_InvalidRole(t *testing.T) {
+	manager := setupTestManager(t)
+
+	if _, err := manager.CreatePairing("owner"); err != ErrInvalidRole {
+		t.Errorf("Expected ErrInvalidRole, got %v", err)
+	}
+}
+
+func TestManager_Revoke(t *testing.T) {
+	manager := setupTestManager(t)
+
+	pairing, _ := manager.CreatePairing(RolePress)
+	token, device, err := manager.Pair(pairing.Code, "Phone")
+	if err != nil {
+		t.Fatalf("Failed to pair: %v", err)
+	}
+
+	if err := manager.Revoke(device.ID); err != nil {
+		t.Fatalf("Failed to revoke device: %v", err)
+	}
+	if _, err := manager.Authenticate(token); err != ErrUnauthorized {
+		t.Errorf("Expected ErrUnauthorized for revoked token, got %v", err)
+	}
+	if err := manager.Revoke(device.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows revoking twice, got %v", err)
+	}
+	if _, err := manager.Authenticate(""); err != ErrUnauthorized {
+		t.Errorf("Expected ErrUnauthorized for empty token, got %v", err)
+	}
+}
+
+func TestManager_SetOptions(t *testing.T) {
+	manager := setupTestManager(t)
+
+	manager.SetOptions(Options{PairingTTL: time.Minute, RemoteAccess: true})
+	pairing, err := manager.CreatePairing(RolePress)
+	if err != nil {
+		t.Fatalf("Failed to create pairing: %v", err)
+	}
+	if ttl := time.Until(pairing.ExpiresAt); ttl > time.Minute || ttl < 50*time.Second {
+		t.Errorf("Expected the pairing code to expire in a minute, got %v", ttl)
+	}
+	token, _, err := manager.Pair(pairing.Code, "Phone")
+	if err != nil {
+		t.Fatalf("Failed to pair: %v", err)
+	}
+
+	manager.SetOptions(Options{PairingTTL: time.Minute})
+	if _, err := manager.Authenticate(token); err != ErrRemoteDisabled {
+		t.Errorf("Expected ErrRemoteDisabled authenticating, got %v", err)
+	}
+	pairing, err = manager.CreatePairing(RolePress)
+	if err != nil {
+		t.Fatalf("Failed to create pairing: %v", err)
+	}
+	if _, _, err := manager.Pair(pairing.Code, "Tablet"); err != ErrRemoteDisabled {
+		t.Errorf("Expected ErrRemoteDisabled pairing, got %v", err)
+	}
+
+	// Devices paired before remote access was turned off work again
+	manager.SetOptions(DefaultOptions)
+	if _, err := manager.Authenticate(token); err != nil {
+		t.Errorf("Failed to authenticate after enabling remote access: %v", err)
+	}
+}
@@ -0,0 +1,432 @@
+package bundle
+
+import (
+	"archive/zip"
+	"bundeck/internal/db"
+	"bundeck/internal/macro"
+	"bundeck/internal/plugin"
+	"bundeck/internal/scheduler"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// Format identifies BunDeck bundles
+const Format = "bundeck-bundle"
+
+// Version is the bundle version written by this build. Bundles with a
+// higher version are rejected.
+const Version = 1
+
+// manifestFile is the name of the manifest inside zip bundles
+const manifestFile = "manifest.json"
+
+// ErrInvalidBundle is wrapped by all validation errors
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// Bundle is a portable copy of a deck. IDs are only used to link entries
+// within the bundle and are replaced on import.
+type Bundle struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Profiles   []Profile `json:"profiles"`
+	Pages      []Page    `json:"pages"`
+	Plugins    []Plugin  `json:"plugins"`
+}
+
+type Profile struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+}
+
+type Page struct {
+	ID        int    `json:"id"`
+	ProfileID int    `json:"profile_id"`
+	ParentID  *int   `json:"parent_id"`
+	Name      string `json:"name"`
+	OrderNum  int    `json:"order_num"`
+}
+
+type Plugin struct {
+	ID     int    `json:"id"`
+	PageID int    `json:"page_id"`
+	Name   string `json:"name"`
+	// Kind is empty or "script" for plugins running their code, and "macro"
+	// for plugins running Steps
+	Kind              string `json:"kind,omitempty"`
+	OrderNum          int    `json:"order_num"`
+	Code              string `json:"code,omitempty"`
+	Image             []byte `json:"image,omitempty"`
+	ImageType         string `json:"image_type,omitempty"`
+	RunContinuously   bool   `json:"run_continuously"`
+	IntervalSeconds   int    `json:"interval_seconds"`
+	CronExpression    string `json:"cron_expression,omitempty"`
+	TimeZone          string `json:"time_zone,omitempty"`
+	MissedRunPolicy   string `json:"missed_run_policy,omitempty"`
+	TimeoutSeconds    int    `json:"timeout_seconds"`
+	ConcurrencyPolicy string `json:"concurrency_policy"`
+	// Runtime is empty for bundles written before plugins declared one, which
+	// run with plugin.DefaultRuntime
+	Runtime  string `json:"runtime,omitempty"`
+	Resident bool   `json:"resident"`
+	// TemplateID, TemplateVersion and TemplateVariables keep the link to the
+	// template the plugin was created from, so it can be re-configured
+	TemplateID        string          `json:"template_id,omitempty"`
+	TemplateVersion   int             `json:"template_version,omitempty"`
+	TemplateVariables json.RawMessage `json:"template_variables,omitempty"`
+	Steps             []Step          `json:"steps,omitempty"`
+	Presets           []Preset        `json:"presets,omitempty"`
+	// CodeFile and ImageFile name the files holding the code and image in
+	// zip bundles
+	CodeFile  string `json:"code_file,omitempty"`
+	ImageFile string `json:"image_file,omitempty"`
+}
+
+// Step is a step of a macro. TargetPluginID refers to a plugin in the
+// bundle.
+type Step struct {
+	Action            string          `json:"action"`
+	TargetPluginID    *int            `json:"target_plugin_id,omitempty"`
+	Input             json.RawMessage `json:"input,omitempty"`
+	DelayMS           int             `json:"delay_ms,omitempty"`
+	Condition         string          `json:"condition,omitempty"`
+	ExitCode          int             `json:"exit_code,omitempty"`
+	ContinueOnFailure bool            `json:"continue_on_failure,omitempty"`
+}
+
+// Preset is a named input of a plugin
+type Preset struct {
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidBundle, fmt.Sprintf(format, args...))
+}
+
+// Validate checks the manifest and the links between its entries
+func (b *Bundle) Validate() error {
+	if b.Format != Format {
+		return invalid("format must be %q", Format)
+	}
+	if b.Version < 1 || b.Version > Version {
+		return invalid("unsupported version %d, this BunDeck reads up to version %d", b.Version, Version)
+	}
+
+	profiles := map[int]bool{}
+	for _, p := range b.Profiles {
+		if profiles[p.ID] {
+			return invalid("duplicate profile id %d", p.ID)
+		}
+		if strings.TrimSpace(p.Name) == "" {
+			return invalid("profile %d has no name", p.ID)
+		}
+		profiles[p.ID] = true
+	}
+
+	pages := map[int]Page{}
+	for _, p := range b.Pages {
+		if _, ok := pages[p.ID]; ok {
+			return invalid("duplicate page id %d", p.ID)
+		}
+		if strings.TrimSpace(p.Name) == "" {
+			return invalid("page %d has no name", p.ID)
+		}
+		if !profiles[p.ProfileID] {
+			return invalid("page %d belongs to unknown profile %d", p.ID, p.ProfileID)
+		}
+		pages[p.ID] = p
+	}
+	for _, p := range b.Pages {
+		// Walk up to the top-level page, which also catches cycles
+		seen := map[int]bool{p.ID: true}
+		for parent := p.ParentID; parent != nil; {
+			ancestor, ok := pages[*parent]
+			if !ok {
+				return invalid("page %d has unknown parent %d", p.ID, *parent)
+			}
+			if ancestor.ProfileID != p.ProfileID {
+				return invalid("page %d and its parent belong to different profiles", p.ID)
+			}
+			if seen[ancestor.ID] {
+				return invalid("page %d is its own ancestor", p.ID)
+			}
+			seen[ancestor.ID] = true
+			parent = ancestor.ParentID
+		}
+	}
+
+	plugins := map[int]Plugin{}
+	for _, p := range b.Plugins {
+		if _, ok := plugins[p.ID]; ok {
+			return invalid("duplicate plugin id %d", p.ID)
+		}
+		plugins[p.ID] = p
+	}
+	for _, p := range b.Plugins {
+		if strings.TrimSpace(p.Name) == "" {
+			return invalid("plugin %d has no name", p.ID)
+		}
+		switch p.Kind {
+		case "", db.PluginKindScript:
+			if p.Code == "" {
+				return invalid("plugin %d has no code", p.ID)
+			}
+			if len(p.Steps) > 0 {
+				return invalid("plugin %d has steps but is not a macro", p.ID)
+			}
+		case db.PluginKindMacro:
+			if err := validateSteps(p, plugins); err != nil {
+				return err
+			}
+		default:
+			return invalid("plugin %d has unknown kind %q", p.ID, p.Kind)
+		}
+		if _, ok := pages[p.PageID]; !ok {
+			return invalid("plugin %d is on unknown page %d", p.ID, p.PageID)
+		}
+		if p.ConcurrencyPolicy != "" && !plugin.ValidPolicy(p.ConcurrencyPolicy) {
+			return invalid("plugin %d has unknown concurrency policy %q", p.ID, p.ConcurrencyPolicy)
+		}
+		rt, err := plugin.LookupRuntime(p.Runtime)
+		if err != nil {
+			return invalid("plugin %d has unknown runtime %q", p.ID, p.Runtime)
+		}
+		if p.Resident && !rt.Resident {
+			return invalid("plugin %d is resident but %s plugins cannot be", p.ID, rt.Name)
+		}
+		if p.IntervalSeconds < 0 || p.TimeoutSeconds < 0 {
+			return invalid("plugin %d has a negative interval or timeout", p.ID)
+		}
+		if p.CronExpression != "" {
+			if _, err := scheduler.ParseCron(p.CronExpression, p.TimeZone); err != nil {
+				return invalid("plugin %d has an invalid cron expression: %v", p.ID, err)
+			}
+		}
+		if p.MissedRunPolicy != "" && !scheduler.ValidMissedPolicy(p.MissedRunPolicy) {
+			return invalid("plugin %d has unknown missed run policy %q", p.ID, p.MissedRunPolicy)
+		}
+		if len(p.Image) > 0 && !strings.HasPrefix(p.ImageType, "image/") {
+			return invalid("plugin %d has an image of type %q", p.ID, p.ImageType)
+		}
+		presets := map[string]bool{}
+		for _, preset := range p.Presets {
+			if strings.TrimSpace(preset.Name) == "" || presets[preset.Name] {
+				return invalid("plugin %d has a preset without a name or with a duplicate name", p.ID)
+			}
+			presets[preset.Name] = true
+			if len(preset.Input) == 0 || !json.Valid(preset.Input) {
+				return invalid("preset %q of plugin %d has an input that is not valid JSON", preset.Name, p.ID)
+			}
+		}
+		if len(p.TemplateVariables) > 0 {
+			var variables map[string]any
+			if err := json.Unmarshal(p.TemplateVariables, &variables); err != nil {
+				return invalid("plugin %d has template variables that are not an object", p.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateSteps checks that the steps of a macro run scripts of the bundle
+func validateSteps(p Plugin, plugins map[int]Plugin) error {
+	if len(p.Steps) == 0 {
+		return invalid("macro %d has no steps", p.ID)
+	}
+	for i, step := range p.Steps {
+		switch step.Action {
+		case macro.ActionRun:
+			if step.TargetPluginID == nil {
+				return invalid("step %d of macro %d has no plugin to run", i+1, p.ID)
+			}
+			target, ok := plugins[*step.TargetPluginID]
+			if !ok {
+				return invalid("step %d of macro %d runs unknown plugin %d", i+1, p.ID, *step.TargetPluginID)
+			}
+			if target.Kind == db.PluginKindMacro {
+				return invalid("step %d of macro %d runs another macro", i+1, p.ID)
+			}
+			if len(step.Input) > 0 && !json.Valid(step.Input) {
+				return invalid("step %d of macro %d has an input that is not valid JSON", i+1, p.ID)
+			}
+		case macro.ActionDelay:
+			delay := time.Duration(step.DelayMS) * time.Millisecond
+			if delay <= 0 || delay > macro.MaxDelay {
+				return invalid("step %d of macro %d must wait between 1ms and %s", i+1, p.ID, macro.MaxDelay)
+			}
+		default:
+			return invalid("step %d of macro %d has unknown action %q", i+1, p.ID, step.Action)
+		}
+		switch step.Condition {
+		case macro.ConditionAlways, macro.ConditionSuccess, macro.ConditionFailure, macro.ConditionExitCode:
+		default:
+			return invalid("step %d of macro %d has unknown condition %q", i+1, p.ID, step.Condition)
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the bundle as a single JSON document with embedded code
+// and images
+func WriteJSON(w io.Writer, b *Bundle) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(b)
+}
+
+// WriteZip writes the bundle as a zip archive holding the manifest, plugin
+// code under plugins/ and images under images/
+func WriteZip(w io.Writer, b *Bundle) error {
+	archive := zip.NewWriter(w)
+
+	manifest := *b
+	manifest.Plugins = make([]Plugin, len(b.Plugins))
+	for i, p := range b.Plugins {
+		// Macros have no code
+		if p.Code != "" {
+			extension := ".ts"
+			if rt, err := plugin.LookupRuntime(p.Runtime); err == nil {
+				extension = rt.Extension
+			}
+			p.CodeFile = fmt.Sprintf("plugins/%d%s", p.ID, extension)
+			if err := writeZipFile(archive, p.CodeFile, []byte(p.Code)); err != nil {
+				return err
+			}
+			p.Code = ""
+		}
+
+		if len(p.Image) > 0 {
+			p.ImageFile = fmt.Sprintf("images/%d%s", p.ID, imageExtension(p.ImageType))
+			if err := writeZipFile(archive, p.ImageFile, p.Image); err != nil {
+				return err
+			}
+			p.Image = nil
+		}
+		manifest.Plugins[i] = p
+	}
+
+	var data bytes.Buffer
+	if err := WriteJSON(&data, &manifest); err != nil {
+		return err
+	}
+	if err := writeZipFile(archive, manifestFile, data.Bytes()); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+func writeZipFile(archive *zip.Writer, name string, data []byte) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func imageExtension(imageType string) string {
+	switch imageType {
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/gif":
+		return ".gif"
+	case "image/svg+xml":
+		return ".svg"
+	case "image/webp":
+		return ".webp"
+	}
+	return ""
+}
+
+// Read decodes a JSON or zip bundle and validates it
+func Read(data []byte) (*Bundle, error) {
+	var b *Bundle
+	var err error
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		b, err = readZip(data)
+	} else {
+		b = &Bundle{}
+		if jsonErr := json.Unmarshal(data, b); jsonErr != nil {
+			err = invalid("%v", jsonErr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func readZip(data []byte) (*Bundle, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[path.Clean(f.Name)] = f
+	}
+
+	manifest, err := readZipFile(files, manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{}
+	if err := json.Unmarshal(manifest, b); err != nil {
+		return nil, invalid("%s: %v", manifestFile, err)
+	}
+
+	for i := range b.Plugins {
+		p := &b.Plugins[i]
+		if p.CodeFile != "" {
+			code, err := readZipFile(files, p.CodeFile)
+			if err != nil {
+				return nil, err
+			}
+			p.Code = string(code)
+		}
+		if p.ImageFile != "" {
+			if p.Image, err = readZipFile(files, p.ImageFile); err != nil {
+				return nil, err
+			}
+		}
+		p.CodeFile = ""
+		p.ImageFile = ""
+	}
+
+	return b, nil
+}
+
+func readZipFile(files map[string]*zip.File, name string) ([]byte, error) {
+	f, ok := files[path.Clean(name)]
+	if !ok {
+		return nil, invalid("missing file %s", name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, invalid("%s: %v", name, err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, invalid("%s: %v", name, err)
+	}
+	return data, nil
+}
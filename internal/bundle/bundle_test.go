@@ -0,0 +1,103 @@
+package bundle
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func testBundle() *Bundle {
+	parent := 10
+	return &Bundle{
+		Format:   Format,
+		Version:  Version,
+		Profiles: []Profile{{ID: 1, Name: "Default", Active: true}},
+		Pages: []Page{
+			{ID: 10, ProfileID: 1, Name: "Home"},
+			{ID: 11, ProfileID: 1, ParentID: &parent, Name: "Audio"},
+		},
+		Plugins: []Plugin{
+			{ID: 5, PageID: 10, Name: "Hello", Code: "console.log('hello')", ConcurrencyPolicy: "parallel"},
+			{ID: 6, PageID: 11, Name: "Mute", Code: "amixer set Master mute", Runtime: "shell", Image: []byte{0x89, 'P', 'N', 'G'}, ImageType: "image/png"},
+		},
+	}
+}
+
+func TestBundle_Validate(t *testing.T) {
+	if err := testBundle().Validate(); err != nil {
+		t.Fatalf("Expected valid bundle, got %v", err)
+	}
+
+	tests := map[string]func(b *Bundle){
+		"Wrong Format":     func(b *Bundle) { b.Format = "zip" },
+		"Newer Version":    func(b *Bundle) { b.Version = Version + 1 },
+		"Unknown Page":     func(b *Bundle) { b.Plugins[0].PageID = 99 },
+		"Unknown Profile":  func(b *Bundle) { b.Pages[0].ProfileID = 7 },
+		"Duplicate Plugin": func(b *Bundle) { b.Plugins[1].ID = 5 },
+		"Missing Code":     func(b *Bundle) { b.Plugins[0].Code = "" },
+		"Bad Policy":       func(b *Bundle) { b.Plugins[0].ConcurrencyPolicy = "sometimes" },
+		"Bad Image Type":   func(b *Bundle) { b.Plugins[1].ImageType = "text/html" },
+		"Bad Variables":    func(b *Bundle) { b.Plugins[0].TemplateVariables = []byte(`[1]`) },
+		"Unknown Kind":     func(b *Bundle) { b.Plugins[0].Kind = "shortcut" },
+		"Unknown Runtime":  func(b *Bundle) { b.Plugins[0].Runtime = "ruby" },
+		"Resident Shell": func(b *Bundle) {
+			b.Plugins[1].Resident = true
+		},
+		"Duplicate Preset": func(b *Bundle) {
+			b.Plugins[0].Presets = []Preset{{Name: "A", Input: []byte("1")}, {Name: "A", Input: []byte("2")}}
+		},
+		"Macro Without Steps": func(b *Bundle) {
+			b.Plugins[0].Kind = "macro"
+		},
+		"Step Outside Bundle": func(b *Bundle) {
+			target := 99
+			b.Plugins[0].Kind = "macro"
+			b.Plugins[0].Steps = []Step{{Action: "run", TargetPluginID: &target}}
+		},
+		"Cycle": func(b *Bundle) {
+			child := 11
+			b.Pages[0].ParentID = &child
+		},
+	}
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := testBundle()
+			modify(b)
+			if err := b.Validate(); !errors.Is(err, ErrInvalidBundle) {
+				t.Errorf("Expected ErrInvalidBundle, got %v", err)
+			}
+		})
+	}
+}
+
+func TestBundle_ReadWrite(t *testing.T) {
+	for name, write := range map[string]func(*bytes.Buffer, *Bundle) error{
+		"JSON": func(buf *bytes.Buffer, b *Bundle) error { return WriteJSON(buf, b) },
+		"Zip":  func(buf *bytes.Buffer, b *Bundle) error { return WriteZip(buf, b) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := write(&buf, testBundle()); err != nil {
+				t.Fatalf("Failed to write bundle: %v", err)
+			}
+
+			b, err := Read(buf.Bytes())
+			if err != nil {
+				t.Fatalf("Failed to read bundle: %v", err)
+			}
+			if len(b.Plugins) != 2 || b.Plugins[0].Code != "console.log('hello')" {
+				t.Fatalf("Unexpected plugins: %+v", b.Plugins)
+			}
+			if !bytes.Equal(b.Plugins[1].Image, []byte{0x89, 'P', 'N', 'G'}) || b.Plugins[1].CodeFile != "" {
+				t.Errorf("Expected image and code to be read back, got %+v", b.Plugins[1])
+			}
+			if b.Plugins[1].Runtime != "shell" || b.Plugins[1].Code != "amixer set Master mute" {
+				t.Errorf("Expected the shell script to be read back, got %+v", b.Plugins[1])
+			}
+		})
+	}
+
+	if _, err := Read([]byte("not json")); !errors.Is(err, ErrInvalidBundle) {
+		t.Errorf("Expected ErrInvalidBundle for garbage, got %v", err)
+	}
+}
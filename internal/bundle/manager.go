@@ -0,0 +1,613 @@
+package bundle
+
+import (
+	"bundeck/internal/db"
+	"bundeck/internal/plugin"
+	"bundeck/internal/scheduler"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Import modes
+const (
+	// ModeMerge adds the bundle to the existing deck. Profiles and pages with
+	// the same name and place are reused.
+	ModeMerge = "merge"
+	// ModeReplace deletes all profiles, pages and plugins first
+	ModeReplace = "replace"
+)
+
+// Conflict resolutions for plugins whose name already exists on the target
+// page during a merge
+const (
+	ConflictSkip   = "skip"
+	ConflictRename = "rename"
+)
+
+// ErrInvalidOptions is returned for an unknown import mode or resolution
+var ErrInvalidOptions = errors.New("invalid import options")
+
+// PluginStore is the subset of plugin operations needed for bundles
+type PluginStore interface {
+	GetAll() ([]db.Plugin, error)
+	ListSteps(pluginID int) ([]db.MacroStep, error)
+	ListPresets(pluginID int) ([]db.Preset, error)
+	BeginImport() (*db.ImportTx, error)
+}
+
+// PageStore is the subset of page operations needed for bundles
+type PageStore interface {
+	ListProfiles() ([]db.Profile, error)
+	ListPages(profileID int) ([]db.Page, error)
+}
+
+// Selection limits an export to some plugins and pages. Selected pages are
+// exported with their folders and plugins. An empty selection exports the
+// whole deck.
+type Selection struct {
+	PluginIDs []int
+	PageIDs   []int
+}
+
+func (s Selection) empty() bool {
+	return len(s.PluginIDs) == 0 && len(s.PageIDs) == 0
+}
+
+type ImportOptions struct {
+	Mode string
+	// OnConflict is one of the Conflict* values, merges skip by default
+	OnConflict string
+	// DryRun validates and reports without changing anything
+	DryRun bool
+}
+
+// Conflict is a bundled plugin whose name is already used on its page
+type Conflict struct {
+	Plugin     string `json:"plugin"`
+	Page       string `json:"page"`
+	Resolution string `json:"resolution"`
+	// RenamedTo is the new name when the plugin was renamed
+	RenamedTo string `json:"renamed_to,omitempty"`
+}
+
+// Report describes the outcome of an import
+type Report struct {
+	Mode            string     `json:"mode"`
+	DryRun          bool       `json:"dry_run"`
+	ProfilesCreated int        `json:"profiles_created"`
+	PagesCreated    int        `json:"pages_created"`
+	PluginsCreated  int        `json:"plugins_created"`
+	PluginsRemoved  int        `json:"plugins_removed"`
+	Conflicts       []Conflict `json:"conflicts"`
+	// Created and Removed are the IDs of added and deleted plugins
+	Created []int `json:"created"`
+	Removed []int `json:"removed"`
+}
+
+// Manager exports and imports decks
+type Manager struct {
+	plugins PluginStore
+	pages   PageStore
+}
+
+func NewManager(plugins PluginStore, pages PageStore) *Manager {
+	return &Manager{plugins: plugins, pages: pages}
+}
+
+// Export builds a bundle of the selected plugins and pages
+func (m *Manager) Export(selection Selection) (*Bundle, error) {
+	profiles, err := m.pages.ListProfiles()
+	if err != nil {
+		return nil, err
+	}
+	var pages []db.Page
+	for _, profile := range profiles {
+		list, err := m.pages.ListPages(profile.ID)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, list...)
+	}
+	plugins, err := m.plugins.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	pageByID := make(map[int]db.Page, len(pages))
+	for _, p := range pages {
+		pageByID[p.ID] = p
+	}
+	steps := map[int][]db.MacroStep{}
+	for _, p := range plugins {
+		if p.Kind == db.PluginKindMacro {
+			if steps[p.ID], err = m.plugins.ListSteps(p.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Pick the plugins and pages to export, then add the parents of every
+	// exported page so the structure can be rebuilt
+	includePages := map[int]bool{}
+	includePlugins := map[int]bool{}
+	if selection.empty() {
+		for _, p := range pages {
+			includePages[p.ID] = true
+		}
+		for _, p := range plugins {
+			includePlugins[p.ID] = true
+		}
+	} else {
+		for _, id := range selection.PageIDs {
+			if _, ok := pageByID[id]; !ok {
+				return nil, fmt.Errorf("page %d: %w", id, sql.ErrNoRows)
+			}
+			includePages[id] = true
+		}
+		// Add folders until no page is added anymore
+		for added := true; added; {
+			added = false
+			for _, p := range pages {
+				if p.ParentID != nil && includePages[*p.ParentID] && !includePages[p.ID] {
+					includePages[p.ID] = true
+					added = true
+				}
+			}
+		}
+		for _, p := range plugins {
+			if includePages[p.PageID] {
+				includePlugins[p.ID] = true
+			}
+		}
+
+		known := map[int]bool{}
+		for _, p := range plugins {
+			known[p.ID] = true
+		}
+		for _, id := range selection.PluginIDs {
+			if !known[id] {
+				return nil, fmt.Errorf("plugin %d: %w", id, sql.ErrNoRows)
+			}
+			includePlugins[id] = true
+		}
+		// Macros need the plugins their steps run
+		for _, p := range plugins {
+			if !includePlugins[p.ID] {
+				continue
+			}
+			for _, step := range steps[p.ID] {
+				if step.TargetPluginID != nil && known[*step.TargetPluginID] {
+					includePlugins[*step.TargetPluginID] = true
+				}
+			}
+		}
+		for _, p := range plugins {
+			if includePlugins[p.ID] {
+				includePages[p.PageID] = true
+			}
+		}
+		for id := range includePages {
+			for parent := pageByID[id].ParentID; parent != nil; parent = pageByID[*parent].ParentID {
+				includePages[*parent] = true
+			}
+		}
+	}
+
+	b := &Bundle{
+		Format:     Format,
+		Version:    Version,
+		ExportedAt: time.Now().UTC(),
+		Profiles:   []Profile{},
+		Pages:      []Page{},
+		Plugins:    []Plugin{},
+	}
+
+	includeProfiles := map[int]bool{}
+	for _, p := range pages {
+		if includePages[p.ID] {
+			includeProfiles[p.ProfileID] = true
+			b.Pages = append(b.Pages, Page{
+				ID:        p.ID,
+				ProfileID: p.ProfileID,
+				ParentID:  p.ParentID,
+				Name:      p.Name,
+				OrderNum:  p.OrderNum,
+			})
+		}
+	}
+	for _, p := range profiles {
+		if includeProfiles[p.ID] {
+			b.Profiles = append(b.Profiles, Profile{ID: p.ID, Name: p.Name, Active: p.Active})
+		}
+	}
+	for _, p := range plugins {
+		if !includePlugins[p.ID] {
+			continue
+		}
+		exported := Plugin{
+			ID:                p.ID,
+			PageID:            p.PageID,
+			Name:              p.Name,
+			OrderNum:          p.OrderNum,
+			Code:              p.Code,
+			Image:             p.Image,
+			RunContinuously:   p.RunContinuously,
+			IntervalSeconds:   p.IntervalSeconds,
+			CronExpression:    p.CronExpression,
+			TimeZone:          p.TimeZone,
+			MissedRunPolicy:   p.MissedRunPolicy,
+			TimeoutSeconds:    p.TimeoutSeconds,
+			ConcurrencyPolicy: p.ConcurrencyPolicy,
+			Runtime:           p.Runtime,
+			Resident:          p.Resident,
+		}
+		if p.TemplateID != nil {
+			exported.TemplateID = *p.TemplateID
+			exported.TemplateVersion = p.TemplateVersion
+			exported.TemplateVariables = p.TemplateVariables
+		}
+		if p.Kind == db.PluginKindMacro {
+			exported.Kind = p.Kind
+			exported.Steps = exportSteps(steps[p.ID], includePlugins)
+		}
+		presets, err := m.plugins.ListPresets(p.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, preset := range presets {
+			exported.Presets = append(exported.Presets, Preset{Name: preset.Name, Input: preset.Input})
+		}
+		if p.ImageType != nil && len(p.Image) > 0 {
+			exported.ImageType = *p.ImageType
+		} else {
+			exported.Image = nil
+		}
+		b.Plugins = append(b.Plugins, exported)
+	}
+
+	return b, nil
+}
+
+// Import adds a validated bundle to the deck. All changes are made in one
+// transaction, which is rolled back for dry runs.
+func (m *Manager) Import(b *Bundle, options ImportOptions) (*Report, error) {
+	if options.Mode == "" {
+		options.Mode = ModeMerge
+	}
+	if options.OnConflict == "" {
+		options.OnConflict = ConflictSkip
+	}
+	if options.Mode != ModeMerge && options.Mode != ModeReplace {
+		return nil, fmt.Errorf("%w: mode must be %s or %s", ErrInvalidOptions, ModeMerge, ModeReplace)
+	}
+	if options.OnConflict != ConflictSkip && options.OnConflict != ConflictRename {
+		return nil, fmt.Errorf("%w: conflict resolution must be %s or %s", ErrInvalidOptions, ConflictSkip, ConflictRename)
+	}
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	if options.Mode == ModeReplace && !hasTopLevelPage(b) {
+		return nil, invalid("replacing the deck needs a bundle with at least one page")
+	}
+
+	tx, err := m.plugins.BeginImport()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report := &Report{
+		Mode:      options.Mode,
+		DryRun:    options.DryRun,
+		Conflicts: []Conflict{},
+		Created:   []int{},
+		Removed:   []int{},
+	}
+
+	if options.Mode == ModeReplace {
+		removed, err := tx.Clear()
+		if err != nil {
+			return nil, err
+		}
+		report.Removed = append(report.Removed, removed...)
+		report.PluginsRemoved = len(removed)
+	}
+
+	// Map bundle IDs to database IDs
+	profileIDs := map[int]int{}
+	activeID := 0
+	for _, p := range b.Profiles {
+		if options.Mode == ModeMerge {
+			existing, err := tx.FindProfile(p.Name)
+			if err == nil {
+				profileIDs[p.ID] = existing.ID
+				continue
+			}
+			if err != sql.ErrNoRows {
+				return nil, err
+			}
+		}
+
+		profile := &db.Profile{Name: p.Name}
+		if err := tx.CreateProfile(profile); err != nil {
+			return nil, err
+		}
+		profileIDs[p.ID] = profile.ID
+		report.ProfilesCreated++
+		if p.Active || activeID == 0 {
+			activeID = profile.ID
+		}
+	}
+	if options.Mode == ModeReplace {
+		if err := tx.ActivateProfile(activeID); err != nil {
+			return nil, err
+		}
+	}
+
+	pageIDs := map[int]int{}
+	pageNames := map[int]string{}
+	for _, p := range sortPages(b.Pages) {
+		var parentID *int
+		if p.ParentID != nil {
+			id := pageIDs[*p.ParentID]
+			parentID = &id
+		}
+		profileID := profileIDs[p.ProfileID]
+
+		if options.Mode == ModeMerge {
+			existing, err := tx.FindPage(profileID, parentID, p.Name)
+			if err == nil {
+				pageIDs[p.ID] = existing.ID
+				pageNames[existing.ID] = existing.Name
+				continue
+			}
+			if err != sql.ErrNoRows {
+				return nil, err
+			}
+		}
+
+		orderNum := p.OrderNum
+		if options.Mode == ModeMerge {
+			if orderNum, err = tx.NextPageOrder(profileID, parentID); err != nil {
+				return nil, err
+			}
+		}
+		page := &db.Page{ProfileID: profileID, ParentID: parentID, Name: p.Name, OrderNum: orderNum}
+		if err := tx.CreatePage(page); err != nil {
+			return nil, err
+		}
+		pageIDs[p.ID] = page.ID
+		pageNames[page.ID] = page.Name
+		report.PagesCreated++
+	}
+
+	// Keep the bundled order of plugins on each page, behind the plugins
+	// already there
+	plugins := append([]Plugin(nil), b.Plugins...)
+	sort.SliceStable(plugins, func(i, j int) bool {
+		return plugins[i].OrderNum < plugins[j].OrderNum
+	})
+	names := map[int]map[string]bool{}
+	nextOrder := map[int]int{}
+	// pluginIDs maps bundle IDs to the created plugins, or to the existing
+	// plugin kept instead on a skipped conflict
+	pluginIDs := map[int]int{}
+	for _, p := range plugins {
+		pageID := pageIDs[p.PageID]
+		if _, ok := names[pageID]; !ok {
+			if names[pageID], err = tx.PluginNames(pageID); err != nil {
+				return nil, err
+			}
+			if nextOrder[pageID], err = tx.NextPluginOrder(pageID); err != nil {
+				return nil, err
+			}
+		}
+
+		name := p.Name
+		if names[pageID][name] {
+			conflict := Conflict{Plugin: p.Name, Page: pageNames[pageID], Resolution: options.OnConflict}
+			if options.OnConflict == ConflictSkip {
+				report.Conflicts = append(report.Conflicts, conflict)
+				existing, err := tx.FindPlugin(pageID, name)
+				if err != nil {
+					return nil, err
+				}
+				if existing.Kind != db.PluginKindMacro {
+					pluginIDs[p.ID] = existing.ID
+				}
+				continue
+			}
+			name = uniqueName(names[pageID], p.Name)
+			conflict.RenamedTo = name
+			report.Conflicts = append(report.Conflicts, conflict)
+		}
+
+		created := &db.Plugin{
+			Name:              name,
+			Kind:              p.Kind,
+			Code:              p.Code,
+			OrderNum:          nextOrder[pageID],
+			PageID:            pageID,
+			RunContinuously:   p.RunContinuously,
+			IntervalSeconds:   p.IntervalSeconds,
+			CronExpression:    p.CronExpression,
+			TimeZone:          p.TimeZone,
+			MissedRunPolicy:   p.MissedRunPolicy,
+			TimeoutSeconds:    p.TimeoutSeconds,
+			ConcurrencyPolicy: p.ConcurrencyPolicy,
+			Runtime:           p.Runtime,
+			Resident:          p.Resident,
+		}
+		if p.TemplateID != "" {
+			templateID := p.TemplateID
+			created.TemplateID = &templateID
+			created.TemplateVersion = p.TemplateVersion
+			created.TemplateVariables = p.TemplateVariables
+		}
+		if created.ConcurrencyPolicy == "" {
+			created.ConcurrencyPolicy = plugin.PolicyParallel
+		}
+		if created.MissedRunPolicy == "" {
+			created.MissedRunPolicy = scheduler.MissedSkip
+		}
+		if len(p.Image) > 0 {
+			imageType := p.ImageType
+			created.Image = p.Image
+			created.ImageType = &imageType
+		}
+		if err := tx.CreatePlugin(created); err != nil {
+			return nil, err
+		}
+		for _, preset := range p.Presets {
+			input, err := compactJSON(preset.Input)
+			if err != nil {
+				return nil, invalid("preset %q of plugin %q has an input that is not valid JSON", preset.Name, p.Name)
+			}
+			if err := tx.CreatePreset(&db.Preset{PluginID: created.ID, Name: preset.Name, Input: input}); err != nil {
+				return nil, err
+			}
+		}
+
+		names[pageID][name] = true
+		nextOrder[pageID]++
+		pluginIDs[p.ID] = created.ID
+		report.Created = append(report.Created, created.ID)
+		report.PluginsCreated++
+	}
+
+	// Steps are added once every plugin they may run exists
+	for _, p := range plugins {
+		if p.Kind != db.PluginKindMacro {
+			continue
+		}
+		created, ok := pluginIDs[p.ID]
+		if !ok {
+			continue
+		}
+		steps, err := importSteps(p, pluginIDs)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.CreateSteps(created, steps); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.DryRun {
+		// Created IDs do not exist after the rollback
+		report.Created = []int{}
+		return report, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// exportSteps converts the steps of a macro, dropping steps that run plugins
+// outside the export
+func exportSteps(steps []db.MacroStep, included map[int]bool) []Step {
+	exported := []Step{}
+	for _, step := range steps {
+		if step.TargetPluginID != nil && !included[*step.TargetPluginID] {
+			continue
+		}
+		exported = append(exported, Step{
+			Action:            step.Action,
+			TargetPluginID:    step.TargetPluginID,
+			Input:             step.Input,
+			DelayMS:           step.DelayMS,
+			Condition:         step.Condition,
+			ExitCode:          step.ExitCode,
+			ContinueOnFailure: step.ContinueOnFailure,
+		})
+	}
+	return exported
+}
+
+// importSteps converts the steps of a bundled macro, pointing them at the
+// imported plugins
+func importSteps(p Plugin, pluginIDs map[int]int) ([]db.MacroStep, error) {
+	var steps []db.MacroStep
+	for i, step := range p.Steps {
+		imported := db.MacroStep{
+			Action:            step.Action,
+			DelayMS:           step.DelayMS,
+			Condition:         step.Condition,
+			ExitCode:          step.ExitCode,
+			ContinueOnFailure: step.ContinueOnFailure,
+		}
+		if len(step.Input) > 0 {
+			input, err := compactJSON(step.Input)
+			if err != nil {
+				return nil, invalid("step %d of macro %q has an input that is not valid JSON", i+1, p.Name)
+			}
+			imported.Input = input
+		}
+		if step.TargetPluginID != nil {
+			id, ok := pluginIDs[*step.TargetPluginID]
+			if !ok {
+				return nil, invalid("step %d of macro %q runs a plugin that was not imported", i+1, p.Name)
+			}
+			imported.TargetPluginID = &id
+		}
+		steps = append(steps, imported)
+	}
+	return steps, nil
+}
+
+// compactJSON undoes the indentation of inputs in bundles written by
+// WriteJSON
+func compactJSON(data json.RawMessage) (json.RawMessage, error) {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return nil, err
+	}
+	return compact.Bytes(), nil
+}
+
+func hasTopLevelPage(b *Bundle) bool {
+	for _, p := range b.Pages {
+		if p.ParentID == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// sortPages orders pages so every parent comes before its folders, keeping
+// the bundled order among siblings
+func sortPages(pages []Page) []Page {
+	sorted := append([]Page(nil), pages...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].OrderNum < sorted[j].OrderNum
+	})
+
+	var result []Page
+	placed := map[int]bool{}
+	for len(result) < len(sorted) {
+		for _, p := range sorted {
+			if placed[p.ID] || (p.ParentID != nil && !placed[*p.ParentID]) {
+				continue
+			}
+			placed[p.ID] = true
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// uniqueName appends a counter to name until it is not taken
+func uniqueName(taken map[string]bool, name string) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)", name, i)
+		if !taken[candidate] {
+			return candidate
+		}
+	}
+}
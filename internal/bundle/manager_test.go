@@ -0,0 +1,284 @@
+package bundle
+
+import (
+	"bundeck/internal/db"
+	"bytes"
+	"database/sql"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+type testDeck struct {
+	manager *Manager
+	plugins *db.PluginStore
+	pages   *db.PageStore
+}
+
+func setupTestDeck(t *testing.T) *testDeck {
+	database, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if err := db.InitDB(database); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	plugins := db.NewPluginStore(database)
+	pages := db.NewPageStore(database)
+	return &testDeck{manager: NewManager(plugins, pages), plugins: plugins, pages: pages}
+}
+
+func TestManager_Export(t *testing.T) {
+	deck := setupTestDeck(t)
+
+	home := &db.Plugin{Name: "Home Button", Code: "1", ConcurrencyPolicy: "parallel"}
+	if err := deck.plugins.Create(home); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+	folder := &db.Page{Name: "Audio", ParentID: &home.PageID}
+	if err := deck.pages.CreatePage(folder); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	templateID := "mute"
+	mute := &db.Plugin{Name: "Mute", Code: "2", PageID: folder.ID, ConcurrencyPolicy: "parallel", Runtime: "python", TemplateID: &templateID, TemplateVersion: 3, TemplateVariables: []byte(`{"DEVICE":"Mic"}`)}
+	if err := deck.plugins.Create(mute); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	b, err := deck.manager.Export(Selection{})
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if len(b.Profiles) != 1 || len(b.Pages) != 2 || len(b.Plugins) != 2 {
+		t.Errorf("Expected the whole deck, got %+v", b)
+	}
+
+	b, err = deck.manager.Export(Selection{PluginIDs: []int{mute.ID}})
+	if err != nil {
+		t.Fatalf("Failed to export selection: %v", err)
+	}
+	if len(b.Plugins) != 1 || len(b.Pages) != 2 {
+		t.Fatalf("Expected the plugin with its folder and parent page, got %+v", b)
+	}
+	if p := b.Plugins[0]; p.TemplateID != "mute" || p.TemplateVersion != 3 || string(p.TemplateVariables) != `{"DEVICE":"Mic"}` {
+		t.Errorf("Expected the template link to be exported, got %+v", p)
+	}
+	if p := b.Plugins[0]; p.Runtime != "python" {
+		t.Errorf("Expected the runtime to be exported, got %q", p.Runtime)
+	}
+	if err := b.Validate(); err != nil {
+		t.Errorf("Expected exported selection to be valid, got %v", err)
+	}
+
+	if _, err := deck.manager.Export(Selection{PageIDs: []int{99}}); err == nil {
+		t.Error("Expected an error for an unknown page")
+	}
+}
+
+func TestManager_Import(t *testing.T) {
+	deck := setupTestDeck(t)
+
+	existing := &db.Plugin{Name: "Hello", Code: "old", ConcurrencyPolicy: "parallel"}
+	if err := deck.plugins.Create(existing); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+	// The bundled Home page of the Default profile matches the existing one
+	b := testBundle()
+	b.Plugins[1].TemplateID = "mute"
+	b.Plugins[1].TemplateVersion = 2
+	b.Plugins[1].TemplateVariables = []byte(`{"DEVICE":"Mic"}`)
+
+	t.Run("Dry Run", func(t *testing.T) {
+		report, err := deck.manager.Import(b, ImportOptions{DryRun: true})
+		if err != nil {
+			t.Fatalf("Failed to import: %v", err)
+		}
+		if report.PluginsCreated != 1 || len(report.Conflicts) != 1 || report.Conflicts[0].Plugin != "Hello" {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+		plugins, _ := deck.plugins.GetAll()
+		if len(plugins) != 1 {
+			t.Errorf("Expected a dry run to change nothing, got %d plugins", len(plugins))
+		}
+	})
+
+	t.Run("Merge Rename", func(t *testing.T) {
+		report, err := deck.manager.Import(b, ImportOptions{Mode: ModeMerge, OnConflict: ConflictRename})
+		if err != nil {
+			t.Fatalf("Failed to import: %v", err)
+		}
+		if report.PluginsCreated != 2 || report.PagesCreated != 1 || report.ProfilesCreated != 0 {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+		if len(report.Conflicts) != 1 || report.Conflicts[0].RenamedTo != "Hello (2)" {
+			t.Errorf("Expected the conflicting plugin to be renamed, got %+v", report.Conflicts)
+		}
+
+		renamed, err := deck.plugins.GetByID(report.Created[0])
+		if err != nil {
+			t.Fatalf("Failed to get imported plugin: %v", err)
+		}
+		if renamed.PageID != existing.PageID || renamed.OrderNum <= existing.OrderNum {
+			t.Errorf("Expected the plugin after the existing one on the same page, got %+v", renamed)
+		}
+
+		mute, err := deck.plugins.GetByID(report.Created[1])
+		if err != nil {
+			t.Fatalf("Failed to get imported plugin: %v", err)
+		}
+		if mute.TemplateID == nil || *mute.TemplateID != "mute" || mute.TemplateVersion != 2 || string(mute.TemplateVariables) != `{"DEVICE":"Mic"}` {
+			t.Errorf("Expected the template link to be imported, got %+v", mute)
+		}
+		if mute.Runtime != "shell" || renamed.Runtime != db.DefaultRuntime {
+			t.Errorf("Expected the bundled runtimes, got %q and %q", mute.Runtime, renamed.Runtime)
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		report, err := deck.manager.Import(b, ImportOptions{Mode: ModeReplace})
+		if err != nil {
+			t.Fatalf("Failed to import: %v", err)
+		}
+		if report.PluginsRemoved != 3 || report.PluginsCreated != 2 || len(report.Conflicts) != 0 {
+			t.Errorf("Unexpected report: %+v", report)
+		}
+
+		profiles, _ := deck.pages.ListProfiles()
+		if len(profiles) != 1 || !profiles[0].Active {
+			t.Errorf("Expected one active profile, got %+v", profiles)
+		}
+		if _, err := deck.plugins.GetByID(existing.ID); err != sql.ErrNoRows {
+			t.Errorf("Expected existing plugins to be removed, got %v", err)
+		}
+	})
+
+	t.Run("Invalid Options", func(t *testing.T) {
+		if _, err := deck.manager.Import(b, ImportOptions{Mode: "append"}); err == nil {
+			t.Error("Expected an error for an unknown mode")
+		}
+	})
+}
+
+func TestManager_Macros(t *testing.T) {
+	deck := setupTestDeck(t)
+
+	scene := &db.Plugin{Name: "Scene", Code: "1", ConcurrencyPolicy: "parallel"}
+	if err := deck.plugins.Create(scene); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+	macro := &db.Plugin{Name: "Go Live", ConcurrencyPolicy: "skip"}
+	steps := []db.MacroStep{
+		{Action: "run", TargetPluginID: &scene.ID, Input: []byte(`{"scene":"live"}`)},
+		{Action: "delay", DelayMS: 500, Condition: "success"},
+	}
+	if err := deck.plugins.CreateMacro(macro, steps); err != nil {
+		t.Fatalf("Failed to create macro: %v", err)
+	}
+
+	// Exporting a macro brings the plugins it runs
+	b, err := deck.manager.Export(Selection{PluginIDs: []int{macro.ID}})
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if len(b.Plugins) != 2 {
+		t.Fatalf("Expected the macro and its plugin, got %+v", b.Plugins)
+	}
+	var exported Plugin
+	for _, p := range b.Plugins {
+		if p.Kind == db.PluginKindMacro {
+			exported = p
+		}
+	}
+	if len(exported.Steps) != 2 || *exported.Steps[0].TargetPluginID != scene.ID {
+		t.Fatalf("Expected the macro steps, got %+v", exported)
+	}
+
+	var zipped bytes.Buffer
+	if err := WriteZip(&zipped, b); err != nil {
+		t.Fatalf("Failed to write zip: %v", err)
+	}
+	b, err = Read(zipped.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to read zip: %v", err)
+	}
+
+	report, err := deck.manager.Import(b, ImportOptions{Mode: ModeMerge, OnConflict: ConflictRename})
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if report.PluginsCreated != 2 {
+		t.Fatalf("Expected both plugins to be imported, got %+v", report)
+	}
+
+	var imported *db.Plugin
+	var target int
+	for _, id := range report.Created {
+		p, _ := deck.plugins.GetByID(id)
+		if p.Kind == db.PluginKindMacro {
+			imported = p
+		} else {
+			target = p.ID
+		}
+	}
+	if imported == nil {
+		t.Fatal("Expected the macro to be imported")
+	}
+	list, err := deck.plugins.ListSteps(imported.ID)
+	if err != nil {
+		t.Fatalf("Failed to list steps: %v", err)
+	}
+	if len(list) != 2 || *list[0].TargetPluginID != target || string(list[0].Input) != `{"scene":"live"}` || list[1].DelayMS != 500 {
+		t.Errorf("Expected the steps to run the imported plugin, got %+v", list)
+	}
+
+	// Skipped plugins are replaced by the existing ones in the steps
+	report, err = deck.manager.Import(b, ImportOptions{Mode: ModeMerge, OnConflict: ConflictSkip})
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if report.PluginsCreated != 0 || len(report.Conflicts) != 2 {
+		t.Errorf("Expected both plugins to be skipped, got %+v", report)
+	}
+}
+
+func TestManager_Presets(t *testing.T) {
+	deck := setupTestDeck(t)
+
+	scene := &db.Plugin{Name: "Scene", Code: "1", ConcurrencyPolicy: "parallel"}
+	if err := deck.plugins.Create(scene); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+	if err := deck.plugins.SavePreset(&db.Preset{PluginID: scene.ID, Name: "Intro", Input: []byte(`{"scene":"intro"}`)}); err != nil {
+		t.Fatalf("Failed to save preset: %v", err)
+	}
+
+	b, err := deck.manager.Export(Selection{})
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if len(b.Plugins) != 1 || len(b.Plugins[0].Presets) != 1 || b.Plugins[0].Presets[0].Name != "Intro" {
+		t.Fatalf("Expected the preset to be exported, got %+v", b.Plugins)
+	}
+
+	var data bytes.Buffer
+	if err := WriteJSON(&data, b); err != nil {
+		t.Fatalf("Failed to write bundle: %v", err)
+	}
+	if b, err = Read(data.Bytes()); err != nil {
+		t.Fatalf("Failed to read bundle: %v", err)
+	}
+	report, err := deck.manager.Import(b, ImportOptions{Mode: ModeReplace})
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+
+	presets, err := deck.plugins.ListPresets(report.Created[0])
+	if err != nil {
+		t.Fatalf("Failed to list presets: %v", err)
+	}
+	if len(presets) != 1 || string(presets[0].Input) != `{"scene":"intro"}` {
+		t.Errorf("Expected the imported preset, got %+v", presets)
+	}
+}
@@ -2,7 +2,9 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"time"
 )
 
@@ -29,9 +31,157 @@ var migrations = []string{
 	// v2/3: Add continuous running support
 	`ALTER TABLE plugins ADD COLUMN run_continuously BOOLEAN NOT NULL DEFAULT 0;`,
 	`ALTER TABLE plugins ADD COLUMN interval_seconds INTEGER NOT NULL DEFAULT 0;`,
+	// v4: Add per-plugin execution timeout
+	`ALTER TABLE plugins ADD COLUMN timeout_seconds INTEGER NOT NULL DEFAULT 0;`,
+	// v5/6: Add run history
+	`CREATE TABLE IF NOT EXISTS plugin_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		plugin_id INTEGER NOT NULL REFERENCES plugins(id) ON DELETE CASCADE,
+		trigger_source TEXT NOT NULL,
+		status TEXT NOT NULL,
+		exit_code INTEGER,
+		stdout TEXT NOT NULL DEFAULT '',
+		stderr TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		output_size INTEGER NOT NULL DEFAULT 0,
+		truncated BOOLEAN NOT NULL DEFAULT 0,
+		started_at DATETIME NOT NULL,
+		finished_at DATETIME
+	);`,
+	`CREATE INDEX IF NOT EXISTS idx_plugin_runs_plugin_id ON plugin_runs (plugin_id, id);`,
+	// v7: Add dynamic button state reported by plugins
+	`ALTER TABLE plugins ADD COLUMN state TEXT;`,
+	// v8: Add per-plugin concurrency policy
+	`ALTER TABLE plugins ADD COLUMN concurrency_policy TEXT NOT NULL DEFAULT 'parallel';`,
+	// v9: Add resident mode keeping one plugin process alive between presses
+	`ALTER TABLE plugins ADD COLUMN resident BOOLEAN NOT NULL DEFAULT 0;`,
+	// v10: Add secrets, values are encrypted before they are stored
+	`CREATE TABLE IF NOT EXISTS secrets (
+		name TEXT PRIMARY KEY,
+		value BLOB NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);`,
+	// v11: Add paired devices, only a hash of each device token is stored
+	`CREATE TABLE IF NOT EXISTS devices (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		role TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at DATETIME NOT NULL,
+		last_seen_at DATETIME
+	);`,
+	// v12-17: Add profiles holding pages, pages nested as folders, and move
+	// existing plugins onto the first page of a default profile
+	`CREATE TABLE IF NOT EXISTS profiles (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS pages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		profile_id INTEGER NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+		parent_id INTEGER REFERENCES pages(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		order_num INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);`,
+	`ALTER TABLE plugins ADD COLUMN page_id INTEGER REFERENCES pages(id);`,
+	`INSERT INTO profiles (name, active, created_at, updated_at) VALUES ('Default', 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP);`,
+	`INSERT INTO pages (profile_id, name, order_num, created_at, updated_at) SELECT id, 'Home', 0, created_at, updated_at FROM profiles;`,
+	`UPDATE plugins SET page_id = (SELECT MIN(id) FROM pages);`,
+	// v18-20: Add code history, existing plugins start at revision 1
+	`CREATE TABLE IF NOT EXISTS plugin_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		plugin_id INTEGER NOT NULL REFERENCES plugins(id) ON DELETE CASCADE,
+		revision INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		code TEXT NOT NULL,
+		run_continuously BOOLEAN NOT NULL,
+		interval_seconds INTEGER NOT NULL,
+		timeout_seconds INTEGER NOT NULL,
+		concurrency_policy TEXT NOT NULL,
+		resident BOOLEAN NOT NULL,
+		restored_from INTEGER,
+		created_at DATETIME NOT NULL
+	);`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_plugin_revisions_plugin_id ON plugin_revisions (plugin_id, revision);`,
+	`INSERT INTO plugin_revisions (plugin_id, revision, name, code, run_continuously, interval_seconds, timeout_seconds, concurrency_policy, resident, created_at)
+		SELECT id, 1, name, code, run_continuously, interval_seconds, timeout_seconds, concurrency_policy, resident, updated_at FROM plugins;`,
+	// v21-23: Remember the template and variables a plugin was created from
+	`ALTER TABLE plugins ADD COLUMN template_id TEXT;`,
+	`ALTER TABLE plugins ADD COLUMN template_version INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE plugins ADD COLUMN template_variables TEXT;`,
+	// v24: Add webhooks that run plugins from other systems
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		plugin_id INTEGER NOT NULL REFERENCES plugins(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		signing_secret BLOB,
+		mode TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		last_used_at DATETIME
+	);`,
+	// v25-30: Add cron schedules to plugins and their revisions
+	`ALTER TABLE plugins ADD COLUMN cron_expression TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE plugins ADD COLUMN time_zone TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE plugins ADD COLUMN missed_run_policy TEXT NOT NULL DEFAULT 'skip';`,
+	`ALTER TABLE plugin_revisions ADD COLUMN cron_expression TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE plugin_revisions ADD COLUMN time_zone TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE plugin_revisions ADD COLUMN missed_run_policy TEXT NOT NULL DEFAULT 'skip';`,
+	// v31-33: Add macros, plugins running a list of steps instead of code,
+	// and keep the results of their steps with each run
+	`ALTER TABLE plugins ADD COLUMN kind TEXT NOT NULL DEFAULT 'script';`,
+	`CREATE TABLE IF NOT EXISTS macro_steps (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		plugin_id INTEGER NOT NULL REFERENCES plugins(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		target_plugin_id INTEGER,
+		input TEXT,
+		delay_ms INTEGER NOT NULL DEFAULT 0,
+		condition TEXT NOT NULL DEFAULT '',
+		exit_code INTEGER NOT NULL DEFAULT 0,
+		continue_on_failure BOOLEAN NOT NULL DEFAULT 0
+	);`,
+	`ALTER TABLE plugin_runs ADD COLUMN steps TEXT;`,
+	// v34: Add presets, named inputs a plugin can be run with
+	`CREATE TABLE IF NOT EXISTS plugin_presets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		plugin_id INTEGER NOT NULL REFERENCES plugins(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		input TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		UNIQUE (plugin_id, name)
+	);`,
+	// v35: Add the runtime running a plugin's code, one of the plugin.Runtime* IDs
+	`ALTER TABLE plugins ADD COLUMN runtime TEXT NOT NULL DEFAULT 'bun';`,
+	// v36: Record the runtime in revisions
+	`ALTER TABLE plugin_revisions ADD COLUMN runtime TEXT NOT NULL DEFAULT 'bun';`,
+	// v37: Remember when each cron schedule last fired, to notice runs missed
+	// while BunDeck was not running
+	`CREATE TABLE IF NOT EXISTS schedule_fires (
+		plugin_id INTEGER PRIMARY KEY REFERENCES plugins(id) ON DELETE CASCADE,
+		fired_at DATETIME NOT NULL
+	);`,
+	// v38: Grant plugins the secrets they may read
+	`CREATE TABLE IF NOT EXISTS plugin_secrets (
+		plugin_id INTEGER NOT NULL REFERENCES plugins(id) ON DELETE CASCADE,
+		secret_name TEXT NOT NULL REFERENCES secrets(name) ON DELETE CASCADE,
+		PRIMARY KEY (plugin_id, secret_name)
+	);`,
+	// v39: Plugins keep the secrets their code mentioned before grants
+	`INSERT OR IGNORE INTO plugin_secrets (plugin_id, secret_name)
+		SELECT plugins.id, secrets.name FROM plugins JOIN secrets ON instr(plugins.code, secrets.name) > 0;`,
 }
 
-func getCurrentVersion(db *sql.DB) (int, error) {
+// SchemaVersion returns the number of migrations applied to the database
+func SchemaVersion(db *sql.DB) (int, error) {
 	// Create version table if it doesn't exist
 	if _, err := db.Exec(schemaVersionTable); err != nil {
 		return 0, fmt.Errorf("failed to create schema version table: %w", err)
@@ -47,7 +197,7 @@ func getCurrentVersion(db *sql.DB) (int, error) {
 }
 
 func InitDB(db *sql.DB) error {
-	currentVersion, err := getCurrentVersion(db)
+	currentVersion, err := SchemaVersion(db)
 	if err != nil {
 		return err
 	}
@@ -83,40 +233,156 @@ func InitDB(db *sql.DB) error {
 			return fmt.Errorf("failed to commit migration version %d: %w", version, err)
 		}
 
-		fmt.Printf("Applied migration version %d\n", version)
+		slog.Info("db: applied migration", "version", version)
+	}
+
+	return nil
+}
+
+// Backup writes a consistent copy of the database to a new file at path
+func Backup(db *sql.DB, path string) error {
+	if _, err := db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to back up the database: %w", err)
 	}
+	return nil
+}
 
-	// Do periodic WAL checkpoints
-	checkpoint(db)
+// CheckpointInterval is how often StartCheckpoints truncates the WAL file
+const CheckpointInterval = 5 * time.Minute
+
+// Checkpointer truncates the WAL file of a database periodically so it does
+// not grow without bounds
+type Checkpointer struct {
+	db   *sql.DB
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartCheckpoints runs a checkpoint now and then every interval until Stop
+// is called
+func StartCheckpoints(db *sql.DB, interval time.Duration) *Checkpointer {
+	c := &Checkpointer{
+		db:   db,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if err := Checkpoint(db); err != nil {
+		slog.Error("db: failed to checkpoint", "err", err)
+	}
 	go func() {
-		ticker := time.NewTicker(5 * time.Minute)
+		defer close(c.done)
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
-		for range ticker.C {
-			checkpoint(db)
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				if err := Checkpoint(db); err != nil {
+					slog.Error("db: failed to checkpoint", "err", err)
+				}
+			}
 		}
 	}()
+	return c
+}
 
-	return nil
+// Stop ends the periodic checkpoints and runs a final one, so the database
+// can be closed with an empty WAL file
+func (c *Checkpointer) Stop() error {
+	close(c.stop)
+	<-c.done
+	return Checkpoint(c.db)
 }
 
-func checkpoint(db *sql.DB) {
-	_, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE);")
-	if err != nil {
-		_ = fmt.Errorf("failed to truncate WAL file: %w", err)
+// Checkpoint moves the WAL file into the database and truncates it
+func Checkpoint(db *sql.DB) error {
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE);"); err != nil {
+		return fmt.Errorf("failed to truncate WAL file: %w", err)
 	}
+	return nil
 }
 
+// Plugin kinds
+const (
+	// PluginKindScript plugins run their code with their runtime
+	PluginKindScript = "script"
+	// PluginKindMacro plugins run their macro steps and have no code
+	PluginKindMacro = "macro"
+)
+
+// DefaultRuntime is stored for plugins created without a runtime, it matches
+// plugin.DefaultRuntime
+const DefaultRuntime = "bun"
+
 type Plugin struct {
-	ID              int       `json:"id"`
-	Name            string    `json:"name"`
-	Code            string    `json:"code"`
-	OrderNum        int       `json:"order_num"`
-	Image           []byte    `json:"image"`
-	ImageType       *string   `json:"image_type"`
-	RunContinuously bool      `json:"run_continuously"`
-	IntervalSeconds int       `json:"interval_seconds"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Kind is one of the PluginKind* values
+	Kind     string `json:"kind"`
+	Code     string `json:"code"`
+	OrderNum int    `json:"order_num"`
+	// PageID is the page showing the plugin, OrderNum its position there
+	PageID          int     `json:"page_id"`
+	Image           []byte  `json:"image"`
+	ImageType       *string `json:"image_type"`
+	RunContinuously bool    `json:"run_continuously"`
+	IntervalSeconds int     `json:"interval_seconds"`
+	// CronExpression replaces the interval when set, evaluated in TimeZone or
+	// the local time zone when that is empty
+	CronExpression string `json:"cron_expression"`
+	TimeZone       string `json:"time_zone"`
+	// MissedRunPolicy is one of the scheduler.Missed* values
+	MissedRunPolicy string `json:"missed_run_policy"`
+	TimeoutSeconds  int    `json:"timeout_seconds"`
+	// ConcurrencyPolicy is one of the plugin.Policy* values
+	ConcurrencyPolicy string `json:"concurrency_policy"`
+	// Runtime is one of the plugin.Runtime* IDs
+	Runtime  string          `json:"runtime"`
+	Resident bool            `json:"resident"`
+	State    json.RawMessage `json:"state"`
+	// TemplateID is the template the plugin was created from, if any, and
+	// TemplateVariables the values it was rendered with. Secret values are
+	// not kept here.
+	TemplateID        *string         `json:"template_id"`
+	TemplateVersion   int             `json:"template_version"`
+	TemplateVariables json.RawMessage `json:"template_variables"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
+}
+
+// pluginColumns lists the columns read by scanPlugin, in order
+const pluginColumns = "id, name, kind, code, order_num, page_id, image, image_type, run_continuously, interval_seconds, cron_expression, time_zone, missed_run_policy, timeout_seconds, concurrency_policy, runtime, resident, state, template_id, template_version, template_variables, created_at, updated_at"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPlugin(row scanner) (*Plugin, error) {
+	var p Plugin
+	var imageType sql.NullString // Use sql.NullString for nullable column
+	var state sql.NullString
+	var pageID sql.NullInt64
+	var templateID sql.NullString
+	var templateVariables sql.NullString
+	err := row.Scan(&p.ID, &p.Name, &p.Kind, &p.Code, &p.OrderNum, &pageID, &p.Image, &imageType, &p.RunContinuously, &p.IntervalSeconds, &p.CronExpression, &p.TimeZone, &p.MissedRunPolicy, &p.TimeoutSeconds, &p.ConcurrencyPolicy, &p.Runtime, &p.Resident, &state, &templateID, &p.TemplateVersion, &templateVariables, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if imageType.Valid {
+		p.ImageType = &imageType.String
+	}
+	if state.Valid {
+		p.State = json.RawMessage(state.String)
+	}
+	if templateID.Valid {
+		p.TemplateID = &templateID.String
+	}
+	if templateVariables.Valid {
+		p.TemplateVariables = json.RawMessage(templateVariables.String)
+	}
+	p.PageID = int(pageID.Int64)
+	return &p, nil
 }
 
 type PluginStore struct {
@@ -127,20 +393,64 @@ func NewPluginStore(db *sql.DB) *PluginStore {
 	return &PluginStore{db: db}
 }
 
+// Create adds a plugin. Plugins without a page are put on the first page of
+// the active profile.
 func (s *PluginStore) Create(plugin *Plugin) error {
 	now := time.Now()
 	plugin.CreatedAt = now
 	plugin.UpdatedAt = now
 
-	result, err := s.db.Exec(
-		"INSERT INTO plugins (name, code, order_num, image, image_type, run_continuously, interval_seconds, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	if plugin.PageID == 0 {
+		pageID, err := defaultPageID(s.db)
+		if err != nil {
+			return err
+		}
+		plugin.PageID = pageID
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertPlugin(tx, plugin); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertPlugin adds a plugin together with its first revision
+func insertPlugin(tx *sql.Tx, plugin *Plugin) error {
+	if plugin.Kind == "" {
+		plugin.Kind = PluginKindScript
+	}
+	if plugin.Runtime == "" {
+		plugin.Runtime = DefaultRuntime
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO plugins (name, kind, code, order_num, page_id, image, image_type, run_continuously, interval_seconds, cron_expression, time_zone, missed_run_policy, timeout_seconds, concurrency_policy, runtime, resident, template_id, template_version, template_variables, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		plugin.Name,
+		plugin.Kind,
 		plugin.Code,
 		plugin.OrderNum,
+		plugin.PageID,
 		plugin.Image,
 		plugin.ImageType,
 		plugin.RunContinuously,
 		plugin.IntervalSeconds,
+		plugin.CronExpression,
+		plugin.TimeZone,
+		plugin.MissedRunPolicy,
+		plugin.TimeoutSeconds,
+		plugin.ConcurrencyPolicy,
+		plugin.Runtime,
+		plugin.Resident,
+		plugin.TemplateID,
+		plugin.TemplateVersion,
+		nullableJSON(plugin.TemplateVariables),
 		plugin.CreatedAt,
 		plugin.UpdatedAt,
 	)
@@ -152,13 +462,16 @@ func (s *PluginStore) Create(plugin *Plugin) error {
 	if err != nil {
 		return err
 	}
+	if _, err := recordRevision(tx, int(id), nil); err != nil {
+		return err
+	}
 
 	plugin.ID = int(id)
 	return nil
 }
 
 func (s *PluginStore) GetAll() ([]Plugin, error) {
-	rows, err := s.db.Query("SELECT id, name, code, order_num, image, image_type, run_continuously, interval_seconds, created_at, updated_at FROM plugins ORDER BY order_num")
+	rows, err := s.db.Query("SELECT " + pluginColumns + " FROM plugins ORDER BY order_num")
 	if err != nil {
 		return nil, err
 	}
@@ -166,78 +479,236 @@ func (s *PluginStore) GetAll() ([]Plugin, error) {
 
 	var plugins []Plugin
 	for rows.Next() {
-		var p Plugin
-		var imageType sql.NullString // Use sql.NullString for nullable column
-		err := rows.Scan(&p.ID, &p.Name, &p.Code, &p.OrderNum, &p.Image, &imageType, &p.RunContinuously, &p.IntervalSeconds, &p.CreatedAt, &p.UpdatedAt)
+		p, err := scanPlugin(rows)
 		if err != nil {
 			return nil, err
 		}
-		if imageType.Valid {
-			p.ImageType = &imageType.String
-		}
-		plugins = append(plugins, p)
+		plugins = append(plugins, *p)
 	}
 
 	return plugins, nil
 }
 
+// GetByPage returns the plugins on a page in grid order
+func (s *PluginStore) GetByPage(pageID int) ([]Plugin, error) {
+	rows, err := s.db.Query("SELECT "+pluginColumns+" FROM plugins WHERE page_id = ? ORDER BY order_num", pageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plugins []Plugin
+	for rows.Next() {
+		p, err := scanPlugin(rows)
+		if err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, *p)
+	}
+
+	return plugins, rows.Err()
+}
+
 func (s *PluginStore) GetByID(id int) (*Plugin, error) {
-	var p Plugin
-	var imageType sql.NullString // Use sql.NullString for nullable column
-	err := s.db.QueryRow(
-		"SELECT id, name, code, order_num, image, image_type, run_continuously, interval_seconds, created_at, updated_at FROM plugins WHERE id = ?",
+	return scanPlugin(s.db.QueryRow("SELECT "+pluginColumns+" FROM plugins WHERE id = ?", id))
+}
+
+// PluginUpdate is an edit of a plugin's code, image and settings
+type PluginUpdate struct {
+	Name              string
+	Code              string
+	Image             []byte
+	ImageType         string
+	RunContinuously   bool
+	IntervalSeconds   int
+	CronExpression    string
+	TimeZone          string
+	MissedRunPolicy   string
+	TimeoutSeconds    int
+	ConcurrencyPolicy string
+	Runtime           string
+	Resident          bool
+}
+
+// UpdateCode saves an edit of a plugin and records a new revision when the
+// code, name or settings changed
+func (s *PluginStore) UpdateCode(id int, update PluginUpdate) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE plugins SET code = ?, image = ?, image_type = ?, name = ?, run_continuously = ?, interval_seconds = ?, cron_expression = ?, time_zone = ?, missed_run_policy = ?, timeout_seconds = ?, concurrency_policy = ?, runtime = ?, resident = ?, updated_at = ? WHERE id = ?",
+		update.Code,
+		update.Image,
+		update.ImageType,
+		update.Name,
+		update.RunContinuously,
+		update.IntervalSeconds,
+		update.CronExpression,
+		update.TimeZone,
+		update.MissedRunPolicy,
+		update.TimeoutSeconds,
+		update.ConcurrencyPolicy,
+		update.Runtime,
+		update.Resident,
+		time.Now(),
 		id,
-	).Scan(&p.ID, &p.Name, &p.Code, &p.OrderNum, &p.Image, &imageType, &p.RunContinuously, &p.IntervalSeconds, &p.CreatedAt, &p.UpdatedAt)
+	)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if imageType.Valid {
-		p.ImageType = &imageType.String
+	if err := requireRow(result); err != nil {
+		return err
 	}
-	return &p, nil
+
+	if _, err := recordRevision(tx, id, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (s *PluginStore) UpdateCode(id int, code string, image []byte, imageType string, name string, runContinuously bool, intervalSeconds int) error {
-	result, err := s.db.Exec(
-		"UPDATE plugins SET code = ?, image = ?, image_type = ?, name = ?, run_continuously = ?, interval_seconds = ?, updated_at = ? WHERE id = ?",
-		code,
-		image,
-		imageType,
-		name,
+// SetRunContinuously turns the continuous running of a plugin on or off and
+// records a new revision
+func (s *PluginStore) SetRunContinuously(id int, runContinuously bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE plugins SET run_continuously = ?, updated_at = ? WHERE id = ?",
 		runContinuously,
-		intervalSeconds,
 		time.Now(),
 		id,
 	)
 	if err != nil {
 		return err
 	}
+	if err := requireRow(result); err != nil {
+		return err
+	}
 
-	rows, err := result.RowsAffected()
+	if _, err := recordRevision(tx, id, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateTemplate saves code rendered from the plugin's template together with
+// the template version and variables used, and records a new revision
+func (s *PluginStore) UpdateTemplate(id int, code string, templateVersion int, variables json.RawMessage) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	if rows == 0 {
-		return sql.ErrNoRows
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE plugins SET code = ?, template_version = ?, template_variables = ?, updated_at = ? WHERE id = ? AND template_id IS NOT NULL",
+		code,
+		templateVersion,
+		nullableJSON(variables),
+		time.Now(),
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(result); err != nil {
+		return err
 	}
 
-	return nil
+	if _, err := recordRevision(tx, id, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// nullableJSON stores empty JSON as NULL
+func nullableJSON(data json.RawMessage) any {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
+
+// MergeState applies a partial JSON state update on top of the plugin's stored
+// state. Keys in the update replace existing keys, other keys are kept.
+func (s *PluginStore) MergeState(id int, update []byte) error {
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(update, &patch); err != nil {
+		return fmt.Errorf("invalid state update: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current sql.NullString
+	if err := tx.QueryRow("SELECT state FROM plugins WHERE id = ?", id).Scan(&current); err != nil {
+		return err
+	}
+
+	state := map[string]json.RawMessage{}
+	if current.Valid && current.String != "" {
+		if err := json.Unmarshal([]byte(current.String), &state); err != nil {
+			// Replace a corrupt state rather than failing every update
+			state = map[string]json.RawMessage{}
+		}
+	}
+	for key, value := range patch {
+		state[key] = value
+	}
+
+	merged, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE plugins SET state = ? WHERE id = ?", string(merged), id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// OrderUpdate moves a plugin to a new grid position, and to another page
+// when PageID is set
+type OrderUpdate struct {
+	ID       int  `json:"id"`
+	OrderNum int  `json:"order_num"`
+	PageID   *int `json:"page_id,omitempty"`
 }
 
-func (s *PluginStore) UpdateOrder(orders []struct {
-	ID       int `json:"id"`
-	OrderNum int `json:"order_num"`
-},
-) error {
+// UpdateOrder applies all updates in one transaction. It fails with an error
+// wrapping sql.ErrNoRows when a target page does not exist.
+func (s *PluginStore) UpdateOrder(orders []OrderUpdate) error {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
 
 	for _, o := range orders {
+		if o.PageID != nil {
+			var exists int
+			if err := tx.QueryRow("SELECT 1 FROM pages WHERE id = ?", *o.PageID).Scan(&exists); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("page %d: %w", *o.PageID, err)
+			}
+		}
+
 		_, err := tx.Exec(
-			"UPDATE plugins SET order_num = ?, updated_at = ? WHERE id = ?",
+			"UPDATE plugins SET order_num = ?, page_id = COALESCE(?, page_id), updated_at = ? WHERE id = ?",
 			o.OrderNum,
+			o.PageID,
 			time.Now(),
 			o.ID,
 		)
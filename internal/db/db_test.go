@@ -2,6 +2,10 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -45,6 +49,66 @@ func TestInitDB(t *testing.T) {
 	}
 }
 
+func TestCheckpointer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugins.db")
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	checkpoints := StartCheckpoints(db, time.Hour)
+	store := NewPluginStore(db)
+	if err := store.Create(&Plugin{Name: "Test", Code: "console.log(1)"}); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+	if info, err := os.Stat(path + "-wal"); err != nil || info.Size() == 0 {
+		t.Fatalf("Expected the write in the WAL file, got %v", err)
+	}
+
+	if err := checkpoints.Stop(); err != nil {
+		t.Fatalf("Failed to stop checkpoints: %v", err)
+	}
+	if info, err := os.Stat(path + "-wal"); err == nil && info.Size() != 0 {
+		t.Errorf("Expected the final checkpoint to truncate the WAL file, got %d bytes", info.Size())
+	}
+}
+
+func TestBackup(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	if err := NewPluginStore(db).Create(&Plugin{Name: "Test", Code: "console.log(1)"}); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := Backup(db, path); err != nil {
+		t.Fatalf("Failed to back up the database: %v", err)
+	}
+	if err := Backup(db, path); err == nil {
+		t.Error("Expected an existing backup file not to be overwritten")
+	}
+
+	backup, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer backup.Close()
+	if version, err := SchemaVersion(backup); err != nil || version != len(migrations) {
+		t.Errorf("Expected schema version %d, got %d, %v", len(migrations), version, err)
+	}
+	plugins, err := NewPluginStore(backup).GetAll()
+	if err != nil || len(plugins) != 1 || plugins[0].Name != "Test" {
+		t.Errorf("Expected the plugin in the backup, got %+v, %v", plugins, err)
+	}
+}
+
 func TestPluginStore_CRUD(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
@@ -102,7 +166,7 @@ func TestPluginStore_CRUD(t *testing.T) {
 	t.Run("UpdateCode", func(t *testing.T) {
 		newCode := "console.log('updated')"
 		newName := "Updated Plugin"
-		err := store.UpdateCode(1, newCode, nil, "", newName, false, 0)
+		err := store.UpdateCode(1, PluginUpdate{Name: newName, Code: newCode, CronExpression: "0 9 * * 1-5", TimeZone: "Europe/Berlin", MissedRunPolicy: "run_once", TimeoutSeconds: 30, ConcurrencyPolicy: "queue", Runtime: "node", Resident: true})
 		if err != nil {
 			t.Fatalf("Failed to update plugin code: %v", err)
 		}
@@ -119,14 +183,31 @@ func TestPluginStore_CRUD(t *testing.T) {
 		if plugin.Name != newName {
 			t.Errorf("Expected name '%s', got '%s'", newName, plugin.Name)
 		}
+
+		if plugin.TimeoutSeconds != 30 {
+			t.Errorf("Expected timeout 30, got %d", plugin.TimeoutSeconds)
+		}
+
+		if plugin.ConcurrencyPolicy != "queue" {
+			t.Errorf("Expected concurrency policy 'queue', got '%s'", plugin.ConcurrencyPolicy)
+		}
+
+		if plugin.Runtime != "node" {
+			t.Errorf("Expected runtime 'node', got '%s'", plugin.Runtime)
+		}
+
+		if plugin.CronExpression != "0 9 * * 1-5" || plugin.TimeZone != "Europe/Berlin" || plugin.MissedRunPolicy != "run_once" {
+			t.Errorf("Expected the cron schedule to be saved, got %q in %q with %q", plugin.CronExpression, plugin.TimeZone, plugin.MissedRunPolicy)
+		}
+
+		if !plugin.Resident {
+			t.Error("Expected plugin to be resident")
+		}
 	})
 
 	// Test UpdateOrder
 	t.Run("UpdateOrder", func(t *testing.T) {
-		orders := []struct {
-			ID       int `json:"id"`
-			OrderNum int `json:"order_num"`
-		}{
+		orders := []OrderUpdate{
 			{ID: 1, OrderNum: 2},
 		}
 
@@ -143,6 +224,15 @@ func TestPluginStore_CRUD(t *testing.T) {
 		if plugin.OrderNum != 2 {
 			t.Errorf("Expected order_num 2, got %d", plugin.OrderNum)
 		}
+		if plugin.PageID != 1 {
+			t.Errorf("Expected plugin on the default page, got page %d", plugin.PageID)
+		}
+
+		missing := 99
+		err = store.UpdateOrder([]OrderUpdate{{ID: 1, OrderNum: 0, PageID: &missing}})
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Expected sql.ErrNoRows moving to a missing page, got %v", err)
+		}
 	})
 
 	// Test Delete
@@ -205,7 +295,7 @@ func TestPluginStore_ImageHandling(t *testing.T) {
 		newImageType := "image/jpeg"
 		newImage := []byte("new image data")
 
-		err := store.UpdateCode(1, "new code", newImage, newImageType, "Updated Name", false, 0)
+		err := store.UpdateCode(1, PluginUpdate{Name: "Updated Name", Code: "new code", Image: newImage, ImageType: newImageType, MissedRunPolicy: "skip", ConcurrencyPolicy: "parallel", Runtime: "bun"})
 		if err != nil {
 			t.Fatalf("Failed to update plugin with image: %v", err)
 		}
@@ -224,3 +314,786 @@ func TestPluginStore_ImageHandling(t *testing.T) {
 		}
 	})
 }
+
+func TestRunStore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	plugins := NewPluginStore(db)
+	plugin := &Plugin{Name: "Test Plugin", Code: "console.log('test')"}
+	if err := plugins.Create(plugin); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	store := NewRunStore(db)
+
+	t.Run("StartAndFinish", func(t *testing.T) {
+		run := &PluginRun{
+			PluginID:  plugin.ID,
+			Trigger:   "manual",
+			Status:    "running",
+			StartedAt: time.Now(),
+		}
+		if err := store.Start(run); err != nil {
+			t.Fatalf("Failed to start run: %v", err)
+		}
+		if run.ID == 0 {
+			t.Fatal("Expected non-zero run ID")
+		}
+
+		exitCode := 2
+		finishedAt := time.Now()
+		run.Status = "failed"
+		run.ExitCode = &exitCode
+		run.Stdout = "out"
+		run.Stderr = "err"
+		run.OutputSize = 6
+		run.FinishedAt = &finishedAt
+		if err := store.Finish(run); err != nil {
+			t.Fatalf("Failed to finish run: %v", err)
+		}
+
+		runs, total, err := store.ListByPlugin(plugin.ID, 10, 0)
+		if err != nil {
+			t.Fatalf("Failed to list runs: %v", err)
+		}
+		if total != 1 || len(runs) != 1 {
+			t.Fatalf("Expected 1 run, got %d (total %d)", len(runs), total)
+		}
+		got := runs[0]
+		if got.Status != "failed" || got.ExitCode == nil || *got.ExitCode != 2 {
+			t.Errorf("Unexpected run status or exit code: %+v", got)
+		}
+		if got.Stdout != "out" || got.Stderr != "err" {
+			t.Errorf("Expected separate stdout and stderr, got %q and %q", got.Stdout, got.Stderr)
+		}
+		if got.FinishedAt == nil {
+			t.Error("Expected finished_at to be set")
+		}
+	})
+
+	t.Run("PagingAndPrune", func(t *testing.T) {
+		var running *PluginRun
+		for i := 0; i < 4; i++ {
+			run := &PluginRun{PluginID: plugin.ID, Trigger: "schedule", Status: "running", StartedAt: time.Now()}
+			if err := store.Start(run); err != nil {
+				t.Fatalf("Failed to start run: %v", err)
+			}
+			if i == 0 {
+				// The oldest run is still in progress
+				running = run
+				continue
+			}
+			run.Status = "success"
+			if err := store.Finish(run); err != nil {
+				t.Fatalf("Failed to finish run: %v", err)
+			}
+		}
+
+		runs, total, err := store.ListByPlugin(plugin.ID, 2, 1)
+		if err != nil {
+			t.Fatalf("Failed to list runs: %v", err)
+		}
+		if total != 5 || len(runs) != 2 {
+			t.Fatalf("Expected 2 of 5 runs, got %d of %d", len(runs), total)
+		}
+		if runs[0].ID <= runs[1].ID {
+			t.Error("Expected runs ordered newest first")
+		}
+
+		if err := store.Prune(plugin.ID, 2, 0); err != nil {
+			t.Fatalf("Failed to prune runs: %v", err)
+		}
+		runs, total, err = store.ListByPlugin(plugin.ID, 10, 0)
+		if err != nil {
+			t.Fatalf("Failed to list runs: %v", err)
+		}
+		// The two newest finished runs and the one in progress are kept
+		if total != 3 {
+			t.Errorf("Expected 3 runs after pruning, got %d", total)
+		}
+		if len(runs) == 0 || runs[len(runs)-1].ID != running.ID {
+			t.Errorf("Expected the run in progress to be kept, got %v", runs)
+		}
+	})
+}
+
+func TestPluginStore_MergeState(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	store := NewPluginStore(db)
+	plugin := &Plugin{Name: "Toggle", Code: "console.log('test')"}
+	if err := store.Create(plugin); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	if err := store.MergeState(plugin.ID, []byte(`{"title":"On","toggle":true}`)); err != nil {
+		t.Fatalf("Failed to merge state: %v", err)
+	}
+	if err := store.MergeState(plugin.ID, []byte(`{"toggle":false}`)); err != nil {
+		t.Fatalf("Failed to merge state: %v", err)
+	}
+
+	got, err := store.GetByID(plugin.ID)
+	if err != nil {
+		t.Fatalf("Failed to get plugin: %v", err)
+	}
+	if string(got.State) != `{"title":"On","toggle":false}` {
+		t.Errorf("Unexpected merged state: %s", got.State)
+	}
+
+	if err := store.MergeState(999, []byte(`{}`)); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for unknown plugin, got %v", err)
+	}
+}
+
+func TestSecretStore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	store := NewSecretStore(db)
+	if err := store.Put("TOKEN", []byte("one")); err != nil {
+		t.Fatalf("Failed to put secret: %v", err)
+	}
+	if err := store.Put("API_KEY", []byte("two")); err != nil {
+		t.Fatalf("Failed to put secret: %v", err)
+	}
+	// Putting an existing name replaces its value
+	if err := store.Put("TOKEN", []byte("three")); err != nil {
+		t.Fatalf("Failed to replace secret: %v", err)
+	}
+
+	list, err := store.List()
+	if err != nil {
+		t.Fatalf("Failed to list secrets: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("Expected 2 secrets, got %d", len(list))
+	}
+	if list[0].Name != "API_KEY" || list[1].Name != "TOKEN" {
+		t.Errorf("Expected secrets ordered by name, got %s, %s", list[0].Name, list[1].Name)
+	}
+	if string(list[1].Value) != "three" {
+		t.Errorf("Expected replaced value, got %q", list[1].Value)
+	}
+
+	if err := store.Delete("TOKEN"); err != nil {
+		t.Fatalf("Failed to delete secret: %v", err)
+	}
+	if err := store.Delete("TOKEN"); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows deleting a missing secret, got %v", err)
+	}
+}
+
+func TestSecretStore_Grants(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		t.Fatalf("Failed to enable foreign keys: %v", err)
+	}
+
+	plugins := NewPluginStore(db)
+	plugin := &Plugin{Name: "OBS", Code: "process.env.TOKEN", ConcurrencyPolicy: "parallel"}
+	if err := plugins.Create(plugin); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+	store := NewSecretStore(db)
+	for _, name := range []string{"TOKEN", "API_KEY"} {
+		if err := store.Put(name, []byte(name)); err != nil {
+			t.Fatalf("Failed to put secret: %v", err)
+		}
+	}
+
+	names := func() []string {
+		t.Helper()
+		list, err := store.ListForPlugin(plugin.ID)
+		if err != nil {
+			t.Fatalf("Failed to list secrets of the plugin: %v", err)
+		}
+		var names []string
+		for _, secret := range list {
+			names = append(names, secret.Name)
+		}
+		return names
+	}
+
+	if got := names(); len(got) != 0 {
+		t.Errorf("Expected no secrets before they are granted, got %v", got)
+	}
+	if err := store.Grant(plugin.ID, "TOKEN"); err != nil {
+		t.Fatalf("Failed to grant secret: %v", err)
+	}
+	if err := store.Grant(plugin.ID, "TOKEN"); err != nil {
+		t.Fatalf("Failed to grant secret twice: %v", err)
+	}
+	if got := names(); len(got) != 1 || got[0] != "TOKEN" {
+		t.Errorf("Expected TOKEN, got %v", got)
+	}
+
+	if err := store.SetPluginSecrets(plugin.ID, []string{"API_KEY", "TOKEN"}); err != nil {
+		t.Fatalf("Failed to set the secrets of the plugin: %v", err)
+	}
+	if got := names(); len(got) != 2 || got[0] != "API_KEY" || got[1] != "TOKEN" {
+		t.Errorf("Expected API_KEY and TOKEN, got %v", got)
+	}
+	if err := store.SetPluginSecrets(plugin.ID, []string{"MISSING"}); err == nil {
+		t.Error("Expected an error granting a missing secret")
+	}
+
+	// Deleting a secret removes its grants
+	if err := store.Delete("API_KEY"); err != nil {
+		t.Fatalf("Failed to delete secret: %v", err)
+	}
+	if got := names(); len(got) != 1 || got[0] != "TOKEN" {
+		t.Errorf("Expected TOKEN after deleting API_KEY, got %v", got)
+	}
+
+	// Upgrading grants the secrets the code of existing plugins mentions
+	if _, err := db.Exec("DROP TABLE plugin_secrets"); err != nil {
+		t.Fatalf("Failed to drop grants: %v", err)
+	}
+	if _, err := db.Exec("DELETE FROM schema_version WHERE version >= 38"); err != nil {
+		t.Fatalf("Failed to reset schema version: %v", err)
+	}
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to upgrade database: %v", err)
+	}
+	if got := names(); len(got) != 1 || got[0] != "TOKEN" {
+		t.Errorf("Expected TOKEN granted on upgrade, got %v", got)
+	}
+}
+
+func TestPageStore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	pages := NewPageStore(db)
+	plugins := NewPluginStore(db)
+
+	active, err := pages.ActiveProfile()
+	if err != nil {
+		t.Fatalf("Failed to get default profile: %v", err)
+	}
+	home, err := defaultPageID(db)
+	if err != nil {
+		t.Fatalf("Failed to get default page: %v", err)
+	}
+
+	folder := &Page{Name: "Audio", ParentID: &home}
+	if err := pages.CreatePage(folder); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	if folder.ProfileID != active.ID {
+		t.Errorf("Expected folder to inherit profile %d, got %d", active.ID, folder.ProfileID)
+	}
+
+	t.Run("Move Plugin Into Folder", func(t *testing.T) {
+		plugin := &Plugin{Name: "Mute", Code: "console.log('mute')"}
+		if err := plugins.Create(plugin); err != nil {
+			t.Fatalf("Failed to create plugin: %v", err)
+		}
+		if plugin.PageID != home {
+			t.Errorf("Expected new plugin on page %d, got %d", home, plugin.PageID)
+		}
+
+		if err := plugins.UpdateOrder([]OrderUpdate{{ID: plugin.ID, OrderNum: 3, PageID: &folder.ID}}); err != nil {
+			t.Fatalf("Failed to move plugin: %v", err)
+		}
+		onFolder, err := plugins.GetByPage(folder.ID)
+		if err != nil {
+			t.Fatalf("Failed to list folder plugins: %v", err)
+		}
+		if len(onFolder) != 1 || onFolder[0].OrderNum != 3 {
+			t.Errorf("Expected plugin in folder at position 3, got %+v", onFolder)
+		}
+
+		// Folders holding plugins cannot be deleted
+		if err := pages.DeletePage(folder.ID); err != ErrPageNotEmpty {
+			t.Errorf("Expected ErrPageNotEmpty, got %v", err)
+		}
+		if err := plugins.Delete(plugin.ID); err != nil {
+			t.Fatalf("Failed to delete plugin: %v", err)
+		}
+	})
+
+	t.Run("Folder Cycles", func(t *testing.T) {
+		sub := &Page{Name: "Mics", ParentID: &folder.ID}
+		if err := pages.CreatePage(sub); err != nil {
+			t.Fatalf("Failed to create sub folder: %v", err)
+		}
+		if err := pages.UpdatePage(folder.ID, folder.Name, &sub.ID, 0); err != ErrInvalidParent {
+			t.Errorf("Expected ErrInvalidParent moving a folder into its child, got %v", err)
+		}
+		if err := pages.UpdatePage(sub.ID, "Microphones", &home, 1); err != nil {
+			t.Errorf("Failed to move folder: %v", err)
+		}
+	})
+
+	t.Run("Profiles", func(t *testing.T) {
+		profile := &Profile{Name: "Streaming"}
+		if err := pages.CreateProfile(profile); err != nil {
+			t.Fatalf("Failed to create profile: %v", err)
+		}
+		list, err := pages.ListPages(profile.ID)
+		if err != nil {
+			t.Fatalf("Failed to list pages: %v", err)
+		}
+		if len(list) != 1 || list[0].ParentID != nil {
+			t.Fatalf("Expected new profile to have one top-level page, got %+v", list)
+		}
+
+		if err := pages.ActivateProfile(profile.ID); err != nil {
+			t.Fatalf("Failed to activate profile: %v", err)
+		}
+		got, err := pages.ActiveProfile()
+		if err != nil || got.ID != profile.ID {
+			t.Fatalf("Expected profile %d to be active, got %v, %v", profile.ID, got, err)
+		}
+
+		// New plugins go to the active profile
+		plugin := &Plugin{Name: "Scene", Code: "console.log('scene')"}
+		if err := plugins.Create(plugin); err != nil {
+			t.Fatalf("Failed to create plugin: %v", err)
+		}
+		if plugin.PageID != list[0].ID {
+			t.Errorf("Expected plugin on page %d, got %d", list[0].ID, plugin.PageID)
+		}
+
+		if err := pages.DeletePage(list[0].ID); err != ErrLastPage {
+			t.Errorf("Expected ErrLastPage, got %v", err)
+		}
+		if err := pages.DeleteProfile(profile.ID); err != ErrActiveProfile {
+			t.Errorf("Expected ErrActiveProfile, got %v", err)
+		}
+		if err := pages.ActivateProfile(active.ID); err != nil {
+			t.Fatalf("Failed to activate profile: %v", err)
+		}
+		if err := pages.DeleteProfile(profile.ID); err != ErrPageNotEmpty {
+			t.Errorf("Expected ErrPageNotEmpty, got %v", err)
+		}
+		if err := plugins.Delete(plugin.ID); err != nil {
+			t.Fatalf("Failed to delete plugin: %v", err)
+		}
+		if err := pages.DeleteProfile(profile.ID); err != nil {
+			t.Errorf("Failed to delete profile: %v", err)
+		}
+		if err := pages.ActivateProfile(profile.ID); err != sql.ErrNoRows {
+			t.Errorf("Expected sql.ErrNoRows activating a deleted profile, got %v", err)
+		}
+	})
+}
+
+func TestPluginStore_Revisions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	// Revisions are removed with their plugin through the foreign key
+	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		t.Fatalf("Failed to enable foreign keys: %v", err)
+	}
+
+	store := NewPluginStore(db)
+	plugin := &Plugin{Name: "Test", Code: "console.log(1)", ConcurrencyPolicy: "parallel"}
+	if err := store.Create(plugin); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	if err := store.UpdateCode(plugin.ID, PluginUpdate{Name: "Test", Code: "console.log(2)", MissedRunPolicy: "skip", ConcurrencyPolicy: "parallel", Runtime: "bun"}); err != nil {
+		t.Fatalf("Failed to update plugin: %v", err)
+	}
+	// Saving without changes must not add a revision
+	if err := store.UpdateCode(plugin.ID, PluginUpdate{Name: "Test", Code: "console.log(2)", MissedRunPolicy: "skip", ConcurrencyPolicy: "parallel", Runtime: "bun"}); err != nil {
+		t.Fatalf("Failed to update plugin: %v", err)
+	}
+
+	revisions, err := store.ListRevisions(plugin.ID)
+	if err != nil {
+		t.Fatalf("Failed to list revisions: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].Revision != 2 || revisions[1].Code != "console.log(1)" {
+		t.Fatalf("Unexpected revisions: %+v", revisions)
+	}
+
+	restored, err := store.RestoreRevision(plugin.ID, 1)
+	if err != nil {
+		t.Fatalf("Failed to restore revision: %v", err)
+	}
+	if restored.Revision != 3 || restored.RestoredFrom == nil || *restored.RestoredFrom != 1 {
+		t.Errorf("Unexpected restored revision: %+v", restored)
+	}
+
+	current, err := store.GetByID(plugin.ID)
+	if err != nil {
+		t.Fatalf("Failed to get plugin: %v", err)
+	}
+	if current.Code != "console.log(1)" {
+		t.Errorf("Expected restored code, got %q", current.Code)
+	}
+
+	if _, err := store.RestoreRevision(plugin.ID, 42); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a missing revision, got %v", err)
+	}
+
+	if err := store.Delete(plugin.ID); err != nil {
+		t.Fatalf("Failed to delete plugin: %v", err)
+	}
+	if _, err := store.GetRevision(plugin.ID, 1); err != sql.ErrNoRows {
+		t.Errorf("Expected revisions to be deleted with the plugin, got %v", err)
+	}
+}
+
+func TestPluginStore_UpdateTemplate(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	store := NewPluginStore(db)
+	templateID := "obs"
+	plugin := &Plugin{
+		Name:              "OBS",
+		Code:              "const PORT = 4455;",
+		ConcurrencyPolicy: "parallel",
+		TemplateID:        &templateID,
+		TemplateVersion:   1,
+		TemplateVariables: json.RawMessage(`{"PORT":4455}`),
+	}
+	if err := store.Create(plugin); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	if err := store.UpdateTemplate(plugin.ID, "const PORT = 1234;", 2, json.RawMessage(`{"PORT":1234}`)); err != nil {
+		t.Fatalf("Failed to update template: %v", err)
+	}
+
+	stored, err := store.GetByID(plugin.ID)
+	if err != nil {
+		t.Fatalf("Failed to get plugin: %v", err)
+	}
+	if stored.TemplateID == nil || *stored.TemplateID != "obs" || stored.TemplateVersion != 2 {
+		t.Errorf("Expected template obs version 2, got %v %d", stored.TemplateID, stored.TemplateVersion)
+	}
+	if string(stored.TemplateVariables) != `{"PORT":1234}` || stored.Code != "const PORT = 1234;" {
+		t.Errorf("Expected the new values and code, got %s %q", stored.TemplateVariables, stored.Code)
+	}
+
+	revisions, err := store.ListRevisions(plugin.ID)
+	if err != nil {
+		t.Fatalf("Failed to list revisions: %v", err)
+	}
+	if len(revisions) != 2 {
+		t.Errorf("Expected 2 revisions, got %d", len(revisions))
+	}
+
+	manual := &Plugin{Name: "Manual", Code: "1", ConcurrencyPolicy: "parallel"}
+	if err := store.Create(manual); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+	if manual.TemplateID != nil {
+		t.Error("Expected a plugin without template")
+	}
+	if err := store.UpdateTemplate(manual.ID, "2", 1, nil); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a plugin without template, got %v", err)
+	}
+}
+
+func TestPluginStore_SetRunContinuously(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	store := NewPluginStore(db)
+	plugin := &Plugin{Name: "Ticker", Code: "1", ConcurrencyPolicy: "parallel", RunContinuously: true, IntervalSeconds: 5}
+	if err := store.Create(plugin); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	if err := store.SetRunContinuously(plugin.ID, false); err != nil {
+		t.Fatalf("Failed to switch off continuous running: %v", err)
+	}
+	stored, err := store.GetByID(plugin.ID)
+	if err != nil {
+		t.Fatalf("Failed to get plugin: %v", err)
+	}
+	if stored.RunContinuously || stored.IntervalSeconds != 5 {
+		t.Errorf("Expected continuous running off with the interval kept, got %v %d", stored.RunContinuously, stored.IntervalSeconds)
+	}
+	if revisions, _ := store.ListRevisions(plugin.ID); len(revisions) != 2 {
+		t.Errorf("Expected 2 revisions, got %d", len(revisions))
+	}
+
+	if err := store.SetRunContinuously(999, true); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a missing plugin, got %v", err)
+	}
+}
+
+func TestWebhookStore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		t.Fatalf("Failed to enable foreign keys: %v", err)
+	}
+
+	plugins := NewPluginStore(db)
+	plugin := &Plugin{Name: "Hooked", Code: "console.log(1)"}
+	if err := plugins.Create(plugin); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	store := NewWebhookStore(db)
+	webhook := &Webhook{PluginID: plugin.ID, Name: "CI", TokenHash: "one", Mode: "sync"}
+	if err := store.Create(webhook); err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	if err := store.Create(&Webhook{PluginID: plugin.ID, Name: "Duplicate", TokenHash: "one", Mode: "sync"}); err == nil {
+		t.Error("Expected tokens to be unique")
+	}
+
+	webhook.TokenHash = "two"
+	webhook.SigningSecret = []byte("sealed")
+	webhook.Mode = "async"
+	if err := store.Update(webhook); err != nil {
+		t.Fatalf("Failed to update webhook: %v", err)
+	}
+	if _, err := store.GetByTokenHash("one"); err != sql.ErrNoRows {
+		t.Errorf("Expected the old token to be gone, got %v", err)
+	}
+	found, err := store.GetByTokenHash("two")
+	if err != nil {
+		t.Fatalf("Failed to get webhook by token: %v", err)
+	}
+	if found.ID != webhook.ID || found.Mode != "async" || string(found.SigningSecret) != "sealed" || found.LastUsedAt != nil {
+		t.Errorf("Unexpected webhook %+v", found)
+	}
+
+	if err := store.Touch(webhook.ID, time.Now()); err != nil {
+		t.Fatalf("Failed to touch webhook: %v", err)
+	}
+	list, err := store.ListByPlugin(plugin.ID)
+	if err != nil {
+		t.Fatalf("Failed to list webhooks: %v", err)
+	}
+	if len(list) != 1 || list[0].LastUsedAt == nil {
+		t.Errorf("Expected one used webhook, got %+v", list)
+	}
+
+	// Webhooks go with their plugin
+	if err := plugins.Delete(plugin.ID); err != nil {
+		t.Fatalf("Failed to delete plugin: %v", err)
+	}
+	if _, err := store.GetByID(webhook.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected the webhook to be deleted with its plugin, got %v", err)
+	}
+	if err := store.Delete(webhook.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows deleting a missing webhook, got %v", err)
+	}
+}
+
+func TestPluginStore_Macros(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		t.Fatalf("Failed to enable foreign keys: %v", err)
+	}
+
+	store := NewPluginStore(db)
+	script := &Plugin{Name: "Scene", Code: "console.log(1)"}
+	if err := store.Create(script); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+	if script.Kind != PluginKindScript || script.Runtime != DefaultRuntime {
+		t.Errorf("Expected plugins to be Bun scripts by default, got %q with %q", script.Kind, script.Runtime)
+	}
+
+	macro := &Plugin{Name: "Go Live"}
+	steps := []MacroStep{
+		{Action: "run", TargetPluginID: &script.ID, Input: json.RawMessage(`{"scene":"live"}`)},
+		{Action: "delay", DelayMS: 1500, Condition: "success"},
+	}
+	if err := store.CreateMacro(macro, steps); err != nil {
+		t.Fatalf("Failed to create macro: %v", err)
+	}
+	found, err := store.GetByID(macro.ID)
+	if err != nil {
+		t.Fatalf("Failed to get macro: %v", err)
+	}
+	if found.Kind != PluginKindMacro || found.PageID == 0 {
+		t.Errorf("Expected a macro on the default page, got %+v", found)
+	}
+
+	list, err := store.ListSteps(macro.ID)
+	if err != nil {
+		t.Fatalf("Failed to list steps: %v", err)
+	}
+	if len(list) != 2 || *list[0].TargetPluginID != script.ID || string(list[0].Input) != `{"scene":"live"}` {
+		t.Fatalf("Unexpected steps %+v", list)
+	}
+	if list[1].Position != 1 || list[1].TargetPluginID != nil || list[1].DelayMS != 1500 || list[1].Condition != "success" {
+		t.Errorf("Unexpected delay step %+v", list[1])
+	}
+
+	if err := store.ReplaceSteps(macro.ID, []MacroStep{{Action: "run", TargetPluginID: &script.ID, ContinueOnFailure: true}}); err != nil {
+		t.Fatalf("Failed to replace steps: %v", err)
+	}
+	list, _ = store.ListSteps(macro.ID)
+	if len(list) != 1 || !list[0].ContinueOnFailure || list[0].Input != nil {
+		t.Errorf("Expected the replaced step, got %+v", list)
+	}
+
+	if err := store.ReplaceSteps(script.ID, nil); err != ErrNotMacro {
+		t.Errorf("Expected ErrNotMacro for a script, got %v", err)
+	}
+	if err := store.ReplaceSteps(999, nil); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a missing plugin, got %v", err)
+	}
+
+	// Steps go with their macro
+	if err := store.Delete(macro.ID); err != nil {
+		t.Fatalf("Failed to delete macro: %v", err)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM macro_steps").Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected the steps to be deleted with the macro, got %d", count)
+	}
+}
+
+func TestPluginStore_Presets(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		t.Fatalf("Failed to enable foreign keys: %v", err)
+	}
+
+	store := NewPluginStore(db)
+	plugin := &Plugin{Name: "Scene", Code: "console.log(1)"}
+	if err := store.Create(plugin); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	outro := &Preset{PluginID: plugin.ID, Name: "Outro", Input: json.RawMessage(`{"scene":"outro"}`)}
+	intro := &Preset{PluginID: plugin.ID, Name: "Intro", Input: json.RawMessage(`{"scene":"intro"}`)}
+	for _, preset := range []*Preset{outro, intro} {
+		if err := store.SavePreset(preset); err != nil {
+			t.Fatalf("Failed to save preset: %v", err)
+		}
+	}
+	if err := store.SavePreset(&Preset{PluginID: plugin.ID, Name: "Intro", Input: json.RawMessage(`1`)}); err != ErrPresetExists {
+		t.Errorf("Expected ErrPresetExists for a duplicate name, got %v", err)
+	}
+
+	list, err := store.ListPresets(plugin.ID)
+	if err != nil {
+		t.Fatalf("Failed to list presets: %v", err)
+	}
+	if len(list) != 2 || list[0].Name != "Intro" || list[1].Name != "Outro" {
+		t.Errorf("Expected presets by name, got %+v", list)
+	}
+
+	intro.Input = json.RawMessage(`{"scene":"opening"}`)
+	if err := store.SavePreset(intro); err != nil {
+		t.Fatalf("Failed to update preset: %v", err)
+	}
+	found, err := store.GetPreset(plugin.ID, "Intro")
+	if err != nil {
+		t.Fatalf("Failed to get preset: %v", err)
+	}
+	if found.ID != intro.ID || string(found.Input) != `{"scene":"opening"}` || found.CreatedAt.IsZero() {
+		t.Errorf("Unexpected preset %+v", found)
+	}
+	if err := store.SavePreset(&Preset{ID: 999, PluginID: plugin.ID, Name: "Gone", Input: json.RawMessage(`1`)}); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows updating a missing preset, got %v", err)
+	}
+
+	if err := store.DeletePreset(plugin.ID, outro.ID); err != nil {
+		t.Fatalf("Failed to delete preset: %v", err)
+	}
+	if err := store.DeletePreset(plugin.ID, outro.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows deleting twice, got %v", err)
+	}
+
+	// Presets go with their plugin
+	if err := store.Delete(plugin.ID); err != nil {
+		t.Fatalf("Failed to delete plugin: %v", err)
+	}
+	if _, err := store.GetPreset(plugin.ID, "Intro"); err != sql.ErrNoRows {
+		t.Errorf("Expected the preset to be deleted with its plugin, got %v", err)
+	}
+}
+
+func TestScheduleStore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := InitDB(db); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	plugin := &Plugin{Name: "Recorder", Code: "code", OrderNum: 1}
+	if err := NewPluginStore(db).Create(plugin); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	store := NewScheduleStore(db)
+	if fired, err := store.LastFired(plugin.ID); err != nil || !fired.IsZero() {
+		t.Errorf("Expected no fire time before the first fire, got %v, %v", fired, err)
+	}
+
+	firedAt := time.Date(2025, time.March, 12, 9, 0, 0, 0, time.UTC)
+	for _, at := range []time.Time{firedAt.Add(-24 * time.Hour), firedAt} {
+		if err := store.SetLastFired(plugin.ID, at); err != nil {
+			t.Fatalf("Failed to record fire time: %v", err)
+		}
+	}
+	if fired, err := store.LastFired(plugin.ID); err != nil || !fired.Equal(firedAt) {
+		t.Errorf("Expected the latest fire time %v, got %v, %v", firedAt, fired, err)
+	}
+
+	if err := store.ClearLastFired(plugin.ID); err != nil {
+		t.Fatalf("Failed to clear fire time: %v", err)
+	}
+	if fired, err := store.LastFired(plugin.ID); err != nil || !fired.IsZero() {
+		t.Errorf("Expected no fire time after clearing it, got %v, %v", fired, err)
+	}
+}
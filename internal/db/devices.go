@@ -0,0 +1,109 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Device is a client paired with BunDeck. TokenHash identifies the device's
+// bearer token and is never serialised.
+type Device struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Role       string     `json:"role"`
+	TokenHash  string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
+
+type DeviceStore struct {
+	db *sql.DB
+}
+
+func NewDeviceStore(db *sql.DB) *DeviceStore {
+	return &DeviceStore{db: db}
+}
+
+const deviceColumns = "id, name, role, token_hash, created_at, last_seen_at"
+
+func scanDevice(row scanner) (*Device, error) {
+	device := &Device{}
+	var lastSeen sql.NullTime
+	if err := row.Scan(&device.ID, &device.Name, &device.Role, &device.TokenHash, &device.CreatedAt, &lastSeen); err != nil {
+		return nil, err
+	}
+	if lastSeen.Valid {
+		device.LastSeenAt = &lastSeen.Time
+	}
+	return device, nil
+}
+
+func (s *DeviceStore) Create(device *Device) error {
+	device.CreatedAt = time.Now()
+	result, err := s.db.Exec(
+		"INSERT INTO devices (name, role, token_hash, created_at) VALUES (?, ?, ?, ?)",
+		device.Name,
+		device.Role,
+		device.TokenHash,
+		device.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	device.ID = int(id)
+	return nil
+}
+
+// GetByTokenHash returns the device owning a token, or sql.ErrNoRows
+func (s *DeviceStore) GetByTokenHash(hash string) (*Device, error) {
+	return scanDevice(s.db.QueryRow("SELECT "+deviceColumns+" FROM devices WHERE token_hash = ?", hash))
+}
+
+// List returns all paired devices, oldest first
+func (s *DeviceStore) List() ([]Device, error) {
+	rows, err := s.db.Query("SELECT " + deviceColumns + " FROM devices ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []Device
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, *device)
+	}
+
+	return devices, rows.Err()
+}
+
+// Touch records that a device was just used
+func (s *DeviceStore) Touch(id int, seen time.Time) error {
+	_, err := s.db.Exec("UPDATE devices SET last_seen_at = ? WHERE id = ?", seen, id)
+	return err
+}
+
+func (s *DeviceStore) Delete(id int) error {
+	result, err := s.db.Exec("DELETE FROM devices WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
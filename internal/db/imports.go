@@ -0,0 +1,193 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ImportTx writes an imported deck in a single transaction, so a failed or
+// previewed import leaves the database untouched
+type ImportTx struct {
+	tx  *sql.Tx
+	now time.Time
+}
+
+// BeginImport starts an import. Callers must Commit or Rollback.
+func (s *PluginStore) BeginImport() (*ImportTx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &ImportTx{tx: tx, now: time.Now()}, nil
+}
+
+func (t *ImportTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *ImportTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// Clear removes all profiles, pages and plugins and returns the IDs of the
+// removed plugins
+func (t *ImportTx) Clear() ([]int, error) {
+	rows, err := t.tx.Query("SELECT id FROM plugins")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, statement := range []string{
+		"DELETE FROM plugins",
+		"DELETE FROM pages",
+		"DELETE FROM profiles",
+	} {
+		if _, err := t.tx.Exec(statement); err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, nil
+}
+
+// FindProfile returns the first profile called name, or sql.ErrNoRows
+func (t *ImportTx) FindProfile(name string) (*Profile, error) {
+	return scanProfile(t.tx.QueryRow("SELECT "+profileColumns+" FROM profiles WHERE name = ? ORDER BY id LIMIT 1", name))
+}
+
+// CreateProfile adds a profile without any pages
+func (t *ImportTx) CreateProfile(profile *Profile) error {
+	profile.CreatedAt = t.now
+	profile.UpdatedAt = t.now
+
+	result, err := t.tx.Exec(
+		"INSERT INTO profiles (name, active, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		profile.Name,
+		profile.Active,
+		profile.CreatedAt,
+		profile.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	profile.ID = int(id)
+	return nil
+}
+
+// FindPage returns the page called name below parentID, or among the
+// top-level pages of the profile when parentID is nil
+func (t *ImportTx) FindPage(profileID int, parentID *int, name string) (*Page, error) {
+	return scanPage(t.tx.QueryRow(
+		"SELECT "+pageColumns+" FROM pages WHERE profile_id = ? AND parent_id IS ? AND name = ? ORDER BY id LIMIT 1",
+		profileID,
+		parentID,
+		name,
+	))
+}
+
+// NextPageOrder returns the position after the last page below parentID
+func (t *ImportTx) NextPageOrder(profileID int, parentID *int) (int, error) {
+	var next int
+	err := t.tx.QueryRow(
+		"SELECT COALESCE(MAX(order_num) + 1, 0) FROM pages WHERE profile_id = ? AND parent_id IS ?",
+		profileID,
+		parentID,
+	).Scan(&next)
+	return next, err
+}
+
+func (t *ImportTx) CreatePage(page *Page) error {
+	page.CreatedAt = t.now
+	page.UpdatedAt = t.now
+
+	result, err := t.tx.Exec(
+		"INSERT INTO pages (profile_id, parent_id, name, order_num, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		page.ProfileID,
+		page.ParentID,
+		page.Name,
+		page.OrderNum,
+		page.CreatedAt,
+		page.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	page.ID = int(id)
+	return nil
+}
+
+// PluginNames returns the names of the plugins on a page
+func (t *ImportTx) PluginNames(pageID int) (map[string]bool, error) {
+	rows, err := t.tx.Query("SELECT name FROM plugins WHERE page_id = ?", pageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names[name] = true
+	}
+
+	return names, rows.Err()
+}
+
+// FindPlugin returns the plugin called name on a page
+func (t *ImportTx) FindPlugin(pageID int, name string) (*Plugin, error) {
+	return scanPlugin(t.tx.QueryRow(
+		"SELECT "+pluginColumns+" FROM plugins WHERE page_id = ? AND name = ? ORDER BY id LIMIT 1",
+		pageID,
+		name,
+	))
+}
+
+// NextPluginOrder returns the position after the last plugin on a page
+func (t *ImportTx) NextPluginOrder(pageID int) (int, error) {
+	var next int
+	err := t.tx.QueryRow("SELECT COALESCE(MAX(order_num) + 1, 0) FROM plugins WHERE page_id = ?", pageID).Scan(&next)
+	return next, err
+}
+
+// CreatePlugin adds a plugin on the page given by its PageID
+func (t *ImportTx) CreatePlugin(plugin *Plugin) error {
+	plugin.CreatedAt = t.now
+	plugin.UpdatedAt = t.now
+	return insertPlugin(t.tx, plugin)
+}
+
+// ActivateProfile makes the profile the only active one
+func (t *ImportTx) ActivateProfile(id int) error {
+	if _, err := t.tx.Exec("UPDATE profiles SET active = (id = ?)", id); err != nil {
+		return err
+	}
+	return nil
+}
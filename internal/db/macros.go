@@ -0,0 +1,173 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrNotMacro is returned when macro steps are saved for a script plugin
+var ErrNotMacro = errors.New("plugin is not a macro")
+
+// MacroStep is one step of a macro plugin. Steps run in the order of their
+// Position.
+type MacroStep struct {
+	ID       int `json:"id"`
+	PluginID int `json:"plugin_id"`
+	Position int `json:"position"`
+	// Action is one of the macro.Action* values
+	Action string `json:"action"`
+	// TargetPluginID is the plugin run by run steps
+	TargetPluginID *int `json:"target_plugin_id"`
+	// Input is passed to the target plugin, the same as the input of a
+	// webhook call
+	Input   json.RawMessage `json:"input,omitempty"`
+	DelayMS int             `json:"delay_ms"`
+	// Condition is one of the macro.Condition* values, checked against the
+	// exit code of the previous run step. ExitCode is the code compared by
+	// the exit code condition.
+	Condition string `json:"condition"`
+	ExitCode  int    `json:"exit_code"`
+	// ContinueOnFailure lets the macro go on when the step fails instead of
+	// aborting it
+	ContinueOnFailure bool `json:"continue_on_failure"`
+}
+
+const macroStepColumns = "id, plugin_id, position, action, target_plugin_id, input, delay_ms, condition, exit_code, continue_on_failure"
+
+func scanMacroStep(row scanner) (*MacroStep, error) {
+	var step MacroStep
+	var target sql.NullInt64
+	var input sql.NullString
+	err := row.Scan(&step.ID, &step.PluginID, &step.Position, &step.Action, &target, &input, &step.DelayMS, &step.Condition, &step.ExitCode, &step.ContinueOnFailure)
+	if err != nil {
+		return nil, err
+	}
+	if target.Valid {
+		id := int(target.Int64)
+		step.TargetPluginID = &id
+	}
+	if input.Valid {
+		step.Input = json.RawMessage(input.String)
+	}
+	return &step, nil
+}
+
+// ListSteps returns the steps of a macro in order
+func (s *PluginStore) ListSteps(pluginID int) ([]MacroStep, error) {
+	rows, err := s.db.Query("SELECT "+macroStepColumns+" FROM macro_steps WHERE plugin_id = ? ORDER BY position", pluginID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps := []MacroStep{}
+	for rows.Next() {
+		step, err := scanMacroStep(rows)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, *step)
+	}
+
+	return steps, rows.Err()
+}
+
+// CreateMacro adds a macro plugin together with its steps. Macros without a
+// page are put on the first page of the active profile.
+func (s *PluginStore) CreateMacro(plugin *Plugin, steps []MacroStep) error {
+	now := time.Now()
+	plugin.Kind = PluginKindMacro
+	plugin.CreatedAt = now
+	plugin.UpdatedAt = now
+
+	if plugin.PageID == 0 {
+		pageID, err := defaultPageID(s.db)
+		if err != nil {
+			return err
+		}
+		plugin.PageID = pageID
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertPlugin(tx, plugin); err != nil {
+		return err
+	}
+	if err := insertSteps(tx, plugin.ID, steps); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceSteps replaces all steps of a macro
+func (s *PluginStore) ReplaceSteps(pluginID int, steps []MacroStep) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var kind string
+	if err := tx.QueryRow("SELECT kind FROM plugins WHERE id = ?", pluginID).Scan(&kind); err != nil {
+		return err
+	}
+	if kind != PluginKindMacro {
+		return ErrNotMacro
+	}
+
+	if _, err := tx.Exec("DELETE FROM macro_steps WHERE plugin_id = ?", pluginID); err != nil {
+		return err
+	}
+	if err := insertSteps(tx, pluginID, steps); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE plugins SET updated_at = ? WHERE id = ?", time.Now(), pluginID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertSteps stores steps of a macro, numbering their positions in order
+func insertSteps(tx *sql.Tx, pluginID int, steps []MacroStep) error {
+	for i := range steps {
+		step := &steps[i]
+		step.PluginID = pluginID
+		step.Position = i
+		result, err := tx.Exec(
+			"INSERT INTO macro_steps (plugin_id, position, action, target_plugin_id, input, delay_ms, condition, exit_code, continue_on_failure) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			step.PluginID,
+			step.Position,
+			step.Action,
+			step.TargetPluginID,
+			nullableJSON(step.Input),
+			step.DelayMS,
+			step.Condition,
+			step.ExitCode,
+			step.ContinueOnFailure,
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		step.ID = int(id)
+	}
+	return nil
+}
+
+// CreateSteps adds the steps of an imported macro, once the plugins they run
+// have been imported
+func (t *ImportTx) CreateSteps(pluginID int, steps []MacroStep) error {
+	return insertSteps(t.tx, pluginID, steps)
+}
@@ -0,0 +1,392 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrActiveProfile is returned when deleting the active profile
+	ErrActiveProfile = errors.New("the active profile cannot be deleted")
+	// ErrPageNotEmpty is returned when deleting a page or profile that still
+	// holds plugins
+	ErrPageNotEmpty = errors.New("move or delete the plugins on this page first")
+	// ErrLastPage is returned when deleting the only top-level page of a profile
+	ErrLastPage = errors.New("a profile needs at least one page")
+	// ErrInvalidParent is returned when a folder would be moved into itself or
+	// into another profile
+	ErrInvalidParent = errors.New("invalid parent page")
+)
+
+// Profile is a named set of pages. Exactly one profile is active and shown
+// on the deck.
+type Profile struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Page is a grid of buttons. Top-level pages have no parent, pages with a
+// parent are folders shown as a button on the parent page at OrderNum.
+type Page struct {
+	ID        int       `json:"id"`
+	ProfileID int       `json:"profile_id"`
+	ParentID  *int      `json:"parent_id"`
+	Name      string    `json:"name"`
+	OrderNum  int       `json:"order_num"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type PageStore struct {
+	db *sql.DB
+}
+
+func NewPageStore(db *sql.DB) *PageStore {
+	return &PageStore{db: db}
+}
+
+const profileColumns = "id, name, active, created_at, updated_at"
+
+const pageColumns = "id, profile_id, parent_id, name, order_num, created_at, updated_at"
+
+func scanProfile(row scanner) (*Profile, error) {
+	var p Profile
+	if err := row.Scan(&p.ID, &p.Name, &p.Active, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func scanPage(row scanner) (*Page, error) {
+	var p Page
+	var parentID sql.NullInt64
+	if err := row.Scan(&p.ID, &p.ProfileID, &parentID, &p.Name, &p.OrderNum, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		p.ParentID = &id
+	}
+	return &p, nil
+}
+
+// defaultPageID returns the first top-level page of the active profile
+func defaultPageID(db *sql.DB) (int, error) {
+	var id int
+	err := db.QueryRow(
+		"SELECT pages.id FROM pages JOIN profiles ON profiles.id = pages.profile_id WHERE profiles.active = 1 AND pages.parent_id IS NULL ORDER BY pages.order_num, pages.id LIMIT 1",
+	).Scan(&id)
+	return id, err
+}
+
+func (s *PageStore) ListProfiles() ([]Profile, error) {
+	rows, err := s.db.Query("SELECT " + profileColumns + " FROM profiles ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []Profile
+	for rows.Next() {
+		p, err := scanProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, *p)
+	}
+
+	return profiles, rows.Err()
+}
+
+func (s *PageStore) GetProfile(id int) (*Profile, error) {
+	return scanProfile(s.db.QueryRow("SELECT "+profileColumns+" FROM profiles WHERE id = ?", id))
+}
+
+func (s *PageStore) ActiveProfile() (*Profile, error) {
+	return scanProfile(s.db.QueryRow("SELECT " + profileColumns + " FROM profiles WHERE active = 1 ORDER BY id LIMIT 1"))
+}
+
+// CreateProfile adds an inactive profile together with its first page
+func (s *PageStore) CreateProfile(profile *Profile) error {
+	now := time.Now()
+	profile.Active = false
+	profile.CreatedAt = now
+	profile.UpdatedAt = now
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO profiles (name, active, created_at, updated_at) VALUES (?, 0, ?, ?)",
+		profile.Name,
+		profile.CreatedAt,
+		profile.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	profile.ID = int(id)
+
+	if _, err := tx.Exec(
+		"INSERT INTO pages (profile_id, name, order_num, created_at, updated_at) VALUES (?, 'Home', 0, ?, ?)",
+		profile.ID,
+		now,
+		now,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PageStore) RenameProfile(id int, name string) error {
+	result, err := s.db.Exec("UPDATE profiles SET name = ?, updated_at = ? WHERE id = ?", name, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// ActivateProfile makes a profile the one shown on the deck
+func (s *PageStore) ActivateProfile(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE profiles SET active = 1, updated_at = ? WHERE id = ?", time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(result); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE profiles SET active = 0 WHERE id != ?", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteProfile removes an inactive profile whose pages hold no plugins
+func (s *PageStore) DeleteProfile(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	profile, err := scanProfile(tx.QueryRow("SELECT "+profileColumns+" FROM profiles WHERE id = ?", id))
+	if err != nil {
+		return err
+	}
+	if profile.Active {
+		return ErrActiveProfile
+	}
+
+	var plugins int
+	if err := tx.QueryRow(
+		"SELECT COUNT(*) FROM plugins WHERE page_id IN (SELECT id FROM pages WHERE profile_id = ?)",
+		id,
+	).Scan(&plugins); err != nil {
+		return err
+	}
+	if plugins > 0 {
+		return ErrPageNotEmpty
+	}
+
+	if _, err := tx.Exec("DELETE FROM pages WHERE profile_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM profiles WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListPages returns all pages and folders of a profile
+func (s *PageStore) ListPages(profileID int) ([]Page, error) {
+	rows, err := s.db.Query("SELECT "+pageColumns+" FROM pages WHERE profile_id = ? ORDER BY order_num, id", profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pages []Page
+	for rows.Next() {
+		p, err := scanPage(rows)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, *p)
+	}
+
+	return pages, rows.Err()
+}
+
+func (s *PageStore) GetPage(id int) (*Page, error) {
+	return scanPage(s.db.QueryRow("SELECT "+pageColumns+" FROM pages WHERE id = ?", id))
+}
+
+// CreatePage adds a page. A folder inherits the profile of its parent.
+func (s *PageStore) CreatePage(page *Page) error {
+	if page.ParentID != nil {
+		parent, err := s.GetPage(*page.ParentID)
+		if err == sql.ErrNoRows {
+			return ErrInvalidParent
+		}
+		if err != nil {
+			return err
+		}
+		page.ProfileID = parent.ProfileID
+	} else if _, err := s.GetProfile(page.ProfileID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	page.CreatedAt = now
+	page.UpdatedAt = now
+
+	result, err := s.db.Exec(
+		"INSERT INTO pages (profile_id, parent_id, name, order_num, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		page.ProfileID,
+		page.ParentID,
+		page.Name,
+		page.OrderNum,
+		page.CreatedAt,
+		page.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	page.ID = int(id)
+	return nil
+}
+
+// UpdatePage renames and moves a page. A nil parentID makes it a top-level
+// page of its profile.
+func (s *PageStore) UpdatePage(id int, name string, parentID *int, orderNum int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	page, err := scanPage(tx.QueryRow("SELECT "+pageColumns+" FROM pages WHERE id = ?", id))
+	if err != nil {
+		return err
+	}
+
+	// Walk up from the new parent to make sure the page is not moved into
+	// itself or one of its own folders
+	for ancestor := parentID; ancestor != nil; {
+		if *ancestor == id {
+			return ErrInvalidParent
+		}
+		parent, err := scanPage(tx.QueryRow("SELECT "+pageColumns+" FROM pages WHERE id = ?", *ancestor))
+		if err == sql.ErrNoRows {
+			return ErrInvalidParent
+		}
+		if err != nil {
+			return err
+		}
+		if parent.ProfileID != page.ProfileID {
+			return ErrInvalidParent
+		}
+		ancestor = parent.ParentID
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE pages SET name = ?, parent_id = ?, order_num = ?, updated_at = ? WHERE id = ?",
+		name,
+		parentID,
+		orderNum,
+		time.Now(),
+		id,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeletePage removes a page and its folders when none of them hold plugins
+func (s *PageStore) DeletePage(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	page, err := scanPage(tx.QueryRow("SELECT "+pageColumns+" FROM pages WHERE id = ?", id))
+	if err != nil {
+		return err
+	}
+
+	if page.ParentID == nil {
+		var topLevel int
+		if err := tx.QueryRow(
+			"SELECT COUNT(*) FROM pages WHERE profile_id = ? AND parent_id IS NULL",
+			page.ProfileID,
+		).Scan(&topLevel); err != nil {
+			return err
+		}
+		if topLevel <= 1 {
+			return ErrLastPage
+		}
+	}
+
+	subtree := `WITH RECURSIVE subtree(id) AS (
+		SELECT ?
+		UNION ALL
+		SELECT pages.id FROM pages JOIN subtree ON pages.parent_id = subtree.id
+	)`
+
+	var plugins int
+	if err := tx.QueryRow(
+		subtree+" SELECT COUNT(*) FROM plugins WHERE page_id IN (SELECT id FROM subtree)",
+		id,
+	).Scan(&plugins); err != nil {
+		return err
+	}
+	if plugins > 0 {
+		return ErrPageNotEmpty
+	}
+
+	if _, err := tx.Exec(subtree+" DELETE FROM pages WHERE id IN (SELECT id FROM subtree)", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// requireRow returns sql.ErrNoRows when a statement changed nothing
+func requireRow(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
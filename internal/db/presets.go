@@ -0,0 +1,167 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrPresetExists is returned when a plugin already has a preset of the name
+var ErrPresetExists = errors.New("preset already exists")
+
+// Preset is a named input a plugin can be run with, so several buttons can
+// share one script with different arguments
+type Preset struct {
+	ID        int             `json:"id"`
+	PluginID  int             `json:"plugin_id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// WithInput applies the keys of input on top of the preset's input when both
+// are objects, otherwise a set input replaces it
+func (p *Preset) WithInput(input json.RawMessage) json.RawMessage {
+	if len(input) == 0 {
+		return p.Input
+	}
+
+	var merged, overrides map[string]json.RawMessage
+	if json.Unmarshal(p.Input, &merged) != nil || json.Unmarshal(input, &overrides) != nil || merged == nil || overrides == nil {
+		return input
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return input
+	}
+	return data
+}
+
+const presetColumns = "id, plugin_id, name, input, created_at, updated_at"
+
+func scanPreset(row scanner) (*Preset, error) {
+	var preset Preset
+	var input string
+	if err := row.Scan(&preset.ID, &preset.PluginID, &preset.Name, &input, &preset.CreatedAt, &preset.UpdatedAt); err != nil {
+		return nil, err
+	}
+	preset.Input = json.RawMessage(input)
+	return &preset, nil
+}
+
+// ListPresets returns the presets of a plugin by name
+func (s *PluginStore) ListPresets(pluginID int) ([]Preset, error) {
+	rows, err := s.db.Query("SELECT "+presetColumns+" FROM plugin_presets WHERE plugin_id = ? ORDER BY name", pluginID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	presets := []Preset{}
+	for rows.Next() {
+		preset, err := scanPreset(rows)
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, *preset)
+	}
+
+	return presets, rows.Err()
+}
+
+// GetPreset returns a preset of a plugin by name, or sql.ErrNoRows
+func (s *PluginStore) GetPreset(pluginID int, name string) (*Preset, error) {
+	return scanPreset(s.db.QueryRow("SELECT "+presetColumns+" FROM plugin_presets WHERE plugin_id = ? AND name = ?", pluginID, name))
+}
+
+// SavePreset adds a preset, or updates it when its ID is set. It returns
+// ErrPresetExists when another preset of the plugin has the same name.
+func (s *PluginStore) SavePreset(preset *Preset) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := savePreset(tx, preset, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func savePreset(tx *sql.Tx, preset *Preset, now time.Time) error {
+	var taken int
+	err := tx.QueryRow("SELECT COUNT(*) FROM plugin_presets WHERE plugin_id = ? AND name = ? AND id != ?", preset.PluginID, preset.Name, preset.ID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrPresetExists
+	}
+
+	preset.UpdatedAt = now
+	if preset.ID != 0 {
+		result, err := tx.Exec(
+			"UPDATE plugin_presets SET name = ?, input = ?, updated_at = ? WHERE id = ? AND plugin_id = ?",
+			preset.Name,
+			string(preset.Input),
+			preset.UpdatedAt,
+			preset.ID,
+			preset.PluginID,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return sql.ErrNoRows
+		}
+		return tx.QueryRow("SELECT created_at FROM plugin_presets WHERE id = ?", preset.ID).Scan(&preset.CreatedAt)
+	}
+
+	preset.CreatedAt = now
+	result, err := tx.Exec(
+		"INSERT INTO plugin_presets (plugin_id, name, input, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		preset.PluginID,
+		preset.Name,
+		string(preset.Input),
+		preset.CreatedAt,
+		preset.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	preset.ID = int(id)
+	return nil
+}
+
+// DeletePreset removes a preset of a plugin, or returns sql.ErrNoRows
+func (s *PluginStore) DeletePreset(pluginID int, id int) error {
+	result, err := s.db.Exec("DELETE FROM plugin_presets WHERE id = ? AND plugin_id = ?", id, pluginID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreatePreset adds a preset of an imported plugin
+func (t *ImportTx) CreatePreset(preset *Preset) error {
+	preset.ID = 0
+	return savePreset(t.tx, preset, t.now)
+}
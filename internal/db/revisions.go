@@ -0,0 +1,208 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PluginRevision is a saved version of a plugin's code, name and settings.
+// Revisions are numbered per plugin starting at 1.
+type PluginRevision struct {
+	ID                int    `json:"id"`
+	PluginID          int    `json:"plugin_id"`
+	Revision          int    `json:"revision"`
+	Name              string `json:"name"`
+	Code              string `json:"code"`
+	RunContinuously   bool   `json:"run_continuously"`
+	IntervalSeconds   int    `json:"interval_seconds"`
+	CronExpression    string `json:"cron_expression"`
+	TimeZone          string `json:"time_zone"`
+	MissedRunPolicy   string `json:"missed_run_policy"`
+	TimeoutSeconds    int    `json:"timeout_seconds"`
+	ConcurrencyPolicy string `json:"concurrency_policy"`
+	Runtime           string `json:"runtime"`
+	Resident          bool   `json:"resident"`
+	// RestoredFrom is the revision this one was restored from, if any
+	RestoredFrom *int      `json:"restored_from"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+const revisionColumns = "id, plugin_id, revision, name, code, run_continuously, interval_seconds, cron_expression, time_zone, missed_run_policy, timeout_seconds, concurrency_policy, runtime, resident, restored_from, created_at"
+
+func scanRevision(row scanner) (*PluginRevision, error) {
+	var r PluginRevision
+	var restoredFrom sql.NullInt64
+	err := row.Scan(&r.ID, &r.PluginID, &r.Revision, &r.Name, &r.Code, &r.RunContinuously, &r.IntervalSeconds, &r.CronExpression, &r.TimeZone, &r.MissedRunPolicy, &r.TimeoutSeconds, &r.ConcurrencyPolicy, &r.Runtime, &r.Resident, &restoredFrom, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if restoredFrom.Valid {
+		revision := int(restoredFrom.Int64)
+		r.RestoredFrom = &revision
+	}
+	return &r, nil
+}
+
+// sameContent reports whether a revision matches the current plugin
+func (r *PluginRevision) sameContent(p *Plugin) bool {
+	return r.Name == p.Name &&
+		r.Code == p.Code &&
+		r.RunContinuously == p.RunContinuously &&
+		r.IntervalSeconds == p.IntervalSeconds &&
+		r.CronExpression == p.CronExpression &&
+		r.TimeZone == p.TimeZone &&
+		r.MissedRunPolicy == p.MissedRunPolicy &&
+		r.TimeoutSeconds == p.TimeoutSeconds &&
+		r.ConcurrencyPolicy == p.ConcurrencyPolicy &&
+		r.Runtime == p.Runtime &&
+		r.Resident == p.Resident
+}
+
+// recordRevision stores the current state of a plugin as its next revision.
+// Unless the plugin is being restored, nothing is recorded when the state
+// matches the latest revision, which is returned instead.
+func recordRevision(tx *sql.Tx, pluginID int, restoredFrom *int) (*PluginRevision, error) {
+	plugin, err := scanPlugin(tx.QueryRow("SELECT "+pluginColumns+" FROM plugins WHERE id = ?", pluginID))
+	if err != nil {
+		return nil, err
+	}
+
+	latest, err := scanRevision(tx.QueryRow(
+		"SELECT "+revisionColumns+" FROM plugin_revisions WHERE plugin_id = ? ORDER BY revision DESC LIMIT 1",
+		pluginID,
+	))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if latest != nil && restoredFrom == nil && latest.sameContent(plugin) {
+		return latest, nil
+	}
+
+	revision := &PluginRevision{
+		PluginID:          pluginID,
+		Revision:          1,
+		Name:              plugin.Name,
+		Code:              plugin.Code,
+		RunContinuously:   plugin.RunContinuously,
+		IntervalSeconds:   plugin.IntervalSeconds,
+		CronExpression:    plugin.CronExpression,
+		TimeZone:          plugin.TimeZone,
+		MissedRunPolicy:   plugin.MissedRunPolicy,
+		TimeoutSeconds:    plugin.TimeoutSeconds,
+		ConcurrencyPolicy: plugin.ConcurrencyPolicy,
+		Runtime:           plugin.Runtime,
+		Resident:          plugin.Resident,
+		RestoredFrom:      restoredFrom,
+		CreatedAt:         time.Now(),
+	}
+	if latest != nil {
+		revision.Revision = latest.Revision + 1
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO plugin_revisions (plugin_id, revision, name, code, run_continuously, interval_seconds, cron_expression, time_zone, missed_run_policy, timeout_seconds, concurrency_policy, runtime, resident, restored_from, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		revision.PluginID,
+		revision.Revision,
+		revision.Name,
+		revision.Code,
+		revision.RunContinuously,
+		revision.IntervalSeconds,
+		revision.CronExpression,
+		revision.TimeZone,
+		revision.MissedRunPolicy,
+		revision.TimeoutSeconds,
+		revision.ConcurrencyPolicy,
+		revision.Runtime,
+		revision.Resident,
+		revision.RestoredFrom,
+		revision.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	revision.ID = int(id)
+	return revision, nil
+}
+
+// ListRevisions returns all revisions of a plugin, newest first
+func (s *PluginStore) ListRevisions(pluginID int) ([]PluginRevision, error) {
+	rows, err := s.db.Query("SELECT "+revisionColumns+" FROM plugin_revisions WHERE plugin_id = ? ORDER BY revision DESC", pluginID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []PluginRevision{}
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *r)
+	}
+
+	return revisions, rows.Err()
+}
+
+func (s *PluginStore) GetRevision(pluginID int, revision int) (*PluginRevision, error) {
+	return scanRevision(s.db.QueryRow(
+		"SELECT "+revisionColumns+" FROM plugin_revisions WHERE plugin_id = ? AND revision = ?",
+		pluginID,
+		revision,
+	))
+}
+
+// RestoreRevision puts the code, name and settings of an older revision back
+// on the plugin and records them as a new revision. The image is kept.
+func (s *PluginStore) RestoreRevision(pluginID int, revision int) (*PluginRevision, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := scanRevision(tx.QueryRow(
+		"SELECT "+revisionColumns+" FROM plugin_revisions WHERE plugin_id = ? AND revision = ?",
+		pluginID,
+		revision,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE plugins SET name = ?, code = ?, run_continuously = ?, interval_seconds = ?, cron_expression = ?, time_zone = ?, missed_run_policy = ?, timeout_seconds = ?, concurrency_policy = ?, runtime = ?, resident = ?, updated_at = ? WHERE id = ?",
+		old.Name,
+		old.Code,
+		old.RunContinuously,
+		old.IntervalSeconds,
+		old.CronExpression,
+		old.TimeZone,
+		old.MissedRunPolicy,
+		old.TimeoutSeconds,
+		old.ConcurrencyPolicy,
+		old.Runtime,
+		old.Resident,
+		time.Now(),
+		pluginID,
+	); err != nil {
+		return nil, err
+	}
+
+	restored, err := recordRevision(tx, pluginID, &old.Revision)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return restored, nil
+}
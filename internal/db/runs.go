@@ -0,0 +1,160 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// PluginRun is a single recorded execution of a plugin
+type PluginRun struct {
+	ID         int64  `json:"id"`
+	PluginID   int    `json:"plugin_id"`
+	Trigger    string `json:"trigger"`
+	Status     string `json:"status"`
+	ExitCode   *int   `json:"exit_code"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	Error      string `json:"error"`
+	OutputSize int    `json:"output_size"`
+	Truncated  bool   `json:"truncated"`
+	// Steps holds the results of the steps of a macro run
+	Steps      json.RawMessage `json:"steps,omitempty"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at"`
+}
+
+type RunStore struct {
+	db *sql.DB
+}
+
+func NewRunStore(db *sql.DB) *RunStore {
+	return &RunStore{db: db}
+}
+
+// Start records a run that has just begun and assigns its ID
+func (s *RunStore) Start(run *PluginRun) error {
+	result, err := s.db.Exec(
+		"INSERT INTO plugin_runs (plugin_id, trigger_source, status, started_at) VALUES (?, ?, ?, ?)",
+		run.PluginID,
+		run.Trigger,
+		run.Status,
+		run.StartedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	run.ID = id
+	return nil
+}
+
+// Finish stores the outcome of a run previously recorded with Start, and
+// when it started after waiting for its turn
+func (s *RunStore) Finish(run *PluginRun) error {
+	result, err := s.db.Exec(
+		"UPDATE plugin_runs SET status = ?, exit_code = ?, stdout = ?, stderr = ?, error = ?, output_size = ?, truncated = ?, steps = ?, started_at = ?, finished_at = ? WHERE id = ?",
+		run.Status,
+		run.ExitCode,
+		run.Stdout,
+		run.Stderr,
+		run.Error,
+		run.OutputSize,
+		run.Truncated,
+		nullableJSON(run.Steps),
+		run.StartedAt,
+		run.FinishedAt,
+		run.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ListByPlugin returns a page of runs for a plugin, newest first, along with the total count
+func (s *RunStore) ListByPlugin(pluginID int, limit int, offset int) ([]PluginRun, int, error) {
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM plugin_runs WHERE plugin_id = ?", pluginID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query(
+		"SELECT id, plugin_id, trigger_source, status, exit_code, stdout, stderr, error, output_size, truncated, steps, started_at, finished_at FROM plugin_runs WHERE plugin_id = ? ORDER BY id DESC LIMIT ? OFFSET ?",
+		pluginID,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	runs := []PluginRun{}
+	for rows.Next() {
+		var r PluginRun
+		var exitCode sql.NullInt64
+		var steps sql.NullString
+		var finishedAt sql.NullTime
+		err := rows.Scan(&r.ID, &r.PluginID, &r.Trigger, &r.Status, &exitCode, &r.Stdout, &r.Stderr, &r.Error, &r.OutputSize, &r.Truncated, &steps, &r.StartedAt, &finishedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		if exitCode.Valid {
+			code := int(exitCode.Int64)
+			r.ExitCode = &code
+		}
+		if steps.Valid {
+			r.Steps = json.RawMessage(steps.String)
+		}
+		if finishedAt.Valid {
+			r.FinishedAt = &finishedAt.Time
+		}
+		runs = append(runs, r)
+	}
+
+	return runs, total, rows.Err()
+}
+
+// Prune applies the retention policy, keeping at most keepPerPlugin finished
+// runs for the plugin and deleting runs of any plugin older than maxAge. Runs
+// still in progress are not counted or deleted by keepPerPlugin, so their
+// results can be recorded. Running rows older than maxAge were left behind
+// by a crash, no run lasts that long. Zero values disable the respective
+// limit.
+func (s *RunStore) Prune(pluginID int, keepPerPlugin int, maxAge time.Duration) error {
+	if keepPerPlugin > 0 {
+		_, err := s.db.Exec(
+			"DELETE FROM plugin_runs WHERE plugin_id = ? AND status != 'running' AND id NOT IN (SELECT id FROM plugin_runs WHERE plugin_id = ? AND status != 'running' ORDER BY id DESC LIMIT ?)",
+			pluginID,
+			pluginID,
+			keepPerPlugin,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if maxAge > 0 {
+		_, err := s.db.Exec("DELETE FROM plugin_runs WHERE started_at < ?", time.Now().Add(-maxAge))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ScheduleStore remembers when the cron schedule of each plugin last fired
+type ScheduleStore struct {
+	db *sql.DB
+}
+
+func NewScheduleStore(db *sql.DB) *ScheduleStore {
+	return &ScheduleStore{db: db}
+}
+
+// LastFired returns when the plugin's schedule last fired, the zero time if
+// it never did or was cleared since
+func (s *ScheduleStore) LastFired(pluginID int) (time.Time, error) {
+	var firedAt time.Time
+	err := s.db.QueryRow("SELECT fired_at FROM schedule_fires WHERE plugin_id = ?", pluginID).Scan(&firedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return firedAt, err
+}
+
+// SetLastFired records that the plugin's schedule fired at t
+func (s *ScheduleStore) SetLastFired(pluginID int, t time.Time) error {
+	_, err := s.db.Exec(
+		"INSERT INTO schedule_fires (plugin_id, fired_at) VALUES (?, ?) ON CONFLICT(plugin_id) DO UPDATE SET fired_at = excluded.fired_at",
+		pluginID,
+		t,
+	)
+	return err
+}
+
+// ClearLastFired forgets when the plugin's schedule last fired
+func (s *ScheduleStore) ClearLastFired(pluginID int) error {
+	_, err := s.db.Exec("DELETE FROM schedule_fires WHERE plugin_id = ?", pluginID)
+	return err
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Secret is a named value made available to plugins. Value holds the
+// encrypted value and is never serialised.
+type Secret struct {
+	Name      string    `json:"name"`
+	Value     []byte    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type SecretStore struct {
+	db *sql.DB
+}
+
+func NewSecretStore(db *sql.DB) *SecretStore {
+	return &SecretStore{db: db}
+}
+
+// List returns all secrets ordered by name
+func (s *SecretStore) List() ([]Secret, error) {
+	return s.query("SELECT name, value, created_at, updated_at FROM secrets ORDER BY name")
+}
+
+// ListForPlugin returns the secrets granted to a plugin ordered by name
+func (s *SecretStore) ListForPlugin(pluginID int) ([]Secret, error) {
+	return s.query(
+		"SELECT secrets.name, secrets.value, secrets.created_at, secrets.updated_at FROM secrets JOIN plugin_secrets ON plugin_secrets.secret_name = secrets.name WHERE plugin_secrets.plugin_id = ? ORDER BY secrets.name",
+		pluginID,
+	)
+}
+
+func (s *SecretStore) query(query string, args ...any) ([]Secret, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var secrets []Secret
+	for rows.Next() {
+		var secret Secret
+		if err := rows.Scan(&secret.Name, &secret.Value, &secret.CreatedAt, &secret.UpdatedAt); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+
+	return secrets, rows.Err()
+}
+
+// Put creates or replaces the value of a secret
+func (s *SecretStore) Put(name string, value []byte) error {
+	now := time.Now()
+	_, err := s.db.Exec(
+		"INSERT INTO secrets (name, value, created_at, updated_at) VALUES (?, ?, ?, ?) ON CONFLICT(name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at",
+		name,
+		value,
+		now,
+		now,
+	)
+	return err
+}
+
+// Grant lets a plugin read a secret
+func (s *SecretStore) Grant(pluginID int, name string) error {
+	_, err := s.db.Exec("INSERT OR IGNORE INTO plugin_secrets (plugin_id, secret_name) VALUES (?, ?)", pluginID, name)
+	return err
+}
+
+// SetPluginSecrets replaces the secrets granted to a plugin
+func (s *SecretStore) SetPluginSecrets(pluginID int, names []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM plugin_secrets WHERE plugin_id = ?", pluginID); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO plugin_secrets (plugin_id, secret_name) VALUES (?, ?)", pluginID, name); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SecretStore) Delete(name string) error {
+	result, err := s.db.Exec("DELETE FROM secrets WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Webhook runs a plugin when its URL is called. TokenHash identifies the
+// token in the URL and SigningSecret holds the encrypted HMAC secret of signed
+// webhooks, neither is serialised.
+type Webhook struct {
+	ID            int        `json:"id"`
+	PluginID      int        `json:"plugin_id"`
+	Name          string     `json:"name"`
+	TokenHash     string     `json:"-"`
+	SigningSecret []byte     `json:"-"`
+	Mode          string     `json:"mode"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastUsedAt    *time.Time `json:"last_used_at"`
+}
+
+type WebhookStore struct {
+	db *sql.DB
+}
+
+func NewWebhookStore(db *sql.DB) *WebhookStore {
+	return &WebhookStore{db: db}
+}
+
+const webhookColumns = "id, plugin_id, name, token_hash, signing_secret, mode, created_at, last_used_at"
+
+func scanWebhook(row scanner) (*Webhook, error) {
+	webhook := &Webhook{}
+	var lastUsed sql.NullTime
+	if err := row.Scan(&webhook.ID, &webhook.PluginID, &webhook.Name, &webhook.TokenHash, &webhook.SigningSecret, &webhook.Mode, &webhook.CreatedAt, &lastUsed); err != nil {
+		return nil, err
+	}
+	if lastUsed.Valid {
+		webhook.LastUsedAt = &lastUsed.Time
+	}
+	return webhook, nil
+}
+
+func (s *WebhookStore) Create(webhook *Webhook) error {
+	webhook.CreatedAt = time.Now()
+	result, err := s.db.Exec(
+		"INSERT INTO webhooks (plugin_id, name, token_hash, signing_secret, mode, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		webhook.PluginID,
+		webhook.Name,
+		webhook.TokenHash,
+		webhook.SigningSecret,
+		webhook.Mode,
+		webhook.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	webhook.ID = int(id)
+	return nil
+}
+
+// GetByID returns a webhook, or sql.ErrNoRows
+func (s *WebhookStore) GetByID(id int) (*Webhook, error) {
+	return scanWebhook(s.db.QueryRow("SELECT "+webhookColumns+" FROM webhooks WHERE id = ?", id))
+}
+
+// GetByTokenHash returns the webhook owning a token, or sql.ErrNoRows
+func (s *WebhookStore) GetByTokenHash(hash string) (*Webhook, error) {
+	return scanWebhook(s.db.QueryRow("SELECT "+webhookColumns+" FROM webhooks WHERE token_hash = ?", hash))
+}
+
+// ListByPlugin returns the webhooks of a plugin, oldest first
+func (s *WebhookStore) ListByPlugin(pluginID int) ([]Webhook, error) {
+	rows, err := s.db.Query("SELECT "+webhookColumns+" FROM webhooks WHERE plugin_id = ? ORDER BY id", pluginID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// Update saves the name, mode and credentials of a webhook
+func (s *WebhookStore) Update(webhook *Webhook) error {
+	result, err := s.db.Exec(
+		"UPDATE webhooks SET name = ?, token_hash = ?, signing_secret = ?, mode = ? WHERE id = ?",
+		webhook.Name,
+		webhook.TokenHash,
+		webhook.SigningSecret,
+		webhook.Mode,
+		webhook.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// Touch records that a webhook was just called
+func (s *WebhookStore) Touch(id int, used time.Time) error {
+	_, err := s.db.Exec("UPDATE webhooks SET last_used_at = ? WHERE id = ?", used, id)
+	return err
+}
+
+func (s *WebhookStore) Delete(id int) error {
+	result, err := s.db.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
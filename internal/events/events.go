@@ -0,0 +1,173 @@
+package events
+
+import (
+	"bundeck/internal/plugin"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	TypeRunStarted  = "run-started"
+	TypeOutputLine  = "output-line"
+	TypeRunFinished = "run-finished"
+	TypeState       = "state"
+)
+
+// maxReplay bounds how many events of an in-flight run are kept for clients
+// that subscribe after the run started
+const maxReplay = 1000
+
+// subscriberBuffer is the number of events a slow client may lag behind before
+// events are dropped for it
+const subscriberBuffer = 256
+
+// Event is a single update about a plugin run
+type Event struct {
+	Type     string        `json:"type"`
+	PluginID int           `json:"plugin_id"`
+	RunID    int64         `json:"run_id"`
+	Trigger  string        `json:"trigger,omitempty"`
+	Stream   string        `json:"stream,omitempty"`
+	Line     string        `json:"line,omitempty"`
+	Status   string        `json:"status,omitempty"`
+	ExitCode *int          `json:"exit_code,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	State    *plugin.State `json:"state,omitempty"`
+	Time     time.Time     `json:"time"`
+}
+
+type subscriber struct {
+	pluginID int
+	ch       chan Event
+}
+
+// Hub is a plugin.Observer that fans run events out to subscribers
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	// active holds the events of in-flight runs, keyed by the run pointer
+	active map[*plugin.Run][]Event
+	// closed is set by Close, later subscriptions get a closed channel
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[*subscriber]struct{}),
+		active:      make(map[*plugin.Run][]Event),
+	}
+}
+
+// Subscribe returns a channel receiving events for a plugin, or for all plugins
+// when pluginID is zero. Events of runs already in progress are replayed first.
+// The returned function must be called to release the subscription.
+func (h *Hub) Subscribe(pluginID int) (<-chan Event, func()) {
+	sub := &subscriber{
+		pluginID: pluginID,
+		ch:       make(chan Event, subscriberBuffer),
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		close(sub.ch)
+		return sub.ch, func() {}
+	}
+	for run, events := range h.active {
+		if pluginID != 0 && run.PluginID != pluginID {
+			continue
+		}
+		for _, e := range events {
+			select {
+			case sub.ch <- e:
+			default:
+			}
+		}
+	}
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers, sub)
+			h.mu.Unlock()
+		})
+	}
+}
+
+// Close ends all subscriptions by closing their channels, e.g. so that event
+// streams do not hold up a server shutdown
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		close(sub.ch)
+		delete(h.subscribers, sub)
+	}
+}
+
+func (h *Hub) RunStarted(run *plugin.Run) {
+	h.publish(run, Event{
+		Type:    TypeRunStarted,
+		Trigger: run.Trigger,
+		Time:    run.StartedAt,
+	})
+}
+
+func (h *Hub) RunOutput(run *plugin.Run, stream string, line string) {
+	h.publish(run, Event{
+		Type:   TypeOutputLine,
+		Stream: stream,
+		Line:   line,
+		Time:   time.Now(),
+	})
+}
+
+func (h *Hub) RunState(run *plugin.Run, state *plugin.State) {
+	h.publish(run, Event{
+		Type:  TypeState,
+		State: state,
+		Time:  time.Now(),
+	})
+}
+
+func (h *Hub) RunFinished(run *plugin.Run, result *plugin.Result) {
+	exitCode := result.ExitCode
+	h.publish(run, Event{
+		Type:     TypeRunFinished,
+		Status:   result.Status,
+		ExitCode: &exitCode,
+		Error:    result.Error,
+		Time:     result.FinishedAt,
+	})
+}
+
+func (h *Hub) publish(run *plugin.Run, e Event) {
+	e.PluginID = run.PluginID
+	e.RunID = run.ID
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if e.Type == TypeRunFinished {
+		delete(h.active, run)
+	} else if len(h.active[run]) < maxReplay {
+		h.active[run] = append(h.active[run], e)
+	}
+
+	for sub := range h.subscribers {
+		if sub.pluginID != 0 && sub.pluginID != e.PluginID {
+			continue
+		}
+		// Never block the runner on a slow client
+		select {
+		case sub.ch <- e:
+		default:
+		}
+	}
+}
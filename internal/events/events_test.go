@@ -0,0 +1,118 @@
+package events
+
+import (
+	"bundeck/internal/plugin"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for event")
+		return Event{}
+	}
+}
+
+func TestHub_Publish(t *testing.T) {
+	hub := NewHub()
+
+	ch, unsubscribe := hub.Subscribe(1)
+	defer unsubscribe()
+	all, unsubscribeAll := hub.Subscribe(0)
+	defer unsubscribeAll()
+	other, unsubscribeOther := hub.Subscribe(2)
+	defer unsubscribeOther()
+
+	run := &plugin.Run{ID: 5, PluginID: 1, Trigger: plugin.TriggerManual, StartedAt: time.Now()}
+	hub.RunStarted(run)
+	hub.RunOutput(run, plugin.StreamStdout, "hello")
+	hub.RunFinished(run, &plugin.Result{Status: plugin.StatusSuccess, ExitCode: 0})
+
+	for _, c := range []<-chan Event{ch, all} {
+		started := receive(t, c)
+		if started.Type != TypeRunStarted || started.RunID != 5 || started.Trigger != plugin.TriggerManual {
+			t.Errorf("Unexpected start event: %+v", started)
+		}
+		line := receive(t, c)
+		if line.Type != TypeOutputLine || line.Line != "hello" || line.Stream != plugin.StreamStdout {
+			t.Errorf("Unexpected output event: %+v", line)
+		}
+		finished := receive(t, c)
+		if finished.Type != TypeRunFinished || finished.Status != plugin.StatusSuccess || finished.ExitCode == nil {
+			t.Errorf("Unexpected finish event: %+v", finished)
+		}
+	}
+
+	select {
+	case e := <-other:
+		t.Errorf("Subscriber of another plugin received %+v", e)
+	default:
+	}
+}
+
+func TestHub_ReplayActiveRun(t *testing.T) {
+	hub := NewHub()
+
+	run := &plugin.Run{ID: 1, PluginID: 1, StartedAt: time.Now()}
+	hub.RunStarted(run)
+	hub.RunOutput(run, plugin.StreamStdout, "first")
+
+	// A client joining mid-run sees what it missed
+	ch, unsubscribe := hub.Subscribe(1)
+	defer unsubscribe()
+
+	if e := receive(t, ch); e.Type != TypeRunStarted {
+		t.Errorf("Expected replayed start event, got %+v", e)
+	}
+	if e := receive(t, ch); e.Line != "first" {
+		t.Errorf("Expected replayed output, got %+v", e)
+	}
+
+	hub.RunFinished(run, &plugin.Result{Status: plugin.StatusSuccess})
+	receive(t, ch)
+
+	// Finished runs are no longer replayed
+	late, unsubscribeLate := hub.Subscribe(1)
+	defer unsubscribeLate()
+	select {
+	case e := <-late:
+		t.Errorf("Expected no replay after run finished, got %+v", e)
+	default:
+	}
+}
+
+func TestHub_Unsubscribe(t *testing.T) {
+	hub := NewHub()
+
+	ch, unsubscribe := hub.Subscribe(1)
+	unsubscribe()
+	unsubscribe()
+
+	hub.RunStarted(&plugin.Run{PluginID: 1})
+	select {
+	case e := <-ch:
+		t.Errorf("Unsubscribed client received %+v", e)
+	default:
+	}
+}
+
+func TestHub_Close(t *testing.T) {
+	hub := NewHub()
+
+	ch, unsubscribe := hub.Subscribe(0)
+	hub.Close()
+	if _, ok := <-ch; ok {
+		t.Error("Expected Close to close the subscription")
+	}
+	unsubscribe()
+
+	late, _ := hub.Subscribe(1)
+	if _, ok := <-late; ok {
+		t.Error("Expected subscriptions after Close to be closed")
+	}
+	hub.RunStarted(&plugin.Run{PluginID: 1})
+}
@@ -0,0 +1,129 @@
+package history
+
+import (
+	"bundeck/internal/db"
+	"bundeck/internal/plugin"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxOutputBytes limits how much of each output stream is stored per run,
+// the runner keeps no more than that
+const MaxOutputBytes = plugin.MaxOutputBytes
+
+// Retention controls how much run history is kept
+type Retention struct {
+	// MaxRunsPerPlugin keeps only the newest runs of each plugin, zero keeps all
+	MaxRunsPerPlugin int
+	// MaxAge deletes runs older than this, zero keeps them forever
+	MaxAge time.Duration
+}
+
+// DefaultRetention keeps the last 100 runs of each plugin for up to 30 days
+var DefaultRetention = Retention{
+	MaxRunsPerPlugin: 100,
+	MaxAge:           30 * 24 * time.Hour,
+}
+
+// Store persists plugin runs
+type Store interface {
+	Start(run *db.PluginRun) error
+	Finish(run *db.PluginRun) error
+	Prune(pluginID int, keepPerPlugin int, maxAge time.Duration) error
+}
+
+// Recorder is a plugin.Observer that writes every run to the history store
+type Recorder struct {
+	store Store
+
+	mu        sync.Mutex
+	retention Retention
+}
+
+func NewRecorder(store Store, retention Retention) *Recorder {
+	return &Recorder{
+		store:     store,
+		retention: retention,
+	}
+}
+
+// SetRetention changes how much history is kept, older runs are pruned when
+// each plugin next finishes a run
+func (r *Recorder) SetRetention(retention Retention) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retention = retention
+}
+
+func (r *Recorder) RunStarted(run *plugin.Run) {
+	row := &db.PluginRun{
+		PluginID:  run.PluginID,
+		Trigger:   run.Trigger,
+		Status:    plugin.StatusRunning,
+		StartedAt: run.StartedAt,
+	}
+	if err := r.store.Start(row); err != nil {
+		slog.Error("history: failed to record start of run", "plugin", run.PluginID, "err", err)
+		return
+	}
+	run.ID = row.ID
+}
+
+func (r *Recorder) RunFinished(run *plugin.Run, result *plugin.Result) {
+	if run.ID == 0 {
+		return
+	}
+
+	exitCode := result.ExitCode
+	finishedAt := result.FinishedAt
+	stdout, stdoutTruncated := truncate(result.Stdout)
+	stderr, stderrTruncated := truncate(result.Stderr)
+
+	row := &db.PluginRun{
+		ID:         run.ID,
+		PluginID:   run.PluginID,
+		Trigger:    run.Trigger,
+		Status:     result.Status,
+		ExitCode:   &exitCode,
+		Stdout:     stdout,
+		Stderr:     stderr,
+		Error:      result.Error,
+		OutputSize: len(result.Stdout) + len(result.Stderr) + result.DroppedBytes,
+		Truncated:  stdoutTruncated || stderrTruncated || result.DroppedBytes > 0,
+		StartedAt:  run.StartedAt,
+		FinishedAt: &finishedAt,
+	}
+	if len(result.Steps) > 0 {
+		steps, err := json.Marshal(result.Steps)
+		if err != nil {
+			slog.Error("history: failed to encode steps", "plugin", run.PluginID, "err", err)
+		}
+		row.Steps = steps
+	}
+	if err := r.store.Finish(row); err != nil {
+		slog.Error("history: failed to record result of run", "plugin", run.PluginID, "err", err)
+		return
+	}
+
+	r.mu.Lock()
+	retention := r.retention
+	r.mu.Unlock()
+	if err := r.store.Prune(run.PluginID, retention.MaxRunsPerPlugin, retention.MaxAge); err != nil {
+		slog.Warn("history: failed to prune runs", "plugin", run.PluginID, "err", err)
+	}
+}
+
+// truncate keeps the tail of the output, which usually holds the error
+func truncate(s string) (string, bool) {
+	if len(s) <= MaxOutputBytes {
+		return s, false
+	}
+	start := len(s) - MaxOutputBytes
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+	return s[start:], true
+}
@@ -0,0 +1,126 @@
+package history
+
+import (
+	"bundeck/internal/db"
+	"bundeck/internal/plugin"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockStore struct {
+	started  []db.PluginRun
+	finished []db.PluginRun
+	pruned   int
+	kept     int
+}
+
+func (m *mockStore) Start(run *db.PluginRun) error {
+	run.ID = int64(len(m.started) + 1)
+	m.started = append(m.started, *run)
+	return nil
+}
+
+func (m *mockStore) Finish(run *db.PluginRun) error {
+	m.finished = append(m.finished, *run)
+	return nil
+}
+
+func (m *mockStore) Prune(pluginID int, keepPerPlugin int, maxAge time.Duration) error {
+	m.pruned++
+	m.kept = keepPerPlugin
+	return nil
+}
+
+func TestRecorder(t *testing.T) {
+	store := &mockStore{}
+	recorder := NewRecorder(store, DefaultRetention)
+
+	run := &plugin.Run{PluginID: 3, Trigger: plugin.TriggerManual, StartedAt: time.Now()}
+	recorder.RunStarted(run)
+	if run.ID != 1 {
+		t.Fatalf("Expected run ID 1, got %d", run.ID)
+	}
+	if store.started[0].Status != plugin.StatusRunning {
+		t.Errorf("Expected status %q, got %q", plugin.StatusRunning, store.started[0].Status)
+	}
+
+	large := strings.Repeat("x", MaxOutputBytes+10)
+	recorder.RunFinished(run, &plugin.Result{
+		Status:     plugin.StatusFailed,
+		ExitCode:   1,
+		Stdout:     large,
+		Stderr:     "boom",
+		FinishedAt: time.Now(),
+	})
+
+	if len(store.finished) != 1 {
+		t.Fatalf("Expected 1 finished run, got %d", len(store.finished))
+	}
+	got := store.finished[0]
+	if got.ID != 1 || got.Status != plugin.StatusFailed || *got.ExitCode != 1 {
+		t.Errorf("Unexpected finished run: %+v", got)
+	}
+	if len(got.Stdout) != MaxOutputBytes || !got.Truncated {
+		t.Errorf("Expected stdout truncated to %d bytes, got %d", MaxOutputBytes, len(got.Stdout))
+	}
+	if got.OutputSize != len(large)+len("boom") {
+		t.Errorf("Expected original output size %d, got %d", len(large)+len("boom"), got.OutputSize)
+	}
+	if store.pruned != 1 {
+		t.Errorf("Expected retention to be applied once, got %d", store.pruned)
+	}
+
+	// Output the runner dropped counts towards the size
+	recorder.RunStarted(run)
+	recorder.RunFinished(run, &plugin.Result{
+		Status:       plugin.StatusSuccess,
+		Stdout:       "tail",
+		DroppedBytes: 100,
+		FinishedAt:   time.Now(),
+	})
+	if got := store.finished[1]; got.OutputSize != 104 || !got.Truncated {
+		t.Errorf("Expected output size 104 and truncated, got %d and %t", got.OutputSize, got.Truncated)
+	}
+}
+
+func TestTruncate_RuneBoundary(t *testing.T) {
+	s := "é" + strings.Repeat("a", MaxOutputBytes-1)
+	got, truncated := truncate(s)
+	if !truncated {
+		t.Fatal("Expected output to be truncated")
+	}
+	if !strings.HasPrefix(got, "a") || len(got) != MaxOutputBytes-1 {
+		t.Errorf("Expected truncation to skip the partial rune, got %d bytes", len(got))
+	}
+}
+
+func TestRecorder_Steps(t *testing.T) {
+	store := &mockStore{}
+	recorder := NewRecorder(store, DefaultRetention)
+
+	run := &plugin.Run{PluginID: 3, Trigger: plugin.TriggerManual, StartedAt: time.Now()}
+	recorder.RunStarted(run)
+	recorder.RunFinished(run, &plugin.Result{
+		Status: plugin.StatusSuccess,
+		Steps:  []plugin.StepResult{{Step: 1, Action: "delay", Status: plugin.StatusSuccess}},
+	})
+
+	if steps := string(store.finished[0].Steps); !strings.Contains(steps, `"action":"delay"`) {
+		t.Errorf("Expected the steps to be recorded, got %s", steps)
+	}
+}
+
+func TestRecorder_SetRetention(t *testing.T) {
+	store := &mockStore{}
+	recorder := NewRecorder(store, DefaultRetention)
+	recorder.SetRetention(Retention{MaxRunsPerPlugin: 5})
+
+	run := &plugin.Run{PluginID: 3, Trigger: plugin.TriggerManual, StartedAt: time.Now()}
+	recorder.RunStarted(run)
+	recorder.RunFinished(run, &plugin.Result{Status: plugin.StatusSuccess})
+
+	if store.kept != 5 {
+		t.Errorf("Expected the new retention to be applied, got %d runs kept", store.kept)
+	}
+}
@@ -0,0 +1,266 @@
+package macro
+
+import (
+	"bundeck/internal/db"
+	"bundeck/internal/plugin"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Step actions
+const (
+	// ActionRun runs another plugin, optionally with an input
+	ActionRun = "run"
+	// ActionDelay waits before the next step
+	ActionDelay = "delay"
+)
+
+// Step conditions, checked against the exit code of the previous run step.
+// Steps before the first run step count as following a success.
+const (
+	ConditionAlways   = ""
+	ConditionSuccess  = "success"
+	ConditionFailure  = "failure"
+	ConditionExitCode = "exit_code"
+)
+
+// MaxDelay is the longest a delay step may wait
+const MaxDelay = time.Hour
+
+// ErrInvalidMacro is returned for steps that cannot be saved
+var ErrInvalidMacro = errors.New("invalid macro")
+
+// Plugins looks up the plugins run by macro steps
+type Plugins interface {
+	GetByID(id int) (*db.Plugin, error)
+}
+
+// Store is the subset of database operations the executor needs
+type Store interface {
+	Plugins
+	ListSteps(pluginID int) ([]db.MacroStep, error)
+}
+
+// Runner executes the plugins run by macro steps
+type Runner interface {
+	Execute(ctx context.Context, req plugin.Request) (*plugin.Result, error)
+}
+
+// Validate checks the steps of a macro before they are saved. The delays
+// must add up to less than timeout, the longest the macro may run.
+func Validate(plugins Plugins, steps []db.MacroStep, timeout time.Duration) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("%w: a macro needs at least one step", ErrInvalidMacro)
+	}
+
+	var delays time.Duration
+	for i, step := range steps {
+		invalid := func(format string, args ...any) error {
+			return fmt.Errorf("%w: step %d %s", ErrInvalidMacro, i+1, fmt.Sprintf(format, args...))
+		}
+
+		switch step.Condition {
+		case ConditionAlways, ConditionSuccess, ConditionFailure, ConditionExitCode:
+		default:
+			return invalid("has unknown condition %q", step.Condition)
+		}
+
+		switch step.Action {
+		case ActionRun:
+			if step.TargetPluginID == nil {
+				return invalid("has no plugin to run")
+			}
+			target, err := plugins.GetByID(*step.TargetPluginID)
+			if errors.Is(err, sql.ErrNoRows) {
+				return invalid("runs plugin %d, which does not exist", *step.TargetPluginID)
+			}
+			if err != nil {
+				return err
+			}
+			if target.Kind == db.PluginKindMacro {
+				return invalid("runs the macro %q, macros can only run scripts", target.Name)
+			}
+			if len(step.Input) > 0 && !json.Valid(step.Input) {
+				return invalid("has an input that is not valid JSON")
+			}
+		case ActionDelay:
+			delay := time.Duration(step.DelayMS) * time.Millisecond
+			if delay <= 0 || delay > MaxDelay {
+				return invalid("must wait between 1ms and %s", MaxDelay)
+			}
+			delays += delay
+		default:
+			return invalid("has unknown action %q", step.Action)
+		}
+	}
+	if delays >= timeout {
+		return fmt.Errorf("%w: the delays add up to %s, the macro times out after %s", ErrInvalidMacro, delays, timeout)
+	}
+	return nil
+}
+
+// Executor runs macros as tasks of the plugin runner. Each step that runs a
+// plugin is recorded as a run of that plugin, and the macro's own run keeps
+// the results of all steps.
+type Executor struct {
+	store  Store
+	runner Runner
+}
+
+func NewExecutor(store Store, runner Runner) *Executor {
+	return &Executor{store: store, runner: runner}
+}
+
+// Task returns the task running the steps of a macro, or nil for plugins
+// that are not macros. The steps are read when the run starts.
+func (e *Executor) Task(pluginID int) (plugin.Task, error) {
+	row, err := e.store.GetByID(pluginID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if row.Kind != db.PluginKindMacro {
+		return nil, nil
+	}
+
+	steps, err := e.store.ListSteps(pluginID)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, result *plugin.Result, print func(line string)) error {
+		return e.run(ctx, steps, result, print)
+	}, nil
+}
+
+// run executes the steps in order. A failed step aborts the macro unless it
+// continues on failure.
+func (e *Executor) run(ctx context.Context, steps []db.MacroStep, result *plugin.Result, print func(line string)) error {
+	// previous is the exit code of the last run step
+	var previous *int
+	for i, step := range steps {
+		stepResult := plugin.StepResult{
+			Step:      i + 1,
+			Action:    step.Action,
+			StartedAt: time.Now(),
+		}
+
+		var err error
+		if conditionMet(step, previous) {
+			err = e.runStep(ctx, step, &stepResult, print)
+		} else {
+			stepResult.Status = plugin.StatusSkipped
+			print(fmt.Sprintf("%d. Skipped, the condition %s was not met", stepResult.Step, describeCondition(step)))
+		}
+		stepResult.FinishedAt = time.Now()
+		result.Steps = append(result.Steps, stepResult)
+
+		if stepResult.ExitCode != nil {
+			previous = stepResult.ExitCode
+		}
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if step.ContinueOnFailure {
+			continue
+		}
+		if stepResult.ExitCode != nil {
+			result.ExitCode = *stepResult.ExitCode
+		}
+		return fmt.Errorf("step %d failed: %w", stepResult.Step, err)
+	}
+
+	result.ExitCode = 0
+	return nil
+}
+
+func (e *Executor) runStep(ctx context.Context, step db.MacroStep, stepResult *plugin.StepResult, print func(line string)) error {
+	switch step.Action {
+	case ActionDelay:
+		delay := time.Duration(step.DelayMS) * time.Millisecond
+		print(fmt.Sprintf("%d. Waiting %s", stepResult.Step, delay))
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			stepResult.Status = plugin.StatusCancelled
+			return context.Cause(ctx)
+		case <-timer.C:
+		}
+		stepResult.Status = plugin.StatusSuccess
+		return nil
+
+	case ActionRun:
+		stepResult.Status = plugin.StatusFailed
+		if step.TargetPluginID == nil {
+			return errors.New("no plugin to run")
+		}
+		stepResult.PluginID = *step.TargetPluginID
+
+		target, err := e.store.GetByID(*step.TargetPluginID)
+		if err == nil && target.Kind == db.PluginKindMacro {
+			err = errors.New("macros can only run scripts")
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("plugin %d does not exist", *step.TargetPluginID)
+		}
+		if err != nil {
+			stepResult.Error = err.Error()
+			print(fmt.Sprintf("%d. Failed: %v", stepResult.Step, err))
+			return err
+		}
+
+		req := plugin.NewRequest(target, plugin.TriggerMacro)
+		req.Input = step.Input
+		res, err := e.runner.Execute(ctx, req)
+		exitCode := res.ExitCode
+		stepResult.RunID = res.RunID
+		stepResult.Status = res.Status
+		stepResult.ExitCode = &exitCode
+		stepResult.Error = res.Error
+		print(fmt.Sprintf("%d. %s: %s (exit code %d)", stepResult.Step, target.Name, res.Status, exitCode))
+		return err
+	}
+
+	stepResult.Status = plugin.StatusFailed
+	return fmt.Errorf("unknown action %q", step.Action)
+}
+
+// conditionMet reports whether a step runs after a run step exited with
+// previous, which is nil before the first run step
+func conditionMet(step db.MacroStep, previous *int) bool {
+	code := 0
+	if previous != nil {
+		code = *previous
+	}
+	switch step.Condition {
+	case ConditionSuccess:
+		return code == 0
+	case ConditionFailure:
+		return code != 0
+	case ConditionExitCode:
+		return code == step.ExitCode
+	}
+	return true
+}
+
+func describeCondition(step db.MacroStep) string {
+	switch step.Condition {
+	case ConditionSuccess:
+		return "\"previous step succeeded\""
+	case ConditionFailure:
+		return "\"previous step failed\""
+	case ConditionExitCode:
+		return fmt.Sprintf("\"previous exit code is %d\"", step.ExitCode)
+	}
+	return "\"always\""
+}
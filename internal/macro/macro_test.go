@@ -0,0 +1,234 @@
+package macro
+
+import (
+	"bundeck/internal/db"
+	"bundeck/internal/plugin"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockStore struct {
+	plugins map[int]*db.Plugin
+	steps   map[int][]db.MacroStep
+}
+
+func (m *mockStore) GetByID(id int) (*db.Plugin, error) {
+	plugin, ok := m.plugins[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return plugin, nil
+}
+
+func (m *mockStore) ListSteps(pluginID int) ([]db.MacroStep, error) {
+	return m.steps[pluginID], nil
+}
+
+// mockRunner exits every plugin with the exit code set for it
+type mockRunner struct {
+	mu        sync.Mutex
+	exitCodes map[int]int
+	requests  []plugin.Request
+}
+
+func (m *mockRunner) Execute(ctx context.Context, req plugin.Request) (*plugin.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, req)
+
+	result := &plugin.Result{RunID: int64(len(m.requests)), Status: plugin.StatusSuccess, ExitCode: m.exitCodes[req.PluginID]}
+	if result.ExitCode != 0 {
+		result.Status = plugin.StatusFailed
+		result.Error = "exit status"
+		return result, errors.New("exit status")
+	}
+	return result, nil
+}
+
+func (m *mockRunner) ran() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []int
+	for _, req := range m.requests {
+		ids = append(ids, req.PluginID)
+	}
+	return ids
+}
+
+func target(id int) *int {
+	return &id
+}
+
+func setupExecutor() (*Executor, *mockStore, *mockRunner) {
+	store := &mockStore{
+		plugins: map[int]*db.Plugin{
+			1:  {ID: 1, Name: "Scene", Kind: db.PluginKindScript, Code: "scene()"},
+			2:  {ID: 2, Name: "Mic", Kind: db.PluginKindScript, Code: "mic()", TimeoutSeconds: 5},
+			3:  {ID: 3, Name: "Broken", Kind: db.PluginKindScript, Code: "fail()"},
+			10: {ID: 10, Name: "Macro", Kind: db.PluginKindMacro},
+		},
+		steps: map[int][]db.MacroStep{},
+	}
+	runner := &mockRunner{exitCodes: map[int]int{3: 2}}
+	return NewExecutor(store, runner), store, runner
+}
+
+// runMacro runs the steps as macro 10
+func runMacro(t *testing.T, e *Executor, store *mockStore, steps []db.MacroStep) (*plugin.Result, error) {
+	t.Helper()
+	store.steps[10] = steps
+	task, err := e.Task(10)
+	if err != nil || task == nil {
+		t.Fatalf("Expected a task for the macro, got %v", err)
+	}
+	result := &plugin.Result{ExitCode: -1}
+	var output []string
+	err = task(context.Background(), result, func(line string) { output = append(output, line) })
+	result.Output = strings.Join(output, "\n")
+	return result, err
+}
+
+func TestValidate(t *testing.T) {
+	_, store, _ := setupExecutor()
+
+	valid := []db.MacroStep{
+		{Action: ActionRun, TargetPluginID: target(1)},
+		{Action: ActionDelay, DelayMS: 2000},
+		{Action: ActionRun, TargetPluginID: target(2), Input: []byte(`{"muted":false}`), Condition: ConditionSuccess},
+	}
+	if err := Validate(store, valid, time.Minute); err != nil {
+		t.Errorf("Expected the steps to be valid, got %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		steps []db.MacroStep
+		err   string
+	}{
+		{"No Steps", nil, "at least one step"},
+		{"Unknown Action", []db.MacroStep{{Action: "jump"}}, `step 1 has unknown action "jump"`},
+		{"Missing Target", []db.MacroStep{{Action: ActionRun}}, "has no plugin to run"},
+		{"Deleted Target", []db.MacroStep{{Action: ActionRun, TargetPluginID: target(99)}}, "plugin 99, which does not exist"},
+		{"Nested Macro", []db.MacroStep{{Action: ActionRun, TargetPluginID: target(10)}}, "macros can only run scripts"},
+		{"Invalid Input", []db.MacroStep{{Action: ActionRun, TargetPluginID: target(1), Input: []byte(`{`)}}, "not valid JSON"},
+		{"No Delay", []db.MacroStep{{Action: ActionDelay}}, "must wait between"},
+		{"Long Delay", []db.MacroStep{{Action: ActionDelay, DelayMS: int(2 * MaxDelay / time.Millisecond)}}, "must wait between"},
+		{"Unknown Condition", []db.MacroStep{{Action: ActionDelay, DelayMS: 1, Condition: "maybe"}}, "unknown condition"},
+		{"Delays Over Timeout", []db.MacroStep{{Action: ActionDelay, DelayMS: 40000}, {Action: ActionDelay, DelayMS: 20000}}, "times out after 1m0s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(store, tt.steps, time.Minute)
+			if !errors.Is(err, ErrInvalidMacro) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Expected an invalid macro error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestExecutor_Task(t *testing.T) {
+	e, _, _ := setupExecutor()
+
+	if task, err := e.Task(1); task != nil || err != nil {
+		t.Errorf("Expected no task for a script, got %v", err)
+	}
+	if task, err := e.Task(99); task != nil || err != nil {
+		t.Errorf("Expected no task for a missing plugin, got %v", err)
+	}
+}
+
+func TestExecutor_Run(t *testing.T) {
+	t.Run("Runs Steps In Order", func(t *testing.T) {
+		e, store, runner := setupExecutor()
+		result, err := runMacro(t, e, store, []db.MacroStep{
+			{Action: ActionRun, TargetPluginID: target(1)},
+			{Action: ActionDelay, DelayMS: 10},
+			{Action: ActionRun, TargetPluginID: target(2), Input: []byte(`{"muted":false}`)},
+		})
+		if err != nil {
+			t.Fatalf("Failed to run macro: %v", err)
+		}
+		if got := runner.ran(); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+			t.Fatalf("Expected plugins 1 and 2 to run, got %v", got)
+		}
+		req := runner.requests[1]
+		if req.Trigger != plugin.TriggerMacro || string(req.Input) != `{"muted":false}` || req.Timeout != 5*time.Second {
+			t.Errorf("Expected a macro run with the step's input and the plugin's timeout, got %+v", req)
+		}
+		if result.ExitCode != 0 || len(result.Steps) != 3 {
+			t.Fatalf("Expected 3 successful steps, got %+v", result)
+		}
+		delay := result.Steps[1]
+		if delay.Status != plugin.StatusSuccess || delay.FinishedAt.Sub(delay.StartedAt) < 10*time.Millisecond {
+			t.Errorf("Expected the delay step to wait, got %+v", delay)
+		}
+		if result.Steps[2].RunID != 2 || result.Steps[2].PluginID != 2 {
+			t.Errorf("Expected the step to reference its run, got %+v", result.Steps[2])
+		}
+		if !strings.Contains(result.Output, "3. Mic: success") {
+			t.Errorf("Expected a line per step, got %q", result.Output)
+		}
+	})
+
+	t.Run("Aborts On Failure", func(t *testing.T) {
+		e, store, runner := setupExecutor()
+		result, err := runMacro(t, e, store, []db.MacroStep{
+			{Action: ActionRun, TargetPluginID: target(3)},
+			{Action: ActionRun, TargetPluginID: target(1)},
+		})
+		if err == nil || !strings.Contains(err.Error(), "step 1 failed") {
+			t.Fatalf("Expected the first step to fail the macro, got %v", err)
+		}
+		if got := runner.ran(); len(got) != 1 {
+			t.Errorf("Expected the macro to stop after the failed step, got runs %v", got)
+		}
+		if result.ExitCode != 2 || len(result.Steps) != 1 || result.Steps[0].Status != plugin.StatusFailed {
+			t.Errorf("Expected the failed step's exit code and result, got %+v", result)
+		}
+	})
+
+	t.Run("Conditions On The Previous Exit Code", func(t *testing.T) {
+		e, store, runner := setupExecutor()
+		result, err := runMacro(t, e, store, []db.MacroStep{
+			{Action: ActionRun, TargetPluginID: target(3), ContinueOnFailure: true},
+			{Action: ActionRun, TargetPluginID: target(1), Condition: ConditionSuccess},
+			{Action: ActionRun, TargetPluginID: target(2), Condition: ConditionExitCode, ExitCode: 2},
+		})
+		if err != nil {
+			t.Fatalf("Expected the failure to be handled, got %v", err)
+		}
+		if got := runner.ran(); len(got) != 2 || got[1] != 2 {
+			t.Errorf("Expected only the exit code branch to run, got %v", got)
+		}
+		if result.Steps[1].Status != plugin.StatusSkipped {
+			t.Errorf("Expected the success branch to be skipped, got %+v", result.Steps[1])
+		}
+	})
+
+	t.Run("Missing Plugin", func(t *testing.T) {
+		e, store, _ := setupExecutor()
+		_, err := runMacro(t, e, store, []db.MacroStep{{Action: ActionRun, TargetPluginID: target(99)}})
+		if err == nil || !strings.Contains(err.Error(), "plugin 99 does not exist") {
+			t.Errorf("Expected the deleted plugin to fail the macro, got %v", err)
+		}
+	})
+
+	t.Run("Cancelled During Delay", func(t *testing.T) {
+		e, store, _ := setupExecutor()
+		store.steps[10] = []db.MacroStep{{Action: ActionDelay, DelayMS: 60000}}
+		task, _ := e.Task(10)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		result := &plugin.Result{}
+		err := task(ctx, result, func(string) {})
+		if !errors.Is(err, context.DeadlineExceeded) || result.Steps[0].Status != plugin.StatusCancelled {
+			t.Errorf("Expected the delay to stop with the context, got %v and %+v", err, result.Steps)
+		}
+	})
+}
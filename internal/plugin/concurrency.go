@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Concurrency policies decide what happens when a plugin is started while a
+// previous run of it is still in progress
+const (
+	// PolicyParallel runs every invocation immediately
+	PolicyParallel = "parallel"
+	// PolicySkip drops the new invocation
+	PolicySkip = "skip"
+	// PolicyQueue waits for the previous runs to finish, queued runs start
+	// in the order they were requested
+	PolicyQueue = "queue"
+	// PolicyRestart cancels the previous runs and then starts
+	PolicyRestart = "restart"
+)
+
+// ErrSkipped is returned when a run is dropped because of PolicySkip
+var ErrSkipped = errors.New("plugin is already running")
+
+// ValidPolicy reports whether policy is a known concurrency policy. The empty
+// string is accepted and behaves like PolicyParallel.
+func ValidPolicy(policy string) bool {
+	switch policy {
+	case "", PolicyParallel, PolicySkip, PolicyQueue, PolicyRestart:
+		return true
+	}
+	return false
+}
+
+// activeRun is a run that has been requested and not yet finished. It is not
+// admitted while it waits for its turn under the plugin's policy.
+type activeRun struct {
+	cancel   context.CancelCauseFunc
+	done     chan struct{}
+	admitted bool
+}
+
+// slots is a counting semaphore for runs whose limit may change while runs
+// hold a slot
+type slots struct {
+	mu    sync.Mutex
+	limit int // zero or less means unlimited
+	used  int
+	// freed is closed and cleared when a slot is released or the limit
+	// changes, nil while nobody waits
+	freed chan struct{}
+}
+
+// wake lets every waiting run check for a free slot again. The caller holds
+// s.mu.
+func (s *slots) wake() {
+	if s.freed != nil {
+		close(s.freed)
+		s.freed = nil
+	}
+}
+
+// setLimit changes the limit, runs holding a slot count against the new one
+func (s *slots) setLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = n
+	s.wake()
+}
+
+// acquire waits for a free slot and returns the function releasing it
+func (s *slots) acquire(ctx context.Context) (func(), error) {
+	for {
+		s.mu.Lock()
+		if s.limit <= 0 || s.used < s.limit {
+			s.used++
+			s.mu.Unlock()
+			var once sync.Once
+			return func() { once.Do(s.release) }, nil
+		}
+		if s.freed == nil {
+			s.freed = make(chan struct{})
+		}
+		freed := s.freed
+		s.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+}
+
+func (s *slots) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used--
+	s.wake()
+}
+
+// SetMaxConcurrentRuns limits how many plugins may execute at the same time
+// across all plugins. Runs over the limit wait for a free slot. Zero or less
+// removes the limit. Runs already in progress keep their slot and count
+// against the new limit.
+func (r *Runner) SetMaxConcurrentRuns(n int) {
+	r.slots.setLimit(n)
+}
+
+// admit blocks until the run may start under the given policy and marks it as
+// admitted. It calls announce before it starts waiting or once the run is
+// admitted, but not for skipped runs. Queued runs start in the order they
+// were requested: run IDs increase, so each queued run waits for the newest
+// run requested before it.
+func (r *Runner) admit(ctx context.Context, pluginID int, runID int, policy string, announce func()) error {
+	for {
+		r.mu.Lock()
+		self := r.running[pluginID][runID]
+		var others []*activeRun
+		var ahead *activeRun
+		aheadID := 0
+		for id, other := range r.running[pluginID] {
+			if id == runID {
+				continue
+			}
+			if policy == PolicyRestart {
+				// The newest run wins, including over runs still waiting
+				other.cancel(ErrCancelled)
+			}
+			if other.admitted {
+				others = append(others, other)
+			}
+			if id < runID && id > aheadID {
+				ahead, aheadID = other, id
+			}
+		}
+
+		if policy == PolicyQueue && ahead != nil {
+			// Earlier runs go first, whether they started or still wait
+			others = []*activeRun{ahead}
+		}
+		if len(others) == 0 || policy == PolicyParallel || policy == "" {
+			self.admitted = true
+			r.mu.Unlock()
+			announce()
+			return nil
+		}
+		r.mu.Unlock()
+
+		if policy == PolicySkip {
+			return ErrSkipped
+		}
+		announce()
+
+		// Queue and restart wait for a previous run to finish, then check again
+		select {
+		case <-others[0].done:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
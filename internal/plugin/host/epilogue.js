@@ -0,0 +1,52 @@
+// BunDeck resident host: JSON-RPC requests arrive on stdin, one per line, and
+// responses are written to stdout behind the ::bundeck-rpc:: prefix.
+{
+  const reply = (message) =>
+    process.stdout.write(
+      '::bundeck-rpc::' + JSON.stringify({ jsonrpc: '2.0', ...message }) + '\n',
+    );
+
+  const handle = async (request) => {
+    if (request.method === 'shutdown') {
+      process.exit(0);
+    }
+    try {
+      if (request.method === 'press') {
+        for (const handler of globalThis.bundeck.handlers) {
+          await handler(request.params ?? {});
+        }
+      } else if (request.method !== 'ping') {
+        throw new Error('unknown method ' + request.method);
+      }
+      if (request.id !== undefined) {
+        reply({ id: request.id, result: null });
+      }
+    } catch (error) {
+      if (request.id !== undefined) {
+        reply({
+          id: request.id,
+          error: { code: -32000, message: String(error?.message ?? error) },
+        });
+      }
+    }
+  };
+
+  let buffered = '';
+  process.stdin.setEncoding('utf8');
+  process.stdin.on('data', (chunk) => {
+    buffered += chunk;
+    let newline = buffered.indexOf('\n');
+    while (newline >= 0) {
+      const line = buffered.slice(0, newline).trim();
+      buffered = buffered.slice(newline + 1);
+      if (line) {
+        handle(JSON.parse(line));
+      }
+      newline = buffered.indexOf('\n');
+    }
+  });
+  process.stdin.on('end', () => process.exit(0));
+
+  // The plugin code has loaded, presses may be sent
+  reply({ method: 'ready' });
+}
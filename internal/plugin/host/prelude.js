@@ -0,0 +1,8 @@
+// BunDeck resident host. Resident plugins register press handlers with
+// bundeck.onPress and keep their state, e.g. open connections, between presses.
+globalThis.bundeck = {
+  handlers: [],
+  onPress(handler) {
+    this.handlers.push(handler);
+  },
+};
@@ -1,14 +1,176 @@
 package plugin
 
 import (
+	"bundeck/internal/db"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
+// DefaultTimeout is used for plugins that do not configure their own timeout
+// unless SetDefaultTimeout changes it
+const DefaultTimeout = 60 * time.Second
+
+// InputEnv is the environment variable holding the input of a run
+const InputEnv = "BUNDECK_INPUT"
+
+// MaxInputEnvBytes is the largest input also put in InputEnv. Linux refuses
+// to start processes with a single environment string over 128KB, larger
+// inputs only arrive on stdin.
+const MaxInputEnvBytes = 32 << 10
+
+var (
+	// ErrTimeout is returned when a plugin exceeds its execution timeout
+	ErrTimeout = errors.New("plugin timed out")
+	// ErrCancelled is returned when a running plugin was cancelled
+	ErrCancelled = errors.New("plugin was cancelled")
+	// ErrShuttingDown is returned for runs started after Shutdown and for
+	// runs terminated because they did not finish in time
+	ErrShuttingDown = errors.New("plugin runner is shutting down")
+)
+
+// Run statuses
+const (
+	StatusRunning   = "running"
+	StatusSuccess   = "success"
+	StatusFailed    = "failed"
+	StatusTimedOut  = "timed out"
+	StatusCancelled = "cancelled"
+	StatusSkipped   = "skipped"
+)
+
+// Trigger sources
+const (
+	TriggerManual   = "manual"
+	TriggerSchedule = "schedule"
+	TriggerWebhook  = "webhook"
+	TriggerMacro    = "macro"
+)
+
+// Request describes a single plugin execution
+type Request struct {
+	PluginID int
+	Code     string
+	// Runtime is the ID of the runtime running the code, empty means
+	// DefaultRuntime
+	Runtime string
+	// Timeout of zero uses the runner's default timeout
+	Timeout time.Duration
+	// Trigger records what started the run, e.g. TriggerManual
+	Trigger string
+	// Policy is the plugin's concurrency policy, empty means PolicyParallel
+	Policy string
+	// Resident sends the run as a press to the plugin's long-lived process
+	// instead of starting a new one
+	Resident bool
+	// Input is a JSON document handed to the plugin, on stdin and in the
+	// InputEnv variable up to MaxInputEnvBytes for a new process and as the
+	// press's input for resident plugins
+	Input []byte
+	// OnStart is called once observers have assigned the run its ID, before
+	// it waits for its turn under the concurrency policy and the run limit.
+	// It is not called for runs that are skipped.
+	OnStart func(run *Run)
+}
+
+// NewRequest describes a run of a stored plugin with its own code and
+// settings
+func NewRequest(row *db.Plugin, trigger string) Request {
+	return Request{
+		PluginID: row.ID,
+		Code:     row.Code,
+		Runtime:  row.Runtime,
+		Timeout:  time.Duration(row.TimeoutSeconds) * time.Second,
+		Trigger:  trigger,
+		Policy:   row.ConcurrencyPolicy,
+		Resident: row.Resident,
+	}
+}
+
+// Run identifies an in-flight execution. Observers may assign the ID when the
+// run starts.
+type Run struct {
+	ID       int64
+	PluginID int
+	Trigger  string
+	// StartedAt is when the run was requested until it is admitted and
+	// then when its execution started
+	StartedAt time.Time
+}
+
+// Result is the outcome of a finished execution
+type Result struct {
+	RunID      int64     `json:"run_id"`
+	Status     string    `json:"status"`
+	ExitCode   int       `json:"exit_code"`
+	Stdout     string    `json:"stdout"`
+	Stderr     string    `json:"stderr"`
+	Output     string    `json:"output"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Error      string    `json:"error,omitempty"`
+	// State accumulates the button state updates emitted during the run
+	State *State `json:"state,omitempty"`
+	// Steps holds the results of the steps of a task, e.g. a macro
+	Steps []StepResult `json:"steps,omitempty"`
+	// DroppedBytes counts the output left out of Stdout and Stderr, which
+	// keep the last MaxOutputBytes of each stream
+	DroppedBytes int `json:"dropped_bytes,omitempty"`
+}
+
+// MaxOutputBytes limits how much of each output stream a run keeps
+const MaxOutputBytes = 64 * 1024
+
+// Output stream names
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// Observer is notified about the lifecycle of every run
+type Observer interface {
+	RunStarted(run *Run)
+	RunFinished(run *Run, result *Result)
+}
+
+// OutputObserver is an optional extension of Observer that receives output
+// line by line while the plugin is running
+type OutputObserver interface {
+	RunOutput(run *Run, stream string, line string)
+}
+
 type Runner struct {
 	tempDir string
+
+	mu        sync.Mutex
+	nextRun   int
+	running   map[int]map[int]*activeRun
+	observers []Observer
+	// closed is set by Shutdown, no runs start afterwards
+	closed bool
+	// slots limits the number of runs executing at once
+	slots slots
+	// defaultTimeout applies to requests without a timeout
+	defaultTimeout time.Duration
+
+	residentMu sync.Mutex
+	residents  map[int]*resident
+
+	secrets SecretSource
+	tasks   TaskSource
+}
+
+// SecretSource resolves the secrets granted to a plugin into NAME=value
+// environment variables
+type SecretSource interface {
+	Env(pluginID int) ([]string, error)
 }
 
 func NewRunner() (*Runner, error) {
@@ -18,26 +180,505 @@ func NewRunner() (*Runner, error) {
 	}
 
 	return &Runner{
-		tempDir: tempDir,
+		tempDir:        tempDir,
+		running:        make(map[int]map[int]*activeRun),
+		residents:      make(map[int]*resident),
+		defaultTimeout: DefaultTimeout,
 	}, nil
 }
 
+// AddObserver registers an observer for all subsequent runs. Observers are
+// notified in the order they were added.
+func (r *Runner) AddObserver(o Observer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observers = append(r.observers, o)
+}
+
+// SetDefaultTimeout changes the timeout of plugins that do not configure
+// their own. Runs already in progress keep their timeout.
+func (r *Runner) SetDefaultTimeout(timeout time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaultTimeout = timeout
+}
+
+// SetSecrets makes the secrets granted to a plugin available in the
+// environment of its process. Secrets are never passed to the plugin otherwise.
+func (r *Runner) SetSecrets(s SecretSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secrets = s
+}
+
+// secretEnv returns the secret environment variables of the plugin
+func (r *Runner) secretEnv(pluginID int) ([]string, error) {
+	r.mu.Lock()
+	source := r.secrets
+	r.mu.Unlock()
+
+	if source == nil {
+		return nil, nil
+	}
+	env, err := source.Env(pluginID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+	return env, nil
+}
+
+// Run executes the plugin code with the default timeout and returns its combined output
 func (r *Runner) Run(id int, code string) (string, error) {
-	// Create a temporary file for the code
-	tempFile := filepath.Join(r.tempDir, fmt.Sprintf("%d.ts", id))
-	if err := os.WriteFile(tempFile, []byte(code), 0644); err != nil {
-		return "", fmt.Errorf("failed to write temp file: %w", err)
+	result, err := r.Execute(context.Background(), Request{PluginID: id, Code: code, Trigger: TriggerManual})
+	if err != nil {
+		return "", err
+	}
+	return result.Output, nil
+}
+
+// Execute runs a plugin, killing the whole process group when the context is
+// done, the timeout elapses or the run is cancelled. The run first waits for
+// its turn under the request's concurrency policy and the global run limit.
+// Skipped runs return ErrSkipped without notifying observers, runs cancelled
+// while they wait finish with the cancellation cause. The returned result is
+// populated even when an error is returned.
+func (r *Runner) Execute(ctx context.Context, req Request) (*Result, error) {
+	timeout := req.Timeout
+	if timeout <= 0 {
+		r.mu.Lock()
+		timeout = r.defaultTimeout
+		r.mu.Unlock()
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	runID, err := r.track(req.PluginID, cancel)
+	if err != nil {
+		return notStarted(err), err
+	}
+	defer r.untrack(req.PluginID, runID)
+
+	// Observers learn about the run as soon as it is certain not to be
+	// skipped, so it has an ID while it waits for its turn
+	run := &Run{
+		PluginID:  req.PluginID,
+		Trigger:   req.Trigger,
+		StartedAt: time.Now(),
+	}
+	var observers []Observer
+	announced := false
+	announce := func() {
+		if announced {
+			return
+		}
+		announced = true
+		observers = r.notifyStarted(run)
+		if req.OnStart != nil {
+			req.OnStart(run)
+		}
+	}
+	abort := func(err error) (*Result, error) {
+		result := notStarted(err)
+		if announced {
+			result.RunID = run.ID
+			for _, o := range observers {
+				o.RunFinished(run, result)
+			}
+		}
+		return result, err
+	}
+
+	if err := r.admit(ctx, req.PluginID, runID, req.Policy, announce); err != nil {
+		return abort(err)
+	}
+	task, err := r.task(req.PluginID)
+	if err != nil {
+		return abort(err)
+	}
+	// Tasks start other runs and wait for them, so they must not hold a slot
+	if task == nil {
+		release, err := r.slots.acquire(ctx)
+		if err != nil {
+			return abort(err)
+		}
+		defer release()
+	}
+
+	// The timeout only covers the execution, not the time spent waiting
+	ctx, cancelTimeout := context.WithTimeoutCause(ctx, timeout, ErrTimeout)
+	defer cancelTimeout()
+	run.StartedAt = time.Now()
+
+	onLine := func(stream string, line string) {
+		for _, o := range observers {
+			if oo, ok := o.(OutputObserver); ok {
+				oo.RunOutput(run, stream, line)
+			}
+		}
+	}
+	onState := func(state *State) {
+		for _, o := range observers {
+			if so, ok := o.(StateObserver); ok {
+				so.RunState(run, state)
+			}
+		}
+	}
+	execute := r.execute
+	switch {
+	case task != nil:
+		execute = executeTask(task)
+	case req.Resident:
+		execute = r.executeResident
+	}
+	result, err := execute(ctx, req, timeout, onLine, onState)
+	result.RunID = run.ID
+	result.StartedAt = run.StartedAt
+	result.FinishedAt = time.Now()
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	for _, o := range observers {
+		o.RunFinished(run, result)
+	}
+
+	return result, err
+}
+
+func (r *Runner) execute(ctx context.Context, req Request, timeout time.Duration, onLine func(stream string, line string), onState func(state *State)) (*Result, error) {
+	result := &Result{Status: StatusFailed, ExitCode: -1}
+
+	rt, err := LookupRuntime(req.Runtime)
+	if err != nil {
+		return result, err
+	}
+
+	env, err := r.secretEnv(req.PluginID)
+	if err != nil {
+		return result, err
+	}
+
+	// Create a temporary file for the code, unique per run so concurrent runs
+	// of the same plugin do not overwrite or delete each other's file
+	tempFile, err := writeTempFile(r.tempDir, req.PluginID, rt.Extension, req.Code)
+	if err != nil {
+		return result, err
 	}
 	defer os.Remove(tempFile)
 
-	// Run the code with Bun
-	cmd := exec.Command("bun", "run", tempFile)
-	output, err := cmd.CombinedOutput()
+	// Run the code with the plugin's runtime
+	cmd, err := rt.command(ctx, tempFile)
+	if err != nil {
+		return result, err
+	}
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	cmd.WaitDelay = time.Second
+	if req.Input != nil {
+		cmd.Stdin = bytes.NewReader(req.Input)
+		if len(req.Input) <= MaxInputEnvBytes {
+			env = append(env, InputEnv+"="+string(req.Input))
+		}
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	out := newCollector(result, onLine, onState)
+	stdoutLines := &lineWriter{onLine: out.stdoutLine}
+	stderrLines := &lineWriter{onLine: out.stderrLine}
+	cmd.Stdout = stdoutLines
+	cmd.Stderr = stderrLines
+
+	err = cmd.Run()
+	stdoutLines.Flush()
+	stderrLines.Flush()
+	out.finish()
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	if ctx.Err() != nil {
+		return result, contextError(ctx, result, timeout)
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to run plugin: %w\nOutput: %s", err, result.Output)
+	}
+
+	result.Status = StatusSuccess
+	return result, nil
+}
+
+// Shutdown stops accepting runs and waits for the runs in progress to finish.
+// Runs still going when ctx is done are terminated. The resident processes
+// are stopped last. It is meant to be called on exit.
+func (r *Runner) Shutdown(ctx context.Context) {
+	r.mu.Lock()
+	r.closed = true
+	var runs []*activeRun
+	for _, active := range r.running {
+		for _, run := range active {
+			runs = append(runs, run)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, run := range runs {
+		select {
+		case <-run.done:
+		case <-ctx.Done():
+			// Terminate the rest, killing their processes, and wait until
+			// they were cleaned up
+			for _, run := range runs {
+				run.cancel(ErrShuttingDown)
+			}
+			for _, run := range runs {
+				<-run.done
+			}
+			r.stopResidents()
+			return
+		}
+	}
+	r.stopResidents()
+}
+
+// Cancel aborts all in-flight runs of a plugin and reports whether any were running
+func (r *Runner) Cancel(id int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	runs := r.running[id]
+	for _, run := range runs {
+		run.cancel(ErrCancelled)
+	}
+	return len(runs) > 0
+}
+
+// contextError sets the status of a run whose context is done and returns the
+// matching error
+func contextError(ctx context.Context, result *Result, timeout time.Duration) error {
+	cause := context.Cause(ctx)
+	switch {
+	case errors.Is(cause, ErrTimeout):
+		result.Status = StatusTimedOut
+		return fmt.Errorf("%w after %s", ErrTimeout, timeout)
+	case errors.Is(cause, ErrCancelled):
+		result.Status = StatusCancelled
+		return ErrCancelled
+	default:
+		result.Status = StatusCancelled
+		return cause
+	}
+}
+
+// notStarted returns the result of a run that was dropped before executing
+func notStarted(err error) *Result {
+	status := StatusCancelled
+	if errors.Is(err, ErrSkipped) {
+		status = StatusSkipped
+	}
+	now := time.Now()
+	return &Result{
+		Status:     status,
+		ExitCode:   -1,
+		StartedAt:  now,
+		FinishedAt: now,
+		Error:      err.Error(),
+	}
+}
+
+func writeTempFile(dir string, id int, extension string, code string) (string, error) {
+	f, err := os.CreateTemp(dir, fmt.Sprintf("%d-*%s", id, extension))
 	if err != nil {
-		return "", fmt.Errorf("failed to run plugin: %w\nOutput: %s", err, string(output))
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := f.WriteString(code); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write temp file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+func (r *Runner) notifyStarted(run *Run) []Observer {
+	r.mu.Lock()
+	observers := append([]Observer(nil), r.observers...)
+	r.mu.Unlock()
+
+	for _, o := range observers {
+		o.RunStarted(run)
+	}
+	return observers
+}
+
+func (r *Runner) track(id int, cancel context.CancelCauseFunc) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, ErrShuttingDown
+	}
+	r.nextRun++
+	if r.running[id] == nil {
+		r.running[id] = make(map[int]*activeRun)
+	}
+	r.running[id][r.nextRun] = &activeRun{
+		cancel: cancel,
+		done:   make(chan struct{}),
 	}
+	return r.nextRun, nil
+}
+
+func (r *Runner) untrack(id int, runID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	close(r.running[id][runID].done)
+	delete(r.running[id], runID)
+	if len(r.running[id]) == 0 {
+		delete(r.running, id)
+	}
+}
+
+// collector gathers the output of a run into its result, consuming state
+// protocol lines and forwarding everything else line by line
+type collector struct {
+	result  *Result
+	output  outputBuffer
+	stdout  tailBuffer
+	stderr  tailBuffer
+	onLine  func(stream string, line string)
+	onState func(state *State)
+}
+
+func newCollector(result *Result, onLine func(stream string, line string), onState func(state *State)) *collector {
+	return &collector{result: result, onLine: onLine, onState: onState}
+}
+
+func (c *collector) stdoutLine(line string, newline bool) {
+	// State protocol lines are consumed rather than shown as output
+	state, isState, err := ParseStateLine(line)
+	if isState && err == nil {
+		if c.result.State == nil {
+			c.result.State = &State{}
+		}
+		c.result.State.Merge(state)
+		c.onState(state)
+		return
+	}
+	if isState {
+		line = fmt.Sprintf("%s (invalid state: %v)", line, err)
+	}
+	c.output.writeLine(&c.stdout, line, newline)
+	c.onLine(StreamStdout, line)
+}
+
+func (c *collector) stderrLine(line string, newline bool) {
+	c.output.writeLine(&c.stderr, line, newline)
+	c.onLine(StreamStderr, line)
+}
+
+// finish copies the collected output into the result
+func (c *collector) finish() {
+	c.result.Stdout = c.stdout.String()
+	c.result.Stderr = c.stderr.String()
+	c.result.Output = c.output.String()
+	c.result.DroppedBytes = c.stdout.dropped() + c.stderr.dropped()
+}
+
+// outputBuffer collects stdout and stderr interleaved in the order they were written
+type outputBuffer struct {
+	mu  sync.Mutex
+	buf tailBuffer
+}
+
+// writeLine appends a line to both the combined output and the stream's own buffer
+func (b *outputBuffer) writeLine(stream *tailBuffer, line string, newline bool) {
+	if newline {
+		line += "\n"
+	}
+	b.mu.Lock()
+	b.buf.WriteString(line)
+	b.mu.Unlock()
+	stream.WriteString(line)
+}
+
+func (b *outputBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
 
-	return string(output), nil
+// tailBuffer keeps the last MaxOutputBytes written to it, which usually hold
+// the error, and counts the bytes written before them
+type tailBuffer struct {
+	buf     []byte
+	written int
+}
+
+func (b *tailBuffer) WriteString(s string) {
+	b.written += len(s)
+	b.buf = append(b.buf, s...)
+	// Trimming only once the buffer doubled keeps appending cheap
+	if len(b.buf) > 2*MaxOutputBytes {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-MaxOutputBytes:]...)
+	}
+}
+
+// String returns the kept output, starting at a whole character
+func (b *tailBuffer) String() string {
+	tail := b.buf
+	if len(tail) > MaxOutputBytes {
+		tail = tail[len(tail)-MaxOutputBytes:]
+	}
+	for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+		tail = tail[1:]
+	}
+	return string(tail)
+}
+
+// dropped counts the bytes written but not returned by String
+func (b *tailBuffer) dropped() int {
+	return b.written - len(b.String())
+}
+
+// lineWriter calls onLine for every complete line written to it
+type lineWriter struct {
+	buf    []byte
+	onLine func(line string, newline bool)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(strings.TrimSuffix(string(w.buf[:i]), "\r"), true)
+		w.buf = w.buf[i+1:]
+	}
+	// Output without line breaks is passed on in pieces
+	if len(w.buf) >= MaxOutputBytes {
+		w.onLine(string(w.buf), false)
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+// Flush emits a trailing line that was not terminated by a newline
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.onLine(string(w.buf), false)
+		w.buf = nil
+	}
 }
 
 type PluginResult struct {
@@ -1,12 +1,17 @@
 package plugin
 
 import (
+	"bundeck/internal/db"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestNewRunner(t *testing.T) {
@@ -126,6 +131,314 @@ func TestRunner_TempFileHandling(t *testing.T) {
 	if _, err := os.Stat(tempFile); !os.IsNotExist(err) {
 		t.Error("Temporary file was not cleaned up")
 	}
+	if matches, _ := filepath.Glob(filepath.Join(runner.tempDir, "1-*.ts")); len(matches) > 0 {
+		t.Errorf("Per-run temporary files were not cleaned up: %v", matches)
+	}
+}
+
+func TestRunner_ConcurrentRunsOfSamePlugin(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	// Each run must execute its own code even when started at the same time
+	var wg sync.WaitGroup
+	outputs := make([]string, 5)
+	for i := range outputs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outputs[i], _ = runner.Run(1, fmt.Sprintf(`setTimeout(() => console.log("run %d"), 100)`, i))
+		}()
+	}
+	wg.Wait()
+
+	for i, output := range outputs {
+		if want := fmt.Sprintf("run %d\n", i); output != want {
+			t.Errorf("Expected output %q, got %q", want, output)
+		}
+	}
+}
+
+type startObserver struct {
+	started chan *Run
+}
+
+func (o *startObserver) RunStarted(run *Run) {
+	o.started <- run
+}
+
+func (o *startObserver) RunFinished(run *Run, result *Result) {}
+
+func TestRunner_ConcurrencyPolicy(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	observer := &startObserver{started: make(chan *Run, 10)}
+	runner.AddObserver(observer)
+
+	type outcome struct {
+		result *Result
+		err    error
+	}
+
+	// start executes a request in the background and waits until it is running
+	start := func(req Request) <-chan outcome {
+		done := make(chan outcome, 1)
+		go func() {
+			result, err := runner.Execute(context.Background(), req)
+			done <- outcome{result, err}
+		}()
+		select {
+		case <-observer.started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Plugin run never started")
+		}
+		return done
+	}
+
+	t.Run("Skip", func(t *testing.T) {
+		first := start(Request{PluginID: 1, Code: `setTimeout(() => {}, 30000)`, Policy: PolicySkip})
+		defer func() {
+			runner.Cancel(1)
+			<-first
+		}()
+
+		result, err := runner.Execute(context.Background(), Request{PluginID: 1, Code: `console.log("second")`, Policy: PolicySkip})
+		if !errors.Is(err, ErrSkipped) {
+			t.Errorf("Expected ErrSkipped, got %v", err)
+		}
+		if result.Status != StatusSkipped {
+			t.Errorf("Expected status %q, got %q", StatusSkipped, result.Status)
+		}
+	})
+
+	t.Run("Queue", func(t *testing.T) {
+		first := start(Request{PluginID: 2, Code: `setTimeout(() => {}, 300)`, Policy: PolicyQueue})
+
+		result, err := runner.Execute(context.Background(), Request{PluginID: 2, Code: `console.log("second")`, Policy: PolicyQueue})
+		if err != nil {
+			t.Fatalf("Queued run failed: %v", err)
+		}
+		<-observer.started
+
+		// The queued run only starts once the first run has finished
+		previous := <-first
+		if previous.err != nil {
+			t.Errorf("First run failed: %v", previous.err)
+		}
+		if result.StartedAt.Before(previous.result.FinishedAt) {
+			t.Error("Queued run started before the first run finished")
+		}
+		if result.Output != "second\n" {
+			t.Errorf("Expected output %q, got %q", "second\n", result.Output)
+		}
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		first := start(Request{PluginID: 3, Code: `setTimeout(() => {}, 30000)`, Policy: PolicyRestart})
+
+		result, err := runner.Execute(context.Background(), Request{PluginID: 3, Code: `console.log("second")`, Policy: PolicyRestart})
+		if err != nil {
+			t.Fatalf("Restarted run failed: %v", err)
+		}
+		<-observer.started
+
+		if previous := <-first; !errors.Is(previous.err, ErrCancelled) {
+			t.Errorf("Expected the first run to be cancelled, got %v", previous.err)
+		}
+		if result.Output != "second\n" {
+			t.Errorf("Expected output %q, got %q", "second\n", result.Output)
+		}
+	})
+}
+
+// Queued runs start in the order they were requested, even when a later run
+// asks to start first
+func TestRunner_QueueOrder(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	queued := make([]int, 5)
+	for i := range queued {
+		queued[i], _ = runner.track(1, func(error) {})
+	}
+	admitted := make(chan int, len(queued))
+	for i := len(queued) - 1; i >= 0; i-- {
+		go func(runID int) {
+			if err := runner.admit(context.Background(), 1, runID, PolicyQueue, func() {}); err != nil {
+				t.Errorf("Failed to admit run %d: %v", runID, err)
+			}
+			admitted <- runID
+			runner.untrack(1, runID)
+		}(queued[i])
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, expected := range queued {
+		select {
+		case runID := <-admitted:
+			if runID != expected {
+				t.Fatalf("Expected run %d to start next, got %d", expected, runID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Run %d never started", expected)
+		}
+	}
+}
+
+// A queued run has an ID while it waits, so callers such as async webhooks
+// answer without waiting for the runs ahead of it
+func TestRunner_OnStartWhileQueued(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	observer := &startObserver{started: make(chan *Run, 10)}
+	runner.AddObserver(observer)
+
+	req := Request{PluginID: 1, Code: "sleep 30", Runtime: RuntimeShell, Policy: PolicyQueue}
+	first := make(chan error, 1)
+	go func() {
+		_, err := runner.Execute(context.Background(), req)
+		first <- err
+	}()
+	<-observer.started
+
+	queued := make(chan *Run, 1)
+	req.OnStart = func(run *Run) {
+		queued <- run
+	}
+	second := make(chan error, 1)
+	go func() {
+		_, err := runner.Execute(context.Background(), req)
+		second <- err
+	}()
+	select {
+	case <-queued:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the queued run to be announced while the first run is in progress")
+	}
+	select {
+	case <-first:
+		t.Error("Expected the first run to still be in progress")
+	default:
+	}
+
+	runner.Cancel(1)
+	<-first
+	<-second
+}
+
+func TestRunner_MaxConcurrentRuns(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	observer := &startObserver{started: make(chan *Run, 10)}
+	runner.AddObserver(observer)
+	runner.SetMaxConcurrentRuns(1)
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := runner.Execute(context.Background(), Request{PluginID: 1, Code: `setTimeout(() => {}, 30000)`})
+		first <- err
+	}()
+	select {
+	case <-observer.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Plugin run never started")
+	}
+
+	// A different plugin has to wait for the free slot
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	result, err := runner.Execute(ctx, Request{PluginID: 2, Code: `console.log("second")`})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the waiting run to give up with its context, got %v", err)
+	}
+	if result.Status != StatusCancelled {
+		t.Errorf("Expected status %q, got %q", StatusCancelled, result.Status)
+	}
+	// Observers learn about the run while it waits for the slot
+	if len(observer.started) != 1 {
+		t.Errorf("Expected observers to be notified about the waiting run, got %d notifications", len(observer.started))
+	}
+	<-observer.started
+
+	runner.Cancel(1)
+	<-first
+
+	if _, err := runner.Run(2, `console.log("second")`); err != nil {
+		t.Errorf("Run failed after the slot was released: %v", err)
+	}
+}
+
+func TestRunner_MaxConcurrentRunsChange(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	observer := &startObserver{started: make(chan *Run, 10)}
+	runner.AddObserver(observer)
+	runner.SetMaxConcurrentRuns(1)
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := runner.Execute(context.Background(), Request{PluginID: 1, Code: `setTimeout(() => {}, 30000)`})
+		first <- err
+	}()
+	select {
+	case <-observer.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Plugin run never started")
+	}
+
+	// Saving the same limit again keeps counting the run in flight
+	runner.SetMaxConcurrentRuns(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := runner.Execute(ctx, Request{PluginID: 2, Code: `console.log("second")`}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the run over the limit to wait, got %v", err)
+	}
+	<-observer.started
+
+	// Raising the limit lets the waiting run start
+	second := make(chan error, 1)
+	go func() {
+		_, err := runner.Execute(context.Background(), Request{PluginID: 2, Code: `console.log("second")`})
+		second <- err
+	}()
+	<-observer.started
+	runner.SetMaxConcurrentRuns(2)
+	select {
+	case err := <-second:
+		if err != nil {
+			t.Errorf("Run failed after the limit was raised: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not start after the limit was raised")
+	}
+
+	runner.Cancel(1)
+	<-first
 }
 
 func TestRunner_LargeCode(t *testing.T) {
@@ -148,3 +461,500 @@ func TestRunner_LargeCode(t *testing.T) {
 		t.Fatalf("Failed to run large code: %v", err)
 	}
 }
+
+func TestRunner_LargeOutput(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	// Four times the limit, the last line marks the end
+	result, err := runner.Execute(context.Background(), Request{
+		PluginID: 1,
+		Code:     `for (let i = 0; i < 4096; i++) console.log("x".repeat(63)); console.log("done");`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to run plugin: %v", err)
+	}
+	written := 4096*64 + len("done\n")
+	if len(result.Stdout) != MaxOutputBytes || !strings.HasSuffix(result.Stdout, "done\n") {
+		t.Errorf("Expected the last %d bytes of stdout, got %d", MaxOutputBytes, len(result.Stdout))
+	}
+	if result.DroppedBytes != written-MaxOutputBytes {
+		t.Errorf("Expected %d dropped bytes, got %d", written-MaxOutputBytes, result.DroppedBytes)
+	}
+	if len(result.Output) != MaxOutputBytes {
+		t.Errorf("Expected the combined output capped at %d bytes, got %d", MaxOutputBytes, len(result.Output))
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	var b tailBuffer
+	b.WriteString(strings.Repeat("a", MaxOutputBytes))
+	b.WriteString("é")
+	b.WriteString(strings.Repeat("b", MaxOutputBytes-1))
+
+	// The tail starts at a whole character, the split é counts as dropped
+	got := b.String()
+	if len(got) != MaxOutputBytes-1 || got[0] != 'b' {
+		t.Errorf("Expected %d bytes of b, got %d starting with %q", MaxOutputBytes-1, len(got), got[:1])
+	}
+	if written := 2*MaxOutputBytes + 1; b.dropped() != written-len(got) {
+		t.Errorf("Expected %d dropped bytes, got %d", written-len(got), b.dropped())
+	}
+}
+
+func TestRunner_Timeout(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	start := time.Now()
+	result, err := runner.Execute(context.Background(), Request{
+		PluginID: 1,
+		Code:     `setTimeout(() => {}, 30000)`,
+		Timeout:  500 * time.Millisecond,
+	})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+	if result.Status != StatusTimedOut {
+		t.Errorf("Expected status %q, got %q", StatusTimedOut, result.Status)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Plugin was not killed on timeout, took %s", elapsed)
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		runner.SetDefaultTimeout(500 * time.Millisecond)
+		_, err := runner.Execute(context.Background(), Request{PluginID: 2, Code: `setTimeout(() => {}, 30000)`})
+		if !errors.Is(err, ErrTimeout) {
+			t.Errorf("Expected the default timeout to apply, got %v", err)
+		}
+	})
+}
+
+func TestRunner_Cancel(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	if runner.Cancel(1) {
+		t.Error("Expected Cancel to report no running plugin")
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := runner.Execute(context.Background(), Request{PluginID: 1, Code: `setTimeout(() => {}, 30000)`})
+		errCh <- err
+	}()
+
+	// Wait for the run to be registered before cancelling it
+	deadline := time.Now().Add(2 * time.Second)
+	for !runner.Cancel(1) {
+		if time.Now().After(deadline) {
+			t.Fatal("Plugin run was never registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrCancelled) {
+			t.Errorf("Expected ErrCancelled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cancelled plugin did not exit")
+	}
+}
+
+func TestRunner_Shutdown(t *testing.T) {
+	// start runs code in the background and waits until it has started
+	start := func(runner *Runner, code string) <-chan error {
+		started := make(chan struct{})
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := runner.Execute(context.Background(), Request{
+				PluginID: 1,
+				Code:     code,
+				OnStart:  func(run *Run) { close(started) },
+			})
+			errCh <- err
+		}()
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Plugin run never started")
+		}
+		return errCh
+	}
+
+	t.Run("Waits", func(t *testing.T) {
+		runner, err := NewRunner()
+		if err != nil {
+			t.Fatalf("Failed to create new runner: %v", err)
+		}
+		defer os.RemoveAll(runner.tempDir)
+
+		errCh := start(runner, `setTimeout(() => console.log("done"), 300)`)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		runner.Shutdown(ctx)
+
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Errorf("Expected the run to finish, got %v", err)
+			}
+		default:
+			t.Error("Expected Shutdown to wait for the run")
+		}
+		if _, err := runner.Execute(context.Background(), Request{PluginID: 2, Code: `console.log(1)`}); !errors.Is(err, ErrShuttingDown) {
+			t.Errorf("Expected ErrShuttingDown for a run after Shutdown, got %v", err)
+		}
+	})
+
+	t.Run("Terminates", func(t *testing.T) {
+		runner, err := NewRunner()
+		if err != nil {
+			t.Fatalf("Failed to create new runner: %v", err)
+		}
+		defer os.RemoveAll(runner.tempDir)
+
+		errCh := start(runner, `setTimeout(() => {}, 30000)`)
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		began := time.Now()
+		runner.Shutdown(ctx)
+
+		if err := <-errCh; !errors.Is(err, ErrShuttingDown) {
+			t.Errorf("Expected ErrShuttingDown, got %v", err)
+		}
+		if elapsed := time.Since(began); elapsed > 5*time.Second {
+			t.Errorf("Expected the run to be terminated, Shutdown took %s", elapsed)
+		}
+	})
+}
+
+type recordingObserver struct {
+	started  []*Run
+	finished []*Result
+}
+
+func (o *recordingObserver) RunStarted(run *Run) {
+	run.ID = 42
+	o.started = append(o.started, run)
+}
+
+func (o *recordingObserver) RunFinished(run *Run, result *Result) {
+	o.finished = append(o.finished, result)
+}
+
+func TestRunner_ExecuteResult(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	observer := &recordingObserver{}
+	runner.AddObserver(observer)
+
+	code := `
+		console.log("to stdout");
+		console.error("to stderr");
+		process.exit(3);
+	`
+	result, err := runner.Execute(context.Background(), Request{PluginID: 1, Code: code, Trigger: TriggerSchedule})
+	if err == nil {
+		t.Fatal("Expected error for non-zero exit code")
+	}
+
+	if result.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", result.ExitCode)
+	}
+	if result.Status != StatusFailed {
+		t.Errorf("Expected status %q, got %q", StatusFailed, result.Status)
+	}
+	if strings.TrimSpace(result.Stdout) != "to stdout" {
+		t.Errorf("Expected stdout %q, got %q", "to stdout", result.Stdout)
+	}
+	if strings.TrimSpace(result.Stderr) != "to stderr" {
+		t.Errorf("Expected stderr %q, got %q", "to stderr", result.Stderr)
+	}
+	if result.RunID != 42 {
+		t.Errorf("Expected run ID assigned by observer, got %d", result.RunID)
+	}
+
+	if len(observer.started) != 1 || observer.started[0].Trigger != TriggerSchedule {
+		t.Errorf("Expected one started run with trigger %q, got %v", TriggerSchedule, observer.started)
+	}
+	if len(observer.finished) != 1 || observer.finished[0] != result {
+		t.Error("Expected observer to receive the final result")
+	}
+}
+
+type lineObserver struct {
+	recordingObserver
+	mu    sync.Mutex
+	lines []string
+}
+
+func (o *lineObserver) RunOutput(run *Run, stream string, line string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.lines = append(o.lines, stream+": "+line)
+}
+
+func TestRunner_StreamOutput(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	observer := &lineObserver{}
+	runner.AddObserver(observer)
+
+	code := `
+		console.log("one");
+		console.log("two");
+		process.stdout.write("no newline");
+	`
+	if _, err := runner.Execute(context.Background(), Request{PluginID: 1, Code: code}); err != nil {
+		t.Fatalf("Failed to run plugin: %v", err)
+	}
+
+	want := []string{"stdout: one", "stdout: two", "stdout: no newline"}
+	if strings.Join(observer.lines, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected lines %v, got %v", want, observer.lines)
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	row := &db.Plugin{
+		ID:                3,
+		Code:              "print('hi')",
+		Runtime:           RuntimePython,
+		TimeoutSeconds:    5,
+		ConcurrencyPolicy: PolicyQueue,
+		Resident:          true,
+	}
+	req := NewRequest(row, TriggerSchedule)
+	want := Request{PluginID: 3, Code: "print('hi')", Runtime: RuntimePython, Timeout: 5 * time.Second, Trigger: TriggerSchedule, Policy: PolicyQueue, Resident: true}
+	if req.PluginID != want.PluginID || req.Code != want.Code || req.Runtime != want.Runtime || req.Timeout != want.Timeout ||
+		req.Trigger != want.Trigger || req.Policy != want.Policy || req.Resident != want.Resident {
+		t.Errorf("Expected %+v, got %+v", want, req)
+	}
+}
+
+type staticSecrets []string
+
+func (s staticSecrets) Env(pluginID int) ([]string, error) {
+	return s, nil
+}
+
+func TestRunner_Secrets(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	runner.SetSecrets(staticSecrets{"BUNDECK_TEST_SECRET=hunter2"})
+
+	output, err := runner.Run(1, `console.log(process.env.BUNDECK_TEST_SECRET)`)
+	if err != nil {
+		t.Fatalf("Failed to run plugin: %v", err)
+	}
+	if strings.TrimSpace(output) != "hunter2" {
+		t.Errorf("Expected secret in environment, got %q", output)
+	}
+}
+
+func TestParseStateLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		isState bool
+		wantErr bool
+	}{
+		{name: "Plain output", line: "CPU Usage: 42%"},
+		{name: "Title and badge", line: `::bundeck::{"title":"CPU","badge":"42%"}`, isState: true},
+		{name: "Toggle", line: `::bundeck::{"toggle":true,"color":"#22c55e"}`, isState: true},
+		{name: "Invalid JSON", line: `::bundeck::{"title":`, isState: true, wantErr: true},
+		{name: "Unknown field", line: `::bundeck::{"colour":"red"}`, isState: true, wantErr: true},
+		{name: "Non data URL image", line: `::bundeck::{"image":"https://example.com/a.png"}`, isState: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, isState, err := ParseStateLine(tt.line)
+			if isState != tt.isState {
+				t.Fatalf("Expected isState %v, got %v", tt.isState, isState)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.isState && !tt.wantErr && state == nil {
+				t.Error("Expected a parsed state")
+			}
+		})
+	}
+}
+
+type stateObserver struct {
+	recordingObserver
+	states []*State
+}
+
+func (o *stateObserver) RunState(run *Run, state *State) {
+	o.states = append(o.states, state)
+}
+
+func TestRunner_StateProtocol(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	observer := &stateObserver{}
+	runner.AddObserver(observer)
+
+	code := `
+		console.log("visible");
+		console.log('::bundeck::{"title":"Muted","toggle":true}');
+		console.log('::bundeck::{"toggle":false}');
+	`
+	result, err := runner.Execute(context.Background(), Request{PluginID: 1, Code: code})
+	if err != nil {
+		t.Fatalf("Failed to run plugin: %v", err)
+	}
+
+	if strings.TrimSpace(result.Output) != "visible" {
+		t.Errorf("Expected protocol lines to be removed from output, got %q", result.Output)
+	}
+	if len(observer.states) != 2 {
+		t.Fatalf("Expected 2 state updates, got %d", len(observer.states))
+	}
+	if result.State == nil || *result.State.Title != "Muted" || *result.State.Toggle {
+		t.Errorf("Expected merged state with title Muted and toggle off, got %+v", result.State)
+	}
+}
+
+func TestRunner_Input(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	observer := &recordingObserver{}
+	runner.AddObserver(observer)
+
+	var started *Run
+	code := `
+		let input = "";
+		process.stdin.on("data", (chunk) => { input += chunk; });
+		process.stdin.on("end", () => console.log(JSON.parse(input).name, JSON.parse(process.env.BUNDECK_INPUT).name));
+	`
+	result, err := runner.Execute(context.Background(), Request{
+		PluginID: 1,
+		Code:     code,
+		Trigger:  TriggerWebhook,
+		Input:    []byte(`{"name":"stream"}`),
+		OnStart:  func(run *Run) { started = run },
+	})
+	if err != nil {
+		t.Fatalf("Failed to run plugin: %v", err)
+	}
+	if strings.TrimSpace(result.Output) != "stream stream" {
+		t.Errorf("Expected input read from stdin and the environment, got %q", result.Output)
+	}
+	if started == nil || started.ID != result.RunID || started.ID == 0 {
+		t.Errorf("Expected OnStart with the assigned run ID %d, got %v", result.RunID, started)
+	}
+
+	// Large inputs only arrive on stdin, the environment cannot hold them
+	large := `{"name":"` + strings.Repeat("x", 200<<10) + `"}`
+	result, err = runner.Execute(context.Background(), Request{
+		PluginID: 1,
+		Code: `
+			let input = "";
+			process.stdin.on("data", (chunk) => { input += chunk; });
+			process.stdin.on("end", () => console.log(JSON.parse(input).name.length, process.env.BUNDECK_INPUT === undefined));
+		`,
+		Input: []byte(large),
+	})
+	if err != nil {
+		t.Fatalf("Failed to run plugin with a large input: %v", err)
+	}
+	if strings.TrimSpace(result.Output) != "204800 true" {
+		t.Errorf("Expected the large input on stdin only, got %q", result.Output)
+	}
+}
+
+// taskSource runs every plugin in tasks as a Go task
+type taskSource map[int]Task
+
+func (s taskSource) Task(pluginID int) (Task, error) {
+	return s[pluginID], nil
+}
+
+func TestRunner_Task(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	observer := &recordingObserver{}
+	runner.AddObserver(observer)
+	// The task waits for a run of its own, which needs the only slot
+	runner.SetMaxConcurrentRuns(1)
+	runner.SetTasks(taskSource{
+		1: func(ctx context.Context, result *Result, print func(line string)) error {
+			output, err := runner.Run(2, `console.log("from step")`)
+			if err != nil {
+				return err
+			}
+			print("step said " + strings.TrimSpace(output))
+			result.ExitCode = 0
+			result.Steps = []StepResult{{Step: 1, Action: "run", PluginID: 2, Status: StatusSuccess}}
+			return nil
+		},
+		3: func(ctx context.Context, result *Result, print func(line string)) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+
+	t.Run("Runs Instead Of Code", func(t *testing.T) {
+		result, err := runner.Execute(context.Background(), Request{PluginID: 1, Trigger: TriggerManual})
+		if err != nil {
+			t.Fatalf("Failed to run task: %v", err)
+		}
+		if result.Status != StatusSuccess || result.ExitCode != 0 {
+			t.Errorf("Expected a successful task, got %q with exit code %d", result.Status, result.ExitCode)
+		}
+		if strings.TrimSpace(result.Output) != "step said from step" {
+			t.Errorf("Expected the printed line as output, got %q", result.Output)
+		}
+		if len(result.Steps) != 1 || result.RunID != 42 {
+			t.Errorf("Expected one step and the observed run ID, got %+v", result)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		result, err := runner.Execute(context.Background(), Request{PluginID: 3, Timeout: 50 * time.Millisecond})
+		if !errors.Is(err, ErrTimeout) || result.Status != StatusTimedOut {
+			t.Errorf("Expected the task to time out, got %q: %v", result.Status, err)
+		}
+	})
+}
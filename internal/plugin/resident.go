@@ -0,0 +1,618 @@
+package plugin
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RPCPrefix marks a stdout line of a resident plugin as a JSON-RPC response
+// rather than output
+const RPCPrefix = "::bundeck-rpc::"
+
+// The host code wraps the plugin code of resident plugins: the prelude defines
+// the bundeck API and the epilogue serves JSON-RPC requests from stdin
+var (
+	//go:embed host/prelude.js
+	hostPrelude string
+	//go:embed host/epilogue.js
+	hostEpilogue string
+)
+
+// residentTimings configures the supervisor of a resident process
+type residentTimings struct {
+	healthInterval time.Duration
+	healthTimeout  time.Duration
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	// stableAfter resets the backoff once a process stayed up this long
+	stableAfter   time.Duration
+	shutdownGrace time.Duration
+}
+
+// defaultTimings applies to resident processes started afterwards, it is a
+// variable so tests can shorten it
+var defaultTimings = residentTimings{
+	healthInterval: 30 * time.Second,
+	healthTimeout:  5 * time.Second,
+	minBackoff:     time.Second,
+	maxBackoff:     30 * time.Second,
+	stableAfter:    time.Minute,
+	shutdownGrace:  2 * time.Second,
+}
+
+// ErrResidentStopped is returned for presses of a resident plugin that is
+// being shut down
+var ErrResidentStopped = errors.New("resident plugin was stopped")
+
+// errProcessExited fails requests that were pending when the process exited
+var errProcessExited = errors.New("resident plugin process exited")
+
+// errNoResponse is returned for requests the process received but did not
+// answer in time
+var errNoResponse = errors.New("resident plugin did not respond")
+
+// startupStderrLines is how many lines of stderr are kept to explain why a
+// process exited before it was ready
+const startupStderrLines = 20
+
+// ResidentStatus describes the long-lived process of a resident plugin
+type ResidentStatus struct {
+	PluginID  int        `json:"plugin_id"`
+	Running   bool       `json:"running"`
+	PID       int        `json:"pid,omitempty"`
+	Restarts  int        `json:"restarts"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int64  `json:"id,omitempty"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	ID     int64           `json:"id"`
+	Method string          `json:"method,omitempty"` // set on notifications such as ready
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// pressParams are sent to the plugin's press handlers
+type pressParams struct {
+	Trigger string          `json:"trigger"`
+	Input   json.RawMessage `json:"input,omitempty"`
+}
+
+// resident supervises the long-lived process of one plugin, restarting it with
+// backoff when it exits or fails a health check
+type resident struct {
+	pluginID int
+	code     string
+	runtime  Runtime
+	// env holds the secret environment variables of the process
+	env     []string
+	tempDir string
+	timings residentTimings
+
+	// press holds the token of the press in progress, a process handles one
+	// press at a time
+	press chan struct{}
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	nextID  int64
+	pending map[int64]chan rpcResponse
+	// sink receives the output of the press in progress
+	sink *collector
+	// ready is closed once the process loaded the plugin code and replaced
+	// when it exits
+	ready chan struct{}
+	// startErr is why the last process exited before it was ready, with the
+	// stderr it wrote while starting
+	startErr      error
+	startupStderr []string
+	status        ResidentStatus
+	stopped       bool
+	// failed is why the supervisor gave up, e.g. the runtime was uninstalled
+	failed error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func startResident(pluginID int, code string, rt Runtime, env []string, tempDir string) *resident {
+	p := &resident{
+		pluginID: pluginID,
+		code:     code,
+		runtime:  rt,
+		env:      env,
+		tempDir:  tempDir,
+		timings:  defaultTimings,
+		press:    make(chan struct{}, 1),
+		pending:  make(map[int64]chan rpcResponse),
+		ready:    make(chan struct{}),
+		status:   ResidentStatus{PluginID: pluginID},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.supervise()
+	return p
+}
+
+func (p *resident) supervise() {
+	defer close(p.done)
+
+	backoff := p.timings.minBackoff
+	for {
+		started := time.Now()
+		err := p.runProcess()
+
+		p.mu.Lock()
+		if p.stopped {
+			p.mu.Unlock()
+			return
+		}
+		if errors.Is(err, ErrRuntimeUnavailable) {
+			// Restarting cannot help, presses fail until the runtime is back
+			p.status.LastError = err.Error()
+			p.failed = err
+			p.stopped = true
+			close(p.stop)
+			p.mu.Unlock()
+			slog.Error("plugin: resident plugin cannot start", "plugin", p.pluginID, "err", err)
+			return
+		}
+		p.status.Restarts++
+		p.status.LastError = fmt.Sprint(err)
+		p.mu.Unlock()
+		slog.Warn("plugin: resident plugin exited, restarting", "plugin", p.pluginID, "backoff", backoff, "err", err)
+
+		if time.Since(started) > p.timings.stableAfter {
+			backoff = p.timings.minBackoff
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, p.timings.maxBackoff)
+	}
+}
+
+// runProcess starts the plugin process and blocks until it exits
+func (p *resident) runProcess() error {
+	tempFile, err := writeTempFile(p.tempDir, p.pluginID, p.runtime.Extension, hostPrelude+"\n"+p.code+"\n"+hostEpilogue)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile)
+
+	cmd, err := p.runtime.command(context.Background(), tempFile)
+	if err != nil {
+		return err
+	}
+	setProcessGroup(cmd)
+	cmd.WaitDelay = time.Second
+	if len(p.env) > 0 {
+		cmd.Env = append(os.Environ(), p.env...)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open stdin: %w", err)
+	}
+	stdoutLines := &lineWriter{onLine: func(line string, newline bool) {
+		p.handleLine(StreamStdout, line, newline)
+	}}
+	stderrLines := &lineWriter{onLine: func(line string, newline bool) {
+		p.handleLine(StreamStderr, line, newline)
+	}}
+	cmd.Stdout = stdoutLines
+	cmd.Stderr = stderrLines
+
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return ErrResidentStopped
+	}
+	if err := cmd.Start(); err != nil {
+		p.mu.Unlock()
+		return fmt.Errorf("failed to start plugin: %w", err)
+	}
+	startedAt := time.Now()
+	p.cmd = cmd
+	p.stdin = stdin
+	p.startErr = nil
+	p.startupStderr = nil
+	p.status.Running = true
+	p.status.PID = cmd.Process.Pid
+	p.status.StartedAt = &startedAt
+	p.mu.Unlock()
+
+	exited := make(chan struct{})
+	go p.checkHealth(exited)
+
+	err = cmd.Wait()
+	close(exited)
+	stdoutLines.Flush()
+	stderrLines.Flush()
+
+	p.mu.Lock()
+	p.cmd = nil
+	p.stdin = nil
+	p.status.Running = false
+	p.status.PID = 0
+	if !isClosed(p.ready) {
+		// Presses waiting for the process learn why it could not start
+		exit := "exit status 0"
+		if err != nil {
+			exit = err.Error()
+		}
+		p.startErr = fmt.Errorf("%w before it was ready (%s)", errProcessExited, exit)
+		if len(p.startupStderr) > 0 {
+			p.startErr = fmt.Errorf("%w:\n%s", p.startErr, strings.Join(p.startupStderr, "\n"))
+		}
+		close(p.ready)
+	}
+	p.ready = make(chan struct{})
+	for id, ch := range p.pending {
+		close(ch)
+		delete(p.pending, id)
+	}
+	p.mu.Unlock()
+
+	if err == nil {
+		return errProcessExited
+	}
+	return fmt.Errorf("%w: %v", errProcessExited, err)
+}
+
+// checkHealth pings the process periodically and kills it when it stops
+// responding, which makes the supervisor restart it
+func (p *resident) checkHealth(exited <-chan struct{}) {
+	ticker := time.NewTicker(p.timings.healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-exited:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), p.timings.healthTimeout)
+		_, err := p.call(ctx, "ping", nil)
+		cancel()
+		if err != nil && !errors.Is(err, ErrResidentStopped) && !errors.Is(err, errProcessExited) {
+			slog.Warn("plugin: resident plugin failed its health check", "plugin", p.pluginID, "err", err)
+			p.kill()
+		}
+	}
+}
+
+// handleLine dispatches JSON-RPC responses and passes other output to the
+// press in progress, or to the log between presses
+func (p *resident) handleLine(stream string, line string, newline bool) {
+	if payload, ok := strings.CutPrefix(line, RPCPrefix); ok && stream == StreamStdout {
+		var resp rpcResponse
+		if err := json.Unmarshal([]byte(payload), &resp); err != nil {
+			slog.Warn("plugin: invalid response from resident plugin", "plugin", p.pluginID, "err", err)
+			return
+		}
+		p.mu.Lock()
+		if resp.Method == "ready" {
+			if p.stdin != nil && !isClosed(p.ready) {
+				close(p.ready)
+			}
+			p.mu.Unlock()
+			return
+		}
+		ch := p.pending[resp.ID]
+		delete(p.pending, resp.ID)
+		p.mu.Unlock()
+		if ch != nil {
+			ch <- resp
+		}
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if stream == StreamStderr && !isClosed(p.ready) {
+		p.startupStderr = append(p.startupStderr, line)
+		if len(p.startupStderr) > startupStderrLines {
+			p.startupStderr = p.startupStderr[1:]
+		}
+	}
+	switch {
+	case p.sink == nil:
+		slog.Debug("plugin: resident plugin output", "plugin", p.pluginID, "line", line)
+	case stream == StreamStdout:
+		p.sink.stdoutLine(line, newline)
+	default:
+		p.sink.stderrLine(line, newline)
+	}
+}
+
+// isClosed reports whether ch is closed
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// call sends a request to the process, waiting for it to be ready first, and
+// returns the response. It fails right away while the process keeps exiting
+// before it is ready.
+func (p *resident) call(ctx context.Context, method string, params any) (rpcResponse, error) {
+	for {
+		p.mu.Lock()
+		ready := p.ready
+		startErr := p.startErr
+		p.mu.Unlock()
+		if startErr != nil {
+			return rpcResponse{}, startErr
+		}
+
+		select {
+		case <-ready:
+		case <-p.stop:
+			return rpcResponse{}, p.stopError()
+		case <-ctx.Done():
+			return rpcResponse{}, context.Cause(ctx)
+		}
+
+		p.mu.Lock()
+		if p.stdin == nil {
+			// The process exited in the meantime, wait for its replacement
+			// unless it never got ready
+			p.mu.Unlock()
+			continue
+		}
+		p.nextID++
+		id := p.nextID
+		ch := make(chan rpcResponse, 1)
+		p.pending[id] = ch
+		err := writeRequest(p.stdin, rpcRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+		if err != nil {
+			delete(p.pending, id)
+		}
+		p.mu.Unlock()
+		if err != nil {
+			return rpcResponse{}, fmt.Errorf("failed to send %s request: %w", method, err)
+		}
+
+		select {
+		case resp, ok := <-ch:
+			if !ok {
+				return rpcResponse{}, errProcessExited
+			}
+			return resp, nil
+		case <-ctx.Done():
+			p.mu.Lock()
+			delete(p.pending, id)
+			p.mu.Unlock()
+			return rpcResponse{}, fmt.Errorf("%w: %w", errNoResponse, context.Cause(ctx))
+		}
+	}
+}
+
+func writeRequest(w io.Writer, req rpcRequest) error {
+	line, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// handlePress sends a press to the process and collects its output into result
+func (p *resident) handlePress(ctx context.Context, req Request, result *Result, out *collector) error {
+	select {
+	case p.press <- struct{}{}:
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+	defer func() { <-p.press }()
+
+	p.mu.Lock()
+	p.sink = out
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.sink = nil
+		p.mu.Unlock()
+	}()
+
+	resp, err := p.call(ctx, "press", pressParams{Trigger: req.Trigger, Input: req.Input})
+	if err != nil {
+		if errors.Is(err, errNoResponse) {
+			// A press that hangs or is cancelled takes the process with it
+			p.kill()
+		}
+		return err
+	}
+	if resp.Error != nil {
+		result.ExitCode = 1
+		return fmt.Errorf("plugin failed: %s", resp.Error.Message)
+	}
+	result.ExitCode = 0
+	return nil
+}
+
+// failure is why the supervisor gave up, nil unless it did
+func (p *resident) failure() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.failed
+}
+
+// stopError is why presses fail once the supervisor stopped
+func (p *resident) stopError() error {
+	if err := p.failure(); err != nil {
+		return err
+	}
+	return ErrResidentStopped
+}
+
+// kill terminates the current process, if any
+func (p *resident) kill() {
+	p.mu.Lock()
+	cmd := p.cmd
+	p.mu.Unlock()
+	if cmd != nil {
+		killProcessGroup(cmd)
+	}
+}
+
+// shutdown asks the process to exit, killing it after a grace period, and stops
+// the supervisor
+func (p *resident) shutdown() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		<-p.done
+		return
+	}
+	p.stopped = true
+	close(p.stop)
+	if p.stdin != nil {
+		writeRequest(p.stdin, rpcRequest{JSONRPC: "2.0", Method: "shutdown"})
+		p.stdin.Close()
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+	case <-time.After(p.timings.shutdownGrace):
+		p.kill()
+		<-p.done
+	}
+}
+
+func (p *resident) Status() ResidentStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// executeResident handles a press in the plugin's resident process, starting
+// the process on first use and replacing it when the code, runtime or secrets
+// changed
+func (r *Runner) executeResident(ctx context.Context, req Request, timeout time.Duration, onLine func(stream string, line string), onState func(state *State)) (*Result, error) {
+	result := &Result{Status: StatusFailed, ExitCode: -1}
+	out := newCollector(result, onLine, onState)
+
+	rt, err := LookupRuntime(req.Runtime)
+	if err != nil {
+		return result, err
+	}
+	if !rt.Resident {
+		return result, fmt.Errorf("%s plugins cannot be resident", rt.Name)
+	}
+
+	// Fail right away instead of waiting for a process that cannot start
+	if _, err := rt.program(); err != nil {
+		return result, err
+	}
+
+	env, err := r.secretEnv(req.PluginID)
+	if err != nil {
+		return result, err
+	}
+
+	err = r.resident(req.PluginID, req.Code, rt, env).handlePress(ctx, req, result, out)
+	out.finish()
+
+	if ctx.Err() != nil {
+		return result, contextError(ctx, result, timeout)
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Status = StatusSuccess
+	return result, nil
+}
+
+func (r *Runner) resident(id int, code string, rt Runtime, env []string) *resident {
+	r.residentMu.Lock()
+	old := r.residents[id]
+	if old != nil && old.code == code && old.runtime.ID == rt.ID && slices.Equal(old.env, env) && old.failure() == nil {
+		r.residentMu.Unlock()
+		return old
+	}
+	p := startResident(id, code, rt, env, r.tempDir)
+	r.residents[id] = p
+	r.residentMu.Unlock()
+
+	// Shutting down may take a while, presses of other plugins go ahead
+	if old != nil {
+		old.shutdown()
+	}
+	return p
+}
+
+// StopResident shuts down the resident process of a plugin, e.g. after it was
+// edited or deleted, and reports whether one was running. The next press
+// starts a new process.
+func (r *Runner) StopResident(id int) bool {
+	r.residentMu.Lock()
+	p := r.residents[id]
+	delete(r.residents, id)
+	r.residentMu.Unlock()
+
+	if p == nil {
+		return false
+	}
+	p.shutdown()
+	return true
+}
+
+// ResidentStatus reports the state of a plugin's resident process. ok is false
+// when the plugin has no resident process.
+func (r *Runner) ResidentStatus(id int) (status ResidentStatus, ok bool) {
+	r.residentMu.Lock()
+	p := r.residents[id]
+	r.residentMu.Unlock()
+
+	if p == nil {
+		return ResidentStatus{PluginID: id}, false
+	}
+	return p.Status(), true
+}
+
+// stopResidents stops all resident processes
+func (r *Runner) stopResidents() {
+	r.residentMu.Lock()
+	defer r.residentMu.Unlock()
+
+	var wg sync.WaitGroup
+	for id, p := range r.residents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.shutdown()
+		}()
+		delete(r.residents, id)
+	}
+	wg.Wait()
+}
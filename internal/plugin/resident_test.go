@@ -0,0 +1,303 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requireBun skips the test when the bun runtime is not installed
+func requireBun(t *testing.T) {
+	t.Helper()
+	rt, err := LookupRuntime(RuntimeBun)
+	if err == nil {
+		_, err = rt.program()
+	}
+	if err != nil {
+		t.Skipf("bun is not installed: %v", err)
+	}
+}
+
+// residentProcess returns the supervised process of a plugin
+func residentProcess(t *testing.T, runner *Runner, pluginID int) *resident {
+	t.Helper()
+	runner.residentMu.Lock()
+	p := runner.residents[pluginID]
+	runner.residentMu.Unlock()
+	if p == nil {
+		t.Fatalf("Expected a resident process for plugin %d", pluginID)
+	}
+	return p
+}
+
+func TestRunner_Resident(t *testing.T) {
+	requireBun(t)
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+	defer runner.Shutdown(context.Background())
+
+	defaults := defaultTimings
+	defer func() { defaultTimings = defaults }()
+	defaultTimings.minBackoff = 10 * time.Millisecond
+
+	code := `
+		let presses = 0;
+		bundeck.onPress(({ trigger }) => {
+			presses++;
+			console.log("press " + presses + " " + trigger);
+		});
+	`
+	press := func(code string) (*Result, error) {
+		return runner.Execute(context.Background(), Request{
+			PluginID: 1,
+			Code:     code,
+			Trigger:  TriggerManual,
+			Resident: true,
+		})
+	}
+
+	t.Run("Keeps state between presses", func(t *testing.T) {
+		for i := 1; i <= 2; i++ {
+			result, err := press(code)
+			if err != nil {
+				t.Fatalf("Press %d failed: %v", i, err)
+			}
+			want := "press " + string(rune('0'+i)) + " manual\n"
+			if result.Output != want {
+				t.Errorf("Expected output %q, got %q", want, result.Output)
+			}
+			if result.Status != StatusSuccess || result.ExitCode != 0 {
+				t.Errorf("Expected success with exit code 0, got %q and %d", result.Status, result.ExitCode)
+			}
+		}
+	})
+
+	t.Run("Restarts after the process exits", func(t *testing.T) {
+		residentProcess(t, runner, 1).kill()
+
+		// Wait for the supervisor to notice the exit and start a new process
+		deadline := time.Now().Add(5 * time.Second)
+		for status, _ := runner.ResidentStatus(1); status.Restarts == 0 || !status.Running; status, _ = runner.ResidentStatus(1) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Process was not restarted, status %+v", status)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		result, err := press(code)
+		if err != nil {
+			t.Fatalf("Press after restart failed: %v", err)
+		}
+		if result.Output != "press 1 manual\n" {
+			t.Errorf("Expected a fresh process, got output %q", result.Output)
+		}
+		status, ok := runner.ResidentStatus(1)
+		if !ok || !status.Running || status.Restarts != 1 {
+			t.Errorf("Expected a running process restarted once, got %+v", status)
+		}
+	})
+
+	t.Run("Handler errors fail the press", func(t *testing.T) {
+		result, err := press(`bundeck.onPress(() => { throw new Error("boom"); });`)
+		if err == nil || !strings.Contains(err.Error(), "boom") {
+			t.Errorf("Expected the handler error, got %v", err)
+		}
+		if result.Status != StatusFailed || result.ExitCode != 1 {
+			t.Errorf("Expected failure with exit code 1, got %q and %d", result.Status, result.ExitCode)
+		}
+	})
+
+	t.Run("Passes input to the handlers", func(t *testing.T) {
+		result, err := runner.Execute(context.Background(), Request{
+			PluginID: 1,
+			Code:     `bundeck.onPress(({ trigger, input }) => console.log(trigger + " " + input.scene));`,
+			Trigger:  TriggerWebhook,
+			Resident: true,
+			Input:    []byte(`{"scene":"intro"}`),
+		})
+		if err != nil {
+			t.Fatalf("Press failed: %v", err)
+		}
+		if result.Output != "webhook intro\n" {
+			t.Errorf("Expected the input in the press, got output %q", result.Output)
+		}
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		if !runner.StopResident(1) {
+			t.Error("Expected StopResident to report a running process")
+		}
+		if _, ok := runner.ResidentStatus(1); ok {
+			t.Error("Expected no resident process after stopping it")
+		}
+		if runner.StopResident(1) {
+			t.Error("Expected StopResident to report no process")
+		}
+	})
+}
+
+func TestRunner_ResidentStartupFailure(t *testing.T) {
+	requireBun(t)
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+	defer runner.Shutdown(context.Background())
+
+	started := time.Now()
+	_, err = runner.Execute(context.Background(), Request{
+		PluginID: 1,
+		Code:     `throw new Error("broken on start");`,
+		Trigger:  TriggerManual,
+		Timeout:  10 * time.Second,
+		Resident: true,
+	})
+	if err == nil || !strings.Contains(err.Error(), "broken on start") {
+		t.Fatalf("Expected the startup error, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Expected the press to fail once the process exited, took %v", elapsed)
+	}
+}
+
+func TestRunner_ResidentPressWaitTimeout(t *testing.T) {
+	requireBun(t)
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+	defer runner.Shutdown(context.Background())
+
+	code := `bundeck.onPress(() => new Promise((resolve) => setTimeout(resolve, 500)));`
+	press := func(timeout time.Duration) error {
+		_, err := runner.Execute(context.Background(), Request{
+			PluginID: 1,
+			Code:     code,
+			Trigger:  TriggerManual,
+			Timeout:  timeout,
+			Resident: true,
+			Policy:   PolicyParallel,
+		})
+		return err
+	}
+	if err := press(10 * time.Second); err != nil {
+		t.Fatalf("First press failed: %v", err)
+	}
+	pid := residentProcess(t, runner, 1).Status().PID
+
+	// A press that times out waiting for the one in progress leaves the
+	// healthy process alone
+	slow := make(chan error, 1)
+	go func() { slow <- press(10 * time.Second) }()
+	time.Sleep(100 * time.Millisecond)
+	started := time.Now()
+	if err := press(100 * time.Millisecond); err == nil {
+		t.Error("Expected the waiting press to time out")
+	}
+	if elapsed := time.Since(started); elapsed > 300*time.Millisecond {
+		t.Errorf("Expected the waiting press to give up at its timeout, took %v", elapsed)
+	}
+	if err := <-slow; err != nil {
+		t.Errorf("Press in progress failed: %v", err)
+	}
+	if status := residentProcess(t, runner, 1).Status(); status.PID != pid || status.Restarts != 0 {
+		t.Errorf("Expected the same process without restarts, got %+v", status)
+	}
+}
+
+func TestRunner_ResidentHealthCheck(t *testing.T) {
+	requireBun(t)
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+	defer runner.Shutdown(context.Background())
+
+	defaults := defaultTimings
+	defer func() { defaultTimings = defaults }()
+	defaultTimings.healthInterval = 50 * time.Millisecond
+	defaultTimings.healthTimeout = 50 * time.Millisecond
+	defaultTimings.minBackoff = 10 * time.Millisecond
+
+	// The process never gets to answer pings, so the press times out and the
+	// health check keeps replacing the process
+	_, err = runner.Execute(context.Background(), Request{
+		PluginID: 1,
+		Code:     `while (true) {}`,
+		Timeout:  200 * time.Millisecond,
+		Resident: true,
+	})
+	if err == nil {
+		t.Fatal("Expected the press of a hung process to fail")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, _ := runner.ResidentStatus(1)
+		if status.Restarts >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Hung process was not restarted, status %+v", status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRunner_ResidentRuntimeUnavailable(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+	defer runner.Shutdown(context.Background())
+
+	bun, _ := LookupRuntime(RuntimeBun)
+	missing := filepath.Join(t.TempDir(), "bun")
+	setProgram(t, RuntimeBun, missing)
+
+	started := time.Now()
+	_, err = runner.Execute(context.Background(), Request{
+		PluginID: 1,
+		Code:     `bundeck.onPress(() => {});`,
+		Trigger:  TriggerManual,
+		Timeout:  10 * time.Second,
+		Resident: true,
+	})
+	if !errors.Is(err, ErrRuntimeUnavailable) {
+		t.Fatalf("Expected ErrRuntimeUnavailable, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Expected the press to fail right away, took %v", elapsed)
+	}
+	if _, ok := runner.ResidentStatus(1); ok {
+		t.Error("Expected no resident process to be supervised")
+	}
+
+	// A runtime uninstalled while the process runs stops the supervisor
+	// instead of restarting it forever
+	uninstalled := bun
+	uninstalled.Programs = []string{missing}
+	p := startResident(2, "", uninstalled, nil, runner.tempDir)
+	select {
+	case <-p.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the supervisor to give up")
+	}
+	if status := p.Status(); status.Restarts != 0 || status.LastError == "" {
+		t.Errorf("Expected no restarts and the error, got %+v", status)
+	}
+	if _, err := p.call(context.Background(), "ping", nil); !errors.Is(err, ErrRuntimeUnavailable) {
+		t.Errorf("Expected ErrRuntimeUnavailable for presses, got %v", err)
+	}
+}
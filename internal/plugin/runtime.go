@@ -0,0 +1,198 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runtime IDs
+const (
+	RuntimeBun        = "bun"
+	RuntimeNode       = "node"
+	RuntimeDeno       = "deno"
+	RuntimeShell      = "shell"
+	RuntimePython     = "python"
+	RuntimeExecutable = "executable"
+)
+
+// DefaultRuntime runs plugins that do not declare a runtime
+const DefaultRuntime = RuntimeBun
+
+var (
+	// ErrUnknownRuntime is returned for runtime IDs not in Runtimes()
+	ErrUnknownRuntime = errors.New("unknown runtime")
+	// ErrRuntimeUnavailable is returned when a runtime is not installed
+	ErrRuntimeUnavailable = errors.New("runtime is not installed")
+)
+
+// versionTimeout limits how long detection waits for a runtime's version
+const versionTimeout = 2 * time.Second
+
+// Runtime describes how plugin code is run
+type Runtime struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Extension is given to the file holding the code
+	Extension string `json:"extension"`
+	// Programs are looked up on the PATH in order, the first one found runs
+	// the code. Runtimes without programs run the code file itself.
+	Programs []string `json:"-"`
+	// Args come between the program and the code file
+	Args []string `json:"-"`
+	// Resident runtimes run JavaScript and can host resident plugins
+	Resident bool `json:"resident"`
+}
+
+// runtimesMu guards the programs SetProgram changes in runtimes
+var runtimesMu sync.RWMutex
+
+// runtimes are the runtimes plugins can declare, in the order they are offered
+var runtimes = []Runtime{
+	{ID: RuntimeBun, Name: "Bun", Extension: ".ts", Programs: []string{"bun"}, Args: []string{"run"}, Resident: true},
+	{ID: RuntimeNode, Name: "Node.js", Extension: ".mjs", Programs: []string{"node"}, Resident: true},
+	{ID: RuntimeDeno, Name: "Deno", Extension: ".ts", Programs: []string{"deno"}, Args: []string{"run", "--allow-all"}, Resident: true},
+	{ID: RuntimeShell, Name: "Shell", Extension: ".sh", Programs: []string{"sh"}},
+	{ID: RuntimePython, Name: "Python", Extension: ".py", Programs: []string{"python3", "python", "py"}},
+	// Executables start with a shebang line naming their interpreter
+	{ID: RuntimeExecutable, Name: "Executable", Extension: ""},
+}
+
+// Runtimes returns the runtimes plugins can declare, in the order they are
+// offered
+func Runtimes() []Runtime {
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+	return append([]Runtime(nil), runtimes...)
+}
+
+// LookupRuntime returns the runtime with the ID, where an empty ID is the
+// default runtime
+func LookupRuntime(id string) (Runtime, error) {
+	if id == "" {
+		id = DefaultRuntime
+	}
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+	for _, rt := range runtimes {
+		if rt.ID == id {
+			return rt, nil
+		}
+	}
+	return Runtime{}, fmt.Errorf("%w %q", ErrUnknownRuntime, id)
+}
+
+// SetProgram makes the runtime run code with the program at path instead of
+// looking up its programs on the PATH. Plugins started afterwards use it.
+func SetProgram(id string, path string) error {
+	return setPrograms(id, []string{path})
+}
+
+func setPrograms(id string, programs []string) error {
+	runtimesMu.Lock()
+	defer runtimesMu.Unlock()
+	for i := range runtimes {
+		if runtimes[i].ID == id {
+			runtimes[i].Programs = programs
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q", ErrUnknownRuntime, id)
+}
+
+// ValidRuntime reports whether id names a runtime, empty means the default
+func ValidRuntime(id string) bool {
+	_, err := LookupRuntime(id)
+	return err == nil
+}
+
+// program returns the path of the program running the code, which is empty
+// for runtimes running the code file itself
+func (rt Runtime) program() (string, error) {
+	if len(rt.Programs) == 0 {
+		// Windows has no shebang lines
+		if runtime.GOOS == "windows" {
+			return "", fmt.Errorf("%w: %s is not supported on Windows", ErrRuntimeUnavailable, rt.Name)
+		}
+		return "", nil
+	}
+	for _, name := range rt.Programs {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s was not found on the PATH", ErrRuntimeUnavailable, rt.Name)
+}
+
+// command returns the command running the code file
+func (rt Runtime) command(ctx context.Context, file string) (*exec.Cmd, error) {
+	program, err := rt.program()
+	if err != nil {
+		return nil, err
+	}
+	if program == "" {
+		if err := os.Chmod(file, 0700); err != nil {
+			return nil, fmt.Errorf("failed to make the plugin executable: %w", err)
+		}
+		return exec.CommandContext(ctx, file), nil
+	}
+	args := append(append([]string(nil), rt.Args...), file)
+	return exec.CommandContext(ctx, program, args...), nil
+}
+
+// RuntimeStatus reports whether a runtime is installed
+type RuntimeStatus struct {
+	Runtime
+	Available bool `json:"available"`
+	// Path is the program running the code
+	Path string `json:"path,omitempty"`
+	// Version is the first line the program prints for --version
+	Version string `json:"version,omitempty"`
+	// Error tells why the runtime is not available
+	Error string `json:"error,omitempty"`
+}
+
+// DetectRuntimes reports which runtimes are installed
+func DetectRuntimes(ctx context.Context) []RuntimeStatus {
+	runtimes := Runtimes()
+	statuses := make([]RuntimeStatus, len(runtimes))
+	var wg sync.WaitGroup
+	for i, rt := range runtimes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = detectRuntime(ctx, rt)
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
+
+func detectRuntime(ctx context.Context, rt Runtime) RuntimeStatus {
+	status := RuntimeStatus{Runtime: rt}
+	program, err := rt.program()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Available = true
+	status.Path = program
+	if program == "" {
+		return status
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, versionTimeout)
+	defer cancel()
+	// Some shells have no --version, they are still available
+	output, err := exec.CommandContext(ctx, program, "--version").Output()
+	if err == nil {
+		status.Version, _, _ = strings.Cut(strings.TrimSpace(string(output)), "\n")
+	}
+	return status
+}
@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLookupRuntime(t *testing.T) {
+	rt, err := LookupRuntime("")
+	if err != nil || rt.ID != DefaultRuntime {
+		t.Errorf("Expected the default runtime for an empty ID, got %+v, %v", rt, err)
+	}
+	if rt, err := LookupRuntime(RuntimePython); err != nil || rt.Extension != ".py" {
+		t.Errorf("Expected the Python runtime, got %+v, %v", rt, err)
+	}
+	if _, err := LookupRuntime("ruby"); !errors.Is(err, ErrUnknownRuntime) {
+		t.Errorf("Expected ErrUnknownRuntime, got %v", err)
+	}
+	if ValidRuntime("ruby") || !ValidRuntime(RuntimeShell) {
+		t.Error("Expected only known runtimes to be valid")
+	}
+}
+
+// setProgram makes the runtime use the program until the test ends
+func setProgram(t *testing.T, id string, path string) {
+	t.Helper()
+	rt, err := LookupRuntime(id)
+	if err != nil {
+		t.Fatalf("Failed to look up runtime: %v", err)
+	}
+	t.Cleanup(func() { setPrograms(id, rt.Programs) })
+	if err := SetProgram(id, path); err != nil {
+		t.Fatalf("Failed to set program: %v", err)
+	}
+}
+
+func TestSetProgram(t *testing.T) {
+	setProgram(t, RuntimeBun, "/opt/bun/bin/bun")
+	if rt, _ := LookupRuntime(RuntimeBun); len(rt.Programs) != 1 || rt.Programs[0] != "/opt/bun/bin/bun" {
+		t.Errorf("Expected only the configured program, got %v", rt.Programs)
+	}
+	if err := SetProgram("ruby", "/usr/bin/ruby"); !errors.Is(err, ErrUnknownRuntime) {
+		t.Errorf("Expected ErrUnknownRuntime, got %v", err)
+	}
+}
+
+func TestDetectRuntimes(t *testing.T) {
+	runtimes := Runtimes()
+	statuses := DetectRuntimes(context.Background())
+	if len(statuses) != len(runtimes) {
+		t.Fatalf("Expected %d runtimes, got %d", len(runtimes), len(statuses))
+	}
+	for i, status := range statuses {
+		if status.ID != runtimes[i].ID {
+			t.Errorf("Expected runtime %q at %d, got %q", runtimes[i].ID, i, status.ID)
+		}
+		if status.Available == (status.Error != "") {
+			t.Errorf("Expected an error only for unavailable runtimes, got %+v", status)
+		}
+		if status.ID == RuntimeExecutable && status.Available != (runtime.GOOS != "windows") {
+			t.Errorf("Expected executables to be available except on Windows, got %+v", status)
+		}
+	}
+}
+
+func TestRunner_Runtimes(t *testing.T) {
+	runner, err := NewRunner()
+	if err != nil {
+		t.Fatalf("Failed to create new runner: %v", err)
+	}
+	defer os.RemoveAll(runner.tempDir)
+
+	tests := []struct {
+		runtime string
+		program string
+		code    string
+	}{
+		{RuntimeShell, "sh", `read input; echo "hello $input $BUNDECK_INPUT"`},
+		{RuntimePython, "python3", "import os, sys\nprint('hello', sys.stdin.read(), os.environ['BUNDECK_INPUT'])"},
+		{RuntimeExecutable, "sh", "#!/bin/sh\nread input\necho \"hello $input $BUNDECK_INPUT\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.runtime, func(t *testing.T) {
+			if _, err := exec.LookPath(tt.program); err != nil || runtime.GOOS == "windows" {
+				t.Skipf("%s is not installed", tt.program)
+			}
+			result, err := runner.Execute(context.Background(), Request{
+				PluginID: 1,
+				Code:     tt.code,
+				Runtime:  tt.runtime,
+				Input:    []byte(`"world"`),
+			})
+			if err != nil {
+				t.Fatalf("Failed to run plugin: %v", err)
+			}
+			if strings.TrimSpace(result.Output) != `hello "world" "world"` {
+				t.Errorf("Expected the input on stdin and in the environment, got %q", result.Output)
+			}
+		})
+	}
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := runner.Execute(context.Background(), Request{PluginID: 1, Code: "", Runtime: "ruby"})
+		if !errors.Is(err, ErrUnknownRuntime) {
+			t.Errorf("Expected ErrUnknownRuntime, got %v", err)
+		}
+	})
+
+	t.Run("Not Resident", func(t *testing.T) {
+		_, err := runner.Execute(context.Background(), Request{PluginID: 2, Code: "echo", Runtime: RuntimeShell, Resident: true})
+		if err == nil {
+			t.Error("Expected shell plugins to be rejected as resident")
+		}
+	})
+}
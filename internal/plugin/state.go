@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+// StatePrefix marks a stdout line as a button state update rather than output,
+// e.g. ::bundeck::{"title":"CPU","badge":"42%"}
+const StatePrefix = "::bundeck::"
+
+// maxImageBytes bounds the size of an image data URL sent by a plugin
+const maxImageBytes = 512 * 1024
+
+// State is a partial update of how a plugin's button is displayed. Fields that
+// are nil are left unchanged.
+type State struct {
+	Title  *string `json:"title,omitempty"`
+	Badge  *string `json:"badge,omitempty"`
+	Color  *string `json:"color,omitempty"`
+	Toggle *bool   `json:"toggle,omitempty"`
+	// Image is a data URL, e.g. data:image/png;base64,...
+	Image *string `json:"image,omitempty"`
+}
+
+// StateObserver is an optional extension of Observer that receives button
+// state updates as soon as a plugin emits them
+type StateObserver interface {
+	RunState(run *Run, state *State)
+}
+
+// ParseStateLine returns the state update carried by a protocol line. ok is
+// false for ordinary output lines; err is set for malformed protocol lines.
+func ParseStateLine(line string) (state *State, ok bool, err error) {
+	payload, found := strings.CutPrefix(line, StatePrefix)
+	if !found {
+		return nil, false, nil
+	}
+
+	var s State
+	decoder := json.NewDecoder(strings.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		return nil, true, err
+	}
+	if err := s.validate(); err != nil {
+		return nil, true, err
+	}
+	return &s, true, nil
+}
+
+// Merge applies a partial update on top of the state
+func (s *State) Merge(update *State) {
+	if update.Title != nil {
+		s.Title = update.Title
+	}
+	if update.Badge != nil {
+		s.Badge = update.Badge
+	}
+	if update.Color != nil {
+		s.Color = update.Color
+	}
+	if update.Toggle != nil {
+		s.Toggle = update.Toggle
+	}
+	if update.Image != nil {
+		s.Image = update.Image
+	}
+}
+
+func (s *State) validate() error {
+	if s.Title != nil && len(*s.Title) > 100 {
+		return errors.New("title must be at most 100 characters")
+	}
+	if s.Badge != nil && len(*s.Badge) > 20 {
+		return errors.New("badge must be at most 20 characters")
+	}
+	if s.Color != nil && len(*s.Color) > 32 {
+		return errors.New("color must be at most 32 characters")
+	}
+	if s.Image != nil && *s.Image != "" {
+		if !strings.HasPrefix(*s.Image, "data:image/") {
+			return errors.New("image must be a data:image/ URL")
+		}
+		if len(*s.Image) > maxImageBytes {
+			return errors.New("image is too large")
+		}
+	}
+	return nil
+}
+
+// StateStore persists button state updates
+type StateStore interface {
+	MergeState(id int, update []byte) error
+}
+
+// StateRecorder is an Observer that persists button state updates on the plugin
+type StateRecorder struct {
+	store StateStore
+}
+
+func NewStateRecorder(store StateStore) *StateRecorder {
+	return &StateRecorder{store: store}
+}
+
+func (r *StateRecorder) RunStarted(run *Run) {}
+
+func (r *StateRecorder) RunFinished(run *Run, result *Result) {}
+
+func (r *StateRecorder) RunState(run *Run, state *State) {
+	update, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	if err := r.store.MergeState(run.PluginID, update); err != nil {
+		slog.Error("plugin: failed to store state", "plugin", run.PluginID, "err", err)
+	}
+}
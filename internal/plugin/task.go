@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Task is Go code run in place of a plugin process, such as the steps of a
+// macro. Lines passed to print become the run's output. The task sets the
+// exit code and step results of result, its status is set from the error.
+type Task func(ctx context.Context, result *Result, print func(line string)) error
+
+// TaskSource provides the tasks of plugins that do not run as a process
+type TaskSource interface {
+	// Task returns nil for plugins that run their code as a process
+	Task(pluginID int) (Task, error)
+}
+
+// StepResult is the outcome of one step of a task
+type StepResult struct {
+	// Step is the position of the step, starting at 1
+	Step   int    `json:"step"`
+	Action string `json:"action"`
+	// PluginID and RunID identify the run started by the step, if any
+	PluginID   int       `json:"plugin_id,omitempty"`
+	RunID      int64     `json:"run_id,omitempty"`
+	Status     string    `json:"status"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// SetTasks lets the source run plugins as Go tasks instead of processes. The
+// runs of tasks are admitted, observed and cancelled like any other run, but
+// do not count towards the limit of concurrent runs.
+func (r *Runner) SetTasks(s TaskSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tasks = s
+}
+
+// task returns the task of a plugin, or nil when it runs as a process
+func (r *Runner) task(pluginID int) (Task, error) {
+	r.mu.Lock()
+	source := r.tasks
+	r.mu.Unlock()
+
+	if source == nil {
+		return nil, nil
+	}
+	task, err := source.Task(pluginID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load task: %w", err)
+	}
+	return task, nil
+}
+
+// executeTask returns an execute function running the task
+func executeTask(task Task) func(ctx context.Context, req Request, timeout time.Duration, onLine func(stream string, line string), onState func(state *State)) (*Result, error) {
+	return func(ctx context.Context, req Request, timeout time.Duration, onLine func(stream string, line string), onState func(state *State)) (*Result, error) {
+		result := &Result{Status: StatusFailed, ExitCode: -1}
+
+		out := newCollector(result, onLine, onState)
+		err := task(ctx, result, func(line string) {
+			out.stdoutLine(line, true)
+		})
+		out.finish()
+
+		if ctx.Err() != nil {
+			return result, contextError(ctx, result, timeout)
+		}
+		if err != nil {
+			return result, err
+		}
+
+		result.Status = StatusSuccess
+		return result, nil
+	}
+}
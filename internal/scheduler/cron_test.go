@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustParseCron(t *testing.T, expression string, timeZone string) *Cron {
+	t.Helper()
+	cron, err := ParseCron(expression, timeZone)
+	if err != nil {
+		t.Fatalf("Failed to parse %q: %v", expression, err)
+	}
+	return cron
+}
+
+func TestParseCron_Errors(t *testing.T) {
+	tests := []struct {
+		expression string
+		timeZone   string
+		err        string
+	}{
+		{"", "", "needs 5 fields"},
+		{"* * * *", "", "needs 5 fields"},
+		{"60 * * * *", "", "minute: 60 is outside 0-59"},
+		{"* 24 * * *", "", "hour: 24 is outside 0-23"},
+		{"* * 0 * *", "", "day of month: 0 is outside 1-31"},
+		{"* * * 13 *", "", "month: 13 is outside 1-12"},
+		{"* * * * 8", "", "day of week: 8 is outside 0-7"},
+		{"*/0 * * * *", "", "invalid step"},
+		{"5-1 * * * *", "", "starts after it ends"},
+		{"* * * * MONDAY", "", "invalid value"},
+		{"@every 5m", "", "needs 5 fields"},
+		{"0 9 * * *", "Mars/Olympus", "unknown time zone"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseCron(tt.expression, tt.timeZone)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseCron(%q, %q): expected an error containing %q, got %v", tt.expression, tt.timeZone, tt.err, err)
+		}
+	}
+}
+
+func TestCron_Next(t *testing.T) {
+	// Wednesday
+	start := time.Date(2025, time.March, 12, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		expression string
+		expected   []string
+	}{
+		{"Weekdays at nine", "0 9 * * MON-FRI", []string{"2025-03-13 09:00", "2025-03-14 09:00", "2025-03-17 09:00"}},
+		{"Every 15 minutes", "*/15 * * * *", []string{"2025-03-12 10:45", "2025-03-12 11:00", "2025-03-12 11:15"}},
+		{"Step from a value", "50/5 10 * * *", []string{"2025-03-12 10:50", "2025-03-12 10:55", "2025-03-13 10:50"}},
+		{"Lists and ranges", "0 8,12-13 * * *", []string{"2025-03-12 12:00", "2025-03-12 13:00", "2025-03-13 08:00"}},
+		{"Day of month or weekday", "0 0 1 * SUN", []string{"2025-03-16 00:00", "2025-03-23 00:00", "2025-03-30 00:00", "2025-04-01 00:00"}},
+		{"Sunday as 7", "0 0 * * 7", []string{"2025-03-16 00:00"}},
+		{"Leap day", "0 0 29 FEB *", []string{"2028-02-29 00:00"}},
+		{"Macro", "@monthly", []string{"2025-04-01 00:00", "2025-05-01 00:00"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron := mustParseCron(t, tt.expression, "UTC")
+			runs := cron.NextRuns(start, len(tt.expected))
+			if len(runs) != len(tt.expected) {
+				t.Fatalf("Expected %d runs, got %v", len(tt.expected), runs)
+			}
+			for i, run := range runs {
+				if got := run.Format("2006-01-02 15:04"); got != tt.expected[i] {
+					t.Errorf("Run %d: expected %s, got %s", i, tt.expected[i], got)
+				}
+			}
+		})
+	}
+
+	t.Run("Never", func(t *testing.T) {
+		cron := mustParseCron(t, "0 0 30 FEB *", "UTC")
+		if next := cron.Next(start); !next.IsZero() {
+			t.Errorf("Expected no run on February 30th, got %s", next)
+		}
+	})
+}
+
+func TestCron_TimeZone(t *testing.T) {
+	cron := mustParseCron(t, "0 9 * * *", "America/New_York")
+	start := time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	// 09:00 in New York is 13:00 UTC during daylight saving time
+	next := cron.Next(start)
+	if expected := time.Date(2025, time.June, 1, 13, 0, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Errorf("Expected %s, got %s", expected, next.UTC())
+	}
+	if next.Location().String() != "America/New_York" {
+		t.Errorf("Expected the run in the cron's time zone, got %s", next.Location())
+	}
+}
+
+func TestCron_DaylightSaving(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Time zone data not available: %v", err)
+	}
+
+	t.Run("Skipped hour", func(t *testing.T) {
+		// 02:30 does not exist on March 30th 2025 in Berlin
+		cron := mustParseCron(t, "30 2 * * *", "Europe/Berlin")
+		next := cron.Next(time.Date(2025, time.March, 29, 12, 0, 0, 0, berlin))
+		if expected := time.Date(2025, time.March, 31, 2, 30, 0, 0, berlin); !next.Equal(expected) {
+			t.Errorf("Expected %s, got %s", expected, next)
+		}
+	})
+
+	t.Run("Hourly across the change", func(t *testing.T) {
+		cron := mustParseCron(t, "0 * * * *", "Europe/Berlin")
+		runs := cron.NextRuns(time.Date(2025, time.October, 26, 1, 30, 0, 0, berlin), 3)
+		for i := 1; i < len(runs); i++ {
+			if !runs[i].After(runs[i-1]) {
+				t.Fatalf("Expected increasing run times, got %v", runs)
+			}
+		}
+	})
+}
@@ -0,0 +1,440 @@
+package scheduler
+
+import (
+	"bundeck/internal/db"
+	"bundeck/internal/plugin"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrNotSchedulable is returned when a plugin is not configured to run continuously
+var ErrNotSchedulable = errors.New("plugin is not configured to run continuously")
+
+var (
+	// cronPoll is how often cron jobs compare the wall clock with their next
+	// run. Timers may stop while the machine sleeps, so cron jobs do not
+	// sleep until the next run in one go.
+	cronPoll = 30 * time.Second
+	// cronGrace is how late a cron run may start before it counts as missed
+	cronGrace = time.Minute
+)
+
+// PluginStore is the subset of database operations the scheduler needs
+type PluginStore interface {
+	GetAll() ([]db.Plugin, error)
+	GetByID(id int) (*db.Plugin, error)
+}
+
+// Runner executes plugin code
+type Runner interface {
+	Execute(ctx context.Context, req plugin.Request) (*plugin.Result, error)
+}
+
+// FireStore remembers when cron schedules last fired, so runs missed while
+// BunDeck was not running follow the missed run policy after a restart
+type FireStore interface {
+	LastFired(pluginID int) (time.Time, error)
+	SetLastFired(pluginID int, t time.Time) error
+	ClearLastFired(pluginID int) error
+}
+
+// Status describes the schedule state of a single plugin
+type Status struct {
+	PluginID        int    `json:"plugin_id"`
+	Running         bool   `json:"running"`
+	IntervalSeconds int    `json:"interval_seconds"`
+	Cron            string `json:"cron_expression,omitempty"`
+	TimeZone        string `json:"time_zone,omitempty"`
+	MissedRunPolicy string `json:"missed_run_policy,omitempty"`
+	// MissedRuns counts the cron runs skipped because they were due while
+	// the machine was asleep
+	MissedRuns int        `json:"missed_runs"`
+	LastRun    *time.Time `json:"last_run"`
+	NextRun    *time.Time `json:"next_run"`
+	LastResult string     `json:"last_result"`
+	LastError  string     `json:"last_error"`
+}
+
+// schedule is when a plugin runs, either every interval or on a cron
+// expression. Jobs are restarted when it changes.
+type schedule struct {
+	interval time.Duration
+	cron     string
+	timeZone string
+	missed   string
+}
+
+// scheduleOf reads the schedule of a plugin and parses its cron expression
+func scheduleOf(row *db.Plugin) (schedule, *Cron, error) {
+	if !row.RunContinuously || (row.CronExpression == "" && row.IntervalSeconds <= 0) {
+		return schedule{}, nil, ErrNotSchedulable
+	}
+	if row.CronExpression == "" {
+		return schedule{interval: time.Duration(row.IntervalSeconds) * time.Second}, nil, nil
+	}
+
+	cron, err := ParseCron(row.CronExpression, row.TimeZone)
+	if err != nil {
+		return schedule{}, nil, fmt.Errorf("%w: %v", ErrNotSchedulable, err)
+	}
+	missed := row.MissedRunPolicy
+	if missed == "" {
+		missed = MissedSkip
+	}
+	return schedule{cron: row.CronExpression, timeZone: row.TimeZone, missed: missed}, cron, nil
+}
+
+type job struct {
+	schedule schedule
+	cron     *Cron
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	lastRun    time.Time
+	nextRun    time.Time
+	lastResult string
+	lastError  string
+	missedRuns int
+}
+
+// Scheduler owns all periodic plugin runs
+type Scheduler struct {
+	store  PluginStore
+	runner Runner
+	fires  FireStore
+
+	// now is the wall clock, replaced in tests
+	now func() time.Time
+
+	mu   sync.Mutex
+	jobs map[int]*job
+}
+
+func New(store PluginStore, runner Runner) *Scheduler {
+	return &Scheduler{
+		store:  store,
+		runner: runner,
+		now:    time.Now,
+		jobs:   make(map[int]*job),
+	}
+}
+
+// SetFireStore remembers when cron schedules fire in fires. Without one,
+// cron schedules only notice missed runs while BunDeck keeps running.
+func (s *Scheduler) SetFireStore(fires FireStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fires = fires
+}
+
+// Start begins running the plugin on its configured interval or cron
+// expression
+func (s *Scheduler) Start(id int) error {
+	row, err := s.store.GetByID(id)
+	if err != nil {
+		return err
+	}
+	sched, cron, err := scheduleOf(row)
+	if err != nil {
+		return err
+	}
+
+	s.replace(id, sched, cron)
+	return nil
+}
+
+// StartAll starts every plugin configured to run continuously, e.g. when
+// the server starts. Plugins with an invalid schedule are skipped.
+func (s *Scheduler) StartAll() error {
+	rows, err := s.store.GetAll()
+	if err != nil {
+		return err
+	}
+	for i := range rows {
+		sched, cron, err := scheduleOf(&rows[i])
+		if err != nil {
+			if rows[i].RunContinuously {
+				slog.Warn("scheduler: not starting plugin", "plugin", rows[i].ID, "err", err)
+			}
+			continue
+		}
+		s.replace(rows[i].ID, sched, cron)
+	}
+	return nil
+}
+
+// Stop halts the periodic runs of a plugin, cancelling an in-progress run and
+// waiting for it to exit. Runs due while it is stopped do not count as
+// missed when it is started again.
+func (s *Scheduler) Stop(id int) error {
+	s.stop(id)
+
+	s.mu.Lock()
+	fires := s.fires
+	s.mu.Unlock()
+	if fires != nil {
+		if err := fires.ClearLastFired(id); err != nil {
+			slog.Warn("scheduler: failed to clear the last fire time", "plugin", id, "err", err)
+		}
+	}
+	return nil
+}
+
+// stop halts the periodic runs of a plugin like Stop but keeps its last
+// fire time, so they continue where they left off when started again
+func (s *Scheduler) stop(id int) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	if ok {
+		delete(s.jobs, id)
+		j.cancel()
+	}
+	s.mu.Unlock()
+
+	if ok {
+		<-j.done
+	}
+}
+
+// Reload re-reads a plugin after it has been created, updated or deleted and
+// adjusts its schedule accordingly. Plugins configured to run continuously
+// are started, all others stopped.
+func (s *Scheduler) Reload(id int) {
+	row, err := s.store.GetByID(id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("scheduler: failed to reload plugin", "plugin", id, "err", err)
+		return
+	}
+
+	var sched schedule
+	var cron *Cron
+	if row != nil {
+		sched, cron, err = scheduleOf(row)
+	}
+	if row == nil || err != nil {
+		s.Stop(id)
+		return
+	}
+	s.replace(id, sched, cron)
+}
+
+// Status returns the schedule state of a plugin
+func (s *Scheduler) Status(id int) Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return Status{PluginID: id}
+	}
+	return j.status(id)
+}
+
+// StatusAll returns the schedule state of every running plugin
+func (s *Scheduler) StatusAll() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := []Status{}
+	for id, j := range s.jobs {
+		statuses = append(statuses, j.status(id))
+	}
+	return statuses
+}
+
+// StopAll halts every running plugin, e.g. on shutdown. Cron schedules
+// continue where they left off when started again.
+func (s *Scheduler) StopAll() {
+	s.mu.Lock()
+	ids := make([]int, 0, len(s.jobs))
+	for id := range s.jobs {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	for _, id := range ids {
+		s.stop(id)
+	}
+}
+
+// startLocked starts a job. Cron jobs run next after since if it is set,
+// otherwise after the current time.
+func (s *Scheduler) startLocked(id int, sched schedule, cron *Cron, since time.Time) {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		schedule: sched,
+		cron:     cron,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	s.jobs[id] = j
+	if cron != nil {
+		now := s.now()
+		if since.IsZero() || since.After(now) {
+			since = now
+		}
+		j.nextRun = cron.Next(since)
+		go s.cronLoop(id, j)
+		return
+	}
+	go s.loop(id, j)
+}
+
+// replace starts a job for the plugin unless one with the same schedule is
+// running. A job with another schedule is stopped first, and its run in
+// progress must exit before the new job runs the plugin.
+func (s *Scheduler) replace(id int, sched schedule, cron *Cron) {
+	s.mu.Lock()
+	old, ok := s.jobs[id]
+	if ok {
+		if old.schedule == sched {
+			s.mu.Unlock()
+			return
+		}
+		delete(s.jobs, id)
+		old.cancel()
+	}
+	fires := s.fires
+	s.mu.Unlock()
+
+	// A changed schedule starts afresh, a stopped one where it left off
+	var since time.Time
+	if ok {
+		<-old.done
+	} else if cron != nil && fires != nil {
+		var err error
+		if since, err = fires.LastFired(id); err != nil {
+			slog.Warn("scheduler: failed to read the last fire time", "plugin", id, "err", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Another caller may have started the plugin while the old job exited
+	if _, ok := s.jobs[id]; !ok {
+		s.startLocked(id, sched, cron, since)
+	}
+}
+
+func (s *Scheduler) loop(id int, j *job) {
+	defer close(j.done)
+
+	ticker := time.NewTicker(j.schedule.interval)
+	defer ticker.Stop()
+
+	for {
+		s.runOnce(id, j)
+
+		s.mu.Lock()
+		j.nextRun = j.lastRun.Add(j.schedule.interval)
+		s.mu.Unlock()
+
+		select {
+		case <-j.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cronLoop runs a plugin whenever the wall clock passes the next time of its
+// cron expression. Runs that were due more than cronGrace ago, because the
+// machine was asleep, follow the job's missed run policy.
+func (s *Scheduler) cronLoop(id int, j *job) {
+	defer close(j.done)
+
+	ticker := time.NewTicker(cronPoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		next := j.nextRun
+		s.mu.Unlock()
+
+		now := s.now()
+		if next.IsZero() || now.Before(next) {
+			continue
+		}
+
+		if now.Sub(next) > cronGrace && j.schedule.missed == MissedSkip {
+			missed := 0
+			for t := next; !t.IsZero() && !t.After(now); t = j.cron.Next(t) {
+				missed++
+			}
+			slog.Info("scheduler: skipped missed runs", "plugin", id, "missed", missed)
+			s.mu.Lock()
+			j.missedRuns += missed
+			s.mu.Unlock()
+		} else {
+			s.runOnce(id, j)
+		}
+
+		s.mu.Lock()
+		j.nextRun = j.cron.Next(s.now())
+		fires := s.fires
+		s.mu.Unlock()
+
+		if fires != nil {
+			if err := fires.SetLastFired(id, now); err != nil {
+				slog.Warn("scheduler: failed to record the fire time", "plugin", id, "err", err)
+			}
+		}
+	}
+}
+
+func (s *Scheduler) runOnce(id int, j *job) {
+	var result string
+	row, err := s.store.GetByID(id)
+	if err == nil {
+		var res *plugin.Result
+		res, err = s.runner.Execute(j.ctx, plugin.NewRequest(row, plugin.TriggerSchedule))
+		result = res.Output
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j.lastRun = s.now()
+	if err != nil {
+		j.lastError = fmt.Sprint(err)
+		return
+	}
+	j.lastResult = result
+	j.lastError = ""
+}
+
+func (j *job) status(id int) Status {
+	status := Status{
+		PluginID:        id,
+		Running:         true,
+		IntervalSeconds: int(j.schedule.interval / time.Second),
+		Cron:            j.schedule.cron,
+		TimeZone:        j.schedule.timeZone,
+		MissedRunPolicy: j.schedule.missed,
+		MissedRuns:      j.missedRuns,
+		LastResult:      j.lastResult,
+		LastError:       j.lastError,
+	}
+	if !j.lastRun.IsZero() {
+		lastRun := j.lastRun
+		status.LastRun = &lastRun
+	}
+	if !j.nextRun.IsZero() {
+		nextRun := j.nextRun
+		status.NextRun = &nextRun
+	}
+	return status
+}
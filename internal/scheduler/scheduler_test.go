@@ -0,0 +1,418 @@
+package scheduler
+
+import (
+	"bundeck/internal/db"
+	"bundeck/internal/plugin"
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockStore struct {
+	mu      sync.Mutex
+	plugins map[int]*db.Plugin
+}
+
+func (m *mockStore) GetAll() ([]db.Plugin, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	plugins := make([]db.Plugin, 0, len(m.plugins))
+	for _, plugin := range m.plugins {
+		plugins = append(plugins, *plugin)
+	}
+	return plugins, nil
+}
+
+func (m *mockStore) GetByID(id int) (*db.Plugin, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	plugin, ok := m.plugins[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *plugin
+	return &copied, nil
+}
+
+func (m *mockStore) set(plugin *db.Plugin) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.plugins[plugin.ID] = plugin
+}
+
+func (m *mockStore) remove(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.plugins, id)
+}
+
+type mockRunner struct {
+	mu   sync.Mutex
+	runs int
+}
+
+func (m *mockRunner) Execute(ctx context.Context, req plugin.Request) (*plugin.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs++
+	return &plugin.Result{Status: plugin.StatusSuccess, Output: "ran " + req.Code}, nil
+}
+
+func (m *mockRunner) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.runs
+}
+
+func setupScheduler() (*Scheduler, *mockStore, *mockRunner) {
+	store := &mockStore{plugins: map[int]*db.Plugin{
+		1: {ID: 1, Code: "a", RunContinuously: true, IntervalSeconds: 60},
+		2: {ID: 2, Code: "b"},
+	}}
+	runner := &mockRunner{}
+	return New(store, runner), store, runner
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestScheduler_StartStop(t *testing.T) {
+	s, _, runner := setupScheduler()
+	defer s.StopAll()
+
+	if err := s.Start(1); err != nil {
+		t.Fatalf("Failed to start plugin: %v", err)
+	}
+
+	// The first run happens immediately
+	waitFor(t, func() bool { return s.Status(1).LastRun != nil })
+
+	status := s.Status(1)
+	if !status.Running {
+		t.Error("Expected plugin to be running")
+	}
+	if status.LastResult != "ran a" {
+		t.Errorf("Expected last result %q, got %q", "ran a", status.LastResult)
+	}
+	if status.IntervalSeconds != 60 {
+		t.Errorf("Expected interval 60, got %d", status.IntervalSeconds)
+	}
+
+	// Starting again must not create a second job
+	if err := s.Start(1); err != nil {
+		t.Fatalf("Failed to restart plugin: %v", err)
+	}
+	if len(s.StatusAll()) != 1 {
+		t.Errorf("Expected 1 running plugin, got %d", len(s.StatusAll()))
+	}
+
+	if err := s.Stop(1); err != nil {
+		t.Fatalf("Failed to stop plugin: %v", err)
+	}
+	if s.Status(1).Running {
+		t.Error("Expected plugin to be stopped")
+	}
+	if runner.count() != 1 {
+		t.Errorf("Expected 1 run, got %d", runner.count())
+	}
+}
+
+func TestScheduler_StartErrors(t *testing.T) {
+	s, _, _ := setupScheduler()
+
+	if err := s.Start(2); err != ErrNotSchedulable {
+		t.Errorf("Expected ErrNotSchedulable, got %v", err)
+	}
+	if err := s.Start(99); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestScheduler_Reload(t *testing.T) {
+	s, store, _ := setupScheduler()
+	defer s.StopAll()
+
+	t.Run("Interval change", func(t *testing.T) {
+		if err := s.Start(1); err != nil {
+			t.Fatalf("Failed to start plugin: %v", err)
+		}
+
+		store.set(&db.Plugin{ID: 1, Code: "a", RunContinuously: true, IntervalSeconds: 30})
+		s.Reload(1)
+
+		if got := s.Status(1).IntervalSeconds; got != 30 {
+			t.Errorf("Expected interval 30 after reload, got %d", got)
+		}
+	})
+
+	t.Run("Continuous disabled", func(t *testing.T) {
+		store.set(&db.Plugin{ID: 1, Code: "a"})
+		s.Reload(1)
+
+		if s.Status(1).Running {
+			t.Error("Expected plugin to be stopped after disabling continuous runs")
+		}
+	})
+
+	t.Run("Deleted", func(t *testing.T) {
+		store.set(&db.Plugin{ID: 1, Code: "a", RunContinuously: true, IntervalSeconds: 60})
+		if err := s.Start(1); err != nil {
+			t.Fatalf("Failed to start plugin: %v", err)
+		}
+
+		store.remove(1)
+		s.Reload(1)
+
+		if s.Status(1).Running {
+			t.Error("Expected plugin to be stopped after deletion")
+		}
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		store.set(&db.Plugin{ID: 3, Code: "c", RunContinuously: true, IntervalSeconds: 60})
+		s.Reload(3)
+
+		if !s.Status(3).Running {
+			t.Error("Expected plugin to be started after enabling continuous runs")
+		}
+	})
+
+	t.Run("Not continuous", func(t *testing.T) {
+		s.Reload(2)
+
+		if s.Status(2).Running {
+			t.Error("Reload must not start a plugin that does not run continuously")
+		}
+	})
+}
+
+func TestScheduler_StartAll(t *testing.T) {
+	s, store, runner := setupScheduler()
+	defer s.StopAll()
+	store.set(&db.Plugin{ID: 3, Code: "c", RunContinuously: true, CronExpression: "0 25 * * *"})
+
+	if err := s.StartAll(); err != nil {
+		t.Fatalf("Failed to start plugins: %v", err)
+	}
+
+	// Plugins running continuously run without an explicit Start
+	waitFor(t, func() bool { return runner.count() == 1 })
+	if !s.Status(1).Running {
+		t.Error("Expected plugin 1 to be running")
+	}
+	if s.Status(2).Running || s.Status(3).Running {
+		t.Error("Expected plugins without a valid schedule to be left alone")
+	}
+}
+
+// overlapRunner takes a while for every run and records how many ran at
+// the same time
+type overlapRunner struct {
+	mu     sync.Mutex
+	active int
+	max    int
+	runs   int
+}
+
+func (r *overlapRunner) Execute(ctx context.Context, req plugin.Request) (*plugin.Result, error) {
+	r.mu.Lock()
+	r.active++
+	r.runs++
+	r.max = max(r.max, r.active)
+	r.mu.Unlock()
+
+	// Ignores cancellation like a plugin that is slow to exit
+	time.Sleep(50 * time.Millisecond)
+
+	r.mu.Lock()
+	r.active--
+	r.mu.Unlock()
+	return &plugin.Result{Status: plugin.StatusSuccess}, nil
+}
+
+func TestScheduler_ReloadWaitsForRun(t *testing.T) {
+	store := &mockStore{plugins: map[int]*db.Plugin{
+		1: {ID: 1, Code: "a", RunContinuously: true, IntervalSeconds: 60},
+	}}
+	runner := &overlapRunner{}
+	s := New(store, runner)
+	defer s.StopAll()
+
+	if err := s.Start(1); err != nil {
+		t.Fatalf("Failed to start plugin: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	store.set(&db.Plugin{ID: 1, Code: "a", RunContinuously: true, IntervalSeconds: 30})
+	s.Reload(1)
+
+	waitFor(t, func() bool {
+		runner.mu.Lock()
+		defer runner.mu.Unlock()
+		return runner.runs == 2 && runner.active == 0
+	})
+	if runner.max != 1 {
+		t.Errorf("Expected the new job to wait for the old run, %d ran at once", runner.max)
+	}
+}
+
+// mockFires keeps fire times in memory
+type mockFires struct {
+	mu    sync.Mutex
+	fired map[int]time.Time
+}
+
+func (m *mockFires) LastFired(pluginID int) (time.Time, error) {
+	return m.get(pluginID), nil
+}
+
+func (m *mockFires) SetLastFired(pluginID int, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fired[pluginID] = t
+	return nil
+}
+
+func (m *mockFires) ClearLastFired(pluginID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.fired, pluginID)
+	return nil
+}
+
+func (m *mockFires) get(pluginID int) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fired[pluginID]
+}
+
+// fakeClock is a wall clock that only moves when told to
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+func TestScheduler_Cron(t *testing.T) {
+	defer func(poll time.Duration) { cronPoll = poll }(cronPoll)
+	cronPoll = 5 * time.Millisecond
+
+	start := time.Date(2025, time.March, 12, 8, 59, 30, 0, time.UTC)
+	setup := func(t *testing.T, missed string) (*Scheduler, *mockRunner, *fakeClock) {
+		t.Helper()
+		s, store, runner := setupScheduler()
+		clock := &fakeClock{now: start}
+		s.now = clock.Now
+		store.set(&db.Plugin{ID: 1, Code: "a", RunContinuously: true, IntervalSeconds: 60, CronExpression: "0 9 * * *", TimeZone: "UTC", MissedRunPolicy: missed})
+		if err := s.Start(1); err != nil {
+			t.Fatalf("Failed to start plugin: %v", err)
+		}
+		return s, runner, clock
+	}
+
+	t.Run("Runs At The Next Time", func(t *testing.T) {
+		s, runner, clock := setup(t, MissedSkip)
+		defer s.StopAll()
+
+		status := s.Status(1)
+		if status.Cron != "0 9 * * *" || status.NextRun == nil || !status.NextRun.Equal(start.Add(30*time.Second)) {
+			t.Fatalf("Expected the next run at 09:00 before the first run, got %+v", status)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if runner.count() != 0 {
+			t.Fatalf("Expected no run before 09:00, got %d", runner.count())
+		}
+
+		clock.Set(start.Add(40 * time.Second))
+		waitFor(t, func() bool { return runner.count() == 1 })
+		waitFor(t, func() bool {
+			next := s.Status(1).NextRun
+			return next != nil && next.Equal(start.Add(24*time.Hour+30*time.Second))
+		})
+	})
+
+	t.Run("Skips Missed Runs", func(t *testing.T) {
+		s, runner, clock := setup(t, MissedSkip)
+		defer s.StopAll()
+
+		// The machine wakes up after four runs were due
+		clock.Set(start.Add(3*24*time.Hour + time.Hour))
+		waitFor(t, func() bool { return s.Status(1).MissedRuns == 4 })
+		time.Sleep(20 * time.Millisecond)
+		if runner.count() != 0 {
+			t.Errorf("Expected missed runs to be skipped, got %d runs", runner.count())
+		}
+	})
+
+	t.Run("Runs Once For Missed Runs", func(t *testing.T) {
+		s, runner, clock := setup(t, MissedRunOnce)
+		defer s.StopAll()
+
+		clock.Set(start.Add(3*24*time.Hour + time.Hour))
+		waitFor(t, func() bool { return runner.count() == 1 })
+		time.Sleep(20 * time.Millisecond)
+		if runner.count() != 1 {
+			t.Errorf("Expected a single run for the missed runs, got %d", runner.count())
+		}
+		if next := s.Status(1).NextRun; next == nil || !next.Equal(start.Add(4*24*time.Hour+30*time.Second)) {
+			t.Errorf("Expected the next run on the following day, got %v", next)
+		}
+	})
+
+	t.Run("Runs Once For Runs Missed While Not Running", func(t *testing.T) {
+		s, store, runner := setupScheduler()
+		defer s.StopAll()
+		clock := &fakeClock{now: start}
+		s.now = clock.Now
+		fires := &mockFires{fired: map[int]time.Time{1: start.Add(-3*24*time.Hour + 30*time.Second)}}
+		s.SetFireStore(fires)
+		store.set(&db.Plugin{ID: 1, Code: "a", RunContinuously: true, CronExpression: "0 9 * * *", TimeZone: "UTC", MissedRunPolicy: MissedRunOnce})
+
+		// BunDeck starts again after the schedule last fired three days ago
+		if err := s.StartAll(); err != nil {
+			t.Fatalf("Failed to start plugins: %v", err)
+		}
+		waitFor(t, func() bool { return runner.count() == 1 })
+		waitFor(t, func() bool { return fires.get(1).Equal(start) })
+
+		// Stopping forgets the fire time, runs due while stopped are not missed
+		if err := s.Stop(1); err != nil {
+			t.Fatalf("Failed to stop plugin: %v", err)
+		}
+		if !fires.get(1).IsZero() {
+			t.Errorf("Expected the fire time to be cleared, got %v", fires.get(1))
+		}
+	})
+
+	t.Run("Invalid Expression", func(t *testing.T) {
+		s, store, _ := setupScheduler()
+		store.set(&db.Plugin{ID: 1, Code: "a", RunContinuously: true, CronExpression: "0 25 * * *"})
+		if err := s.Start(1); !errors.Is(err, ErrNotSchedulable) {
+			t.Errorf("Expected ErrNotSchedulable, got %v", err)
+		}
+	})
+}
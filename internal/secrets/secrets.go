@@ -0,0 +1,266 @@
+package secrets
+
+import (
+	"bundeck/internal/db"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// KeySize is the length of the local encryption key, AES-256
+const KeySize = 32
+
+// ErrInvalidName is returned for names that cannot be used as environment variables
+var ErrInvalidName = errors.New("secret names must start with a letter or underscore and contain only letters, digits and underscores")
+
+// ErrReservedName is returned for names of environment variables that change
+// how BunDeck or the runtimes behave
+var ErrReservedName = errors.New("secret name is reserved for the environment of plugin processes")
+
+// ErrUnknownSecret is returned when a plugin is granted a secret that does
+// not exist
+var ErrUnknownSecret = errors.New("secret does not exist")
+
+// reservedNames are environment variables that decide which programs and
+// code the runtimes load, a secret must not replace them
+var reservedNames = map[string]bool{
+	"PATH": true, "PATHEXT": true, "HOME": true, "USERPROFILE": true, "SHELL": true,
+	"TMPDIR": true, "TEMP": true, "TMP": true, "IFS": true, "ENV": true, "BASH_ENV": true,
+	"COMSPEC": true, "SYSTEMROOT": true,
+	"NODE_OPTIONS": true, "NODE_PATH": true, "NODE_EXTRA_CA_CERTS": true, "NODE_TLS_REJECT_UNAUTHORIZED": true,
+	"PYTHONPATH": true, "PYTHONHOME": true, "PYTHONSTARTUP": true,
+}
+
+// reservedPrefixes cover the dynamic loader and the variables of Bun and
+// BunDeck itself
+var reservedPrefixes = []string{"LD_", "DYLD_", "BUN_", "BUNDECK_"}
+
+// Info describes a secret without its value
+type Info struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store persists encrypted secrets
+type Store interface {
+	List() ([]db.Secret, error)
+	ListForPlugin(pluginID int) ([]db.Secret, error)
+	Put(name string, value []byte) error
+	Grant(pluginID int, name string) error
+	SetPluginSecrets(pluginID int, names []string) error
+	Delete(name string) error
+}
+
+// LoadKey reads the encryption key from path, creating a new random key
+// readable only by the current user when the file does not exist
+func LoadKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("secret key %s must be %d bytes, got %d", path, KeySize, len(key))
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read secret key: %w", err)
+	}
+
+	key = make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate secret key: %w", err)
+	}
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write secret key: %w", err)
+	}
+	return key, nil
+}
+
+// Manager encrypts secrets at rest and resolves the secrets granted to a
+// plugin into environment variables
+type Manager struct {
+	store Store
+	aead  cipher.AEAD
+}
+
+func NewManager(store Store, key []byte) (*Manager, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{store: store, aead: aead}, nil
+}
+
+// List returns the names of all secrets
+func (m *Manager) List() ([]Info, error) {
+	rows, err := m.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]Info, len(rows))
+	for i, row := range rows {
+		infos[i] = Info{
+			Name:      row.Name,
+			CreatedAt: row.CreatedAt,
+			UpdatedAt: row.UpdatedAt,
+		}
+	}
+	return infos, nil
+}
+
+// Set encrypts and stores a secret, replacing any previous value
+func (m *Manager) Set(name string, value string) error {
+	if reserved(name) {
+		return ErrReservedName
+	}
+	if !ValidName(name) {
+		return ErrInvalidName
+	}
+
+	// The name is authenticated so values cannot be swapped between secrets
+	sealed, err := m.Seal(name, []byte(value))
+	if err != nil {
+		return err
+	}
+	return m.store.Put(name, sealed)
+}
+
+// Seal encrypts a value with the local key for storage elsewhere, e.g. the
+// signing secrets of webhooks. The label must be passed to Open again.
+func (m *Manager) Seal(label string, value []byte) ([]byte, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return m.aead.Seal(nonce, nonce, value, []byte(label)), nil
+}
+
+// Open decrypts a value encrypted by Seal under the same label
+func (m *Manager) Open(label string, sealed []byte) ([]byte, error) {
+	size := m.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, fmt.Errorf("sealed value is corrupt")
+	}
+	return m.aead.Open(nil, sealed[:size], sealed[size:], []byte(label))
+}
+
+// SetForPlugin stores a secret like Set and grants it to the plugin
+func (m *Manager) SetForPlugin(pluginID int, name string, value string) error {
+	if err := m.Set(name, value); err != nil {
+		return err
+	}
+	return m.store.Grant(pluginID, name)
+}
+
+// Delete removes a secret, returning sql.ErrNoRows when it does not exist
+func (m *Manager) Delete(name string) error {
+	return m.store.Delete(name)
+}
+
+// PluginSecrets returns the names of the secrets granted to a plugin
+func (m *Manager) PluginSecrets(pluginID int) ([]string, error) {
+	rows, err := m.store.ListForPlugin(pluginID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(rows))
+	for i, row := range rows {
+		names[i] = row.Name
+	}
+	return names, nil
+}
+
+// SetPluginSecrets replaces the secrets granted to a plugin, returning
+// ErrUnknownSecret when one of them does not exist
+func (m *Manager) SetPluginSecrets(pluginID int, names []string) error {
+	rows, err := m.store.List()
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		exists[row.Name] = true
+	}
+	for _, name := range names {
+		if !exists[name] {
+			return fmt.Errorf("%w: %s", ErrUnknownSecret, name)
+		}
+	}
+	return m.store.SetPluginSecrets(pluginID, names)
+}
+
+// Env returns NAME=value pairs for every secret granted to the plugin
+func (m *Manager) Env(pluginID int) ([]string, error) {
+	rows, err := m.store.ListForPlugin(pluginID)
+	if err != nil {
+		return nil, err
+	}
+
+	var env []string
+	for _, row := range rows {
+		if !ValidName(row.Name) {
+			// Stored before the name was reserved
+			continue
+		}
+		value, err := m.decrypt(row)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, row.Name+"="+value)
+	}
+	return env, nil
+}
+
+func (m *Manager) decrypt(row db.Secret) (string, error) {
+	if len(row.Value) < m.aead.NonceSize() {
+		return "", fmt.Errorf("secret %s is corrupt", row.Name)
+	}
+	value, err := m.Open(row.Name, row.Value)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret %s: %w", row.Name, err)
+	}
+	return string(value), nil
+}
+
+// ValidName reports whether name can be used as an environment variable
+// and is not reserved
+func ValidName(name string) bool {
+	if name == "" || len(name) > 128 || reserved(name) {
+		return false
+	}
+	for i, r := range name {
+		if !isIdentRune(r) || (i == 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// reserved reports whether name is an environment variable secrets must not
+// replace. Windows ignores the case of environment variables.
+func reserved(name string) bool {
+	name = strings.ToUpper(name)
+	if reservedNames[name] {
+		return true
+	}
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
@@ -0,0 +1,225 @@
+package secrets
+
+import (
+	"bundeck/internal/db"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+type memoryStore struct {
+	rows   map[string][]byte
+	grants map[int][]string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{rows: make(map[string][]byte), grants: make(map[int][]string)}
+}
+
+func (m *memoryStore) List() ([]db.Secret, error) {
+	var list []db.Secret
+	for name, value := range m.rows {
+		list = append(list, db.Secret{Name: name, Value: value, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (m *memoryStore) ListForPlugin(pluginID int) ([]db.Secret, error) {
+	var list []db.Secret
+	for _, name := range m.grants[pluginID] {
+		list = append(list, db.Secret{Name: name, Value: m.rows[name], CreatedAt: time.Now(), UpdatedAt: time.Now()})
+	}
+	return list, nil
+}
+
+func (m *memoryStore) Put(name string, value []byte) error {
+	m.rows[name] = value
+	return nil
+}
+
+func (m *memoryStore) Grant(pluginID int, name string) error {
+	if !slices.Contains(m.grants[pluginID], name) {
+		m.grants[pluginID] = append(m.grants[pluginID], name)
+	}
+	return nil
+}
+
+func (m *memoryStore) SetPluginSecrets(pluginID int, names []string) error {
+	m.grants[pluginID] = names
+	return nil
+}
+
+func (m *memoryStore) Delete(name string) error {
+	if _, ok := m.rows[name]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.rows, name)
+	return nil
+}
+
+func newTestManager(t *testing.T) (*Manager, *memoryStore) {
+	store := newMemoryStore()
+	manager, err := NewManager(store, make([]byte, KeySize))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	return manager, store
+}
+
+func TestManager_Env(t *testing.T) {
+	manager, store := newTestManager(t)
+
+	if err := manager.Set("OBS_PASSWORD", "hunter2"); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	if err := manager.Set("OBS", "unused"); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+
+	if string(store.rows["OBS_PASSWORD"]) == "hunter2" {
+		t.Error("Secret stored in plain text")
+	}
+
+	if err := manager.SetPluginSecrets(1, []string{"OBS_PASSWORD"}); err != nil {
+		t.Fatalf("Failed to grant secret: %v", err)
+	}
+	env, err := manager.Env(1)
+	if err != nil {
+		t.Fatalf("Failed to resolve env: %v", err)
+	}
+	// Only granted secrets are injected
+	if len(env) != 1 || env[0] != "OBS_PASSWORD=hunter2" {
+		t.Errorf("Expected only OBS_PASSWORD, got %v", env)
+	}
+	if env, _ := manager.Env(2); len(env) != 0 {
+		t.Errorf("Expected no secrets for a plugin without grants, got %v", env)
+	}
+	if names, _ := manager.PluginSecrets(1); len(names) != 1 || names[0] != "OBS_PASSWORD" {
+		t.Errorf("Expected OBS_PASSWORD granted, got %v", names)
+	}
+
+	if err := manager.SetPluginSecrets(1, []string{"MISSING"}); !errors.Is(err, ErrUnknownSecret) {
+		t.Errorf("Expected ErrUnknownSecret, got %v", err)
+	}
+	if err := manager.SetForPlugin(2, "OBS_2_PASSWORD", "letmein"); err != nil {
+		t.Fatalf("Failed to set secret for plugin: %v", err)
+	}
+	if env, _ := manager.Env(2); len(env) != 1 || env[0] != "OBS_2_PASSWORD=letmein" {
+		t.Errorf("Expected the plugin's own secret, got %v", env)
+	}
+
+	list, err := manager.List()
+	if err != nil {
+		t.Fatalf("Failed to list secrets: %v", err)
+	}
+	if len(list) != 3 {
+		t.Errorf("Expected 3 secrets, got %d", len(list))
+	}
+}
+
+func TestManager_Set(t *testing.T) {
+	manager, _ := newTestManager(t)
+
+	for _, name := range []string{"", "1TOKEN", "MY-TOKEN", "TOKEN VALUE"} {
+		if err := manager.Set(name, "x"); err != ErrInvalidName {
+			t.Errorf("Set(%q): expected ErrInvalidName, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"PATH", "Path", "LD_PRELOAD", "DYLD_INSERT_LIBRARIES", "NODE_OPTIONS", "BUN_INSTALL", "BUNDECK_INPUT"} {
+		if err := manager.Set(name, "x"); err != ErrReservedName {
+			t.Errorf("Set(%q): expected ErrReservedName, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"TOKEN", "_token", "Token2", "NODE_RED_TOKEN"} {
+		if err := manager.Set(name, "x"); err != nil {
+			t.Errorf("Set(%q): unexpected error %v", name, err)
+		}
+	}
+}
+
+func TestManager_Tampered(t *testing.T) {
+	manager, store := newTestManager(t)
+
+	if err := manager.Set("TOKEN", "value"); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	if err := manager.Set("OTHER", "other"); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+
+	store.grants[1] = []string{"TOKEN"}
+
+	// A value moved to another name must not decrypt
+	store.rows["TOKEN"] = store.rows["OTHER"]
+	if _, err := manager.Env(1); err == nil {
+		t.Error("Expected error decrypting swapped secret")
+	}
+
+	store.rows["TOKEN"] = []byte("short")
+	if _, err := manager.Env(1); err == nil {
+		t.Error("Expected error decrypting corrupt secret")
+	}
+}
+
+func TestManager_Seal(t *testing.T) {
+	manager, _ := newTestManager(t)
+
+	sealed, err := manager.Seal("webhook:1", []byte("signing secret"))
+	if err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+	value, err := manager.Open("webhook:1", sealed)
+	if err != nil || string(value) != "signing secret" {
+		t.Errorf("Expected the sealed value back, got %q and %v", value, err)
+	}
+	if _, err := manager.Open("webhook:2", sealed); err == nil {
+		t.Error("Expected error opening with another label")
+	}
+	if _, err := manager.Open("webhook:1", []byte("short")); err == nil {
+		t.Error("Expected error opening a corrupt value")
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.key")
+
+	key, err := LoadKey(path)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	if len(key) != KeySize {
+		t.Errorf("Expected %d byte key, got %d", KeySize, len(key))
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat key: %v", err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("Expected key mode 0600, got %v", info.Mode().Perm())
+		}
+	}
+
+	again, err := LoadKey(path)
+	if err != nil {
+		t.Fatalf("Failed to reload key: %v", err)
+	}
+	if string(again) != string(key) {
+		t.Error("Expected reloaded key to match")
+	}
+
+	if err := os.WriteFile(path, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKey(path); err == nil {
+		t.Error("Expected error for key of the wrong size")
+	}
+}
@@ -0,0 +1,336 @@
+package settings
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Files kept in the data directory
+const (
+	SettingsFile = "settings.json"
+	DatabaseFile = "plugins.db"
+	KeyFile      = "secrets.key"
+)
+
+// EnvPrefix starts the environment variables overriding settings, e.g.
+// BUNDECK_PORT for port
+const EnvPrefix = "BUNDECK_"
+
+// Sources of the effective value of a setting, from lowest to highest
+// precedence. SourceWorkingDir is only used for the data directory.
+const (
+	SourceDefault    = "default"
+	SourceWorkingDir = "working_dir"
+	SourceFile       = "file"
+	SourceEnv        = "env"
+	SourceFlag       = "flag"
+)
+
+// DataDirKey names the data directory in Config.Sources, as a flag
+// (--data-dir) and in the environment (BUNDECK_DATA_DIR)
+const DataDirKey = "data_dir"
+
+// Config is the effective configuration: the settings file in the data
+// directory with the environment and flags applied on top
+type Config struct {
+	DataDir      string `json:"data_dir"`
+	SettingsPath string `json:"settings_path"`
+	DatabasePath string `json:"database_path"`
+	KeyPath      string `json:"key_path"`
+	// Settings holds the effective values, the settings file only its own
+	Settings *Settings `json:"settings"`
+	// Sources names where the data directory and each setting came from, by
+	// the setting's key in the settings file
+	Sources map[string]string `json:"sources"`
+
+	// file holds the settings file, or the defaults if it failed to load
+	// with fileErr, and flags the flags set on the command line by name
+	file    *Settings
+	fileErr error
+	flags   map[string]string
+}
+
+// FileError is the *FileError the settings file failed to load with, the
+// defaults are used instead of its settings
+func (c *Config) FileError() error {
+	return c.fileErr
+}
+
+// option is a setting that can be overridden by a flag and an environment
+// variable
+type option struct {
+	key     string
+	usage   string
+	boolean bool
+	set     func(s *Settings, value string) error
+}
+
+var options = []option{
+	{"bind_address", "IP address or host name the server listens on", false, func(s *Settings, value string) error {
+		s.BindAddress = value
+		return nil
+	}},
+	{"port", "port the server listens on", false, func(s *Settings, value string) error {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %q", value)
+		}
+		s.Port = port
+		return nil
+	}},
+	{"tls_cert_file", "certificate file to serve HTTPS with", false, func(s *Settings, value string) error {
+		s.TLSCertFile = value
+		return nil
+	}},
+	{"tls_key_file", "private key file to serve HTTPS with", false, func(s *Settings, value string) error {
+		s.TLSKeyFile = value
+		return nil
+	}},
+	{"bun_path", "Bun executable to run plugins with", false, func(s *Settings, value string) error {
+		s.BunPath = value
+		return nil
+	}},
+	{"default_timeout_seconds", "timeout of plugins without their own", false, func(s *Settings, value string) error {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 {
+			return fmt.Errorf("invalid number of seconds %q", value)
+		}
+		s.DefaultTimeoutSeconds = seconds
+		return nil
+	}},
+	{"max_concurrent_runs", "how many plugins may run at the same time", false, func(s *Settings, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid number of concurrent runs %q", value)
+		}
+		s.MaxConcurrentRuns = n
+		return nil
+	}},
+	{"template_dirs", "template directories separated by " + string(os.PathListSeparator), false, func(s *Settings, value string) error {
+		s.TemplateDirs = filepath.SplitList(value)
+		return nil
+	}},
+	{"log_level", "least severe messages logged: debug, info, warn or error", false, func(s *Settings, value string) error {
+		switch value {
+		case LogDebug, LogInfo, LogWarn, LogError:
+			s.LogLevel = value
+			return nil
+		}
+		return fmt.Errorf("invalid log level %q", value)
+	}},
+	{"headless", "run the server without the system tray until interrupted", true, func(s *Settings, value string) error {
+		headless, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		s.Headless = headless
+		return nil
+	}},
+	{"pid_file", "write the process ID to this file while running", false, func(s *Settings, value string) error {
+		s.PIDFile = value
+		return nil
+	}},
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(key)
+}
+
+// flagValue holds a flag until Load applies it, after checking it on parse
+type flagValue struct {
+	option option
+	value  string
+}
+
+func (v *flagValue) String() string {
+	return v.value
+}
+
+func (v *flagValue) Set(value string) error {
+	if err := v.option.set(&Settings{}, value); err != nil {
+		return err
+	}
+	v.value = value
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.option.boolean
+}
+
+// RegisterFlags adds --data-dir and a flag for every setting to fs, named
+// like the setting with dashes, e.g. --max-concurrent-runs
+func RegisterFlags(fs *flag.FlagSet) {
+	fs.String(flagName(DataDirKey), "", "directory holding the database, secrets key and settings (env "+envName(DataDirKey)+")")
+	for _, o := range options {
+		fs.Var(&flagValue{option: o}, flagName(o.key), o.usage+" (env "+envName(o.key)+")")
+	}
+}
+
+// UserDataDir is the default data directory: $XDG_DATA_HOME/bundeck or
+// ~/.local/share/bundeck on Linux and other Unix systems, BunDeck in the
+// application support directory on macOS and in %AppData% on Windows
+func UserDataDir() (string, error) {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "BunDeck"), nil
+	}
+
+	// Relative paths are invalid per the XDG specification and ignored
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "bundeck"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "bundeck"), nil
+}
+
+// dataDir picks the data directory from the flag, the environment, a deck
+// in the working directory created before data directories existed, or the
+// user's data directory
+func dataDir(flagged string) (string, string, error) {
+	dir, source := flagged, SourceFlag
+	if dir == "" {
+		dir, source = os.Getenv(envName(DataDirKey)), SourceEnv
+	}
+	if dir == "" {
+		if _, err := os.Stat(DatabaseFile); err == nil {
+			dir, source = ".", SourceWorkingDir
+		}
+	}
+	if dir == "" {
+		var err error
+		if dir, err = UserDataDir(); err != nil {
+			return "", "", fmt.Errorf("failed to find the data directory: %w", err)
+		}
+		source = SourceDefault
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	return dir, source, nil
+}
+
+// fileKeys returns the keys set in the settings file at path
+func fileKeys(path string) map[string]bool {
+	var fields map[string]json.RawMessage
+	data, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &fields) != nil {
+		return nil
+	}
+	keys := map[string]bool{}
+	for key := range fields {
+		keys[key] = true
+	}
+	return keys
+}
+
+// settingsKeys returns the key of every setting in the settings file
+func settingsKeys() []string {
+	var fields map[string]json.RawMessage
+	data, _ := json.Marshal(Defaults())
+	json.Unmarshal(data, &fields)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// effective applies the environment variables and flags to the settings
+// file, whose relative paths are relative to dir, and checks the result.
+// It also returns where each setting came from, inFile names the keys set
+// in the file.
+func effective(dir string, file *Settings, inFile map[string]bool, flags map[string]string) (*Settings, map[string]string, error) {
+	settings := file.Resolve(dir)
+	sources := map[string]string{}
+	for _, key := range settingsKeys() {
+		sources[key] = SourceDefault
+		if inFile[key] {
+			sources[key] = SourceFile
+		}
+	}
+
+	for _, o := range options {
+		if value := os.Getenv(envName(o.key)); value != "" {
+			if err := o.set(settings, value); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", envName(o.key), err)
+			}
+			sources[o.key] = SourceEnv
+		}
+		if value, ok := flags[flagName(o.key)]; ok {
+			if err := o.set(settings, value); err != nil {
+				return nil, nil, fmt.Errorf("--%s: %w", flagName(o.key), err)
+			}
+			sources[o.key] = SourceFlag
+		}
+	}
+
+	if err := settings.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return settings, sources, nil
+}
+
+// Load resolves the data directory, creating it if needed, reads the
+// settings file in it and applies the environment variables and the flags
+// set on fs, which may be nil. Relative paths in the settings file are
+// relative to the data directory. A settings file that fails to load does
+// not fail Load, the defaults are used instead and FileError reports it.
+func Load(fs *flag.FlagSet) (*Config, error) {
+	flags := map[string]string{}
+	if fs != nil {
+		fs.Visit(func(f *flag.Flag) {
+			flags[f.Name] = f.Value.String()
+		})
+	}
+
+	dir, source, err := dataDir(flags[flagName(DataDirKey)])
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the data directory: %w", err)
+	}
+
+	config := &Config{
+		DataDir:      dir,
+		SettingsPath: filepath.Join(dir, SettingsFile),
+		DatabasePath: filepath.Join(dir, DatabaseFile),
+		KeyPath:      filepath.Join(dir, KeyFile),
+		flags:        flags,
+	}
+
+	config.file, config.fileErr = LoadSettings(config.SettingsPath)
+	var inFile map[string]bool
+	if config.fileErr == nil {
+		inFile = fileKeys(config.SettingsPath)
+	}
+
+	config.Settings, config.Sources, err = effective(dir, config.file, inFile, flags)
+	if err != nil {
+		return nil, err
+	}
+	config.Sources[DataDirKey] = source
+
+	return config, nil
+}
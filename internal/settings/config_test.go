@@ -0,0 +1,121 @@
+package settings
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, SettingsFile), []byte(`{"version": 1, "port": 8080, "max_concurrent_runs": 4, "template_dirs": ["mine"]}`), 0666); err != nil {
+		t.Fatalf("Failed to write settings file: %v", err)
+	}
+	t.Setenv("BUNDECK_DATA_DIR", dir)
+	t.Setenv("BUNDECK_MAX_CONCURRENT_RUNS", "2")
+	t.Setenv("BUNDECK_HEADLESS", "true")
+
+	fs := flag.NewFlagSet("bundeck", flag.ContinueOnError)
+	RegisterFlags(fs)
+	if err := fs.Parse([]string{"--port", "9090", "--headless=false"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	config, err := Load(fs)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.DataDir != dir || config.DatabasePath != filepath.Join(dir, DatabaseFile) || config.Sources[DataDirKey] != SourceEnv {
+		t.Errorf("Expected the data directory from the environment, got %+v", config)
+	}
+
+	s := config.Settings
+	if s.Port != 9090 || s.MaxConcurrentRuns != 2 || s.Headless {
+		t.Errorf("Expected flags over the environment over the file, got %+v", s)
+	}
+	want := map[string]string{
+		"port":                SourceFlag,
+		"max_concurrent_runs": SourceEnv,
+		"headless":            SourceFlag,
+		"template_dirs":       SourceFile,
+		"pid_file":            SourceDefault,
+	}
+	for key, source := range want {
+		if config.Sources[key] != source {
+			t.Errorf("Expected %s from %s, got %s", key, source, config.Sources[key])
+		}
+	}
+	if len(s.TemplateDirs) != 1 || s.TemplateDirs[0] != filepath.Join(dir, "mine") {
+		t.Errorf("Expected template dirs relative to the data directory, got %v", s.TemplateDirs)
+	}
+
+	// Overrides are not written back to the settings file
+	if file, err := LoadSettings(config.SettingsPath); err != nil || file.Port != 8080 || file.TemplateDirs[0] != "mine" {
+		t.Errorf("Expected the settings file to keep its values, got %+v, %v", file, err)
+	}
+}
+
+func TestLoad_InvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, SettingsFile), []byte(`{"version": 1, "port": "8080"}`), 0666); err != nil {
+		t.Fatalf("Failed to write settings file: %v", err)
+	}
+	t.Setenv("BUNDECK_DATA_DIR", dir)
+	t.Setenv("BUNDECK_LOG_LEVEL", "debug")
+
+	config, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Expected an invalid settings file to fall back to the defaults, got %v", err)
+	}
+	if config.FileError() == nil {
+		t.Error("Expected the settings file error to be reported")
+	}
+	if config.Settings.Port != DefaultPort || config.Sources["port"] != SourceDefault {
+		t.Errorf("Expected the default port, got %d from %s", config.Settings.Port, config.Sources["port"])
+	}
+	if config.Settings.LogLevel != LogDebug || config.Sources["log_level"] != SourceEnv {
+		t.Errorf("Expected the log level from the environment, got %q from %s", config.Settings.LogLevel, config.Sources["log_level"])
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	t.Setenv("BUNDECK_DATA_DIR", t.TempDir())
+
+	fs := flag.NewFlagSet("bundeck", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	RegisterFlags(fs)
+	if err := fs.Parse([]string{"--port", "http"}); err == nil {
+		t.Error("Expected an invalid flag to be rejected")
+	}
+
+	t.Setenv("BUNDECK_PORT", "70000")
+	if _, err := Load(nil); err == nil {
+		t.Error("Expected an invalid environment variable to be rejected")
+	}
+
+	// Settings are checked together once all overrides are applied
+	t.Setenv("BUNDECK_PORT", "")
+	t.Setenv("BUNDECK_TLS_CERT_FILE", "cert.pem")
+	if _, err := Load(nil); err == nil {
+		t.Error("Expected a certificate without a key to be rejected")
+	}
+}
+
+func TestUserDataDir(t *testing.T) {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		t.Skip("XDG_DATA_HOME only applies to Linux and other Unix systems")
+	}
+	t.Setenv("XDG_DATA_HOME", "/xdg")
+	if dir, err := UserDataDir(); err != nil || dir != filepath.Join("/xdg", "bundeck") {
+		t.Errorf("Expected the XDG data directory, got %q, %v", dir, err)
+	}
+
+	t.Setenv("XDG_DATA_HOME", "relative")
+	t.Setenv("HOME", "/home/deck")
+	if dir, err := UserDataDir(); err != nil || dir != filepath.Join("/home/deck", ".local", "share", "bundeck") {
+		t.Errorf("Expected ~/.local/share/bundeck, got %q, %v", dir, err)
+	}
+}
@@ -0,0 +1,148 @@
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// restartKeys are the settings only read at startup, changing them takes
+// effect after a restart. All other settings apply right away.
+var restartKeys = []string{
+	"bind_address",
+	"port",
+	"tls_cert_file",
+	"tls_key_file",
+	"bun_path",
+	"template_dirs",
+	"headless",
+	"pid_file",
+}
+
+// State is the settings file with what the server makes of it
+type State struct {
+	// Settings are the values in the settings file, or the defaults if it
+	// failed to load
+	Settings *Settings `json:"settings"`
+	// Overridden names settings set by an environment variable or a flag,
+	// the settings file has no effect on them
+	Overridden []string `json:"overridden"`
+	// RestartRequired names settings that changed since startup and only
+	// take effect after a restart
+	RestartRequired []string `json:"restart_required"`
+	// Error is why the settings file failed to load, Backup the copy of it
+	// that was kept
+	Error  string `json:"error,omitempty"`
+	Backup string `json:"backup,omitempty"`
+}
+
+// Manager changes the settings file while the server runs and passes the
+// new effective settings to the services that apply them without a restart
+type Manager struct {
+	mu       sync.Mutex
+	config   *Config
+	started  *Settings
+	onChange []func(*Settings)
+}
+
+func NewManager(config *Config) *Manager {
+	return &Manager{
+		config:  config,
+		started: config.Settings,
+	}
+}
+
+// OnChange calls fn with the effective settings after every update, fn
+// must not call the Manager
+func (m *Manager) OnChange(fn func(*Settings)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onChange = append(m.onChange, fn)
+}
+
+// Config returns the effective configuration
+func (m *Manager) Config() *Config {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.config
+}
+
+// State returns the settings file and which settings are overridden or
+// wait for a restart
+func (m *Manager) State() *State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state()
+}
+
+func (m *Manager) state() *State {
+	state := &State{
+		Settings:        m.config.file,
+		Overridden:      []string{},
+		RestartRequired: []string{},
+	}
+	for key, source := range m.config.Sources {
+		if key != DataDirKey && (source == SourceEnv || source == SourceFlag) {
+			state.Overridden = append(state.Overridden, key)
+		}
+	}
+	sort.Strings(state.Overridden)
+
+	started, current := settingsValues(m.started), settingsValues(m.config.Settings)
+	for _, key := range restartKeys {
+		if string(started[key]) != string(current[key]) {
+			state.RestartRequired = append(state.RestartRequired, key)
+		}
+	}
+
+	if err := m.config.fileErr; err != nil {
+		state.Error = err.Error()
+		if fileErr, ok := err.(*FileError); ok {
+			state.Backup = fileErr.Backup
+		}
+	}
+	return state
+}
+
+// settingsValues returns the JSON value of every setting by its key
+func settingsValues(s *Settings) map[string]json.RawMessage {
+	var values map[string]json.RawMessage
+	data, _ := json.Marshal(s)
+	json.Unmarshal(data, &values)
+	return values
+}
+
+// Update validates s and writes it to the settings file, replacing a file
+// that failed to load. Environment variables and flags still take
+// precedence. An invalid s returns a *ValidationError.
+func (m *Manager) Update(s *Settings) (*State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	config := *m.config
+	if err := s.Resolve(config.DataDir).Validate(); err != nil {
+		return nil, err
+	}
+	inFile := map[string]bool{}
+	for _, key := range settingsKeys() {
+		inFile[key] = true
+	}
+	settings, sources, err := effective(config.DataDir, s, inFile, config.flags)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSettings(config.SettingsPath, s); err != nil {
+		return nil, fmt.Errorf("failed to write settings: %w", err)
+	}
+
+	sources[DataDirKey] = config.Sources[DataDirKey]
+	config.Settings, config.Sources = settings, sources
+	config.file, config.fileErr = s, nil
+	m.config = &config
+
+	for _, fn := range m.onChange {
+		fn(settings)
+	}
+	return m.state(), nil
+}
@@ -0,0 +1,92 @@
+package settings
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"testing"
+)
+
+func TestManager_Update(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("BUNDECK_DATA_DIR", dir)
+
+	fs := flag.NewFlagSet("bundeck", flag.ContinueOnError)
+	RegisterFlags(fs)
+	if err := fs.Parse([]string{"--max-concurrent-runs", "2"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	config, err := Load(fs)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	manager := NewManager(config)
+
+	var applied *Settings
+	manager.OnChange(func(s *Settings) { applied = s })
+
+	state := manager.State()
+	if len(state.Overridden) != 1 || state.Overridden[0] != "max_concurrent_runs" || len(state.RestartRequired) != 0 {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+
+	s := *state.Settings
+	s.Port = 8080
+	s.LogLevel = LogWarn
+	s.MaxConcurrentRuns = 4
+	state, err = manager.Update(&s)
+	if err != nil {
+		t.Fatalf("Failed to update settings: %v", err)
+	}
+	if len(state.RestartRequired) != 1 || state.RestartRequired[0] != "port" {
+		t.Errorf("Expected only the port to need a restart, got %v", state.RestartRequired)
+	}
+	if applied == nil || applied.LogLevel != LogWarn || applied.MaxConcurrentRuns != 2 {
+		t.Errorf("Expected the effective settings with the flag applied, got %+v", applied)
+	}
+	if config := manager.Config(); config.Settings.Port != 8080 || config.Sources["port"] != SourceFile {
+		t.Errorf("Expected the config to show the new port, got %d from %s", config.Settings.Port, config.Sources["port"])
+	}
+	if file, err := LoadSettings(config.SettingsPath); err != nil || file.Port != 8080 || file.MaxConcurrentRuns != 4 {
+		t.Errorf("Expected the settings to be written, got %+v, %v", file, err)
+	}
+
+	// Changing the port back needs no restart
+	s.Port = DefaultPort
+	if state, err = manager.Update(&s); err != nil || len(state.RestartRequired) != 0 {
+		t.Errorf("Expected no restart, got %+v, %v", state, err)
+	}
+
+	invalid := s
+	invalid.Port = 0
+	var validationErr *ValidationError
+	if _, err := manager.Update(&invalid); !errors.As(err, &validationErr) || validationErr.Fields["port"] == "" {
+		t.Errorf("Expected the port to be invalid, got %v", err)
+	}
+}
+
+func TestManager_InvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("BUNDECK_DATA_DIR", dir)
+	if err := os.WriteFile(dir+"/"+SettingsFile, []byte("{"), 0666); err != nil {
+		t.Fatalf("Failed to write settings file: %v", err)
+	}
+	config, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	manager := NewManager(config)
+
+	state := manager.State()
+	if state.Error == "" || state.Backup == "" {
+		t.Errorf("Expected the error and backup to be reported, got %+v", state)
+	}
+
+	// Saving the settings replaces the invalid file
+	if state, err = manager.Update(state.Settings); err != nil || state.Error != "" {
+		t.Errorf("Expected the error to be cleared, got %+v, %v", state, err)
+	}
+	if _, err := LoadSettings(config.SettingsPath); err != nil {
+		t.Errorf("Expected a valid settings file, got %v", err)
+	}
+}
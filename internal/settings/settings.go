@@ -1,62 +1,362 @@
 package settings
 
 import (
+	"bytes"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the version of the settings file written by this build.
+// Files without a version were written before settings were versioned and
+// are migrated on load.
+const SchemaVersion = 1
+
+// DefaultPort is the port the server listens on unless the settings change it
+const DefaultPort = 3004
+
+// DefaultMaxConcurrentRuns is used when the settings file does not set a
+// positive limit
+const DefaultMaxConcurrentRuns = 8
+
+// DefaultTemplateDir is the user template directory used when the settings
+// file does not list any
+const DefaultTemplateDir = "templates"
+
+// Log levels, from most to least verbose
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
 )
 
 type Settings struct {
-	Port int `json:"port"`
+	// Version is the schema version of the settings file
+	Version int `json:"version"`
+	// BindAddress is the IP address or host name the server listens on,
+	// 0.0.0.0 for every interface
+	BindAddress string `json:"bind_address"`
+	Port        int    `json:"port"`
+	// TLSCertFile and TLSKeyFile serve the deck over HTTPS when both are set.
+	// Relative paths are relative to the data directory.
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+	// BunPath is the Bun executable, empty looks for bun on the PATH
+	BunPath string `json:"bun_path"`
+	// DefaultTimeoutSeconds limits runs of plugins that do not configure
+	// their own timeout
+	DefaultTimeoutSeconds int `json:"default_timeout_seconds"`
+	// MaxConcurrentRuns limits how many plugins execute at the same time
+	MaxConcurrentRuns int `json:"max_concurrent_runs"`
+	// LogLevel is the least severe level logged: debug, info, warn or error
+	LogLevel string          `json:"log_level"`
+	History  HistorySettings `json:"history"`
+	Auth     AuthSettings    `json:"auth"`
+	// TemplateDirs are directories of plugin templates, each with its own
+	// list.json. Earlier directories take precedence over later ones and all
+	// of them over the built-in templates.
+	TemplateDirs []string `json:"template_dirs"`
+	// Headless runs the server without the system tray until the process is
+	// interrupted or terminated, e.g. on a server or in a container
+	Headless bool `json:"headless"`
+	// PIDFile is written with the process ID while the server runs, if set
+	PIDFile string `json:"pid_file"`
 }
 
-func LoadSettings() *Settings {
-	fi, err := os.Stat("settings.json")
-	if err != nil {
-		return defaultSettings()
+// HistorySettings control how much run history is kept
+type HistorySettings struct {
+	// MaxRunsPerPlugin keeps only the newest runs of each plugin, zero keeps all
+	MaxRunsPerPlugin int `json:"max_runs_per_plugin"`
+	// MaxAgeDays deletes older runs, zero keeps them forever
+	MaxAgeDays int `json:"max_age_days"`
+}
+
+// AuthSettings control how other devices use the deck
+type AuthSettings struct {
+	// PairingTTLSeconds is how long a pairing code can be used
+	PairingTTLSeconds int `json:"pairing_ttl_seconds"`
+	// RemoteAccess lets paired devices use the deck, without it only this
+	// machine can
+	RemoteAccess bool `json:"remote_access"`
+}
+
+// Defaults returns the settings used for anything the settings file does
+// not set
+func Defaults() *Settings {
+	return &Settings{
+		Version:               SchemaVersion,
+		BindAddress:           "0.0.0.0",
+		Port:                  DefaultPort,
+		DefaultTimeoutSeconds: 60,
+		MaxConcurrentRuns:     DefaultMaxConcurrentRuns,
+		LogLevel:              LogInfo,
+		History: HistorySettings{
+			MaxRunsPerPlugin: 100,
+			MaxAgeDays:       30,
+		},
+		Auth: AuthSettings{
+			PairingTTLSeconds: 300,
+			RemoteAccess:      true,
+		},
+		TemplateDirs: []string{DefaultTemplateDir},
+	}
+}
+
+// TLS reports whether the deck is served over HTTPS
+func (s *Settings) TLS() bool {
+	return s.TLSCertFile != "" && s.TLSKeyFile != ""
+}
+
+// DefaultTimeout is DefaultTimeoutSeconds as a duration
+func (s *Settings) DefaultTimeout() time.Duration {
+	return time.Duration(s.DefaultTimeoutSeconds) * time.Second
+}
+
+// MaxAge is History.MaxAgeDays as a duration
+func (h HistorySettings) MaxAge() time.Duration {
+	return time.Duration(h.MaxAgeDays) * 24 * time.Hour
+}
+
+// PairingTTL is Auth.PairingTTLSeconds as a duration
+func (a AuthSettings) PairingTTL() time.Duration {
+	return time.Duration(a.PairingTTLSeconds) * time.Second
+}
+
+// ValidationError lists the settings that failed validation with the
+// reason for each, by their key in the settings file
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	keys := make([]string, 0, len(e.Fields))
+	for key := range e.Fields {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	if !fi.Mode().IsRegular() {
-		return defaultSettings()
+	messages := make([]string, len(keys))
+	for i, key := range keys {
+		messages[i] = key + " " + e.Fields[key]
 	}
+	return "invalid settings: " + strings.Join(messages, "; ")
+}
 
-	var s *Settings
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?$`)
 
-	f, err := os.Open(fi.Name())
-	if err != nil {
-		return defaultSettings()
+// Validate checks every setting and returns a *ValidationError naming each
+// invalid one. Relative paths are checked against the working directory,
+// see Resolve.
+func (s *Settings) Validate() error {
+	fields := map[string]string{}
+	if s.Version != SchemaVersion {
+		fields["version"] = fmt.Sprintf("must be %d", SchemaVersion)
+	}
+	if net.ParseIP(s.BindAddress) == nil && !hostnamePattern.MatchString(s.BindAddress) {
+		fields["bind_address"] = "must be an IP address or host name"
+	}
+	if s.Port < 1 || s.Port > 65535 {
+		fields["port"] = "must be between 1 and 65535"
+	}
+	if (s.TLSCertFile == "") != (s.TLSKeyFile == "") {
+		fields["tls_cert_file"] = "must be set together with tls_key_file"
+	}
+	for key, path := range map[string]string{"tls_cert_file": s.TLSCertFile, "tls_key_file": s.TLSKeyFile} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			fields[key] = "must be an existing file"
+		}
+	}
+	if s.BunPath != "" {
+		if _, err := exec.LookPath(s.BunPath); err != nil {
+			fields["bun_path"] = "must be an executable"
+		}
+	}
+	if s.DefaultTimeoutSeconds < 1 || s.DefaultTimeoutSeconds > 86400 {
+		fields["default_timeout_seconds"] = "must be between 1 and 86400"
+	}
+	if s.MaxConcurrentRuns < 1 {
+		fields["max_concurrent_runs"] = "must be at least 1"
+	}
+	switch s.LogLevel {
+	case LogDebug, LogInfo, LogWarn, LogError:
+	default:
+		fields["log_level"] = "must be debug, info, warn or error"
+	}
+	if s.History.MaxRunsPerPlugin < 0 || s.History.MaxAgeDays < 0 {
+		fields["history"] = "must not keep a negative number of runs or days"
+	}
+	if s.Auth.PairingTTLSeconds < 30 || s.Auth.PairingTTLSeconds > 86400 {
+		fields["auth"] = "pairing_ttl_seconds must be between 30 and 86400"
+	}
+	for _, dir := range s.TemplateDirs {
+		if dir == "" {
+			fields["template_dirs"] = "must not contain empty paths"
+		}
 	}
 
-	b, err := io.ReadAll(f)
-	if err != nil {
-		return defaultSettings()
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
 	}
-	err = json.Unmarshal(b, &s)
-	if err != nil {
-		return defaultSettings()
+	return nil
+}
+
+// Resolve returns a copy with relative template directories and TLS files
+// made relative to dir
+func (s *Settings) Resolve(dir string) *Settings {
+	resolved := *s
+	path := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	resolved.TLSCertFile = path(s.TLSCertFile)
+	resolved.TLSKeyFile = path(s.TLSKeyFile)
+	resolved.TemplateDirs = nil
+	for _, templateDir := range s.TemplateDirs {
+		resolved.TemplateDirs = append(resolved.TemplateDirs, path(templateDir))
+	}
+	return &resolved
+}
+
+// FileError is returned for a settings file that could not be loaded. The
+// file is left as it is until the settings are saved again, Backup is a copy
+// of it if one could be made.
+type FileError struct {
+	Path   string
+	Backup string
+	Err    error
+}
+
+func (e *FileError) Error() string {
+	message := fmt.Sprintf("failed to load settings from %s: %v", e.Path, e.Err)
+	if e.Backup != "" {
+		message += ", a copy was kept at " + e.Backup
 	}
+	return message
+}
 
-	writeSettings(s)
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
 
-	return s
+// settingsV0 is the settings file written before settings were versioned
+type settingsV0 struct {
+	Port              int      `json:"port"`
+	MaxConcurrentRuns int      `json:"max_concurrent_runs"`
+	TemplateDirs      []string `json:"template_dirs"`
+	Headless          bool     `json:"headless"`
+	PIDFile           string   `json:"pid_file"`
 }
 
-func writeSettings(s *Settings) error {
-	j, err := json.MarshalIndent(&s, "", "\t")
+// LoadSettings reads the settings file at path, creating it with the
+// defaults when it is missing and migrating it when it was written by an
+// older version. A file that cannot be loaded is backed up and left alone,
+// the defaults are returned with a *FileError.
+func LoadSettings(path string) (*Settings, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		s := Defaults()
+		if err := writeSettings(path, s); err != nil {
+			return s, &FileError{Path: path, Err: err}
+		}
+		return s, nil
+	}
 	if err != nil {
-		return err
+		return Defaults(), &FileError{Path: path, Err: err}
 	}
 
-	err = os.WriteFile("settings.json", j, 0666)
-	return err
+	s, migrated, err := decodeSettings(data)
+	if err == nil {
+		err = s.Resolve(filepath.Dir(path)).Validate()
+	}
+	if err != nil {
+		backup, _ := backupSettings(path, data)
+		return Defaults(), &FileError{Path: path, Backup: backup, Err: err}
+	}
+
+	if migrated {
+		// The original is kept in case the migration dropped anything
+		if _, err := backupSettings(path, data); err != nil {
+			return s, &FileError{Path: path, Err: err}
+		}
+		if err := writeSettings(path, s); err != nil {
+			return s, &FileError{Path: path, Err: err}
+		}
+	}
+	return s, nil
+}
+
+// decodeSettings parses a settings file of any version, unset settings keep
+// their defaults. It reports whether the file had an older version.
+func decodeSettings(data []byte) (*Settings, bool, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, false, err
+	}
+
+	s := Defaults()
+	switch {
+	case header.Version == 0:
+		// Older versions ignored unknown settings and filled in missing ones
+		var old settingsV0
+		if err := json.Unmarshal(data, &old); err != nil {
+			return nil, false, err
+		}
+		if old.Port != 0 {
+			s.Port = old.Port
+		}
+		if old.MaxConcurrentRuns > 0 {
+			s.MaxConcurrentRuns = old.MaxConcurrentRuns
+		}
+		if old.TemplateDirs != nil {
+			s.TemplateDirs = old.TemplateDirs
+		}
+		s.Headless = old.Headless
+		s.PIDFile = old.PIDFile
+		return s, true, nil
+	case header.Version > SchemaVersion:
+		return nil, false, fmt.Errorf("version %d was written by a newer BunDeck, this one supports up to %d", header.Version, SchemaVersion)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(s); err != nil {
+		return nil, false, err
+	}
+	return s, false, nil
 }
 
-func defaultSettings() *Settings {
-	s := &Settings{
-		Port: 3004,
+// backupSettings copies the settings file next to it with the time in the
+// name and returns the copy's path
+func backupSettings(path string, data []byte) (string, error) {
+	backup := fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102-150405"))
+	if err := os.WriteFile(backup, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to back up settings: %w", err)
 	}
+	return backup, nil
+}
 
-	writeSettings(s)
+func writeSettings(path string, s *Settings) error {
+	j, err := json.MarshalIndent(&s, "", "\t")
+	if err != nil {
+		return err
+	}
 
-	return s
+	err = os.WriteFile(path, j, 0666)
+	return err
 }
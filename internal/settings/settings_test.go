@@ -2,7 +2,9 @@ package settings
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -13,12 +15,12 @@ func TestLoadSettings(t *testing.T) {
 
 	// Test loading default settings when file doesn't exist
 	t.Run("Default Settings", func(t *testing.T) {
-		settings := LoadSettings()
-		if settings == nil {
-			t.Fatal("Expected non-nil settings")
+		settings, err := LoadSettings("settings.json")
+		if err != nil {
+			t.Fatalf("Failed to load settings: %v", err)
 		}
-		if settings.Port != 3004 {
-			t.Errorf("Expected default port 3004, got %d", settings.Port)
+		if settings.Port != DefaultPort || settings.Version != SchemaVersion {
+			t.Errorf("Expected the defaults, got %+v", settings)
 		}
 
 		// Verify file was created
@@ -29,9 +31,10 @@ func TestLoadSettings(t *testing.T) {
 
 	// Test loading custom settings
 	t.Run("Custom Settings", func(t *testing.T) {
-		customSettings := &Settings{
-			Port: 8080,
-		}
+		customSettings := Defaults()
+		customSettings.Port = 8080
+		customSettings.LogLevel = LogDebug
+		customSettings.Auth.RemoteAccess = false
 
 		// Write custom settings to file
 		data, err := json.MarshalIndent(customSettings, "", "\t")
@@ -44,99 +47,165 @@ func TestLoadSettings(t *testing.T) {
 		}
 
 		// Load settings
-		settings := LoadSettings()
-		if settings == nil {
-			t.Fatal("Expected non-nil settings")
+		settings, err := LoadSettings("settings.json")
+		if err != nil {
+			t.Fatalf("Failed to load settings: %v", err)
 		}
-		if settings.Port != 8080 {
-			t.Errorf("Expected port 8080, got %d", settings.Port)
+		if settings.Port != 8080 || settings.LogLevel != LogDebug || settings.Auth.RemoteAccess {
+			t.Errorf("Expected the custom settings, got %+v", settings)
 		}
 	})
-}
-
-func TestLoadSettings_InvalidFile(t *testing.T) {
-	// Clean up any existing settings file
-	os.Remove("settings.json")
-	defer os.Remove("settings.json")
 
-	// Test loading with invalid JSON
-	t.Run("Invalid JSON", func(t *testing.T) {
-		if err := os.WriteFile("settings.json", []byte("invalid json"), 0666); err != nil {
-			t.Fatalf("Failed to write invalid settings file: %v", err)
+	// Test settings missing from the file keep their defaults
+	t.Run("Partial Settings", func(t *testing.T) {
+		if err := os.WriteFile("settings.json", []byte(`{"version": 1, "port": 8081}`), 0666); err != nil {
+			t.Fatalf("Failed to write settings file: %v", err)
 		}
 
-		settings := LoadSettings()
-		if settings == nil {
-			t.Fatal("Expected non-nil settings")
+		settings, err := LoadSettings("settings.json")
+		if err != nil {
+			t.Fatalf("Failed to load settings: %v", err)
 		}
-		if settings.Port != 3004 {
-			t.Errorf("Expected default port 3004, got %d", settings.Port)
+		if settings.Port != 8081 || settings.BindAddress != "0.0.0.0" || settings.History.MaxRunsPerPlugin != 100 {
+			t.Errorf("Expected missing settings to default, got %+v", settings)
 		}
 	})
+}
+
+func TestLoadSettings_Migrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SettingsFile)
+	if err := os.WriteFile(path, []byte(`{"port": 8080, "max_concurrent_runs": 0, "template_dirs": null, "headless": true}`), 0666); err != nil {
+		t.Fatalf("Failed to write settings file: %v", err)
+	}
+
+	settings, err := LoadSettings(path)
+	if err != nil {
+		t.Fatalf("Failed to load settings: %v", err)
+	}
+	if settings.Version != SchemaVersion || settings.Port != 8080 || !settings.Headless {
+		t.Errorf("Expected the old settings at version %d, got %+v", SchemaVersion, settings)
+	}
+	if settings.MaxConcurrentRuns != DefaultMaxConcurrentRuns {
+		t.Errorf("Expected missing max concurrent runs to default to %d, got %d", DefaultMaxConcurrentRuns, settings.MaxConcurrentRuns)
+	}
+	if len(settings.TemplateDirs) != 1 || settings.TemplateDirs[0] != DefaultTemplateDir {
+		t.Errorf("Expected missing template dirs to default to %q, got %v", DefaultTemplateDir, settings.TemplateDirs)
+	}
+
+	// The file is rewritten at the current version, the original kept
+	var written Settings
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &written); err != nil || written.Version != SchemaVersion || written.Port != 8080 {
+		t.Errorf("Expected the migrated settings to be written, got %s", data)
+	}
+	if backups, _ := filepath.Glob(path + ".*.bak"); len(backups) != 1 {
+		t.Errorf("Expected a backup of the old settings, got %v", backups)
+	}
+}
+
+func TestLoadSettings_InvalidFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"Invalid JSON", "invalid json"},
+		{"Unknown Setting", `{"version": 1, "prot": 8080}`},
+		{"Invalid Setting", `{"version": 1, "port": 70000, "log_level": "verbose"}`},
+		{"Newer Version", `{"version": 99}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), SettingsFile)
+			if err := os.WriteFile(path, []byte(tt.data), 0666); err != nil {
+				t.Fatalf("Failed to write settings file: %v", err)
+			}
+
+			settings, err := LoadSettings(path)
+			var fileErr *FileError
+			if !errors.As(err, &fileErr) {
+				t.Fatalf("Expected a *FileError, got %v", err)
+			}
+			if settings.Port != DefaultPort {
+				t.Errorf("Expected default port %d, got %d", DefaultPort, settings.Port)
+			}
+
+			// The file is left alone and a copy kept
+			if data, _ := os.ReadFile(path); string(data) != tt.data {
+				t.Errorf("Expected the settings file to be left alone, got %s", data)
+			}
+			if backup, _ := os.ReadFile(fileErr.Backup); string(backup) != tt.data {
+				t.Errorf("Expected a backup at %q, got %s", fileErr.Backup, backup)
+			}
+		})
+	}
 
 	// Test loading with directory instead of file
 	t.Run("Directory Instead of File", func(t *testing.T) {
-		os.Remove("settings.json")
-		if err := os.Mkdir("settings.json", 0755); err != nil {
+		path := filepath.Join(t.TempDir(), SettingsFile)
+		if err := os.Mkdir(path, 0755); err != nil {
 			t.Fatalf("Failed to create directory: %v", err)
 		}
 
-		settings := LoadSettings()
-		if settings == nil {
-			t.Fatal("Expected non-nil settings")
+		settings, err := LoadSettings(path)
+		if err == nil {
+			t.Error("Expected an error loading a directory")
 		}
-		if settings.Port != 3004 {
-			t.Errorf("Expected default port 3004, got %d", settings.Port)
+		if settings.Port != DefaultPort {
+			t.Errorf("Expected default port %d, got %d", DefaultPort, settings.Port)
 		}
-
-		os.RemoveAll("settings.json")
 	})
 }
 
-func TestWriteSettings(t *testing.T) {
-	// Clean up any existing settings file
-	os.Remove("settings.json")
-	defer os.Remove("settings.json")
-
-	settings := &Settings{
-		Port: 9090,
+func TestSettings_Validate(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "cert.pem")
+	if err := os.WriteFile(cert, nil, 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
 	}
 
-	if err := writeSettings(settings); err != nil {
-		t.Fatalf("Failed to write settings: %v", err)
+	valid := Defaults()
+	valid.BindAddress = "localhost"
+	valid.TLSCertFile, valid.TLSKeyFile = cert, cert
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected valid settings, got %v", err)
 	}
 
-	// Read and verify file contents
-	data, err := os.ReadFile("settings.json")
-	if err != nil {
-		t.Fatalf("Failed to read settings file: %v", err)
+	invalid := Defaults()
+	invalid.BindAddress = "not an address"
+	invalid.TLSCertFile = cert
+	invalid.BunPath = filepath.Join(dir, "missing-bun")
+	invalid.DefaultTimeoutSeconds = 0
+	invalid.History.MaxAgeDays = -1
+	invalid.Auth.PairingTTLSeconds = 1
+	err := invalid.Validate()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a *ValidationError, got %v", err)
 	}
-
-	var loadedSettings Settings
-	if err := json.Unmarshal(data, &loadedSettings); err != nil {
-		t.Fatalf("Failed to unmarshal settings: %v", err)
+	for _, key := range []string{"bind_address", "tls_cert_file", "bun_path", "default_timeout_seconds", "history", "auth"} {
+		if validationErr.Fields[key] == "" {
+			t.Errorf("Expected %s to be invalid, got %v", key, validationErr.Fields)
+		}
 	}
-
-	if loadedSettings.Port != settings.Port {
-		t.Errorf("Expected port %d, got %d", settings.Port, loadedSettings.Port)
+	if len(validationErr.Fields) != 6 {
+		t.Errorf("Expected 6 invalid settings, got %v", validationErr.Fields)
 	}
 }
 
-func TestDefaultSettings(t *testing.T) {
+func TestWriteSettings(t *testing.T) {
 	// Clean up any existing settings file
 	os.Remove("settings.json")
 	defer os.Remove("settings.json")
 
-	settings := defaultSettings()
-	if settings == nil {
-		t.Fatal("Expected non-nil settings")
+	settings := &Settings{
+		Port: 9090,
 	}
 
-	if settings.Port != 3004 {
-		t.Errorf("Expected default port 3004, got %d", settings.Port)
+	if err := writeSettings("settings.json", settings); err != nil {
+		t.Fatalf("Failed to write settings: %v", err)
 	}
 
-	// Verify file was created with default settings
+	// Read and verify file contents
 	data, err := os.ReadFile("settings.json")
 	if err != nil {
 		t.Fatalf("Failed to read settings file: %v", err)
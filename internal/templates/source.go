@@ -0,0 +1,394 @@
+package templates
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoDeclaration is returned when a template has no top-level declaration
+// with an initializer for a variable
+var ErrNoDeclaration = errors.New("declaration not found")
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenTemplate
+	tokenRegex
+	tokenPunct
+)
+
+// token is a lexical token of a template's source. Comments and whitespace
+// are dropped, but whether a line break preceded the token is kept for
+// automatic semicolon insertion.
+type token struct {
+	kind          tokenKind
+	text          string
+	start, end    int
+	newlineBefore bool
+}
+
+// punctuators lists the multi-character operators, longest first, so that
+// "=" is never confused with "==", "=>" or "+="
+var punctuators = []string{
+	">>>=", "...", "===", "!==", "**=", "<<=", ">>=", ">>>", "&&=", "||=", "??=",
+	"=>", "==", "!=", "<=", ">=", "&&", "||", "??", "?.", "++", "--", "+=", "-=",
+	"*=", "/=", "%=", "&=", "|=", "^=", "**", "<<", ">>",
+}
+
+// regexKeywords are the keywords after which a slash starts a regular
+// expression rather than a division
+var regexKeywords = map[string]bool{
+	"return": true, "typeof": true, "case": true, "do": true, "else": true,
+	"in": true, "of": true, "new": true, "delete": true, "void": true,
+	"throw": true, "instanceof": true, "yield": true, "await": true,
+}
+
+// continuingKeywords are the keywords that continue an expression on the
+// next line
+var continuingKeywords = map[string]bool{
+	"in": true, "instanceof": true, "as": true, "satisfies": true,
+}
+
+// lexer splits JavaScript and TypeScript source into tokens. It understands
+// strings, template literals, comments and regular expressions, which is
+// enough to find declarations without being fooled by their contents.
+type lexer struct {
+	src    string
+	pos    int
+	tokens []token
+}
+
+func tokenize(src string) ([]token, error) {
+	l := &lexer{src: src}
+	newline := false
+	for l.pos < len(src) {
+		c := src[l.pos]
+		switch {
+		case c == '\n':
+			newline = true
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			l.pos++
+		case strings.HasPrefix(src[l.pos:], "//"):
+			for l.pos < len(src) && src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(src[l.pos:], "/*"):
+			end := strings.Index(src[l.pos+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			if strings.Contains(src[l.pos:l.pos+2+end], "\n") {
+				newline = true
+			}
+			l.pos += end + 4
+		default:
+			start := l.pos
+			kind, err := l.next()
+			if err != nil {
+				return nil, err
+			}
+			l.tokens = append(l.tokens, token{
+				kind:          kind,
+				text:          src[start:l.pos],
+				start:         start,
+				end:           l.pos,
+				newlineBefore: newline,
+			})
+			newline = false
+		}
+	}
+	return l.tokens, nil
+}
+
+// next reads the token at the current position
+func (l *lexer) next() (tokenKind, error) {
+	c := l.src[l.pos]
+	switch {
+	case c == '"' || c == '\'':
+		return tokenString, l.skipString(c)
+	case c == '`':
+		return tokenTemplate, l.skipTemplate()
+	case c == '/' && l.regexAllowed():
+		return tokenRegex, l.skipRegex()
+	case isIdentStart(c):
+		for l.pos < len(l.src) && isIdentPart(l.src[l.pos]) {
+			l.pos++
+		}
+		return tokenIdent, nil
+	case c >= '0' && c <= '9' || c == '.' && l.pos+1 < len(l.src) && l.src[l.pos+1] >= '0' && l.src[l.pos+1] <= '9':
+		for l.pos < len(l.src) && (isIdentPart(l.src[l.pos]) || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return tokenNumber, nil
+	}
+	for _, p := range punctuators {
+		if strings.HasPrefix(l.src[l.pos:], p) {
+			l.pos += len(p)
+			return tokenPunct, nil
+		}
+	}
+	l.pos++
+	return tokenPunct, nil
+}
+
+// regexAllowed reports whether a slash at the current position starts a
+// regular expression, which depends on the token before it
+func (l *lexer) regexAllowed() bool {
+	if len(l.tokens) == 0 {
+		return true
+	}
+	prev := l.tokens[len(l.tokens)-1]
+	switch prev.kind {
+	case tokenIdent:
+		return regexKeywords[prev.text]
+	case tokenPunct:
+		return prev.text != ")" && prev.text != "]" && prev.text != "}" && prev.text != "++" && prev.text != "--"
+	}
+	return false
+}
+
+func (l *lexer) skipString(quote byte) error {
+	start := l.pos
+	for l.pos++; l.pos < len(l.src); l.pos++ {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos++
+		case '\n':
+			return fmt.Errorf("unterminated string at offset %d", start)
+		case quote:
+			l.pos++
+			return nil
+		}
+	}
+	return fmt.Errorf("unterminated string at offset %d", start)
+}
+
+// skipTemplate skips a template literal including the expressions in its
+// ${} placeholders
+func (l *lexer) skipTemplate() error {
+	start := l.pos
+	for l.pos++; l.pos < len(l.src); l.pos++ {
+		switch {
+		case l.src[l.pos] == '\\':
+			l.pos++
+		case l.src[l.pos] == '`':
+			l.pos++
+			return nil
+		case strings.HasPrefix(l.src[l.pos:], "${"):
+			l.pos += 2
+			if err := l.skipPlaceholder(); err != nil {
+				return err
+			}
+			l.pos--
+		}
+	}
+	return fmt.Errorf("unterminated template literal at offset %d", start)
+}
+
+// skipPlaceholder skips to just after the brace closing a ${} placeholder
+func (l *lexer) skipPlaceholder() error {
+	start := l.pos
+	depth := 0
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"' || c == '\'':
+			if err := l.skipString(c); err != nil {
+				return err
+			}
+			continue
+		case c == '`':
+			if err := l.skipTemplate(); err != nil {
+				return err
+			}
+			continue
+		case strings.HasPrefix(l.src[l.pos:], "//"):
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+			continue
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				return fmt.Errorf("unterminated comment")
+			}
+			l.pos += end + 4
+			continue
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth == 0 {
+				l.pos++
+				return nil
+			}
+			depth--
+		}
+		l.pos++
+	}
+	return fmt.Errorf("unterminated template placeholder at offset %d", start)
+}
+
+func (l *lexer) skipRegex() error {
+	start := l.pos
+	inClass := false
+	for l.pos++; l.pos < len(l.src); l.pos++ {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos++
+		case '\n':
+			return fmt.Errorf("unterminated regular expression at offset %d", start)
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '/':
+			if !inClass {
+				// Flags
+				for l.pos++; l.pos < len(l.src) && isIdentPart(l.src[l.pos]); l.pos++ {
+				}
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("unterminated regular expression at offset %d", start)
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+func isOpening(t token) bool {
+	return t.kind == tokenPunct && (t.text == "(" || t.text == "[" || t.text == "{")
+}
+
+func isClosing(t token) bool {
+	return t.kind == tokenPunct && (t.text == ")" || t.text == "]" || t.text == "}")
+}
+
+// endsExpression reports whether an expression can end with the token, so
+// a line break after it may end the statement
+func endsExpression(t token) bool {
+	if t.kind != tokenPunct {
+		return true
+	}
+	switch t.text {
+	case ")", "]", "}", "++", "--":
+		return true
+	}
+	return false
+}
+
+// continuesExpression reports whether a token at the start of a line
+// continues the expression on the line before
+func continuesExpression(t token) bool {
+	switch t.kind {
+	case tokenIdent:
+		return continuingKeywords[t.text]
+	case tokenPunct:
+		switch t.text {
+		case "{", "}", ")", "]", ";", "++", "--", "!", "~":
+			return false
+		}
+		return true
+	case tokenTemplate:
+		// A template literal after an expression is a tagged template
+		return true
+	}
+	return false
+}
+
+// findDeclaration returns the byte range of the initializer of the first
+// top-level const, let or var declaration of name
+func findDeclaration(tokens []token, name string) (int, int, bool) {
+	depth := 0
+	for i, t := range tokens {
+		if isOpening(t) {
+			depth++
+			continue
+		}
+		if isClosing(t) {
+			depth--
+			continue
+		}
+		if depth != 0 || t.kind != tokenIdent || (t.text != "const" && t.text != "let" && t.text != "var") {
+			continue
+		}
+		if i > 0 && tokens[i-1].kind == tokenPunct && (tokens[i-1].text == "." || tokens[i-1].text == "?.") {
+			continue
+		}
+		if i+1 >= len(tokens) || tokens[i+1].kind != tokenIdent || tokens[i+1].text != name {
+			continue
+		}
+
+		// Skip a type annotation
+		j := i + 2
+		if j < len(tokens) && tokens[j].text == ":" {
+			inner := 0
+			for j++; j < len(tokens); j++ {
+				if isOpening(tokens[j]) {
+					inner++
+				} else if isClosing(tokens[j]) {
+					inner--
+				} else if inner == 0 && tokens[j].kind == tokenPunct && (tokens[j].text == "=" || tokens[j].text == ";") {
+					break
+				}
+			}
+		}
+		if j+1 >= len(tokens) || tokens[j].kind != tokenPunct || tokens[j].text != "=" {
+			continue
+		}
+
+		start := j + 1
+		end := -1
+		inner := 0
+		for k := start; k < len(tokens); k++ {
+			current := tokens[k]
+			if inner == 0 && k > start {
+				if current.kind == tokenPunct && (current.text == ";" || current.text == ",") {
+					break
+				}
+				if current.newlineBefore && endsExpression(tokens[k-1]) && !continuesExpression(current) {
+					break
+				}
+			}
+			if isOpening(current) {
+				inner++
+			} else if isClosing(current) {
+				if inner == 0 {
+					break
+				}
+				inner--
+			}
+			end = k
+		}
+		if end < 0 {
+			continue
+		}
+		return tokens[start].start, tokens[end].end, true
+	}
+	return 0, 0, false
+}
+
+// SetDeclaration replaces the initializer of the top-level declaration of
+// name with value, which must be a JavaScript expression. The declaration may
+// use const, let or var, carry a type annotation and span several lines.
+func SetDeclaration(code, name, value string) (string, error) {
+	tokens, err := tokenize(code)
+	if err != nil {
+		return "", err
+	}
+	start, end, ok := findDeclaration(tokens, name)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNoDeclaration, name)
+	}
+	return code[:start] + value + code[end:], nil
+}
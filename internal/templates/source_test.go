@@ -0,0 +1,112 @@
+package templates
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetDeclaration(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name:     "const",
+			code:     "const PORT = 4455;\nconsole.log(PORT);",
+			expected: "const PORT = 9999;\nconsole.log(PORT);",
+		},
+		{
+			name:     "let without semicolon",
+			code:     "let PORT = 4455\nconsole.log(PORT)",
+			expected: "let PORT = 9999\nconsole.log(PORT)",
+		},
+		{
+			name:     "var with type annotation",
+			code:     "var PORT: number | undefined = 4455;",
+			expected: "var PORT: number | undefined = 9999;",
+		},
+		{
+			name:     "multi-line initializer",
+			code:     "const PORT = [\n  1,\n  2,\n];\nconst OTHER = 1;",
+			expected: "const PORT = 9999;\nconst OTHER = 1;",
+		},
+		{
+			name:     "expression continued on the next line",
+			code:     "const PORT = 4000\n  + 455\nrun()",
+			expected: "const PORT = 9999\nrun()",
+		},
+		{
+			name:     "semicolons and quotes in strings",
+			code:     "const PORT = \"a;b\" + 'c\\';' + `d;${\"}\"}`;\nconst NEXT = 1;",
+			expected: "const PORT = 9999;\nconst NEXT = 1;",
+		},
+		{
+			name:     "regular expression",
+			code:     "const PORT = /;[/]\\//.source; // ;\nconst NEXT = 1;",
+			expected: "const PORT = 9999; // ;\nconst NEXT = 1;",
+		},
+		{
+			name:     "several declarators",
+			code:     "const PORT = 4455, HOST = \"localhost\";",
+			expected: "const PORT = 9999, HOST = \"localhost\";",
+		},
+		{
+			name:     "mentions in comments and strings are skipped",
+			code:     "// const PORT = 1;\n/* const PORT = 2; */\nconst s = \"const PORT = 3;\";\nconst PORT = 4455;",
+			expected: "// const PORT = 1;\n/* const PORT = 2; */\nconst s = \"const PORT = 3;\";\nconst PORT = 9999;",
+		},
+		{
+			name:     "nested declarations are skipped",
+			code:     "function f() {\n  const PORT = 1;\n}\nconst PORT = 4455;",
+			expected: "function f() {\n  const PORT = 1;\n}\nconst PORT = 9999;",
+		},
+		{
+			name:     "similar names are skipped",
+			code:     "const PORT_NUMBER = 1;\nconst PORT = 4455;",
+			expected: "const PORT_NUMBER = 1;\nconst PORT = 9999;",
+		},
+		{
+			name:     "arrow function",
+			code:     "const PORT = () => {\n  return 1;\n};",
+			expected: "const PORT = 9999;",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := SetDeclaration(tt.code, "PORT", "9999")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected\n%s\ngot\n%s", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestSetDeclaration_NotFound(t *testing.T) {
+	for name, code := range map[string]string{
+		"missing":        "const HOST = 1;",
+		"no initializer": "let PORT;\nPORT = 1;",
+		"only nested":    "if (x) {\n  const PORT = 1;\n}",
+		"only a string":  "const s = 'const PORT = 1;';",
+	} {
+		if _, err := SetDeclaration(code, "PORT", "9999"); !errors.Is(err, ErrNoDeclaration) {
+			t.Errorf("%s: expected ErrNoDeclaration, got %v", name, err)
+		}
+	}
+}
+
+func TestSetDeclaration_Unterminated(t *testing.T) {
+	for name, code := range map[string]string{
+		"string":   "const PORT = \"4455;",
+		"template": "const PORT = `4455;",
+		"comment":  "/* const PORT = 4455;",
+	} {
+		if _, err := SetDeclaration(code, "PORT", "9999"); err == nil || errors.Is(err, ErrNoDeclaration) {
+			t.Errorf("%s: expected a syntax error, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ListFile is the template index every root holds
+const ListFile = "list.json"
+
+// SourceBuiltin names the templates embedded in the binary
+const SourceBuiltin = "builtin"
+
+// Root is one directory of templates with its own list.json
+type Root struct {
+	// Source names the root in GetPluginTemplates output, such as the
+	// directory it was loaded from
+	Source string
+	FS     fs.FS
+}
+
+// Template is one entry of a list.json
+type Template struct {
+	ID          string `json:"id"`
+	Title       string `json:"title,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description"`
+	File        string `json:"file"`
+	Category    string `json:"category"`
+	Label       string `json:"label,omitempty"`
+	// Version is raised by the template's author when the template changes,
+	// so plugins created from an older version can be upgraded
+	Version   int                  `json:"version"`
+	Variables map[string]*Variable `json:"variables"`
+	// Source is the root the template was loaded from
+	Source string `json:"source"`
+	// Overrides names the root whose template of the same ID this one hides
+	Overrides string `json:"overrides,omitempty"`
+
+	fsys fs.FS
+}
+
+// DisplayName is the name given to plugins created from the template
+func (t *Template) DisplayName() string {
+	switch {
+	case t.Title != "":
+		return t.Title
+	case t.Name != "":
+		return t.Name
+	case t.ID != "":
+		return t.ID
+	}
+	return "Plugin from template"
+}
+
+// ReadFile reads the template's source from the root it was loaded from
+func (t *Template) ReadFile() ([]byte, error) {
+	return fs.ReadFile(t.fsys, t.File)
+}
+
+// rootState is the last loaded version of a root's list.json
+type rootState struct {
+	root      Root
+	modTime   time.Time
+	size      int64
+	loaded    bool
+	templates []Template
+}
+
+// Catalog merges the templates of several roots. Roots listed first take
+// precedence, so a template in a user directory replaces a built-in one with
+// the same ID. Each root's list.json is reloaded when it changes on disk.
+type Catalog struct {
+	mu    sync.Mutex
+	roots []*rootState
+}
+
+func NewCatalog(roots ...Root) *Catalog {
+	c := &Catalog{}
+	for _, root := range roots {
+		c.roots = append(c.roots, &rootState{root: root})
+	}
+	return c
+}
+
+// List returns the templates of all roots in precedence order, with
+// categories and templates in the order of their list.json
+func (c *Catalog) List() []Template {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	templates := []Template{}
+	index := map[string]int{}
+	for _, state := range c.roots {
+		state.refresh()
+		for _, t := range state.templates {
+			if i, ok := index[t.ID]; ok {
+				if templates[i].Overrides == "" && templates[i].Source != t.Source {
+					templates[i].Overrides = t.Source
+				}
+				continue
+			}
+			index[t.ID] = len(templates)
+			templates = append(templates, t)
+		}
+	}
+	return templates
+}
+
+// Get returns the template with the given ID from the root with the highest
+// precedence
+func (c *Catalog) Get(id string) (*Template, bool) {
+	for _, t := range c.List() {
+		if t.ID == id {
+			return &t, true
+		}
+	}
+	return nil, false
+}
+
+// refresh reloads a root's list.json when its size or modification time
+// changed. A root without a list.json is empty, and a broken one keeps its
+// last good templates so an edit in progress does not hide them.
+func (state *rootState) refresh() {
+	info, err := fs.Stat(state.root.FS, ListFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		state.templates = nil
+		state.loaded = false
+		return
+	}
+	if err != nil {
+		slog.Warn("templates: failed to load templates", "source", state.root.Source, "err", err)
+		return
+	}
+	if state.loaded && info.ModTime().Equal(state.modTime) && info.Size() == state.size {
+		return
+	}
+
+	// Record the version even if it fails to parse so the error is only
+	// logged once per change
+	state.modTime = info.ModTime()
+	state.size = info.Size()
+	state.loaded = true
+
+	data, err := fs.ReadFile(state.root.FS, ListFile)
+	if err != nil {
+		slog.Warn("templates: failed to load templates", "source", state.root.Source, "err", err)
+		return
+	}
+	templates, err := parseList(data)
+	if err != nil {
+		slog.Warn("templates: failed to load templates", "source", state.root.Source+"/"+ListFile, "err", err)
+		return
+	}
+	for i := range templates {
+		templates[i].Source = state.root.Source
+		templates[i].fsys = state.root.FS
+	}
+	state.templates = templates
+}
+
+// parseList decodes a list.json, which maps category keys to their plugins.
+// Categories are read in file order, which a map would lose.
+func parseList(data []byte) ([]Template, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('{') {
+		return nil, fmt.Errorf("expected an object of categories")
+	}
+
+	var templates []Template
+	seen := map[string]bool{}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var category struct {
+			Plugins []Template `json:"plugins"`
+		}
+		if err := decoder.Decode(&category); err != nil {
+			return nil, fmt.Errorf("category %v: %w", key, err)
+		}
+		for _, t := range category.Plugins {
+			if t.ID == "" || t.File == "" {
+				return nil, fmt.Errorf("category %v: every template needs an id and a file", key)
+			}
+			if seen[t.ID] {
+				return nil, fmt.Errorf("duplicate template id %q", t.ID)
+			}
+			seen[t.ID] = true
+			if t.Version < 0 {
+				return nil, fmt.Errorf("template %s: version must be positive", t.ID)
+			}
+			if t.Version == 0 {
+				t.Version = 1
+			}
+			for name, variable := range t.Variables {
+				if variable == nil {
+					return nil, fmt.Errorf("template %s: variable %s has no definition", t.ID, name)
+				}
+				if err := variable.compile(); err != nil {
+					return nil, fmt.Errorf("template %s: variable %s: %w", t.ID, name, err)
+				}
+			}
+			if t.Category == "" {
+				t.Category = fmt.Sprint(key)
+			}
+			templates = append(templates, t)
+		}
+	}
+	return templates, nil
+}
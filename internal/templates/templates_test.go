@@ -0,0 +1,151 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+const builtinList = `{
+	"OBS": {"plugins": [
+		{"id": "obs", "title": "OBS", "file": "obs.ts"},
+		{"id": "shared", "title": "Built-in Shared", "file": "shared.ts"}
+	]},
+	"Input": {"plugins": [
+		{"id": "keys", "title": "Keys", "file": "keys.ts", "category": "Keyboard"}
+	]}
+}`
+
+func builtinRoot() Root {
+	return Root{Source: SourceBuiltin, FS: fstest.MapFS{
+		"list.json": &fstest.MapFile{Data: []byte(builtinList)},
+		"obs.ts":    &fstest.MapFile{Data: []byte("builtin obs")},
+		"shared.ts": &fstest.MapFile{Data: []byte("builtin shared")},
+		"keys.ts":   &fstest.MapFile{Data: []byte("builtin keys")},
+	}}
+}
+
+func ids(templates []Template) []string {
+	var result []string
+	for _, t := range templates {
+		result = append(result, t.ID)
+	}
+	return result
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCatalog_List(t *testing.T) {
+	catalog := NewCatalog(builtinRoot())
+
+	templates := catalog.List()
+	if got := ids(templates); len(got) != 3 || got[0] != "obs" || got[1] != "shared" || got[2] != "keys" {
+		t.Fatalf("expected templates in list.json order, got %v", got)
+	}
+	if templates[0].Category != "OBS" {
+		t.Errorf("expected category to default to its key, got %q", templates[0].Category)
+	}
+	if templates[2].Category != "Keyboard" {
+		t.Errorf("expected explicit category to be kept, got %q", templates[2].Category)
+	}
+	for _, template := range templates {
+		if template.Source != SourceBuiltin {
+			t.Errorf("expected source %q, got %q", SourceBuiltin, template.Source)
+		}
+	}
+}
+
+func TestCatalog_Precedence(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "list.json", `{"Team": {"plugins": [
+		{"id": "shared", "title": "Team Shared", "file": "shared.ts"},
+		{"id": "team", "title": "Team Only", "file": "team.ts"}
+	]}}`)
+	writeFile(t, dir, "shared.ts", "team shared")
+	writeFile(t, dir, "team.ts", "team only")
+
+	catalog := NewCatalog(Root{Source: dir, FS: os.DirFS(dir)}, builtinRoot())
+
+	if got := ids(catalog.List()); len(got) != 4 || got[0] != "shared" || got[1] != "team" || got[2] != "obs" || got[3] != "keys" {
+		t.Fatalf("expected user templates first without duplicates, got %v", got)
+	}
+
+	shared, ok := catalog.Get("shared")
+	if !ok {
+		t.Fatal("expected shared template")
+	}
+	if shared.Source != dir || shared.Overrides != SourceBuiltin || shared.Title != "Team Shared" {
+		t.Errorf("expected user template to override the built-in one, got %+v", shared)
+	}
+	code, err := shared.ReadFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(code) != "team shared" {
+		t.Errorf("expected source from the user directory, got %q", code)
+	}
+
+	obs, _ := catalog.Get("obs")
+	if code, _ := obs.ReadFile(); string(code) != "builtin obs" {
+		t.Errorf("expected source from the built-in root, got %q", code)
+	}
+
+	if _, ok := catalog.Get("missing"); ok {
+		t.Error("expected unknown template to be missing")
+	}
+}
+
+func TestCatalog_Reload(t *testing.T) {
+	dir := t.TempDir()
+	catalog := NewCatalog(Root{Source: dir, FS: os.DirFS(dir)}, builtinRoot())
+
+	// A directory without list.json adds nothing
+	if got := ids(catalog.List()); len(got) != 3 {
+		t.Fatalf("expected only built-in templates, got %v", got)
+	}
+
+	writeFile(t, dir, "list.json", `{"Team": {"plugins": [{"id": "team", "file": "team.ts"}]}}`)
+	if _, ok := catalog.Get("team"); !ok {
+		t.Fatal("expected new list.json to be picked up")
+	}
+
+	writeFile(t, dir, "list.json", `{"Team": {"plugins": [{"id": "renamed", "file": "team.ts"}, {"id": "other", "file": "other.ts"}]}}`)
+	if _, ok := catalog.Get("team"); ok {
+		t.Error("expected removed template to be gone after reload")
+	}
+	if _, ok := catalog.Get("renamed"); !ok {
+		t.Fatal("expected changed list.json to be reloaded")
+	}
+
+	// A broken edit keeps the last good templates
+	writeFile(t, dir, "list.json", `{"Team": {"plugins": [`)
+	if _, ok := catalog.Get("renamed"); !ok {
+		t.Error("expected last good templates to be kept while list.json is broken")
+	}
+
+	if err := os.Remove(filepath.Join(dir, "list.json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := catalog.Get("renamed"); ok {
+		t.Error("expected templates to be gone once list.json is removed")
+	}
+}
+
+func TestParseList_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"not an object": `[]`,
+		"missing file":  `{"A": {"plugins": [{"id": "a"}]}}`,
+		"missing id":    `{"A": {"plugins": [{"file": "a.ts"}]}}`,
+		"duplicate id":  `{"A": {"plugins": [{"id": "a", "file": "a.ts"}]}, "B": {"plugins": [{"id": "a", "file": "b.ts"}]}}`,
+	} {
+		if _, err := parseList([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Variable types a template can declare in its list.json
+const (
+	TypeString   = "string"
+	TypeNumber   = "number"
+	TypeBoolean  = "boolean"
+	TypeStrings  = "string[]"
+	TypeNumbers  = "number[]"
+	TypeBooleans = "boolean[]"
+	// TypeSecret is a string with the secret flag set
+	TypeSecret = "secret"
+)
+
+// Variable describes a value a template asks for when a plugin is created.
+// Min and Max bound numbers and the length of strings, and apply to every
+// item of an array, as do Enum and Pattern.
+type Variable struct {
+	Type        string   `json:"type"`
+	Default     any      `json:"default"`
+	Description string   `json:"description"`
+	Label       string   `json:"label,omitempty"`
+	Required    bool     `json:"required,omitempty"`
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+	Enum        []any    `json:"enum,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	// Secret values are stored encrypted under the variable's name and read
+	// by the plugin from its environment instead of being written into the
+	// code
+	Secret bool `json:"secret,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// IsSecret reports whether the variable is a secret
+func (v *Variable) IsSecret() bool {
+	return v.Secret || v.Type == TypeSecret
+}
+
+// itemType is the type of a single value, the item type for arrays
+func (v *Variable) itemType() string {
+	if v.IsSecret() {
+		return TypeString
+	}
+	return strings.TrimSuffix(v.Type, "[]")
+}
+
+func (v *Variable) isArray() bool {
+	return strings.HasSuffix(v.Type, "[]")
+}
+
+// compile checks the definition itself, so template authors find mistakes
+// when list.json is loaded rather than when a plugin is created
+func (v *Variable) compile() error {
+	switch v.Type {
+	case TypeString, TypeNumber, TypeBoolean, TypeStrings, TypeNumbers, TypeBooleans, TypeSecret:
+	default:
+		return fmt.Errorf("unknown type %q", v.Type)
+	}
+	if v.Secret && v.Type != TypeString && v.Type != TypeSecret {
+		return fmt.Errorf("only strings can be secret")
+	}
+	if v.Min != nil && v.Max != nil && *v.Min > *v.Max {
+		return fmt.Errorf("min is greater than max")
+	}
+	if v.Pattern != "" {
+		if v.itemType() != TypeString {
+			return fmt.Errorf("pattern needs a string type")
+		}
+		pattern, err := regexp.Compile(v.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern: %w", err)
+		}
+		v.pattern = pattern
+	}
+	for _, option := range v.Enum {
+		if err := v.checkItem(option); err != nil {
+			return fmt.Errorf("enum value %v: %w", option, err)
+		}
+	}
+	return nil
+}
+
+// checkItem checks a single value, an array item for arrays, against the
+// type and constraints
+func (v *Variable) checkItem(value any) error {
+	switch v.itemType() {
+	case TypeString:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("must be a string")
+		}
+		length := float64(utf8.RuneCountInString(s))
+		if v.Min != nil && length < *v.Min {
+			return fmt.Errorf("must be at least %g characters", *v.Min)
+		}
+		if v.Max != nil && length > *v.Max {
+			return fmt.Errorf("must be at most %g characters", *v.Max)
+		}
+		if v.pattern != nil && !v.pattern.MatchString(s) {
+			return fmt.Errorf("must match %s", v.Pattern)
+		}
+	case TypeNumber:
+		n, ok := value.(float64)
+		if !ok || math.IsNaN(n) || math.IsInf(n, 0) {
+			return fmt.Errorf("must be a number")
+		}
+		if v.Min != nil && n < *v.Min {
+			return fmt.Errorf("must be at least %g", *v.Min)
+		}
+		if v.Max != nil && n > *v.Max {
+			return fmt.Errorf("must be at most %g", *v.Max)
+		}
+	case TypeBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("must be true or false")
+		}
+	}
+	if len(v.Enum) > 0 && !slices.Contains(v.Enum, value) {
+		options := make([]string, len(v.Enum))
+		for i, option := range v.Enum {
+			options[i] = fmt.Sprint(option)
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(options, ", "))
+	}
+	return nil
+}
+
+// check validates a submitted value. Optional strings and lists may be left
+// empty, and a nil value keeps the template's own default.
+func (v *Variable) check(value any) error {
+	if isEmpty(value) && v.Required {
+		return fmt.Errorf("is required")
+	}
+	if value == nil {
+		return nil
+	}
+	if !v.isArray() {
+		if s, ok := value.(string); ok && s == "" && v.itemType() == TypeString {
+			return nil
+		}
+		return v.checkItem(value)
+	}
+
+	items, ok := value.([]any)
+	if !ok {
+		return fmt.Errorf("must be a list")
+	}
+	for i, item := range items {
+		if err := v.checkItem(item); err != nil {
+			return fmt.Errorf("item %d %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func isEmpty(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []any:
+		return len(v) == 0
+	}
+	return false
+}
+
+// ValidationError lists the variables that failed validation with the
+// reason for each
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]string, len(names))
+	for i, name := range names {
+		messages[i] = name + " " + e.Fields[name]
+	}
+	return "invalid template variables: " + strings.Join(messages, "; ")
+}
+
+// Validate checks submitted variable values against the template's schema
+// and returns a *ValidationError naming every invalid one. A required
+// secret may be left empty when storedSecrets has its variable name, as the
+// plugin already has it stored.
+func (t *Template) Validate(values map[string]any, storedSecrets map[string]bool) error {
+	fields := map[string]string{}
+	for name, value := range values {
+		variable, ok := t.Variables[name]
+		if !ok {
+			fields[name] = "is not a variable of this template"
+			continue
+		}
+		if variable.IsSecret() && variable.Required && isEmpty(value) && storedSecrets[name] {
+			continue
+		}
+		if err := variable.check(value); err != nil {
+			fields[name] = err.Error()
+		}
+	}
+	for name, variable := range t.Variables {
+		if _, ok := values[name]; ok || !variable.Required {
+			continue
+		}
+		if variable.IsSecret() && storedSecrets[name] {
+			continue
+		}
+		fields[name] = "is required"
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// Literal renders a validated value as a JavaScript expression
+func Literal(value any) (string, error) {
+	switch v := value.(type) {
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			literal, err := Literal(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = literal
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			return fmt.Sprintf("%d", int64(v)), nil
+		}
+	}
+
+	// JSON strings, numbers and booleans are valid JavaScript
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// SecretName is the name a secret variable of the plugin is stored under.
+// Each plugin created from the template has its own secrets, so two plugins
+// connecting to different servers do not share a password.
+func (t *Template) SecretName(variable string, pluginID int) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, t.ID)
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return fmt.Sprintf("%s_%d_%s", name, pluginID, variable)
+}
+
+// Render substitutes validated values into the source of the plugin's
+// template. Secret variables always read the plugin's environment under
+// their SecretName, and variables without a value keep the default written
+// in the source.
+func (t *Template) Render(source string, values map[string]any, pluginID int) (string, error) {
+	names := make([]string, 0, len(t.Variables))
+	for name := range t.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	code := source
+	for _, name := range names {
+		var expression string
+		if t.Variables[name].IsSecret() {
+			expression = "process.env." + t.SecretName(name, pluginID)
+		} else if value := values[name]; value != nil {
+			literal, err := Literal(value)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", name, err)
+			}
+			expression = literal
+		} else {
+			continue
+		}
+
+		var err error
+		if code, err = SetDeclaration(code, name, expression); err != nil {
+			return "", err
+		}
+	}
+	return code, nil
+}
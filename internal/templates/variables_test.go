@@ -0,0 +1,211 @@
+package templates
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func compiled(t *testing.T, variables map[string]*Variable) *Template {
+	t.Helper()
+	for name, variable := range variables {
+		if err := variable.compile(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	return &Template{ID: "test", Variables: variables}
+}
+
+func TestTemplate_Validate(t *testing.T) {
+	template := compiled(t, map[string]*Variable{
+		"PORT":     {Type: TypeNumber, Required: true, Min: float(1), Max: float(65535)},
+		"HOST":     {Type: TypeString, Pattern: `^[a-z.]+$`, Max: float(20)},
+		"MODE":     {Type: TypeString, Enum: []any{"fast", "slow"}},
+		"DEVICES":  {Type: TypeStrings, Min: float(1)},
+		"KEYS":     {Type: TypeNumbers},
+		"LOGGING":  {Type: TypeBoolean},
+		"PASSWORD": {Type: TypeSecret, Required: true},
+		"TOKEN":    {Type: TypeString, Secret: true},
+	})
+
+	valid := map[string]any{
+		"PORT":     float64(4455),
+		"HOST":     "localhost",
+		"MODE":     "fast",
+		"DEVICES":  []any{"Webcam"},
+		"KEYS":     []any{float64(1), float64(2)},
+		"LOGGING":  true,
+		"PASSWORD": "hunter2",
+		"TOKEN":    "",
+	}
+	if err := template.Validate(valid, nil); err != nil {
+		t.Fatalf("expected valid values, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		values map[string]any
+		field  string
+	}{
+		{"number below min", map[string]any{"PORT": float64(0)}, "PORT"},
+		{"number above max", map[string]any{"PORT": float64(70000)}, "PORT"},
+		{"string for number", map[string]any{"PORT": "4455"}, "PORT"},
+		{"required missing", map[string]any{"PORT": nil}, "PORT"},
+		{"pattern", map[string]any{"HOST": "Local Host"}, "HOST"},
+		{"string too long", map[string]any{"HOST": strings.Repeat("a", 21)}, "HOST"},
+		{"enum", map[string]any{"MODE": "medium"}, "MODE"},
+		{"empty array item", map[string]any{"DEVICES": []any{""}}, "DEVICES"},
+		{"not an array", map[string]any{"DEVICES": "Webcam"}, "DEVICES"},
+		{"wrong item type", map[string]any{"KEYS": []any{"1"}}, "KEYS"},
+		{"not a boolean", map[string]any{"LOGGING": "true"}, "LOGGING"},
+		{"required secret empty", map[string]any{"PASSWORD": ""}, "PASSWORD"},
+		{"unknown variable", map[string]any{"OTHER": "x"}, "OTHER"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := map[string]any{}
+			for k, v := range valid {
+				values[k] = v
+			}
+			for k, v := range tt.values {
+				values[k] = v
+			}
+
+			err := template.Validate(values, nil)
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+			if len(invalid.Fields) != 1 || invalid.Fields[tt.field] == "" {
+				t.Errorf("expected only %s to be invalid, got %v", tt.field, invalid.Fields)
+			}
+		})
+	}
+
+	t.Run("missing required", func(t *testing.T) {
+		err := template.Validate(map[string]any{}, nil)
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) {
+			t.Fatalf("expected a validation error, got %v", err)
+		}
+		if len(invalid.Fields) != 2 || invalid.Fields["PORT"] == "" || invalid.Fields["PASSWORD"] == "" {
+			t.Errorf("expected PORT and PASSWORD to be required, got %v", invalid.Fields)
+		}
+	})
+
+	t.Run("stored secret", func(t *testing.T) {
+		if err := template.Validate(map[string]any{"PORT": float64(1), "PASSWORD": ""}, map[string]bool{"PASSWORD": true}); err != nil {
+			t.Errorf("expected a stored secret to satisfy required, got %v", err)
+		}
+	})
+}
+
+func TestVariable_Compile(t *testing.T) {
+	for name, variable := range map[string]*Variable{
+		"unknown type":      {Type: "date"},
+		"secret number":     {Type: TypeNumber, Secret: true},
+		"min above max":     {Type: TypeNumber, Min: float(2), Max: float(1)},
+		"pattern on number": {Type: TypeNumber, Pattern: "1"},
+		"bad pattern":       {Type: TypeString, Pattern: "("},
+		"enum wrong type":   {Type: TypeNumber, Enum: []any{"a"}},
+	} {
+		if err := variable.compile(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	tests := []struct {
+		value    any
+		expected string
+	}{
+		{"plain", `"plain"`},
+		{"quote \" and </script>", `"quote \" and </script>"`},
+		{float64(4455), "4455"},
+		{float64(-1.5), "-1.5"},
+		{true, "true"},
+		{[]any{"a", "b"}, `["a", "b"]`},
+		{[]any{float64(1), float64(2.5)}, "[1, 2.5]"},
+		{[]any{}, "[]"},
+	}
+	for _, tt := range tests {
+		result, err := Literal(tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, result)
+		}
+	}
+}
+
+func TestTemplate_Render(t *testing.T) {
+	template := compiled(t, map[string]*Variable{
+		"PORT":     {Type: TypeNumber},
+		"HOST":     {Type: TypeString},
+		"PASSWORD": {Type: TypeSecret},
+	})
+	source := "const PORT = 4455;\nconst HOST = \"localhost\";\nconst PASSWORD = \"\";\n"
+
+	code, err := template.Render(source, map[string]any{"PORT": float64(1234), "PASSWORD": "hunter2"}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "const PORT = 1234;\nconst HOST = \"localhost\";\nconst PASSWORD = process.env.TEST_7_PASSWORD;\n"
+	if code != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, code)
+	}
+
+	if _, err := template.Render("const PORT = 1;", nil, 7); !errors.Is(err, ErrNoDeclaration) {
+		t.Errorf("expected a missing secret declaration to fail, got %v", err)
+	}
+}
+
+func TestTemplate_SecretName(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected string
+	}{
+		{"obs", "OBS_3_PASSWORD"},
+		{"home-assistant.light", "HOME_ASSISTANT_LIGHT_3_PASSWORD"},
+		{"2fa", "_2FA_3_PASSWORD"},
+	}
+	for _, tt := range tests {
+		template := &Template{ID: tt.id}
+		if name := template.SecretName("PASSWORD", 3); name != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.id, tt.expected, name)
+		}
+	}
+}
+
+// The built-in templates must load and render with their own defaults
+func TestBuiltinTemplates(t *testing.T) {
+	catalog := NewCatalog(Root{Source: SourceBuiltin, FS: os.DirFS("../../plugins")})
+	list := catalog.List()
+	if len(list) == 0 {
+		t.Fatal("expected built-in templates")
+	}
+
+	for _, template := range list {
+		source, err := template.ReadFile()
+		if err != nil {
+			t.Fatalf("%s: %v", template.ID, err)
+		}
+		defaults := map[string]any{}
+		for name, variable := range template.Variables {
+			defaults[name] = variable.Default
+		}
+		if err := template.Validate(defaults, nil); err != nil {
+			t.Errorf("%s: %v", template.ID, err)
+		}
+		if _, err := template.Render(string(source), defaults, 1); err != nil {
+			t.Errorf("%s: %v", template.ID, err)
+		}
+	}
+}
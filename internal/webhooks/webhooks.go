@@ -0,0 +1,300 @@
+package webhooks
+
+import (
+	"bundeck/internal/db"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Response modes of a webhook
+const (
+	// ModeSync waits for the run and responds with the plugin's output
+	ModeSync = "sync"
+	// ModeAsync responds with 202 Accepted and the run ID once the run started
+	ModeAsync = "async"
+)
+
+// SignatureHeaders carry the HMAC-SHA256 of the request body as
+// sha256=<hex>, the second is the header GitHub sends
+var SignatureHeaders = []string{"X-Bundeck-Signature", "X-Hub-Signature-256"}
+
+var (
+	// ErrUnknownToken is returned for tokens that belong to no webhook
+	ErrUnknownToken = errors.New("unknown webhook")
+	// ErrInvalidSignature is returned when a signed webhook is called without
+	// a valid signature
+	ErrInvalidSignature = errors.New("missing or invalid signature")
+	// ErrInvalidMode is returned for modes other than ModeSync and ModeAsync
+	ErrInvalidMode = errors.New("mode must be sync or async")
+)
+
+// Store persists webhooks
+type Store interface {
+	Create(webhook *db.Webhook) error
+	GetByID(id int) (*db.Webhook, error)
+	GetByTokenHash(hash string) (*db.Webhook, error)
+	ListByPlugin(pluginID int) ([]db.Webhook, error)
+	Update(webhook *db.Webhook) error
+	Touch(id int, used time.Time) error
+	Delete(id int) error
+}
+
+// Cipher encrypts signing secrets at rest
+type Cipher interface {
+	Seal(label string, value []byte) ([]byte, error)
+	Open(label string, sealed []byte) ([]byte, error)
+}
+
+// Webhook is a webhook as shown to clients
+type Webhook struct {
+	db.Webhook
+	Signed bool `json:"signed"`
+}
+
+// Credentials are the token and signing secret of a webhook. They are only
+// available when the webhook is created or its credentials are rotated.
+type Credentials struct {
+	Webhook
+	Token string `json:"token"`
+	// SigningSecret is empty for webhooks that are not signed
+	SigningSecret string `json:"signing_secret,omitempty"`
+}
+
+// Manager creates webhooks and resolves the tokens of incoming calls
+type Manager struct {
+	store  Store
+	cipher Cipher
+}
+
+func NewManager(store Store, cipher Cipher) *Manager {
+	return &Manager{store: store, cipher: cipher}
+}
+
+// ValidMode reports whether mode is a known response mode
+func ValidMode(mode string) bool {
+	return mode == ModeSync || mode == ModeAsync
+}
+
+// Create adds a webhook to a plugin. Signed webhooks get a signing secret
+// callers must sign the request body with.
+func (m *Manager) Create(pluginID int, name string, mode string, signed bool) (*Credentials, error) {
+	if !ValidMode(mode) {
+		return nil, ErrInvalidMode
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Webhook"
+	}
+	webhook := &db.Webhook{PluginID: pluginID, Name: name, Mode: mode}
+	credentials, err := m.issue(webhook, signed)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store.Create(webhook); err != nil {
+		return nil, err
+	}
+	credentials.Webhook = view(webhook)
+	return credentials, nil
+}
+
+// List returns the webhooks of a plugin
+func (m *Manager) List(pluginID int) ([]Webhook, error) {
+	rows, err := m.store.ListByPlugin(pluginID)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]Webhook, len(rows))
+	for i := range rows {
+		webhooks[i] = view(&rows[i])
+	}
+	return webhooks, nil
+}
+
+// Get returns a webhook, or sql.ErrNoRows
+func (m *Manager) Get(id int) (*Webhook, error) {
+	row, err := m.store.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	webhook := view(row)
+	return &webhook, nil
+}
+
+// Update renames a webhook and changes its response mode
+func (m *Manager) Update(id int, name string, mode string) (*Webhook, error) {
+	if !ValidMode(mode) {
+		return nil, ErrInvalidMode
+	}
+
+	row, err := m.store.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if name = strings.TrimSpace(name); name != "" {
+		row.Name = name
+	}
+	row.Mode = mode
+	if err := m.store.Update(row); err != nil {
+		return nil, err
+	}
+	webhook := view(row)
+	return &webhook, nil
+}
+
+// Rotate replaces the token of a webhook, and its signing secret when signed
+// is set, so the old URL stops working. Leaving signed unset removes the
+// signature requirement.
+func (m *Manager) Rotate(id int, signed bool) (*Credentials, error) {
+	row, err := m.store.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := m.issue(row, signed)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store.Update(row); err != nil {
+		return nil, err
+	}
+	credentials.Webhook = view(row)
+	return credentials, nil
+}
+
+// Delete removes a webhook, returning sql.ErrNoRows when it does not exist
+func (m *Manager) Delete(id int) error {
+	return m.store.Delete(id)
+}
+
+// Authenticate returns the webhook owning token after checking the signature
+// of body for signed webhooks
+func (m *Manager) Authenticate(token string, body []byte, signature string) (*db.Webhook, error) {
+	if token == "" {
+		return nil, ErrUnknownToken
+	}
+
+	webhook, err := m.store.GetByTokenHash(hashToken(token))
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if webhook.SigningSecret != nil {
+		secret, err := m.cipher.Open(secretLabel(webhook.TokenHash), webhook.SigningSecret)
+		if err != nil {
+			return nil, err
+		}
+		if !Verify(secret, body, signature) {
+			return nil, ErrInvalidSignature
+		}
+	}
+
+	if err := m.store.Touch(webhook.ID, time.Now()); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// issue sets a new token and, for signed webhooks, a new signing secret
+func (m *Manager) issue(webhook *db.Webhook, signed bool) (*Credentials, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	credentials := &Credentials{Token: token}
+	webhook.TokenHash = hashToken(token)
+	webhook.SigningSecret = nil
+
+	if signed {
+		secret, err := randomToken()
+		if err != nil {
+			return nil, err
+		}
+		// The token hash is authenticated so secrets cannot be swapped
+		// between webhooks
+		sealed, err := m.cipher.Seal(secretLabel(webhook.TokenHash), []byte(secret))
+		if err != nil {
+			return nil, err
+		}
+		webhook.SigningSecret = sealed
+		credentials.SigningSecret = secret
+	}
+	return credentials, nil
+}
+
+// Sign returns the signature header value for body
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the HMAC-SHA256 of body under secret
+func Verify(secret []byte, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(strings.TrimSpace(signature)))
+}
+
+// Input is the JSON document a webhook hands to its plugin
+type Input struct {
+	// Body is the request body, parsed when it is JSON and a string otherwise
+	Body any `json:"body"`
+	// Query holds a string for every query parameter, or a list of strings
+	// when the parameter is repeated
+	Query map[string]any `json:"query"`
+}
+
+// NewInput builds the plugin input of a webhook call
+func NewInput(body []byte, contentType string, query map[string][]string) ([]byte, error) {
+	input := Input{Query: map[string]any{}}
+	for key, values := range query {
+		if len(values) == 1 {
+			input.Query[key] = values[0]
+		} else {
+			input.Query[key] = values
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	switch {
+	case len(body) == 0:
+	case isJSON && json.Valid(body):
+		input.Body = json.RawMessage(body)
+	default:
+		input.Body = string(body)
+	}
+	return json.Marshal(input)
+}
+
+func view(row *db.Webhook) Webhook {
+	return Webhook{Webhook: *row, Signed: row.SigningSecret != nil}
+}
+
+func secretLabel(tokenHash string) string {
+	return "webhook:" + tokenHash
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,246 @@
+package webhooks
+
+import (
+	"bundeck/internal/db"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memoryStore struct {
+	webhooks map[int]*db.Webhook
+	nextID   int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{webhooks: make(map[int]*db.Webhook)}
+}
+
+func (m *memoryStore) Create(webhook *db.Webhook) error {
+	m.nextID++
+	webhook.ID = m.nextID
+	webhook.CreatedAt = time.Now()
+	stored := *webhook
+	m.webhooks[webhook.ID] = &stored
+	return nil
+}
+
+func (m *memoryStore) GetByID(id int) (*db.Webhook, error) {
+	webhook, ok := m.webhooks[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	found := *webhook
+	return &found, nil
+}
+
+func (m *memoryStore) GetByTokenHash(hash string) (*db.Webhook, error) {
+	for _, webhook := range m.webhooks {
+		if webhook.TokenHash == hash {
+			found := *webhook
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memoryStore) ListByPlugin(pluginID int) ([]db.Webhook, error) {
+	var list []db.Webhook
+	for id := 1; id <= m.nextID; id++ {
+		if webhook, ok := m.webhooks[id]; ok && webhook.PluginID == pluginID {
+			list = append(list, *webhook)
+		}
+	}
+	return list, nil
+}
+
+func (m *memoryStore) Update(webhook *db.Webhook) error {
+	if _, ok := m.webhooks[webhook.ID]; !ok {
+		return sql.ErrNoRows
+	}
+	stored := *webhook
+	m.webhooks[webhook.ID] = &stored
+	return nil
+}
+
+func (m *memoryStore) Touch(id int, used time.Time) error {
+	if webhook, ok := m.webhooks[id]; ok {
+		webhook.LastUsedAt = &used
+	}
+	return nil
+}
+
+func (m *memoryStore) Delete(id int) error {
+	if _, ok := m.webhooks[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.webhooks, id)
+	return nil
+}
+
+// labelCipher prefixes values with their label, enough to check that the
+// manager opens secrets with the label they were sealed with
+type labelCipher struct{}
+
+func (labelCipher) Seal(label string, value []byte) ([]byte, error) {
+	return []byte(label + "|" + string(value)), nil
+}
+
+func (labelCipher) Open(label string, sealed []byte) ([]byte, error) {
+	value, ok := strings.CutPrefix(string(sealed), label+"|")
+	if !ok {
+		return nil, errors.New("wrong label")
+	}
+	return []byte(value), nil
+}
+
+func TestManager_Create(t *testing.T) {
+	store := newMemoryStore()
+	manager := NewManager(store, labelCipher{})
+
+	if _, err := manager.Create(1, "CI", "later", false); err != ErrInvalidMode {
+		t.Errorf("Expected ErrInvalidMode, got %v", err)
+	}
+
+	created, err := manager.Create(1, "  ", ModeSync, false)
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	if created.Token == "" || created.SigningSecret != "" || created.Signed {
+		t.Errorf("Expected an unsigned webhook with a token, got %+v", created)
+	}
+	if created.Name != "Webhook" {
+		t.Errorf("Expected a default name, got %q", created.Name)
+	}
+	if store.webhooks[created.ID].TokenHash == created.Token {
+		t.Error("Token stored in plain text")
+	}
+
+	webhook, err := manager.Authenticate(created.Token, []byte("anything"), "")
+	if err != nil || webhook.ID != created.ID {
+		t.Fatalf("Expected the webhook for its token, got %v and %v", webhook, err)
+	}
+	if store.webhooks[created.ID].LastUsedAt == nil {
+		t.Error("Expected the call to be recorded")
+	}
+	if _, err := manager.Authenticate("guess", nil, ""); err != ErrUnknownToken {
+		t.Errorf("Expected ErrUnknownToken, got %v", err)
+	}
+	if _, err := manager.Authenticate("", nil, ""); err != ErrUnknownToken {
+		t.Errorf("Expected ErrUnknownToken for an empty token, got %v", err)
+	}
+}
+
+func TestManager_Signed(t *testing.T) {
+	manager := NewManager(newMemoryStore(), labelCipher{})
+
+	created, err := manager.Create(1, "GitHub", ModeAsync, true)
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	if created.SigningSecret == "" || !created.Signed {
+		t.Fatalf("Expected a signing secret, got %+v", created)
+	}
+
+	body := []byte(`{"ref":"main"}`)
+	signature := Sign([]byte(created.SigningSecret), body)
+	if _, err := manager.Authenticate(created.Token, body, signature); err != nil {
+		t.Errorf("Expected a valid signature to pass, got %v", err)
+	}
+	for name, signature := range map[string]string{
+		"missing":        "",
+		"other body":     Sign([]byte(created.SigningSecret), []byte(`{}`)),
+		"other secret":   Sign([]byte("guess"), body),
+		"without prefix": strings.TrimPrefix(signature, "sha256="),
+	} {
+		if _, err := manager.Authenticate(created.Token, body, signature); err != ErrInvalidSignature {
+			t.Errorf("%s: expected ErrInvalidSignature, got %v", name, err)
+		}
+	}
+}
+
+func TestManager_Rotate(t *testing.T) {
+	manager := NewManager(newMemoryStore(), labelCipher{})
+
+	created, err := manager.Create(1, "CI", ModeSync, true)
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	rotated, err := manager.Rotate(created.ID, true)
+	if err != nil {
+		t.Fatalf("Failed to rotate webhook: %v", err)
+	}
+	if rotated.Token == created.Token || rotated.SigningSecret == created.SigningSecret {
+		t.Error("Expected new credentials")
+	}
+	if _, err := manager.Authenticate(created.Token, nil, ""); err != ErrUnknownToken {
+		t.Errorf("Expected the old token to stop working, got %v", err)
+	}
+	body := []byte("payload")
+	if _, err := manager.Authenticate(rotated.Token, body, Sign([]byte(rotated.SigningSecret), body)); err != nil {
+		t.Errorf("Expected the new credentials to work, got %v", err)
+	}
+
+	unsigned, err := manager.Rotate(created.ID, false)
+	if err != nil {
+		t.Fatalf("Failed to rotate webhook: %v", err)
+	}
+	if unsigned.Signed || unsigned.SigningSecret != "" {
+		t.Errorf("Expected the signature requirement to be removed, got %+v", unsigned)
+	}
+
+	if _, err := manager.Rotate(99, false); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a missing webhook, got %v", err)
+	}
+}
+
+func TestManager_Update(t *testing.T) {
+	manager := NewManager(newMemoryStore(), labelCipher{})
+
+	created, err := manager.Create(1, "CI", ModeSync, false)
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	updated, err := manager.Update(created.ID, "Home Assistant", ModeAsync)
+	if err != nil {
+		t.Fatalf("Failed to update webhook: %v", err)
+	}
+	if updated.Name != "Home Assistant" || updated.Mode != ModeAsync {
+		t.Errorf("Unexpected webhook %+v", updated)
+	}
+	if _, err := manager.Authenticate(created.Token, nil, ""); err != nil {
+		t.Errorf("Expected the token to keep working, got %v", err)
+	}
+	if _, err := manager.Update(created.ID, "", "later"); err != ErrInvalidMode {
+		t.Errorf("Expected ErrInvalidMode, got %v", err)
+	}
+}
+
+func TestNewInput(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		query       map[string][]string
+		expected    string
+	}{
+		{"json", `{"a":1}`, "application/json; charset=utf-8", nil, `{"body":{"a":1},"query":{}}`},
+		{"vendor json", `[1]`, "application/vnd.github+json", nil, `{"body":[1],"query":{}}`},
+		{"invalid json", `{`, "application/json", nil, `{"body":"{","query":{}}`},
+		{"text", `scene=intro`, "application/x-www-form-urlencoded", nil, `{"body":"scene=intro","query":{}}`},
+		{"empty", ``, "", map[string][]string{"scene": {"intro"}, "tag": {"a", "b"}}, `{"body":null,"query":{"scene":"intro","tag":["a","b"]}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input, err := NewInput([]byte(tt.body), tt.contentType, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(input) != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, input)
+			}
+		})
+	}
+}
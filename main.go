@@ -2,16 +2,34 @@ package main
 
 import (
 	"bundeck/internal/api"
+	"bundeck/internal/auth"
+	"bundeck/internal/bundle"
 	"bundeck/internal/db"
+	"bundeck/internal/events"
+	"bundeck/internal/history"
+	"bundeck/internal/macro"
 	"bundeck/internal/plugin"
+	"bundeck/internal/scheduler"
+	"bundeck/internal/secrets"
 	"bundeck/internal/settings"
+	"bundeck/internal/templates"
+	"bundeck/internal/webhooks"
+	"context"
 	"database/sql"
 	"embed"
-	"fmt"
+	"encoding/json"
+	"flag"
 	"io/fs"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
+	_ "time/tzdata"
 
 	"fyne.io/systray"
 	_ "modernc.org/sqlite"
@@ -34,43 +52,239 @@ var macLogo []byte
 //go:embed plugins
 var pluginsEmbedFS embed.FS
 
-var dbPath = "./plugins.db"
+// dbPath and keyPath are in the data directory, keyPath holds the local key
+// used to encrypt secrets at rest
+var (
+	dbPath  = settings.DatabaseFile
+	keyPath = settings.KeyFile
+)
+
+// sched owns all periodic plugin runs and is stopped on exit
+var sched *scheduler.Scheduler
+
+// runner owns the resident plugin processes, which are shut down on exit
+var runner *plugin.Runner
+
+// server, hub, checkpoints and database are stopped in order by shutdown
+var (
+	server      *fiber.App
+	hub         *events.Hub
+	checkpoints *db.Checkpointer
+	database    *sql.DB
+)
+
+// shutdownTimeout is how long shutdown waits for open requests and then for
+// running plugins before terminating them
+const shutdownTimeout = 10 * time.Second
+
+// shutdownOnce makes shutdown idempotent, the tray and signals may both ask
+var shutdownOnce sync.Once
+
+// config holds the effective configuration loaded at startup,
+// settingsManager changes it while the server runs
+var (
+	config          *settings.Config
+	settingsManager *settings.Manager
+)
 
 func onReady() {
-	settings := settings.LoadSettings()
+	initTray(config.Settings)
+
+	server = newApp(config.Settings)
 
-	initTray(settings)
+	// Start server, Listen returns without an error after shutdown
+	if err := listen(config.Settings); err != nil {
+		log.Fatal(err)
+	}
+}
 
+// listenAddress is the address the server listens on
+func listenAddress(settings *settings.Settings) string {
+	return net.JoinHostPort(settings.BindAddress, strconv.Itoa(settings.Port))
+}
+
+// listen serves the deck over HTTPS if a certificate is configured
+func listen(settings *settings.Settings) error {
+	if settings.TLS() {
+		return server.ListenTLS(listenAddress(settings), settings.TLSCertFile, settings.TLSKeyFile)
+	}
+	return server.Listen(listenAddress(settings))
+}
+
+// setLogLevel drops log messages less severe than level
+func setLogLevel(level string) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err == nil {
+		slog.SetLogLoggerLevel(l)
+	}
+}
+
+// openDatabase opens the SQLite database at dbPath without migrating it
+func openDatabase() (*sql.DB, error) {
 	pragmas := "?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=journal_size_limit(200000000)&_pragma=synchronous(NORMAL)&_pragma=foreign_keys(ON)&_pragma=temp_store(MEMORY)&_pragma=cache_size(-16000)"
+	return sql.Open("sqlite", dbPath+pragmas)
+}
+
+// deck holds the stores and services shared by the server and the commands
+type deck struct {
+	store    *db.PluginStore
+	bundles  *bundle.Manager
+	handlers *api.Handlers
+	recorder *history.Recorder
+	devices  *auth.Manager
+}
+
+// applySettings applies the settings that take effect without a restart
+func (d *deck) applySettings(s *settings.Settings) {
+	runner.SetMaxConcurrentRuns(s.MaxConcurrentRuns)
+	runner.SetDefaultTimeout(s.DefaultTimeout())
+	d.recorder.SetRetention(history.Retention{
+		MaxRunsPerPlugin: s.History.MaxRunsPerPlugin,
+		MaxAge:           s.History.MaxAge(),
+	})
+	d.devices.SetOptions(auth.Options{
+		PairingTTL:   s.Auth.PairingTTL(),
+		RemoteAccess: s.Auth.RemoteAccess,
+	})
+	setLogLevel(s.LogLevel)
+}
+
+// openDeck opens and migrates the database and sets up the plugin runner,
+// scheduler and template catalog. Everything it starts is stopped by
+// closeDeck.
+func openDeck(settings *settings.Settings) (*deck, error) {
 	// Initialize SQLite database
-	database, err := sql.Open("sqlite", dbPath+pragmas)
+	var err error
+	database, err = openDatabase()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer database.Close()
 
 	// Initialize database schema
 	if err := db.InitDB(database); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	// Keep the WAL file small with periodic checkpoints
+	checkpoints = db.StartCheckpoints(database, db.CheckpointInterval)
 
 	// Initialize dependencies
 	store := db.NewPluginStore(database)
-	runner, err := plugin.NewRunner()
+	runner, err = plugin.NewRunner()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if settings.BunPath != "" {
+		if err := plugin.SetProgram(plugin.RuntimeBun, settings.BunPath); err != nil {
+			return nil, err
+		}
+	}
+	runs := db.NewRunStore(database)
+	hub = events.NewHub()
+	key, err := secrets.LoadKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	secretManager, err := secrets.NewManager(db.NewSecretStore(database), key)
+	if err != nil {
+		return nil, err
 	}
-	handlers := api.NewHandlers(store, runner)
+	runner.SetSecrets(secretManager)
+	// Macros run their steps in Go instead of starting a process
+	runner.SetTasks(macro.NewExecutor(store, runner))
+	// The history recorder assigns run IDs, so it must observe runs before the hub
+	recorder := history.NewRecorder(runs, history.DefaultRetention)
+	runner.AddObserver(recorder)
+	runner.AddObserver(plugin.NewStateRecorder(store))
+	runner.AddObserver(hub)
+	sched = scheduler.New(store, runner)
+	sched.SetFireStore(db.NewScheduleStore(database))
+	devices := auth.NewManager(db.NewDeviceStore(database))
+	pages := db.NewPageStore(database)
+	bundles := bundle.NewManager(store, pages)
+	hooks := webhooks.NewManager(db.NewWebhookStore(database), secretManager)
+	handlers := api.NewHandlers(store, runner, sched, runs, hub, secretManager, devices, pages, bundles, hooks)
 
-	// Set the plugins filesystem in api package
+	// User template directories take precedence over the embedded templates
 	subFS, err := fs.Sub(pluginsEmbedFS, "plugins")
 	if err != nil {
+		return nil, err
+	}
+	var roots []templates.Root
+	for _, dir := range settings.TemplateDirs {
+		roots = append(roots, templates.Root{Source: dir, FS: os.DirFS(dir)})
+	}
+	roots = append(roots, templates.Root{Source: templates.SourceBuiltin, FS: subFS})
+	api.Templates = templates.NewCatalog(roots...)
+
+	deck := &deck{
+		store:    store,
+		bundles:  bundles,
+		handlers: handlers,
+		recorder: recorder,
+		devices:  devices,
+	}
+	deck.applySettings(settings)
+	return deck, nil
+}
+
+// closeDeck lets running plugins finish or terminates them after
+// shutdownTimeout, stops the schedules and closes the database after a final
+// checkpoint
+func closeDeck() {
+	// Plugins started from the tray or by schedules may still be running
+	if runner != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		runner.Shutdown(ctx)
+		cancel()
+	}
+	if sched != nil {
+		sched.StopAll()
+	}
+
+	if checkpoints != nil {
+		if err := checkpoints.Stop(); err != nil {
+			slog.Error("bundeck: failed to checkpoint the database", "err", err)
+		}
+	}
+	if database != nil {
+		if err := database.Close(); err != nil {
+			slog.Error("bundeck: failed to close the database", "err", err)
+		}
+	}
+}
+
+// newApp opens the deck and sets up the routes. Everything it starts is
+// stopped by shutdown.
+func newApp(settings *settings.Settings) *fiber.App {
+	deck, err := openDeck(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handlers := deck.handlers
+	if settingsManager != nil {
+		settingsManager.OnChange(deck.applySettings)
+	}
+	// The scheduler owns periodic runs, they start with the server
+	if err := sched.StartAll(); err != nil {
 		log.Fatal(err)
 	}
-	api.PluginsFS = subFS
 
 	// Initialize Fiber app
-	app := fiber.New()
+	app := fiber.New(fiber.Config{
+		// Imported bundles carry plugin images
+		BodyLimit: 32 * 1024 * 1024,
+	})
+
+	// Every API route needs a paired device unless it comes from this machine
+	app.Use("/api", handlers.RequireAuth)
+
+	// Pairing and device routes
+	app.Post("/api/auth/pairings", handlers.CreatePairing)
+	app.Post("/api/auth/pair", handlers.Pair)
+	app.Get("/api/auth/me", handlers.GetCurrentDevice)
+	app.Delete("/api/auth/me", handlers.RevokeCurrentDevice)
+	app.Get("/api/devices", handlers.GetDevices)
+	app.Delete("/api/devices/:id", handlers.RevokeDevice)
 
 	// API routes
 	app.Post("/api/plugins", handlers.CreatePlugin)
@@ -80,6 +294,74 @@ func onReady() {
 	app.Put("/api/plugins/:id/code", handlers.UpdatePluginData)
 	app.Delete("/api/plugins/:id", handlers.DeletePlugin)
 	app.Post("/api/plugins/:id/run", handlers.RunPlugin)
+	app.Post("/api/plugins/:id/cancel", handlers.CancelPlugin)
+	app.Get("/api/plugins/:id/runs", handlers.GetPluginRuns)
+	app.Get("/api/plugins/:id/stream", handlers.StreamPlugin)
+	app.Get("/api/plugins/:id/resident", handlers.GetResidentStatus)
+	app.Get("/api/plugins/:id/revisions", handlers.GetPluginRevisions)
+	app.Get("/api/plugins/:id/revisions/diff", handlers.GetPluginRevisionDiff)
+	app.Get("/api/plugins/:id/revisions/:revision", handlers.GetPluginRevision)
+	app.Post("/api/plugins/:id/revisions/:revision/restore", handlers.RestorePluginRevision)
+	app.Get("/api/plugins/:id/variables", handlers.GetPluginVariables)
+	app.Put("/api/plugins/:id/variables", handlers.UpdatePluginVariables)
+
+	// Preset routes, presets are named inputs passed when running a plugin
+	app.Get("/api/plugins/:id/presets", handlers.GetPluginPresets)
+	app.Post("/api/plugins/:id/presets", handlers.CreatePluginPreset)
+	app.Put("/api/plugins/:id/presets/:preset", handlers.UpdatePluginPreset)
+	app.Delete("/api/plugins/:id/presets/:preset", handlers.DeletePluginPreset)
+
+	// Profile and page routes, folders are pages with a parent
+	app.Get("/api/profiles", handlers.GetProfiles)
+	app.Post("/api/profiles", handlers.CreateProfile)
+	app.Get("/api/profiles/active", handlers.GetActiveProfile)
+	app.Put("/api/profiles/:id", handlers.UpdateProfile)
+	app.Delete("/api/profiles/:id", handlers.DeleteProfile)
+	app.Post("/api/profiles/:id/activate", handlers.ActivateProfile)
+	app.Get("/api/profiles/:id/pages", handlers.GetProfilePages)
+	app.Post("/api/pages", handlers.CreatePage)
+	app.Get("/api/pages/:id", handlers.GetPage)
+	app.Put("/api/pages/:id", handlers.UpdatePage)
+	app.Delete("/api/pages/:id", handlers.DeletePage)
+
+	// Schedule routes for continuously running plugins
+	app.Get("/api/schedule", handlers.GetAllScheduleStatus)
+	app.Get("/api/schedule/cron", handlers.PreviewCron)
+	app.Get("/api/plugins/:id/schedule", handlers.GetScheduleStatus)
+	app.Post("/api/plugins/:id/schedule/start", handlers.StartSchedule)
+	app.Post("/api/plugins/:id/schedule/stop", handlers.StopSchedule)
+
+	// Export and import of the whole deck or selected plugins and pages
+	app.Get("/api/export", handlers.ExportDeck)
+	app.Post("/api/import", handlers.ImportDeck)
+
+	// Secret routes, values are write-only
+	app.Get("/api/secrets", handlers.GetSecrets)
+	app.Put("/api/secrets/:name", handlers.PutSecret)
+	app.Delete("/api/secrets/:name", handlers.DeleteSecret)
+	app.Get("/api/plugins/:id/secrets", handlers.GetPluginSecrets)
+	app.Put("/api/plugins/:id/secrets", handlers.UpdatePluginSecrets)
+
+	// Macro routes, macros run other plugins as ordered steps
+	app.Post("/api/macros", handlers.CreateMacro)
+	app.Get("/api/plugins/:id/steps", handlers.GetMacroSteps)
+	app.Put("/api/plugins/:id/steps", handlers.UpdateMacroSteps)
+
+	// Webhook routes, calls to /api/hooks authenticate with the token in the URL
+	app.Get("/api/plugins/:id/webhooks", handlers.GetPluginWebhooks)
+	app.Post("/api/plugins/:id/webhooks", handlers.CreatePluginWebhook)
+	app.Put("/api/webhooks/:id", handlers.UpdateWebhook)
+	app.Post("/api/webhooks/:id/rotate", handlers.RotateWebhook)
+	app.Delete("/api/webhooks/:id", handlers.DeleteWebhook)
+	app.Post("/api/hooks/:token", handlers.CallWebhook)
+
+	// Runtime routes
+	app.Get("/api/runtimes", handlers.GetRuntimes)
+
+	// Configuration and settings routes
+	app.Get("/api/config", handlers.GetConfig)
+	app.Get("/api/settings", handlers.GetSettings)
+	app.Put("/api/settings", handlers.UpdateSettings)
 
 	// Plugin template routes
 	app.Get("/api/plugins/templates", handlers.GetPluginTemplates)
@@ -99,14 +381,111 @@ func onReady() {
 		return c.Send(content)
 	})
 
-	// Start server
-	log.Fatal(app.Listen("0.0.0.0:" + strconv.Itoa(settings.Port)))
+	return app
 }
 
 func onExit() {
-	fmt.Println("closing!")
+	shutdown()
+}
+
+// shutdown stops the server from accepting requests, lets running plugins
+// finish or terminates them after shutdownTimeout, and closes the database
+// after a final checkpoint
+func shutdown() {
+	shutdownOnce.Do(func() {
+		slog.Info("bundeck: shutting down")
+
+		// Event streams stay open until closed and would hold up the server
+		if hub != nil {
+			hub.Close()
+		}
+		if server != nil {
+			if err := server.ShutdownWithTimeout(shutdownTimeout); err != nil {
+				slog.Error("bundeck: failed to stop the server", "err", err)
+			}
+		}
+
+		closeDeck()
+		slog.Info("bundeck: stopped")
+	})
+}
+
+// logConfig reports the effective configuration and where each value
+// came from
+func logConfig(config *settings.Config) {
+	slog.Info("bundeck: data directory", "path", config.DataDir, "source", config.Sources[settings.DataDirKey])
+
+	var values map[string]any
+	data, err := json.Marshal(config.Settings)
+	if err == nil {
+		err = json.Unmarshal(data, &values)
+	}
+	if err != nil {
+		slog.Warn("bundeck: failed to report the settings", "err", err)
+		return
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		slog.Info("bundeck: setting", "key", key, "value", values[key], "source", config.Sources[key])
+	}
 }
 
 func main() {
+	settings.RegisterFlags(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
+
+	// The server flags may also follow serve, other commands run once and exit
+	args := flag.Args()
+	serve := len(args) == 0 || args[0] == "serve"
+	if serve && len(args) > 0 {
+		flag.CommandLine.Parse(args[1:])
+		if flag.NArg() > 0 {
+			os.Exit(exitCode(&usageError{"serve takes no arguments"}))
+		}
+	}
+
+	var err error
+	if config, err = settings.Load(flag.CommandLine); err != nil {
+		os.Exit(exitCode(err))
+	}
+	dbPath = config.DatabasePath
+	keyPath = config.KeyPath
+	setLogLevel(config.Settings.LogLevel)
+	if err := config.FileError(); err != nil {
+		slog.Error("bundeck: using the default settings", "err", err)
+	}
+	settingsManager = settings.NewManager(config)
+	api.Settings = settingsManager
+
+	if !serve {
+		if err := runCommand(args, os.Stdout); err != nil {
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+	logConfig(config)
+
+	if pidFile := config.Settings.PIDFile; pidFile != "" {
+		if err := writePIDFile(pidFile); err != nil {
+			log.Fatal(err)
+		}
+		defer os.Remove(pidFile)
+	}
+
+	if config.Settings.Headless {
+		if err := runHeadless(config.Settings); err != nil {
+			// os.Exit skips the deferred removal of the PID file
+			if pidFile := config.Settings.PIDFile; pidFile != "" {
+				os.Remove(pidFile)
+			}
+			os.Exit(exitCode(err))
+		}
+		return
+	}
 	systray.Run(onReady, onExit)
 }
@@ -2,16 +2,22 @@ package main
 
 import (
 	"bundeck/internal/api"
+	"bundeck/internal/bundle"
 	"bundeck/internal/settings"
+	"bundeck/internal/templates"
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -61,7 +67,7 @@ func TestMainIntegration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to create sub filesystem: %v", err)
 	}
-	api.PluginsFS = subFS
+	api.Templates = templates.NewCatalog(templates.Root{Source: templates.SourceBuiltin, FS: subFS})
 
 	// Start the server in a goroutine
 	go func() {
@@ -209,9 +215,19 @@ func TestSettingsLoading(t *testing.T) {
 	// Create a temporary settings file
 	settingsFile := "settings.json"
 	defer os.Remove(settingsFile)
+	defer func() {
+		// Settings files without a version are backed up when migrated
+		backups, _ := filepath.Glob(settingsFile + ".*.bak")
+		for _, backup := range backups {
+			os.Remove(backup)
+		}
+	}()
 
 	// Test default settings
-	s := settings.LoadSettings()
+	s, err := settings.LoadSettings(settingsFile)
+	if err != nil {
+		t.Fatalf("Failed to load settings: %v", err)
+	}
 	if s.Port != 3004 {
 		t.Errorf("Expected default port 3004, got %d", s.Port)
 	}
@@ -230,7 +246,10 @@ func TestSettingsLoading(t *testing.T) {
 		t.Fatalf("Failed to write settings file: %v", err)
 	}
 
-	loadedSettings := settings.LoadSettings()
+	loadedSettings, err := settings.LoadSettings(settingsFile)
+	if err != nil {
+		t.Fatalf("Failed to load settings: %v", err)
+	}
 	if loadedSettings.Port != 8080 {
 		t.Errorf("Expected port 8080, got %d", loadedSettings.Port)
 	}
@@ -263,3 +282,172 @@ func TestServerStartup(t *testing.T) {
 		t.Fatal("Server failed to start within timeout")
 	}
 }
+
+func TestWritePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundeck.pid")
+	if err := os.WriteFile(path, []byte("99999\n"), 0644); err != nil {
+		t.Fatalf("Failed to write stale PID file: %v", err)
+	}
+
+	if err := writePIDFile(path); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read PID file: %v", err)
+	}
+	if want := fmt.Sprintf("%d\n", os.Getpid()); string(data) != want {
+		t.Errorf("Expected PID file %q, got %q", want, data)
+	}
+
+	if err := writePIDFile(filepath.Join(path, "missing", "bundeck.pid")); err == nil {
+		t.Error("Expected an error for a directory that does not exist")
+	}
+}
+
+// setupCommands points the commands at a database in a temporary directory
+func setupCommands(t *testing.T) string {
+	dir := t.TempDir()
+	dbPath = filepath.Join(dir, "plugins.db")
+	keyPath = filepath.Join(dir, "secrets.key")
+	config = &settings.Config{Settings: settings.Defaults()}
+	t.Cleanup(func() {
+		dbPath = settings.DatabaseFile
+		keyPath = settings.KeyFile
+	})
+	return dir
+}
+
+func TestCommands(t *testing.T) {
+	dir := setupCommands(t)
+	command := func(args ...string) (string, error) {
+		var stdout bytes.Buffer
+		err := runCommand(args, &stdout)
+		return stdout.String(), err
+	}
+
+	t.Run("Migrate", func(t *testing.T) {
+		out, err := command("migrate")
+		if err != nil || !strings.Contains(out, "from version 0") {
+			t.Fatalf("Expected the database to be migrated, got %q, %v", out, err)
+		}
+		if out, err := command("migrate"); err != nil || !strings.Contains(out, "up to date") {
+			t.Errorf("Expected the database to be up to date, got %q, %v", out, err)
+		}
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		path := filepath.Join(dir, "deck.json")
+		deck := `{
+			"format": "bundeck-bundle",
+			"version": 1,
+			"profiles": [{"id": 1, "name": "Default", "active": true}],
+			"pages": [{"id": 1, "profile_id": 1, "name": "Home"}],
+			"plugins": [{"id": 1, "page_id": 1, "name": "Echo", "runtime": "shell", "code": "read input; echo \"got $input\"; exit 3"}]
+		}`
+		if err := os.WriteFile(path, []byte(deck), 0644); err != nil {
+			t.Fatalf("Failed to write bundle: %v", err)
+		}
+		out, err := command("import", "--mode", "replace", path)
+		if err != nil || !strings.Contains(out, "Imported 1 plugins") {
+			t.Fatalf("Expected the plugin to be imported, got %q, %v", out, err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		// Listing only reads the store, no runner is set up
+		runner = nil
+		out, err := command("list", "--json")
+		if err != nil {
+			t.Fatalf("Failed to list plugins: %v", err)
+		}
+		var listed []listedPlugin
+		if err := json.Unmarshal([]byte(out), &listed); err != nil {
+			t.Fatalf("Failed to decode plugins: %v", err)
+		}
+		if len(listed) != 1 || listed[0].Name != "Echo" || listed[0].Runtime != "shell" {
+			t.Errorf("Unexpected plugins: %+v", listed)
+		}
+		if runner != nil {
+			t.Error("Expected list to leave the runner alone")
+		}
+	})
+
+	t.Run("Run", func(t *testing.T) {
+		if _, err := exec.LookPath("sh"); err != nil {
+			t.Skip("sh is not installed")
+		}
+		out, err := command("run", "--input", `{"a":1}`, "Echo")
+		if strings.TrimSpace(out) != `got {"a":1}` {
+			t.Errorf("Expected the plugin output, got %q", out)
+		}
+		var failed *exitError
+		if !errors.As(err, &failed) || failed.code != 3 {
+			t.Errorf("Expected the exit code of the plugin, got %v", err)
+		}
+		if _, err := command("run", "Missing"); err == nil {
+			t.Error("Expected an error for an unknown plugin")
+		}
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		out, err := command("create", "--from-template", "obs-scene-switch", "--var", `BUNDECK_DEVICES=["Cam"]`)
+		if err != nil || !strings.Contains(out, "Created plugin 2") {
+			t.Fatalf("Expected the plugin to be created, got %q, %v", out, err)
+		}
+		if _, err := command("create", "--from-template", "obs-scene-switch", "--var", "BUNDECK_DEVICES=Cam"); err == nil {
+			t.Error("Expected an error for an invalid variable")
+		}
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		path := filepath.Join(dir, "export.zip")
+		if _, err := command("export", "--plugins", "1", "-o", path); err != nil {
+			t.Fatalf("Failed to export: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read export: %v", err)
+		}
+		b, err := bundle.Read(data)
+		if err != nil {
+			t.Fatalf("Failed to read bundle: %v", err)
+		}
+		if len(b.Plugins) != 1 || b.Plugins[0].Name != "Echo" {
+			t.Errorf("Expected only the selected plugin, got %+v", b.Plugins)
+		}
+	})
+
+	t.Run("Backup", func(t *testing.T) {
+		path := filepath.Join(dir, "backup.db")
+		if _, err := command("db", "backup", path); err != nil {
+			t.Fatalf("Failed to back up: %v", err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Errorf("Expected a backup file, got %v", err)
+		}
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		out, err := command("config")
+		if err != nil {
+			t.Fatalf("Failed to print config: %v", err)
+		}
+		var printed settings.Config
+		if err := json.Unmarshal([]byte(out), &printed); err != nil {
+			t.Fatalf("Failed to decode config: %v", err)
+		}
+		if printed.Settings.MaxConcurrentRuns != settings.DefaultMaxConcurrentRuns {
+			t.Errorf("Unexpected config: %+v", printed)
+		}
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		var usage *usageError
+		for _, args := range [][]string{{"unknown"}, {"run"}, {"db", "restore"}, {"run", "--input", "{", "Echo"}} {
+			if _, err := command(args...); !errors.As(err, &usage) {
+				t.Errorf("Expected a usage error for %v, got %v", args, err)
+			}
+		}
+	})
+}
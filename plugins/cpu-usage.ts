@@ -3,6 +3,7 @@ import { cpu } from "node-os-utils";
 try {
   const initialPercentage = await cpu.usage();
   console.log(`CPU Usage: ${initialPercentage}%`);
+  console.log(`::bundeck::${JSON.stringify({ badge: `${initialPercentage}%` })}`);
 } catch (error) {
   console.error("Error getting initial CPU usage:", error);
 }
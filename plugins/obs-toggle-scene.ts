@@ -2,7 +2,7 @@ import OBSWebSocket from "obs-websocket-bun";
 const obs = new OBSWebSocket();
 
 const BUNDECK_DEVICES = ["~~Webcam", "~~WebcamCS"];
-const BUNDECK_OBS_PASSWORD = "password";
+const BUNDECK_OBS_PASSWORD = process.env.BUNDECK_OBS_PASSWORD;
 const BUNDECK_OBS_PORT = 4455;
 const BUNDECK_ENABLE_LOGGING = false;
 
@@ -34,17 +34,22 @@ func initTray(settings *settings.Settings) {
 		systray.Quit()
 	}()
 
+	scheme := "http"
+	if settings.TLS() {
+		scheme = "https"
+	}
+
 	go func() {
 		<-qr.ClickedCh
 		ip := GetOutboundIP().To4().String()
-		qrUrl := fmt.Sprintf("http://%s:%d", ip, settings.Port)
-		fullUrl := fmt.Sprintf("http://localhost:%d/qr/%s", settings.Port, url.PathEscape(qrUrl))
+		qrUrl := fmt.Sprintf("%s://%s:%d", scheme, ip, settings.Port)
+		fullUrl := fmt.Sprintf("%s://localhost:%d/qr/%s", scheme, settings.Port, url.PathEscape(qrUrl))
 		openURL(fullUrl)
 	}()
 
 	go func() {
 		<-browser.ClickedCh
-		openURL(fmt.Sprintf("http://localhost:%d", settings.Port))
+		openURL(fmt.Sprintf("%s://localhost:%d", scheme, settings.Port))
 	}()
 }
 
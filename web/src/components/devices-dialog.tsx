@@ -0,0 +1,97 @@
+import { Button } from '@/components/ui/button';
+import {
+  Dialog,
+  DialogContent,
+  DialogDescription,
+  DialogHeader,
+  DialogTitle,
+} from '@/components/ui/dialog';
+import { useToast } from '@/hooks/use-toast';
+import type { Device } from '@/types/auth';
+import { useMutation, useQuery, useQueryClient } from '@tanstack/react-query';
+import { Loader2, TrashIcon } from 'lucide-react';
+
+interface DevicesDialogProps {
+  isOpen: boolean;
+  onOpenChange: (open: boolean) => void;
+}
+
+export function DevicesDialog({ isOpen, onOpenChange }: DevicesDialogProps) {
+  const { toast } = useToast();
+  const queryClient = useQueryClient();
+
+  const { data: devices, isLoading } = useQuery({
+    queryKey: ['devices'],
+    queryFn: async () => {
+      const response = await fetch('/api/devices');
+      if (!response.ok) {
+        throw new Error('Failed to fetch devices');
+      }
+      return (await response.json()) as Device[];
+    },
+    enabled: isOpen,
+  });
+
+  const { mutate: revoke } = useMutation({
+    mutationFn: async (id: number) => {
+      const response = await fetch(`/api/devices/${id}`, { method: 'DELETE' });
+      if (!response.ok) {
+        throw new Error('Failed to revoke device');
+      }
+    },
+    onSuccess: () => {
+      queryClient.invalidateQueries({ queryKey: ['devices'] });
+    },
+    onError: (error) => {
+      toast({
+        title: 'Error',
+        description: error.message,
+        variant: 'destructive',
+      });
+    },
+  });
+
+  return (
+    <Dialog open={isOpen} onOpenChange={onOpenChange}>
+      <DialogContent>
+        <DialogHeader>
+          <DialogTitle>Paired Devices</DialogTitle>
+          <DialogDescription>
+            Devices paired with the tray QR code. Revoking a device signs it out
+            immediately.
+          </DialogDescription>
+        </DialogHeader>
+        {isLoading && <Loader2 className='animate-spin' />}
+        {devices?.length === 0 && (
+          <p className='text-sm text-muted-foreground'>No paired devices.</p>
+        )}
+        <ul className='flex flex-col gap-2'>
+          {devices?.map((device) => (
+            <li
+              key={device.id}
+              className='flex items-center justify-between gap-2'
+            >
+              <div>
+                <p className='font-medium'>{device.name}</p>
+                <p className='text-xs text-muted-foreground'>
+                  {device.role === 'admin' ? 'Full access' : 'Press only'} ·
+                  last seen{' '}
+                  {device.last_seen_at
+                    ? new Date(device.last_seen_at).toLocaleString()
+                    : 'never'}
+                </p>
+              </div>
+              <Button
+                variant='outline'
+                size='icon'
+                onClick={() => revoke(device.id)}
+              >
+                <TrashIcon />
+              </Button>
+            </li>
+          ))}
+        </ul>
+      </DialogContent>
+    </Dialog>
+  );
+}
@@ -0,0 +1,185 @@
+import { Button } from '@/components/ui/button';
+import {
+  Dialog,
+  DialogContent,
+  DialogDescription,
+  DialogFooter,
+  DialogHeader,
+  DialogTitle,
+} from '@/components/ui/dialog';
+import { Input } from '@/components/ui/input';
+import { Label } from '@/components/ui/label';
+import { useToast } from '@/hooks/use-toast';
+import type {
+  ConflictResolution,
+  ImportMode,
+  ImportReport,
+} from '@/types/bundle';
+import { useMutation } from '@tanstack/react-query';
+import { useRouter } from '@tanstack/react-router';
+import { Loader2 } from 'lucide-react';
+import { useState } from 'react';
+
+interface ImportDialogProps {
+  isOpen: boolean;
+  onOpenChange: (open: boolean) => void;
+}
+
+const selectClassName =
+  'flex h-9 w-full rounded-md border border-input bg-transparent px-3 py-1 text-sm shadow-sm';
+
+export function ImportDialog({ isOpen, onOpenChange }: ImportDialogProps) {
+  const { toast } = useToast();
+  const router = useRouter();
+  const [file, setFile] = useState<File | null>(null);
+  const [mode, setMode] = useState<ImportMode>('merge');
+  const [onConflict, setOnConflict] = useState<ConflictResolution>('skip');
+  const [preview, setPreview] = useState<ImportReport | null>(null);
+
+  const { mutate: runImport, isPending } = useMutation({
+    mutationFn: async (dryRun: boolean) => {
+      if (!file) {
+        throw new Error('Select a bundle first');
+      }
+      const formData = new FormData();
+      formData.append('bundle', file);
+      const params = new URLSearchParams({
+        mode,
+        on_conflict: onConflict,
+        dry_run: dryRun.toString(),
+      });
+      const response = await fetch(`/api/import?${params}`, {
+        method: 'POST',
+        body: formData,
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(data.error);
+      }
+      return data as ImportReport;
+    },
+    onSuccess: (report) => {
+      if (report.dry_run) {
+        setPreview(report);
+        return;
+      }
+      toast({
+        title: 'Success',
+        description: `Imported ${report.plugins_created} plugins`,
+      });
+      setPreview(null);
+      setFile(null);
+      onOpenChange(false);
+      router.invalidate();
+    },
+    onError: (error) => {
+      toast({
+        title: 'Error',
+        description: error.message,
+        variant: 'destructive',
+      });
+    },
+  });
+
+  return (
+    <Dialog open={isOpen} onOpenChange={onOpenChange}>
+      <DialogContent>
+        <DialogHeader>
+          <DialogTitle>Import Deck</DialogTitle>
+          <DialogDescription>
+            Import a bundle exported from BunDeck, as JSON or zip file.
+          </DialogDescription>
+        </DialogHeader>
+        <div className='grid gap-4'>
+          <div className='grid gap-2'>
+            <Label htmlFor='bundle'>Bundle</Label>
+            <Input
+              id='bundle'
+              type='file'
+              accept='.json,.zip,application/json,application/zip'
+              onChange={(e) => {
+                setFile(e.target.files?.[0] ?? null);
+                setPreview(null);
+              }}
+            />
+          </div>
+          <div className='grid grid-cols-2 gap-4'>
+            <div className='grid gap-2'>
+              <Label htmlFor='mode'>Mode</Label>
+              <select
+                id='mode'
+                className={selectClassName}
+                value={mode}
+                onChange={(e) => {
+                  setMode(e.target.value as ImportMode);
+                  setPreview(null);
+                }}
+              >
+                <option value='merge'>Merge with this deck</option>
+                <option value='replace'>Replace this deck</option>
+              </select>
+            </div>
+            <div className='grid gap-2'>
+              <Label htmlFor='on-conflict'>Existing names</Label>
+              <select
+                id='on-conflict'
+                className={selectClassName}
+                value={onConflict}
+                disabled={mode === 'replace'}
+                onChange={(e) => {
+                  setOnConflict(e.target.value as ConflictResolution);
+                  setPreview(null);
+                }}
+              >
+                <option value='skip'>Skip plugin</option>
+                <option value='rename'>Import renamed</option>
+              </select>
+            </div>
+          </div>
+          {preview && (
+            <div className='rounded-md border p-3 text-sm'>
+              <p>
+                {preview.plugins_created} plugins, {preview.pages_created}{' '}
+                pages and {preview.profiles_created} profiles will be added.
+              </p>
+              {preview.plugins_removed > 0 && (
+                <p className='text-destructive'>
+                  {preview.plugins_removed} existing plugins will be deleted.
+                </p>
+              )}
+              {preview.conflicts.length > 0 && (
+                <ul className='mt-2 list-disc pl-4'>
+                  {preview.conflicts.map((conflict) => (
+                    <li key={`${conflict.page}/${conflict.plugin}`}>
+                      "{conflict.plugin}" already exists on {conflict.page}
+                      {conflict.renamed_to
+                        ? `, imported as "${conflict.renamed_to}"`
+                        : ', skipped'}
+                    </li>
+                  ))}
+                </ul>
+              )}
+            </div>
+          )}
+        </div>
+        <DialogFooter>
+          <Button
+            variant='outline'
+            disabled={!file || isPending}
+            onClick={() => runImport(true)}
+          >
+            Preview
+          </Button>
+          <Button
+            variant={mode === 'replace' ? 'destructive' : 'default'}
+            disabled={!file || isPending}
+            onClick={() => runImport(false)}
+          >
+            {isPending && <Loader2 className='animate-spin' />}
+            Import
+          </Button>
+        </DialogFooter>
+      </DialogContent>
+    </Dialog>
+  );
+}
@@ -27,6 +27,11 @@ import {
 } from '@/components/ui/form';
 import { Input } from '@/components/ui/input';
 import { useToast } from '@/hooks/use-toast';
+import type {
+  PluginTemplate,
+  Variable,
+  VariableValue,
+} from '@/types/template';
 import { zodResolver } from '@hookform/resolvers/zod';
 import { useMutation, useQuery } from '@tanstack/react-query';
 import { useRouter } from '@tanstack/react-router';
@@ -38,23 +43,6 @@ import { useForm } from 'react-hook-form';
 import { z } from 'zod';
 import { Badge } from '../ui/badge';
 
-interface Variable {
-  type: string;
-  default: string | number | string[] | boolean | boolean[] | number[];
-  description: string;
-  label: string;
-}
-
-interface PluginTemplate {
-  id: string;
-  title: string;
-  description: string;
-  file: string;
-  category: string;
-  label: string;
-  variables: Record<string, Variable>;
-}
-
 interface CategorizedTemplates {
   [category: string]: {
     plugins: PluginTemplate[];
@@ -65,19 +53,12 @@ interface AddPluginDialogProps {
   isOpen: boolean;
   onOpenChange: (open: boolean) => void;
   onSave: () => void;
+  // pageId is the page the new plugin is added to
+  pageId?: number;
 }
 
-// Define a more precise variable value type
-type VariableValue =
-  | string
-  | number
-  | boolean
-  | string[]
-  | number[]
-  | boolean[];
-
 // Define our form values type using z.infer
-type FormValues = z.infer<typeof formSchema>;
+export type FormValues = z.infer<typeof formSchema>;
 
 const variableSchema = z.union([
   z.string(),
@@ -88,7 +69,7 @@ const variableSchema = z.union([
   z.array(z.boolean()),
 ]);
 
-const formSchema = z.object({
+export const formSchema = z.object({
   templateId: z.string().min(1),
   variables: z.record(variableSchema),
   run_continuously: z.boolean().default(false),
@@ -96,7 +77,7 @@ const formSchema = z.object({
 });
 
 // Helper function to determine the input type based on the variable type
-function getInputComponent(
+export function getInputComponent(
   variable: Variable,
   control: Control<FormValues>,
   name: `variables.${string}`, // Make it a template literal type
@@ -146,7 +127,17 @@ function getInputComponent(
       name={name}
       label={label}
       description={variable.description}
-      type={variable.type === 'number' ? 'number' : 'text'}
+      type={
+        variable.type === 'number'
+          ? 'number'
+          : variable.type === 'secret' || variable.secret
+            ? 'password'
+            : 'text'
+      }
+      required={variable.required}
+      {...(variable.type === 'number'
+        ? { min: variable.min, max: variable.max }
+        : { minLength: variable.min, maxLength: variable.max })}
     />
   );
 }
@@ -155,6 +146,7 @@ export function AddPluginDialog({
   isOpen,
   onOpenChange,
   onSave,
+  pageId,
 }: AddPluginDialogProps) {
   const { toast } = useToast();
   const router = useRouter();
@@ -257,11 +249,18 @@ export function AddPluginDialog({
           ...values,
           run_continuously: values.run_continuously,
           interval_seconds: values.interval_seconds,
+          pageId,
         }),
       });
 
       if (!response.ok) {
         const error = await response.json();
+        // Show the reason for each rejected variable next to its field
+        for (const [key, message] of Object.entries(
+          (error.fields ?? {}) as Record<string, string>,
+        )) {
+          form.setError(`variables.${key}`, { message: `Value ${message}` });
+        }
         throw new Error(error.error || 'Failed to create plugin');
       }
 
@@ -382,6 +381,13 @@ export function AddPluginDialog({
                                   <div className='text-sm text-muted-foreground'>
                                     {parse(template.description)}
                                   </div>
+                                  {template.source !== 'builtin' && (
+                                    <div className='text-xs text-muted-foreground'>
+                                      From {template.source}
+                                      {template.overrides &&
+                                        `, replaces the ${template.overrides} template`}
+                                    </div>
+                                  )}
                                 </div>
                                 {template.label && (
                                   <Badge
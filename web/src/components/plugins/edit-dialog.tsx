@@ -9,11 +9,20 @@ import {
   DialogTitle,
 } from '@/components/ui/dialog';
 import { useToast } from '@/hooks/use-toast';
-import type { Plugin } from '@/types/plugin';
+import type { ActiveProfile } from '@/types/page';
+import type { Plugin, Runtime, RuntimeStatus } from '@/types/plugin';
 import { zodResolver } from '@hookform/resolvers/zod';
 import { useMutation, useQuery } from '@tanstack/react-query';
 import { useRouter } from '@tanstack/react-router';
-import { ImageIcon, Loader2 } from 'lucide-react';
+import {
+  BookmarkIcon,
+  HistoryIcon,
+  ImageIcon,
+  ListOrderedIcon,
+  Loader2,
+  SlidersIcon,
+  WebhookIcon,
+} from 'lucide-react';
 import { useEffect, useRef, useState } from 'react';
 import { useForm } from 'react-hook-form';
 import { z } from 'zod';
@@ -28,20 +37,65 @@ import {
   FormLabel,
 } from '../ui/form';
 import { Input } from '../ui/input';
+import { MacroDialog } from './macro-dialog';
+import { PresetsDialog } from './presets-dialog';
+import { RevisionsDialog } from './revisions-dialog';
+import { VariablesDialog } from './variables-dialog';
+import { WebhooksDialog } from './webhooks-dialog';
 
 interface EditPluginDialogProps {
   plugin?: Plugin;
   isOpen: boolean;
   onOpenChange: (open: boolean) => void;
   onSave: () => void;
+  // pageId is the page new plugins are added to
+  pageId?: number;
 }
 
+// movePlugin puts a plugin at the end of another page
+async function movePlugin(plugin: Plugin, pageId: number) {
+  const response = await fetch('/api/plugins/reorder', {
+    method: 'PUT',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify([
+      { id: plugin.id, order_num: 999, page_id: pageId },
+    ]),
+  });
+  if (!response.ok) {
+    const data = await response.json();
+    throw new Error(data.error);
+  }
+}
+
+// editorLanguages highlights the code of each runtime in the editor
+const editorLanguages: Record<Runtime, string> = {
+  bun: 'typescript',
+  node: 'javascript',
+  deno: 'typescript',
+  shell: 'shell',
+  python: 'python',
+  executable: 'plaintext',
+};
+
 const schema = z.object({
   name: z.string().min(1),
-  code: z.string().min(1),
+  // Macros run steps instead of code
+  code: z.string(),
   image: z.instanceof(File).optional(),
   run_continuously: z.boolean().default(false),
   interval_seconds: z.coerce.number().min(0).default(0),
+  cron_expression: z.string().default(''),
+  time_zone: z.string().default(''),
+  missed_run_policy: z.enum(['skip', 'run_once']).default('skip'),
+  timeout_seconds: z.coerce.number().min(0).default(0),
+  concurrency_policy: z
+    .enum(['parallel', 'skip', 'queue', 'restart'])
+    .default('parallel'),
+  runtime: z
+    .enum(['bun', 'node', 'deno', 'shell', 'python', 'executable'])
+    .default('bun'),
+  resident: z.boolean().default(false),
+  page_id: z.coerce.number().optional(),
 });
 
 export function EditPluginDialog({
@@ -49,6 +103,7 @@ export function EditPluginDialog({
   isOpen,
   onOpenChange,
   onSave,
+  pageId,
 }: EditPluginDialogProps) {
   const defaultValues = {
     name: plugin?.name ?? '',
@@ -62,11 +117,24 @@ console.log(uuidv4());`,
     image: undefined,
     run_continuously: plugin?.run_continuously ?? false,
     interval_seconds: plugin?.interval_seconds ?? 0,
+    cron_expression: plugin?.cron_expression ?? '',
+    time_zone: plugin?.time_zone ?? '',
+    missed_run_policy: plugin?.missed_run_policy || 'skip',
+    timeout_seconds: plugin?.timeout_seconds ?? 0,
+    concurrency_policy: plugin?.concurrency_policy || 'parallel',
+    runtime: plugin?.runtime || 'bun',
+    resident: plugin?.resident ?? false,
   };
   const router = useRouter();
   const { toast } = useToast();
   const [selectedImage, setSelectedImage] = useState<File | null>(null);
   const [previewUrl, setPreviewUrl] = useState<string | null>(null);
+  const [isHistoryOpen, setIsHistoryOpen] = useState(false);
+  const [isVariablesOpen, setIsVariablesOpen] = useState(false);
+  const [isWebhooksOpen, setIsWebhooksOpen] = useState(false);
+  const [isStepsOpen, setIsStepsOpen] = useState(false);
+  const [isPresetsOpen, setIsPresetsOpen] = useState(false);
+  const isMacro = plugin?.kind === 'macro';
   const fileInputRef = useRef<HTMLInputElement>(null);
 
   const form = useForm<z.infer<typeof schema>>({
@@ -74,6 +142,59 @@ console.log(uuidv4());`,
     defaultValues,
   });
   const run_continuously = form.watch('run_continuously');
+  const cron_expression = form.watch('cron_expression');
+  const time_zone = form.watch('time_zone');
+  const runtime = form.watch('runtime');
+
+  // Only installed runtimes are offered, plus the plugin's own
+  const { data: runtimes } = useQuery({
+    queryKey: ['runtimes'],
+    queryFn: async () => {
+      const response = await fetch('/api/runtimes');
+      if (!response.ok) {
+        throw new Error('Failed to fetch runtimes');
+      }
+      return (await response.json()) as RuntimeStatus[];
+    },
+    enabled: isOpen && !isMacro,
+  });
+  const offeredRuntimes = (runtimes ?? []).filter(
+    (rt) => rt.available || rt.id === plugin?.runtime,
+  );
+  const canBeResident =
+    runtimes?.find((rt) => rt.id === runtime)?.resident ?? true;
+
+  // Upcoming runs of the cron expression, or why it is invalid
+  const { data: cronPreview } = useQuery({
+    queryKey: ['cron-preview', cron_expression, time_zone],
+    queryFn: async () => {
+      const params = new URLSearchParams({
+        expression: cron_expression,
+        time_zone,
+        count: '3',
+      });
+      const response = await fetch(`/api/schedule/cron?${params}`);
+      const data = await response.json();
+      if (!response.ok) {
+        return { error: data.error as string, next_runs: [] as string[] };
+      }
+      return { error: '', next_runs: data.next_runs as string[] };
+    },
+    enabled: isOpen && cron_expression.trim() !== '',
+  });
+
+  // Pages of the active profile the plugin can be moved to
+  const { data: activeProfile } = useQuery({
+    queryKey: ['active-profile'],
+    queryFn: async () => {
+      const response = await fetch('/api/profiles/active');
+      if (!response.ok) {
+        throw new Error('Failed to fetch pages');
+      }
+      return (await response.json()) as ActiveProfile;
+    },
+    enabled: isOpen && !!plugin,
+  });
 
   const { data: image, isSuccess: imageLoaded } = useQuery({
     queryKey: ['plugin-image', plugin?.id],
@@ -108,6 +229,14 @@ console.log(uuidv4());`,
         code: plugin.code,
         run_continuously: plugin.run_continuously,
         interval_seconds: plugin.interval_seconds,
+        cron_expression: plugin.cron_expression,
+        time_zone: plugin.time_zone,
+        missed_run_policy: plugin.missed_run_policy || 'skip',
+        timeout_seconds: plugin.timeout_seconds,
+        concurrency_policy: plugin.concurrency_policy || 'parallel',
+        runtime: plugin.runtime || 'bun',
+        resident: plugin.resident,
+        page_id: plugin.page_id,
       });
       // Do not clear image state when editing an existing plugin.
     } else {
@@ -121,6 +250,13 @@ console.log(uuidv4());`,
         image: undefined,
         run_continuously: false,
         interval_seconds: 0,
+        cron_expression: '',
+        time_zone: '',
+        missed_run_policy: 'skip',
+        timeout_seconds: 0,
+        concurrency_policy: 'parallel',
+        runtime: 'bun',
+        resident: false,
       });
       setPreviewUrl(null);
       setSelectedImage(null);
@@ -178,6 +314,16 @@ console.log(uuidv4());`,
       formData.append('code', values.code);
       formData.append('run_continuously', values.run_continuously.toString());
       formData.append('interval_seconds', values.interval_seconds.toString());
+      formData.append('cron_expression', values.cron_expression);
+      formData.append('time_zone', values.time_zone);
+      formData.append('missed_run_policy', values.missed_run_policy);
+      formData.append('timeout_seconds', values.timeout_seconds.toString());
+      formData.append('concurrency_policy', values.concurrency_policy);
+      formData.append('runtime', values.runtime);
+      formData.append(
+        'resident',
+        (values.resident && canBeResident).toString(),
+      );
 
       if (selectedImage) {
         formData.append('image', selectedImage);
@@ -194,11 +340,17 @@ console.log(uuidv4());`,
         if (data.error) {
           throw new Error(data.error);
         }
+        if (values.page_id && values.page_id !== plugin.page_id) {
+          await movePlugin(plugin, values.page_id);
+        }
         return data;
       }
 
       // Create new plugin
       formData.append('order_num', '999'); // Will be last in order
+      if (pageId) {
+        formData.append('page_id', pageId.toString());
+      }
       const response = await fetch('/api/plugins', {
         method: 'POST',
         body: formData,
@@ -228,6 +380,10 @@ console.log(uuidv4());`,
   });
 
   function onSubmit(values: z.infer<typeof schema>) {
+    if (!isMacro && values.code === '') {
+      form.setError('code', { message: 'Code is required' });
+      return;
+    }
     mutate(values);
   }
 
@@ -315,7 +471,31 @@ console.log(uuidv4());`,
                       <div className='space-y-1 leading-none'>
                         <FormLabel>Run Continuously</FormLabel>
                         <FormDescription>
-                          Run this plugin at regular intervals
+                          Run this plugin on an interval or cron schedule
+                        </FormDescription>
+                      </div>
+                    </FormItem>
+                  )}
+                />
+
+                <FormField
+                  control={form.control}
+                  name='resident'
+                  render={({ field }) => (
+                    <FormItem className='flex flex-row items-start space-x-3 space-y-0 rounded-md border p-4'>
+                      <FormControl>
+                        <Checkbox
+                          checked={field.value && canBeResident}
+                          disabled={!canBeResident}
+                          onCheckedChange={field.onChange}
+                        />
+                      </FormControl>
+                      <div className='space-y-1 leading-none'>
+                        <FormLabel>Keep Running Between Presses</FormLabel>
+                        <FormDescription>
+                          {canBeResident
+                            ? 'Start the plugin once and call its bundeck.onPress handler on every press'
+                            : 'Only JavaScript runtimes can keep running between presses'}
                         </FormDescription>
                       </div>
                     </FormItem>
@@ -342,18 +522,241 @@ console.log(uuidv4());`,
                     </FormItem>
                   )}
                 />
-              </div>
 
-              <div className='h-[400px]'>
-                <FormEditor
+                <FormField
                   control={form.control}
-                  name='code'
-                  description='Your plugin code'
-                  containerClassName='h-[400px]'
+                  name='cron_expression'
+                  render={({ field }) => (
+                    <FormItem>
+                      <FormLabel>Cron Schedule</FormLabel>
+                      <FormControl>
+                        <Input placeholder='0 9 * * MON-FRI' {...field} />
+                      </FormControl>
+                      <FormDescription>
+                        {!cron_expression.trim()
+                          ? 'Run at set times instead of every interval (minute hour day month weekday)'
+                          : cronPreview?.error
+                            ? cronPreview.error
+                            : `Next runs: ${(cronPreview?.next_runs ?? [])
+                                .map((run) => new Date(run).toLocaleString())
+                                .join(', ')}`}
+                      </FormDescription>
+                    </FormItem>
+                  )}
                 />
+
+                {run_continuously && cron_expression.trim() && (
+                  <>
+                    <FormField
+                      control={form.control}
+                      name='time_zone'
+                      render={({ field }) => (
+                        <FormItem>
+                          <FormLabel>Time Zone</FormLabel>
+                          <FormControl>
+                            <Input placeholder='Europe/Berlin' {...field} />
+                          </FormControl>
+                          <FormDescription>
+                            Leave empty to use this computer's time zone
+                          </FormDescription>
+                        </FormItem>
+                      )}
+                    />
+
+                    <FormField
+                      control={form.control}
+                      name='missed_run_policy'
+                      render={({ field }) => (
+                        <FormItem>
+                          <FormLabel>Missed Runs</FormLabel>
+                          <FormControl>
+                            <select
+                              className='flex h-9 w-full rounded-md border border-input bg-transparent px-3 py-1 text-sm shadow-sm'
+                              {...field}
+                            >
+                              <option value='skip'>Skip</option>
+                              <option value='run_once'>Run once</option>
+                            </select>
+                          </FormControl>
+                          <FormDescription>
+                            What to do with runs that were due while the
+                            computer was asleep
+                          </FormDescription>
+                        </FormItem>
+                      )}
+                    />
+                  </>
+                )}
+
+                <FormField
+                  control={form.control}
+                  name='timeout_seconds'
+                  render={({ field }) => (
+                    <FormItem>
+                      <FormLabel>Timeout (seconds)</FormLabel>
+                      <FormControl>
+                        <Input
+                          type='number'
+                          min={0}
+                          {...field}
+                          onChange={field.onChange}
+                        />
+                      </FormControl>
+                      <FormDescription>
+                        Stop the plugin after this long (0 uses the default)
+                      </FormDescription>
+                    </FormItem>
+                  )}
+                />
+
+                <FormField
+                  control={form.control}
+                  name='concurrency_policy'
+                  render={({ field }) => (
+                    <FormItem>
+                      <FormLabel>When already running</FormLabel>
+                      <FormControl>
+                        <select
+                          className='flex h-9 w-full rounded-md border border-input bg-transparent px-3 py-1 text-sm shadow-sm'
+                          {...field}
+                        >
+                          <option value='parallel'>Run in parallel</option>
+                          <option value='skip'>Skip</option>
+                          <option value='queue'>Queue</option>
+                          <option value='restart'>Restart</option>
+                        </select>
+                      </FormControl>
+                      <FormDescription>
+                        What to do when the plugin is started again before it
+                        finished
+                      </FormDescription>
+                    </FormItem>
+                  )}
+                />
+
+                {!isMacro && (
+                  <FormField
+                    control={form.control}
+                    name='runtime'
+                    render={({ field }) => (
+                      <FormItem>
+                        <FormLabel>Runtime</FormLabel>
+                        <FormControl>
+                          <select
+                            className='flex h-9 w-full rounded-md border border-input bg-transparent px-3 py-1 text-sm shadow-sm'
+                            {...field}
+                          >
+                            {offeredRuntimes.length === 0 && (
+                              <option value={field.value}>{field.value}</option>
+                            )}
+                            {offeredRuntimes.map((rt) => (
+                              <option key={rt.id} value={rt.id}>
+                                {rt.name}
+                                {rt.version && ` (${rt.version})`}
+                                {!rt.available && ' (not installed)'}
+                              </option>
+                            ))}
+                          </select>
+                        </FormControl>
+                        <FormDescription>
+                          What runs the plugin code
+                        </FormDescription>
+                      </FormItem>
+                    )}
+                  />
+                )}
+
+                {plugin && activeProfile && (
+                  <FormField
+                    control={form.control}
+                    name='page_id'
+                    render={({ field }) => (
+                      <FormItem>
+                        <FormLabel>Page</FormLabel>
+                        <FormControl>
+                          <select
+                            className='flex h-9 w-full rounded-md border border-input bg-transparent px-3 py-1 text-sm shadow-sm'
+                            {...field}
+                          >
+                            {activeProfile.pages.map((page) => (
+                              <option key={page.id} value={page.id}>
+                                {page.name}
+                              </option>
+                            ))}
+                          </select>
+                        </FormControl>
+                      </FormItem>
+                    )}
+                  />
+                )}
               </div>
+
+              {!isMacro && (
+                <div className='h-[400px]'>
+                  <FormEditor
+                    control={form.control}
+                    name='code'
+                    description='Your plugin code'
+                    containerClassName='h-[400px]'
+                    language={editorLanguages[runtime]}
+                  />
+                </div>
+              )}
             </div>
             <DialogFooter>
+              {plugin && (
+                <Button
+                  type='button'
+                  variant='outline'
+                  className='sm:mr-auto'
+                  onClick={() => setIsHistoryOpen(true)}
+                >
+                  <HistoryIcon />
+                  History
+                </Button>
+              )}
+              {isMacro && (
+                <Button
+                  type='button'
+                  variant='outline'
+                  onClick={() => setIsStepsOpen(true)}
+                >
+                  <ListOrderedIcon />
+                  Steps
+                </Button>
+              )}
+              {plugin && !isMacro && (
+                <Button
+                  type='button'
+                  variant='outline'
+                  onClick={() => setIsPresetsOpen(true)}
+                >
+                  <BookmarkIcon />
+                  Presets
+                </Button>
+              )}
+              {plugin && (
+                <Button
+                  type='button'
+                  variant='outline'
+                  onClick={() => setIsWebhooksOpen(true)}
+                >
+                  <WebhookIcon />
+                  Webhooks
+                </Button>
+              )}
+              {plugin?.template_id && (
+                <Button
+                  type='button'
+                  variant='outline'
+                  onClick={() => setIsVariablesOpen(true)}
+                >
+                  <SlidersIcon />
+                  {plugin.template_update_available
+                    ? 'Template (update available)'
+                    : 'Template'}
+                </Button>
+              )}
               <Button
                 type='button'
                 variant='outline'
@@ -370,6 +773,54 @@ console.log(uuidv4());`,
             </DialogFooter>
           </form>
         </Form>
+        {plugin && (
+          <RevisionsDialog
+            plugin={plugin}
+            isOpen={isHistoryOpen}
+            onOpenChange={setIsHistoryOpen}
+            onRestore={() => {
+              // The form still holds the old code, close it to reload
+              setIsHistoryOpen(false);
+              onSave();
+              onOpenChange(false);
+            }}
+          />
+        )}
+        {plugin && (
+          <WebhooksDialog
+            plugin={plugin}
+            isOpen={isWebhooksOpen}
+            onOpenChange={setIsWebhooksOpen}
+          />
+        )}
+        {plugin && !isMacro && (
+          <PresetsDialog
+            plugin={plugin}
+            isOpen={isPresetsOpen}
+            onOpenChange={setIsPresetsOpen}
+          />
+        )}
+        {isMacro && (
+          <MacroDialog
+            macro={plugin}
+            isOpen={isStepsOpen}
+            onOpenChange={setIsStepsOpen}
+            onSave={onSave}
+          />
+        )}
+        {plugin?.template_id && (
+          <VariablesDialog
+            plugin={plugin}
+            isOpen={isVariablesOpen}
+            onOpenChange={setIsVariablesOpen}
+            onSave={() => {
+              // The form still holds the old code, close it to reload
+              setIsVariablesOpen(false);
+              onSave();
+              onOpenChange(false);
+            }}
+          />
+        )}
       </DialogContent>
     </Dialog>
   );
@@ -0,0 +1,84 @@
+import { Button } from "@/components/ui/button";
+import { Card, CardContent, CardHeader, CardTitle } from "@/components/ui/card";
+import { cn } from "@/lib/utils";
+import type { Page } from "@/types/page";
+import { useSortable } from "@dnd-kit/sortable";
+import { CSS } from "@dnd-kit/utilities";
+import { FolderIcon } from "lucide-react";
+
+interface FolderCardProps {
+	folder: Page;
+	onOpen: (folder: Page) => void;
+	onRename: (folder: Page) => void;
+	onDelete: (folder: Page) => void;
+	isEditMode: boolean;
+}
+
+// folderSortId keeps folder IDs apart from plugin IDs in the shared grid
+export const folderSortId = (folder: Page) => `folder-${folder.id}`;
+
+export function SortableFolderCard({
+	folder,
+	onOpen,
+	onRename,
+	onDelete,
+	isEditMode,
+}: FolderCardProps) {
+	const { attributes, listeners, setNodeRef, transform, transition, isOver } =
+		useSortable({ id: folderSortId(folder) });
+
+	const style = {
+		transform: CSS.Transform.toString(transform),
+		transition,
+		height: "100%",
+	};
+
+	return (
+		<div
+			ref={setNodeRef}
+			style={style}
+			{...(isEditMode ? { ...attributes, ...listeners } : {})}
+		>
+			<Card
+				className={cn(
+					"w-full h-full flex flex-col",
+					isEditMode ? "cursor-move" : "cursor-pointer hover:bg-accent",
+					isOver && "ring-2 ring-primary",
+				)}
+				onClick={() => !isEditMode && onOpen(folder)}
+				tabIndex={0}
+				onKeyDown={(e) => {
+					if (!isEditMode && (e.key === "Enter" || e.key === " ")) {
+						onOpen(folder);
+					}
+				}}
+			>
+				<CardHeader>
+					<CardTitle>{folder.name}</CardTitle>
+				</CardHeader>
+				<CardContent className="flex flex-col gap-2 flex-grow items-center justify-center">
+					<FolderIcon className="size-24 text-muted-foreground" />
+					{isEditMode && (
+						<div className="flex gap-2">
+							<Button variant="outline" onClick={() => onOpen(folder)}>
+								Open
+							</Button>
+							<Button variant="outline" onClick={() => onRename(folder)}>
+								Rename
+							</Button>
+							<Button
+								variant="destructive"
+								onClick={(e) => {
+									e.stopPropagation();
+									onDelete(folder);
+								}}
+							>
+								Delete
+							</Button>
+						</div>
+					)}
+				</CardContent>
+			</Card>
+		</div>
+	);
+}
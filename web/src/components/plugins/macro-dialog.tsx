@@ -0,0 +1,395 @@
+import { Button } from '@/components/ui/button';
+import { Checkbox } from '@/components/ui/checkbox';
+import {
+  Dialog,
+  DialogContent,
+  DialogDescription,
+  DialogFooter,
+  DialogHeader,
+  DialogTitle,
+} from '@/components/ui/dialog';
+import { Input } from '@/components/ui/input';
+import { Label } from '@/components/ui/label';
+import { useToast } from '@/hooks/use-toast';
+import type { MacroStep, StepResult } from '@/types/macro';
+import type { Plugin } from '@/types/plugin';
+import { useMutation, useQuery } from '@tanstack/react-query';
+import { useRouter } from '@tanstack/react-router';
+import {
+  ArrowDownIcon,
+  ArrowUpIcon,
+  Loader2,
+  PlusIcon,
+  TrashIcon,
+} from 'lucide-react';
+import { useEffect, useState } from 'react';
+
+interface MacroDialogProps {
+  // macro is the macro whose steps are edited, a new macro is created
+  // without it
+  macro?: Plugin;
+  isOpen: boolean;
+  onOpenChange: (open: boolean) => void;
+  onSave: () => void;
+  // pageId is the page new macros are added to
+  pageId?: number;
+}
+
+const selectClassName =
+  'flex h-9 w-full rounded-md border border-input bg-transparent px-3 py-1 text-sm shadow-sm';
+
+function newStep(action: MacroStep['action']): MacroStep {
+  return {
+    action,
+    target_plugin_id: null,
+    delay_ms: action === 'delay' ? 1000 : 0,
+    condition: '',
+    exit_code: 0,
+    continue_on_failure: false,
+  };
+}
+
+interface MacroRun {
+  id: number;
+  status: string;
+  started_at: string;
+  steps?: StepResult[];
+}
+
+export function MacroDialog({
+  macro,
+  isOpen,
+  onOpenChange,
+  onSave,
+  pageId,
+}: MacroDialogProps) {
+  const router = useRouter();
+  const { toast } = useToast();
+  const [name, setName] = useState('');
+  const [steps, setSteps] = useState<MacroStep[]>([]);
+  // Inputs are edited as JSON text and parsed on save
+  const [inputs, setInputs] = useState<string[]>([]);
+
+  // Macros can only run scripts
+  const { data: plugins } = useQuery({
+    queryKey: ['plugins'],
+    queryFn: async () => {
+      const response = await fetch('/api/plugins');
+      if (!response.ok) {
+        throw new Error('Failed to fetch plugins');
+      }
+      return (await response.json()) as Plugin[];
+    },
+    enabled: isOpen,
+  });
+  const scripts = (plugins ?? []).filter((p) => p.kind !== 'macro');
+
+  const { data: savedSteps } = useQuery({
+    queryKey: ['macro-steps', macro?.id],
+    queryFn: async () => {
+      const response = await fetch(`/api/plugins/${macro?.id}/steps`);
+      if (!response.ok) {
+        throw new Error('Failed to fetch steps');
+      }
+      return (await response.json()) as MacroStep[];
+    },
+    enabled: isOpen && !!macro,
+  });
+
+  const { data: lastRun } = useQuery({
+    queryKey: ['macro-last-run', macro?.id],
+    queryFn: async () => {
+      const response = await fetch(`/api/plugins/${macro?.id}/runs?limit=1`);
+      if (!response.ok) {
+        throw new Error('Failed to fetch runs');
+      }
+      const data = (await response.json()) as { runs: MacroRun[] | null };
+      return data.runs?.[0] ?? null;
+    },
+    enabled: isOpen && !!macro,
+  });
+
+  useEffect(() => {
+    if (!isOpen) return;
+    const initial = macro ? (savedSteps ?? []) : [newStep('run')];
+    setName(macro?.name ?? '');
+    setSteps(initial);
+    setInputs(
+      initial.map((step) =>
+        step.input === undefined ? '' : JSON.stringify(step.input),
+      ),
+    );
+  }, [isOpen, macro, savedSteps]);
+
+  const updateStep = (index: number, changes: Partial<MacroStep>) => {
+    setSteps(steps.map((s, i) => (i === index ? { ...s, ...changes } : s)));
+  };
+
+  const moveStep = (index: number, offset: number) => {
+    const target = index + offset;
+    if (target < 0 || target >= steps.length) return;
+    const swap = <T,>(list: T[]) => {
+      const copy = [...list];
+      [copy[index], copy[target]] = [copy[target], copy[index]];
+      return copy;
+    };
+    setSteps(swap(steps));
+    setInputs(swap(inputs));
+  };
+
+  const removeStep = (index: number) => {
+    setSteps(steps.filter((_, i) => i !== index));
+    setInputs(inputs.filter((_, i) => i !== index));
+  };
+
+  const addStep = (action: MacroStep['action']) => {
+    setSteps([...steps, newStep(action)]);
+    setInputs([...inputs, '']);
+  };
+
+  const { mutate: save, isPending } = useMutation({
+    mutationFn: async () => {
+      const body = steps.map((step, i) => {
+        if (step.action !== 'run' || inputs[i].trim() === '') {
+          return { ...step, input: undefined };
+        }
+        try {
+          return { ...step, input: JSON.parse(inputs[i]) };
+        } catch {
+          throw new Error(`The input of step ${i + 1} is not valid JSON`);
+        }
+      });
+
+      const response = macro
+        ? await fetch(`/api/plugins/${macro.id}/steps`, {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ steps: body }),
+          })
+        : await fetch('/api/macros', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ name, page_id: pageId, steps: body }),
+          });
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(data.error || 'Failed to save macro');
+      }
+      return data;
+    },
+    onSuccess: () => {
+      onSave();
+      onOpenChange(false);
+      toast({
+        title: 'Success',
+        description: 'Macro saved successfully',
+      });
+      router.invalidate();
+    },
+    onError: (error) => {
+      toast({
+        title: 'Error',
+        description: error.message,
+        variant: 'destructive',
+      });
+    },
+  });
+
+  return (
+    <Dialog open={isOpen} onOpenChange={onOpenChange}>
+      <DialogContent className='max-w-3xl max-h-[90vh] overflow-y-auto'>
+        <DialogHeader>
+          <DialogTitle>
+            {macro ? `Steps of ${macro.name}` : 'Add New Macro'}
+          </DialogTitle>
+          <DialogDescription>
+            A macro runs plugins one after another. It stops at the first
+            failed step unless the step continues on failure, and conditions
+            check the exit code of the previous plugin.
+          </DialogDescription>
+        </DialogHeader>
+        {!macro && (
+          <div className='flex flex-col gap-2'>
+            <Label htmlFor='macro-name'>Name</Label>
+            <Input
+              id='macro-name'
+              placeholder='e.g. Go Live'
+              value={name}
+              onChange={(e) => setName(e.target.value)}
+            />
+          </div>
+        )}
+        <ol className='flex flex-col gap-3'>
+          {steps.map((step, i) => (
+            <li
+              key={i}
+              className='flex flex-col gap-2 rounded-md border p-3 text-sm'
+            >
+              <div className='flex items-center gap-2'>
+                <span className='font-medium w-6'>{i + 1}.</span>
+                {step.action === 'run' ? (
+                  <select
+                    className={selectClassName}
+                    value={step.target_plugin_id ?? ''}
+                    onChange={(e) =>
+                      updateStep(i, {
+                        target_plugin_id: e.target.value
+                          ? Number(e.target.value)
+                          : null,
+                      })
+                    }
+                  >
+                    <option value=''>Choose a plugin</option>
+                    {scripts.map((p) => (
+                      <option key={p.id} value={p.id}>
+                        {p.name}
+                      </option>
+                    ))}
+                  </select>
+                ) : (
+                  <div className='flex w-full items-center gap-2'>
+                    <span>Wait</span>
+                    <Input
+                      type='number'
+                      min={1}
+                      value={step.delay_ms}
+                      onChange={(e) =>
+                        updateStep(i, { delay_ms: Number(e.target.value) })
+                      }
+                    />
+                    <span>ms</span>
+                  </div>
+                )}
+                <Button
+                  type='button'
+                  variant='outline'
+                  size='icon'
+                  title='Move up'
+                  onClick={() => moveStep(i, -1)}
+                >
+                  <ArrowUpIcon />
+                </Button>
+                <Button
+                  type='button'
+                  variant='outline'
+                  size='icon'
+                  title='Move down'
+                  onClick={() => moveStep(i, 1)}
+                >
+                  <ArrowDownIcon />
+                </Button>
+                <Button
+                  type='button'
+                  variant='outline'
+                  size='icon'
+                  title='Remove'
+                  onClick={() => removeStep(i)}
+                >
+                  <TrashIcon />
+                </Button>
+              </div>
+              {step.action === 'run' && (
+                <Input
+                  placeholder='Input as JSON, passed on stdin (optional)'
+                  value={inputs[i] ?? ''}
+                  onChange={(e) =>
+                    setInputs(
+                      inputs.map((input, j) =>
+                        j === i ? e.target.value : input,
+                      ),
+                    )
+                  }
+                />
+              )}
+              <div className='flex flex-wrap items-center gap-2'>
+                <select
+                  className={`${selectClassName} w-auto`}
+                  value={step.condition}
+                  onChange={(e) =>
+                    updateStep(i, {
+                      condition: e.target.value as MacroStep['condition'],
+                    })
+                  }
+                >
+                  <option value=''>Always</option>
+                  <option value='success'>If the previous plugin succeeded</option>
+                  <option value='failure'>If the previous plugin failed</option>
+                  <option value='exit_code'>If the previous exit code is</option>
+                </select>
+                {step.condition === 'exit_code' && (
+                  <Input
+                    type='number'
+                    className='w-24'
+                    value={step.exit_code}
+                    onChange={(e) =>
+                      updateStep(i, { exit_code: Number(e.target.value) })
+                    }
+                  />
+                )}
+                {step.action === 'run' && (
+                  <div className='flex items-center gap-2'>
+                    <Checkbox
+                      id={`step-${i}-continue`}
+                      checked={step.continue_on_failure}
+                      onCheckedChange={(checked) =>
+                        updateStep(i, { continue_on_failure: checked === true })
+                      }
+                    />
+                    <Label htmlFor={`step-${i}-continue`}>
+                      Continue on failure
+                    </Label>
+                  </div>
+                )}
+              </div>
+            </li>
+          ))}
+        </ol>
+        <div className='flex gap-2'>
+          <Button type='button' variant='outline' onClick={() => addStep('run')}>
+            <PlusIcon />
+            Run Plugin
+          </Button>
+          <Button
+            type='button'
+            variant='outline'
+            onClick={() => addStep('delay')}
+          >
+            <PlusIcon />
+            Delay
+          </Button>
+        </div>
+        {lastRun?.steps && (
+          <div className='flex flex-col gap-1 border-t pt-4 text-sm'>
+            <p className='font-medium'>
+              Last run {new Date(lastRun.started_at).toLocaleString()}:{' '}
+              {lastRun.status}
+            </p>
+            <ul className='text-muted-foreground'>
+              {lastRun.steps.map((result) => (
+                <li key={result.step}>
+                  {result.step}. {result.status}
+                  {result.exit_code !== undefined &&
+                    ` (exit code ${result.exit_code})`}
+                  {result.error && ` · ${result.error}`}
+                </li>
+              ))}
+            </ul>
+          </div>
+        )}
+        <DialogFooter>
+          <Button
+            type='button'
+            variant='outline'
+            onClick={() => onOpenChange(false)}
+          >
+            Cancel
+          </Button>
+          <Button type='button' disabled={isPending} onClick={() => save()}>
+            {isPending && <Loader2 className='mr-2 h-4 w-4 animate-spin' />}
+            {macro ? 'Save Steps' : 'Create Macro'}
+          </Button>
+        </DialogFooter>
+      </DialogContent>
+    </Dialog>
+  );
+}
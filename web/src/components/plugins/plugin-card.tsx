@@ -6,14 +6,19 @@ import {
 	CardHeader,
 	CardTitle,
 } from "@/components/ui/card";
+import { usePluginStream } from "@/hooks/use-plugin-stream";
 import { useToast } from "@/hooks/use-toast";
 import { cn } from "@/lib/utils";
-import type { Plugin } from "@/types/plugin";
+import type { Plugin, ScheduleStatus } from "@/types/plugin";
 import { useSortable } from "@dnd-kit/sortable";
 import { CSS } from "@dnd-kit/utilities";
-import { useMutation } from "@tanstack/react-query";
+import {
+	useMutation,
+	useQuery,
+	useQueryClient,
+} from "@tanstack/react-query";
 import { ImageIcon, PauseIcon, PlayIcon } from "lucide-react";
-import { useCallback, useEffect, useRef, useState } from "react";
+import { useCallback, useState } from "react";
 import { Badge } from "../ui/badge";
 
 interface PluginCardProps {
@@ -30,9 +35,8 @@ export function SortablePluginCard({
 	isEditMode,
 }: PluginCardProps) {
 	const { toast } = useToast();
+	const queryClient = useQueryClient();
 	const [result, setResult] = useState<string | null>(null);
-	const [isRunning, setIsRunning] = useState(false);
-	const intervalRef = useRef<Timer | null>(null);
 	const { attributes, listeners, setNodeRef, transform, transition } =
 		useSortable({ id: plugin.id });
 
@@ -59,67 +63,61 @@ export function SortablePluginCard({
 				description: error.message,
 				variant: "destructive",
 			});
-			// Stop continuous run on error
-			if (isRunning) {
-				stopContinuousRun();
-			}
 		},
 	});
 
-	const startContinuousRun = useCallback(() => {
-		// Don't start if already running or not configured for continuous running
-		if (isRunning || !plugin.run_continuously || plugin.interval_seconds <= 0) {
-			return;
-		}
-
-		// Stop any existing interval first
-		stopContinuousRun();
+	// Continuous runs are driven by the server scheduler, the card only polls
+	// for the latest result
+	const { data: schedule } = useQuery({
+		queryKey: ["plugin-schedule", plugin.id],
+		queryFn: async () => {
+			const response = await fetch(`/api/plugins/${plugin.id}/schedule`);
+			if (!response.ok) {
+				throw new Error("Failed to fetch schedule status");
+			}
+			return (await response.json()) as ScheduleStatus;
+		},
+		enabled: plugin.run_continuously,
+		refetchInterval: 1000,
+	});
+	const isRunning = schedule?.running ?? false;
 
-		// Run immediately
-		runPlugin(plugin);
-		setIsRunning(true);
+	// Output is pushed live by the server while a run is in progress
+	const stream = usePluginStream(plugin.id);
+	const liveOutput = stream.lines.join("\n");
+	const state = { ...plugin.state, ...stream.state };
 
-		try {
-			// Use global window.setInterval to ensure we get a numeric ID
-			intervalRef.current = setInterval(() => {
-				runPlugin(plugin);
-			}, plugin.interval_seconds * 1000);
-		} catch (err) {
+	const { mutate: setSchedule } = useMutation({
+		mutationFn: async (action: "start" | "stop") => {
+			const response = await fetch(
+				`/api/plugins/${plugin.id}/schedule/${action}`,
+				{ method: "POST" },
+			);
+			if (!response.ok) {
+				const body = await response.json();
+				throw new Error(body.error);
+			}
+			return (await response.json()) as ScheduleStatus;
+		},
+		onSuccess: (status) => {
+			queryClient.setQueryData(["plugin-schedule", plugin.id], status);
+		},
+		onError: (error) => {
 			toast({
-				title: "Error setting interval",
-				description: (err as Error).message,
+				title: "Error updating schedule",
+				description: error.message,
 				variant: "destructive",
 			});
-		}
-	}, [plugin, runPlugin, isRunning, toast]);
-
-	const stopContinuousRun = useCallback(() => {
-		try {
-			if (intervalRef.current) {
-				window.clearInterval(intervalRef.current);
-				intervalRef.current = null;
-			}
-		} catch (err) {}
-
-		setIsRunning(false);
-	}, []);
+		},
+	});
 
-	// Clean up on unmount
-	useEffect(() => {
-		return () => {
-			if (intervalRef.current) {
-				window.clearInterval(intervalRef.current);
-				intervalRef.current = null;
-			}
-		};
-	}, []);
+	const startContinuousRun = useCallback(() => {
+		setSchedule("start");
+	}, [setSchedule]);
 
-	// Handle edit mode changes
-	useEffect(() => {
-		if (isEditMode && isRunning) {
-			stopContinuousRun();
-		}
-	}, [isEditMode, isRunning, stopContinuousRun]);
+	const stopContinuousRun = useCallback(() => {
+		setSchedule("stop");
+	}, [setSchedule]);
 
 	const handleCardClick = () => {
 		if (!isEditMode) {
@@ -145,7 +143,9 @@ export function SortablePluginCard({
 				className={cn(
 					"w-full h-full flex flex-col",
 					isEditMode ? "cursor-move" : "cursor-pointer hover:bg-accent",
+					state.toggle && "ring-2 ring-primary",
 				)}
+				style={state.color ? { borderColor: state.color } : undefined}
 				onClick={handleCardClick}
 				tabIndex={0}
 				onKeyDown={(e) => {
@@ -156,7 +156,19 @@ export function SortablePluginCard({
 			>
 				<CardHeader>
 					<CardTitle className="flex items-center justify-between gap-2">
-						<span>{plugin.name}</span>
+						<span>{state.title || plugin.name}</span>
+						{state.badge && !isEditMode && (
+							<Badge
+								style={
+									state.color ? { backgroundColor: state.color } : undefined
+								}
+							>
+								{state.badge}
+							</Badge>
+						)}
+						{plugin.template_update_available && isEditMode && (
+							<Badge variant="outline">Update</Badge>
+						)}
 						{plugin.run_continuously && !isEditMode && (
 							<Badge variant={isRunning ? "default" : "outline"}>
 								{isRunning ? "Running" : "Not Running"}
@@ -166,9 +178,9 @@ export function SortablePluginCard({
 				</CardHeader>
 				<CardContent className="flex flex-col gap-2 flex-grow">
 					<div className="flex items-center justify-center overflow-hidden">
-						{plugin.image ? (
+						{state.image || plugin.image ? (
 							<img
-								src={plugin.image}
+								src={state.image || plugin.image}
 								alt={plugin.name}
 								className="size-32 object-contain"
 							/>
@@ -179,11 +191,16 @@ export function SortablePluginCard({
 						)}
 					</div>
 
-					{((isRunning && plugin.run_continuously) ||
+					{(stream.isRunning ||
+						(isRunning && plugin.run_continuously) ||
 						(result && !plugin.run_continuously)) && (
 						<div className="mt-2 w-full">
 							<div className="bg-muted p-3 rounded-md mt-1 max-h-32 overflow-y-auto text-sm font-mono whitespace-pre-wrap">
-								{result}
+								{stream.isRunning || liveOutput
+									? liveOutput
+									: plugin.run_continuously
+										? schedule?.last_error || schedule?.last_result
+										: result}
 							</div>
 						</div>
 					)}
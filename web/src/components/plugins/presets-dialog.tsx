@@ -0,0 +1,198 @@
+import { Button } from '@/components/ui/button';
+import {
+  Dialog,
+  DialogContent,
+  DialogDescription,
+  DialogHeader,
+  DialogTitle,
+} from '@/components/ui/dialog';
+import { Input } from '@/components/ui/input';
+import { Label } from '@/components/ui/label';
+import { useToast } from '@/hooks/use-toast';
+import type { Plugin, Preset } from '@/types/plugin';
+import { useMutation, useQuery, useQueryClient } from '@tanstack/react-query';
+import { Loader2, PlayIcon, TrashIcon } from 'lucide-react';
+import { useState } from 'react';
+
+interface PresetsDialogProps {
+  plugin: Plugin;
+  isOpen: boolean;
+  onOpenChange: (open: boolean) => void;
+}
+
+export function PresetsDialog({
+  plugin,
+  isOpen,
+  onOpenChange,
+}: PresetsDialogProps) {
+  const { toast } = useToast();
+  const queryClient = useQueryClient();
+  const [name, setName] = useState('');
+  const [input, setInput] = useState('{}');
+
+  const queryKey = ['plugin-presets', plugin.id];
+  const { data: presets, isLoading } = useQuery({
+    queryKey,
+    queryFn: async () => {
+      const response = await fetch(`/api/plugins/${plugin.id}/presets`);
+      if (!response.ok) {
+        throw new Error('Failed to fetch presets');
+      }
+      return (await response.json()) as Preset[];
+    },
+    enabled: isOpen,
+  });
+
+  const onError = (error: Error) => {
+    toast({
+      title: 'Error',
+      description: error.message,
+      variant: 'destructive',
+    });
+  };
+
+  const { mutate: create, isPending: isCreating } = useMutation({
+    mutationFn: async () => {
+      let parsed: unknown;
+      try {
+        parsed = JSON.parse(input);
+      } catch {
+        throw new Error('The input is not valid JSON');
+      }
+      const response = await fetch(`/api/plugins/${plugin.id}/presets`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ name, input: parsed }),
+      });
+      const result = await response.json();
+      if (!response.ok) {
+        throw new Error(result.error || 'Failed to save preset');
+      }
+      return result as Preset;
+    },
+    onSuccess: () => {
+      setName('');
+      setInput('{}');
+      queryClient.invalidateQueries({ queryKey });
+    },
+    onError,
+  });
+
+  const { mutate: remove } = useMutation({
+    mutationFn: async (id: number) => {
+      const response = await fetch(`/api/plugins/${plugin.id}/presets/${id}`, {
+        method: 'DELETE',
+      });
+      if (!response.ok) {
+        throw new Error('Failed to delete preset');
+      }
+    },
+    onSuccess: () => queryClient.invalidateQueries({ queryKey }),
+    onError,
+  });
+
+  const { mutate: run, isPending: isRunning } = useMutation({
+    mutationFn: async (preset: Preset) => {
+      const response = await fetch(`/api/plugins/${plugin.id}/run`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ preset: preset.name }),
+      });
+      const text = await response.text();
+      if (!response.ok) {
+        throw new Error(JSON.parse(text).error || 'Failed to run plugin');
+      }
+      return { preset, output: text };
+    },
+    onSuccess: ({ preset, output }) => {
+      toast({
+        title: `${plugin.name} with ${preset.name}`,
+        description: output,
+      });
+    },
+    onError,
+  });
+
+  return (
+    <Dialog open={isOpen} onOpenChange={onOpenChange}>
+      <DialogContent className='max-w-2xl max-h-[90vh] overflow-y-auto'>
+        <DialogHeader>
+          <DialogTitle>Presets of {plugin.name}</DialogTitle>
+          <DialogDescription>
+            A preset is a named JSON input for this plugin. The plugin reads
+            the input from stdin or, up to 32 KB, the BUNDECK_INPUT environment
+            variable, so one script can serve several buttons.
+          </DialogDescription>
+        </DialogHeader>
+        {isLoading && <Loader2 className='animate-spin' />}
+        {presets?.length === 0 && (
+          <p className='text-sm text-muted-foreground'>No presets yet.</p>
+        )}
+        <ul className='flex flex-col gap-2'>
+          {presets?.map((preset) => (
+            <li
+              key={preset.id}
+              className='flex items-center justify-between gap-2'
+            >
+              <div className='min-w-0'>
+                <p className='font-medium'>{preset.name}</p>
+                <code className='block truncate text-xs text-muted-foreground'>
+                  {JSON.stringify(preset.input)}
+                </code>
+              </div>
+              <div className='flex gap-2'>
+                <Button
+                  variant='outline'
+                  size='icon'
+                  title='Run with this preset'
+                  disabled={isRunning}
+                  onClick={() => run(preset)}
+                >
+                  <PlayIcon />
+                </Button>
+                <Button
+                  variant='outline'
+                  size='icon'
+                  title='Delete'
+                  onClick={() => remove(preset.id)}
+                >
+                  <TrashIcon />
+                </Button>
+              </div>
+            </li>
+          ))}
+        </ul>
+        <form
+          className='flex flex-col gap-3 border-t pt-4'
+          onSubmit={(e) => {
+            e.preventDefault();
+            create();
+          }}
+        >
+          <div className='flex flex-col gap-2'>
+            <Label htmlFor='preset-name'>Name</Label>
+            <Input
+              id='preset-name'
+              placeholder='e.g. Intro Scene'
+              value={name}
+              onChange={(e) => setName(e.target.value)}
+            />
+          </div>
+          <div className='flex flex-col gap-2'>
+            <Label htmlFor='preset-input'>Input</Label>
+            <textarea
+              id='preset-input'
+              className='min-h-24 rounded-md border border-input bg-transparent px-3 py-2 font-mono text-sm shadow-sm'
+              value={input}
+              onChange={(e) => setInput(e.target.value)}
+            />
+          </div>
+          <Button type='submit' className='self-end' disabled={isCreating}>
+            {isCreating && <Loader2 className='animate-spin' />}
+            Add Preset
+          </Button>
+        </form>
+      </DialogContent>
+    </Dialog>
+  );
+}
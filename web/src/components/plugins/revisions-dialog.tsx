@@ -0,0 +1,208 @@
+import { Button } from '@/components/ui/button';
+import {
+  Dialog,
+  DialogContent,
+  DialogDescription,
+  DialogHeader,
+  DialogTitle,
+} from '@/components/ui/dialog';
+import { useToast } from '@/hooks/use-toast';
+import { cn } from '@/lib/utils';
+import type { Plugin, PluginRevision, RevisionDiff } from '@/types/plugin';
+import { useMutation, useQuery, useQueryClient } from '@tanstack/react-query';
+import { useRouter } from '@tanstack/react-router';
+import { Loader2, RotateCcwIcon } from 'lucide-react';
+import { useEffect, useState } from 'react';
+
+interface RevisionsDialogProps {
+  plugin: Plugin;
+  isOpen: boolean;
+  onOpenChange: (open: boolean) => void;
+  onRestore: () => void;
+}
+
+// diffLineClass colours the lines of a unified diff
+function diffLineClass(line: string) {
+  if (line.startsWith('@@')) return 'text-muted-foreground';
+  if (line.startsWith('+')) {
+    return 'bg-green-500/15 text-green-700 dark:text-green-400';
+  }
+  if (line.startsWith('-')) {
+    return 'bg-red-500/15 text-red-700 dark:text-red-400';
+  }
+  return '';
+}
+
+export function RevisionsDialog({
+  plugin,
+  isOpen,
+  onOpenChange,
+  onRestore,
+}: RevisionsDialogProps) {
+  const { toast } = useToast();
+  const router = useRouter();
+  const queryClient = useQueryClient();
+  const [selected, setSelected] = useState<number | null>(null);
+
+  const { data: revisions, isLoading } = useQuery({
+    queryKey: ['plugin-revisions', plugin.id],
+    queryFn: async () => {
+      const response = await fetch(`/api/plugins/${plugin.id}/revisions`);
+      if (!response.ok) {
+        throw new Error('Failed to fetch revisions');
+      }
+      return (await response.json()) as PluginRevision[];
+    },
+    enabled: isOpen,
+  });
+
+  // Compare with the latest revision by default
+  useEffect(() => {
+    if (isOpen && revisions && revisions.length > 1 && selected === null) {
+      setSelected(revisions[1].revision);
+    }
+  }, [isOpen, revisions, selected]);
+
+  const { data: diff } = useQuery({
+    queryKey: ['plugin-revision-diff', plugin.id, selected],
+    queryFn: async () => {
+      const response = await fetch(
+        `/api/plugins/${plugin.id}/revisions/diff?from=${selected}`,
+      );
+      if (!response.ok) {
+        throw new Error('Failed to fetch diff');
+      }
+      return (await response.json()) as RevisionDiff;
+    },
+    enabled: isOpen && selected !== null,
+  });
+
+  const { mutate: restore, isPending: isRestoring } = useMutation({
+    mutationFn: async (revision: number) => {
+      const response = await fetch(
+        `/api/plugins/${plugin.id}/revisions/${revision}/restore`,
+        { method: 'POST' },
+      );
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(data.error);
+      }
+      return data as PluginRevision;
+    },
+    onSuccess: (restored) => {
+      queryClient.invalidateQueries({
+        queryKey: ['plugin-revisions', plugin.id],
+      });
+      setSelected(null);
+      toast({
+        title: 'Success',
+        description: `Restored revision ${restored.restored_from} as revision ${restored.revision}`,
+      });
+      router.invalidate();
+      onRestore();
+    },
+    onError: (error) => {
+      toast({
+        title: 'Error',
+        description: error.message,
+        variant: 'destructive',
+      });
+    },
+  });
+
+  return (
+    <Dialog
+      open={isOpen}
+      onOpenChange={(open) => {
+        if (!open) setSelected(null);
+        onOpenChange(open);
+      }}
+    >
+      <DialogContent className='max-w-4xl'>
+        <DialogHeader>
+          <DialogTitle>History of {plugin.name}</DialogTitle>
+          <DialogDescription>
+            Every saved change of the code, name or settings. Restoring a
+            revision saves it again as the newest revision.
+          </DialogDescription>
+        </DialogHeader>
+        {isLoading && <Loader2 className='animate-spin' />}
+        <div className='grid grid-cols-[12rem_1fr] gap-4 h-[60vh]'>
+          <ul className='flex flex-col gap-1 overflow-y-auto'>
+            {revisions?.map((revision, index) => (
+              <li key={revision.id}>
+                <button
+                  type='button'
+                  className={cn(
+                    'w-full rounded-md px-2 py-1 text-left text-sm hover:bg-accent',
+                    selected === revision.revision && 'bg-accent',
+                  )}
+                  disabled={index === 0}
+                  onClick={() => setSelected(revision.revision)}
+                >
+                  <p className='font-medium'>
+                    Revision {revision.revision}
+                    {index === 0 && ' (current)'}
+                  </p>
+                  <p className='text-xs text-muted-foreground'>
+                    {new Date(revision.created_at).toLocaleString()}
+                    {revision.restored_from &&
+                      ` · restored from ${revision.restored_from}`}
+                  </p>
+                </button>
+              </li>
+            ))}
+          </ul>
+          <div className='flex flex-col gap-2 overflow-hidden'>
+            {revisions?.length === 1 && (
+              <p className='text-sm text-muted-foreground'>
+                This plugin has not been changed since it was created.
+              </p>
+            )}
+            {diff && (
+              <>
+                <div className='flex items-center justify-between gap-2'>
+                  <p className='text-sm'>
+                    Changes from revision {diff.from.revision} to the current
+                    revision {diff.to.revision}
+                  </p>
+                  <Button
+                    variant='outline'
+                    disabled={isRestoring}
+                    onClick={() => restore(diff.from.revision)}
+                  >
+                    {isRestoring ? (
+                      <Loader2 className='animate-spin' />
+                    ) : (
+                      <RotateCcwIcon />
+                    )}
+                    Restore revision {diff.from.revision}
+                  </Button>
+                </div>
+                {diff.changes.length > 0 && (
+                  <ul className='text-sm'>
+                    {diff.changes.map((change) => (
+                      <li key={change.field}>
+                        <span className='font-mono'>{change.field}</span>:{' '}
+                        {String(change.from)} → {String(change.to)}
+                      </li>
+                    ))}
+                  </ul>
+                )}
+                <pre className='flex-1 overflow-auto rounded-md border p-2 text-xs'>
+                  {diff.diff === ''
+                    ? 'The code is unchanged.'
+                    : diff.diff.split('\n').map((line, index) => (
+                        <div key={index} className={diffLineClass(line)}>
+                          {line || ' '}
+                        </div>
+                      ))}
+                </pre>
+              </>
+            )}
+          </div>
+        </div>
+      </DialogContent>
+    </Dialog>
+  );
+}
@@ -0,0 +1,178 @@
+import { Alert, AlertDescription, AlertTitle } from '@/components/ui/alert';
+import { Button } from '@/components/ui/button';
+import {
+  Dialog,
+  DialogContent,
+  DialogDescription,
+  DialogFooter,
+  DialogHeader,
+  DialogTitle,
+} from '@/components/ui/dialog';
+import { Form } from '@/components/ui/form';
+import { useToast } from '@/hooks/use-toast';
+import type { Plugin } from '@/types/plugin';
+import type { PluginVariables, VariableValue } from '@/types/template';
+import { zodResolver } from '@hookform/resolvers/zod';
+import { useMutation, useQuery, useQueryClient } from '@tanstack/react-query';
+import { useRouter } from '@tanstack/react-router';
+import { Loader2 } from 'lucide-react';
+import { useEffect } from 'react';
+import { useForm } from 'react-hook-form';
+import {
+  type FormValues,
+  formSchema,
+  getInputComponent,
+} from './add-plugin-dialog';
+
+interface VariablesDialogProps {
+  plugin: Plugin;
+  isOpen: boolean;
+  onOpenChange: (open: boolean) => void;
+  onSave: () => void;
+}
+
+export function VariablesDialog({
+  plugin,
+  isOpen,
+  onOpenChange,
+  onSave,
+}: VariablesDialogProps) {
+  const { toast } = useToast();
+  const router = useRouter();
+  const queryClient = useQueryClient();
+
+  const { data, isLoading } = useQuery({
+    queryKey: ['plugin-variables', plugin.id],
+    queryFn: async () => {
+      const response = await fetch(`/api/plugins/${plugin.id}/variables`);
+      if (!response.ok) {
+        throw new Error('Failed to fetch template values');
+      }
+      return (await response.json()) as PluginVariables;
+    },
+    enabled: isOpen,
+  });
+
+  const form = useForm<FormValues>({
+    resolver: zodResolver(formSchema),
+    defaultValues: { templateId: plugin.template_id ?? '', variables: {} },
+  });
+
+  // Start from the stored values, new variables of an updated template start
+  // at their default and secrets stay empty to keep the stored secret
+  useEffect(() => {
+    if (!data?.template) return;
+    const values: Record<string, VariableValue> = {};
+    for (const [key, variable] of Object.entries(data.template.variables)) {
+      if (variable.type === 'secret' || variable.secret) {
+        values[key] = '';
+      } else {
+        values[key] = data.variables[key] ?? variable.default;
+      }
+    }
+    form.reset({ templateId: data.template_id, variables: values });
+  }, [data, form]);
+
+  const { mutate, isPending } = useMutation({
+    mutationFn: async (values: FormValues) => {
+      const response = await fetch(`/api/plugins/${plugin.id}/variables`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ variables: values.variables }),
+      });
+      const result = await response.json();
+      if (!response.ok) {
+        for (const [key, message] of Object.entries(
+          (result.fields ?? {}) as Record<string, string>,
+        )) {
+          form.setError(`variables.${key}`, { message: `Value ${message}` });
+        }
+        throw new Error(result.error || 'Failed to update plugin');
+      }
+      return result as PluginVariables;
+    },
+    onSuccess: (result) => {
+      queryClient.setQueryData(['plugin-variables', plugin.id], result);
+      queryClient.invalidateQueries({
+        queryKey: ['plugin-revisions', plugin.id],
+      });
+      toast({
+        title: 'Success',
+        description: `Plugin updated from template version ${result.template_version}`,
+      });
+      router.invalidate();
+      onSave();
+    },
+    onError: (error) => {
+      toast({
+        title: 'Error',
+        description: error.message,
+        variant: 'destructive',
+      });
+    },
+  });
+
+  return (
+    <Dialog open={isOpen} onOpenChange={onOpenChange}>
+      <DialogContent className='max-w-2xl max-h-[90vh] overflow-y-auto'>
+        <DialogHeader>
+          <DialogTitle>Template values of {plugin.name}</DialogTitle>
+          <DialogDescription>
+            Saving renders the code again from the template. Changes made to
+            the code by hand are replaced and stay available in the history.
+          </DialogDescription>
+        </DialogHeader>
+        {isLoading && <Loader2 className='animate-spin' />}
+        {data && !data.template && (
+          <Alert variant='destructive'>
+            <AlertTitle>Template not found</AlertTitle>
+            <AlertDescription>
+              The template {data.template_id} is no longer available, so this
+              plugin can only be changed by editing its code.
+            </AlertDescription>
+          </Alert>
+        )}
+        {data?.update_available && data.template && (
+          <Alert>
+            <AlertTitle>Template updated</AlertTitle>
+            <AlertDescription>
+              This plugin was created from version {data.template_version} of
+              the template. Saving upgrades it to version{' '}
+              {data.template.version}.
+            </AlertDescription>
+          </Alert>
+        )}
+        {data?.template && (
+          <Form {...form}>
+            <form
+              onSubmit={form.handleSubmit((values) => mutate(values))}
+              className='space-y-4'
+            >
+              {Object.entries(data.template.variables).map(([key, variable]) =>
+                getInputComponent(
+                  variable,
+                  form.control,
+                  `variables.${key}`,
+                  key,
+                ),
+              )}
+              <DialogFooter>
+                <Button
+                  type='button'
+                  variant='outline'
+                  onClick={() => onOpenChange(false)}
+                >
+                  Cancel
+                </Button>
+                <Button type='submit' disabled={isPending}>
+                  {isPending && <Loader2 className='animate-spin' />}
+                  {data.update_available ? 'Upgrade' : 'Save'}
+                </Button>
+              </DialogFooter>
+            </form>
+          </Form>
+        )}
+      </DialogContent>
+    </Dialog>
+  );
+}
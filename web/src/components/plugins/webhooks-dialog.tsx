@@ -0,0 +1,243 @@
+import { Alert, AlertDescription, AlertTitle } from '@/components/ui/alert';
+import { Button } from '@/components/ui/button';
+import { Checkbox } from '@/components/ui/checkbox';
+import {
+  Dialog,
+  DialogContent,
+  DialogDescription,
+  DialogHeader,
+  DialogTitle,
+} from '@/components/ui/dialog';
+import { Input } from '@/components/ui/input';
+import { Label } from '@/components/ui/label';
+import { useToast } from '@/hooks/use-toast';
+import type { Plugin } from '@/types/plugin';
+import type {
+  Webhook,
+  WebhookCredentials,
+  WebhookMode,
+} from '@/types/webhook';
+import { useMutation, useQuery, useQueryClient } from '@tanstack/react-query';
+import { Loader2, RefreshCwIcon, TrashIcon } from 'lucide-react';
+import { useState } from 'react';
+
+interface WebhooksDialogProps {
+  plugin: Plugin;
+  isOpen: boolean;
+  onOpenChange: (open: boolean) => void;
+}
+
+export function WebhooksDialog({
+  plugin,
+  isOpen,
+  onOpenChange,
+}: WebhooksDialogProps) {
+  const { toast } = useToast();
+  const queryClient = useQueryClient();
+  const [name, setName] = useState('');
+  const [mode, setMode] = useState<WebhookMode>('sync');
+  const [signed, setSigned] = useState(false);
+  // The token and signing secret are only shown once
+  const [credentials, setCredentials] = useState<WebhookCredentials | null>(
+    null,
+  );
+
+  const queryKey = ['plugin-webhooks', plugin.id];
+  const { data: webhooks, isLoading } = useQuery({
+    queryKey,
+    queryFn: async () => {
+      const response = await fetch(`/api/plugins/${plugin.id}/webhooks`);
+      if (!response.ok) {
+        throw new Error('Failed to fetch webhooks');
+      }
+      return (await response.json()) as Webhook[];
+    },
+    enabled: isOpen,
+  });
+
+  const onError = (error: Error) => {
+    toast({
+      title: 'Error',
+      description: error.message,
+      variant: 'destructive',
+    });
+  };
+
+  const send = async (url: string, method: string, body?: object) => {
+    const response = await fetch(url, {
+      method,
+      headers: { 'Content-Type': 'application/json' },
+      body: body && JSON.stringify(body),
+    });
+    const result = await response.json().catch(() => ({}));
+    if (!response.ok) {
+      throw new Error(result.error || 'Failed to update webhook');
+    }
+    return result;
+  };
+
+  const { mutate: create, isPending: isCreating } = useMutation({
+    mutationFn: async () =>
+      (await send(`/api/plugins/${plugin.id}/webhooks`, 'POST', {
+        name,
+        mode,
+        signed,
+      })) as WebhookCredentials,
+    onSuccess: (created) => {
+      setCredentials(created);
+      setName('');
+      queryClient.invalidateQueries({ queryKey });
+    },
+    onError,
+  });
+
+  const { mutate: rotate } = useMutation({
+    mutationFn: async (webhook: Webhook) =>
+      (await send(`/api/webhooks/${webhook.id}/rotate`, 'POST', {
+        signed: webhook.signed,
+      })) as WebhookCredentials,
+    onSuccess: (rotated) => {
+      setCredentials(rotated);
+      queryClient.invalidateQueries({ queryKey });
+    },
+    onError,
+  });
+
+  const { mutate: remove } = useMutation({
+    mutationFn: async (id: number) => {
+      const response = await fetch(`/api/webhooks/${id}`, {
+        method: 'DELETE',
+      });
+      if (!response.ok) {
+        throw new Error('Failed to delete webhook');
+      }
+    },
+    onSuccess: () => {
+      setCredentials(null);
+      queryClient.invalidateQueries({ queryKey });
+    },
+    onError,
+  });
+
+  return (
+    <Dialog
+      open={isOpen}
+      onOpenChange={(open) => {
+        if (!open) setCredentials(null);
+        onOpenChange(open);
+      }}
+    >
+      <DialogContent className='max-w-2xl max-h-[90vh] overflow-y-auto'>
+        <DialogHeader>
+          <DialogTitle>Webhooks of {plugin.name}</DialogTitle>
+          <DialogDescription>
+            Other systems run this plugin by posting to a webhook URL. The
+            request body and query are passed to the plugin on stdin.
+          </DialogDescription>
+        </DialogHeader>
+        {credentials && (
+          <Alert>
+            <AlertTitle>Copy the URL of {credentials.name} now</AlertTitle>
+            <AlertDescription className='flex flex-col gap-1 break-all'>
+              <span>
+                It is not shown again. Rotate the webhook if you lose it.
+              </span>
+              <code>{credentials.url}</code>
+              {credentials.signing_secret && (
+                <span>
+                  Sign the body with HMAC-SHA256 using{' '}
+                  <code>{credentials.signing_secret}</code> and send it as{' '}
+                  <code>X-Bundeck-Signature: sha256=&lt;hex&gt;</code>
+                </span>
+              )}
+            </AlertDescription>
+          </Alert>
+        )}
+        {isLoading && <Loader2 className='animate-spin' />}
+        {webhooks?.length === 0 && (
+          <p className='text-sm text-muted-foreground'>No webhooks yet.</p>
+        )}
+        <ul className='flex flex-col gap-2'>
+          {webhooks?.map((webhook) => (
+            <li
+              key={webhook.id}
+              className='flex items-center justify-between gap-2'
+            >
+              <div>
+                <p className='font-medium'>{webhook.name}</p>
+                <p className='text-xs text-muted-foreground'>
+                  {webhook.mode === 'sync'
+                    ? 'Waits for the output'
+                    : 'Responds at once'}
+                  {webhook.signed && ' · signed'} · last used{' '}
+                  {webhook.last_used_at
+                    ? new Date(webhook.last_used_at).toLocaleString()
+                    : 'never'}
+                </p>
+              </div>
+              <div className='flex gap-2'>
+                <Button
+                  variant='outline'
+                  size='icon'
+                  title='Rotate token'
+                  onClick={() => rotate(webhook)}
+                >
+                  <RefreshCwIcon />
+                </Button>
+                <Button
+                  variant='outline'
+                  size='icon'
+                  title='Delete'
+                  onClick={() => remove(webhook.id)}
+                >
+                  <TrashIcon />
+                </Button>
+              </div>
+            </li>
+          ))}
+        </ul>
+        <form
+          className='flex flex-col gap-3 border-t pt-4'
+          onSubmit={(e) => {
+            e.preventDefault();
+            create();
+          }}
+        >
+          <div className='flex flex-col gap-2'>
+            <Label htmlFor='webhook-name'>Name</Label>
+            <Input
+              id='webhook-name'
+              placeholder='e.g. CI or Home Assistant'
+              value={name}
+              onChange={(e) => setName(e.target.value)}
+            />
+          </div>
+          <div className='flex items-center gap-2'>
+            <Checkbox
+              id='webhook-async'
+              checked={mode === 'async'}
+              onCheckedChange={(checked) =>
+                setMode(checked === true ? 'async' : 'sync')
+              }
+            />
+            <Label htmlFor='webhook-async'>
+              Respond at once with the run ID instead of waiting for the output
+            </Label>
+          </div>
+          <div className='flex items-center gap-2'>
+            <Checkbox
+              id='webhook-signed'
+              checked={signed}
+              onCheckedChange={(checked) => setSigned(checked === true)}
+            />
+            <Label htmlFor='webhook-signed'>Require an HMAC signature</Label>
+          </div>
+          <Button type='submit' className='self-end' disabled={isCreating}>
+            {isCreating && <Loader2 className='animate-spin' />}
+            Add Webhook
+          </Button>
+        </form>
+      </DialogContent>
+    </Dialog>
+  );
+}
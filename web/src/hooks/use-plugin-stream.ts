@@ -0,0 +1,67 @@
+import type { ButtonState, RunEvent } from '@/types/plugin';
+import { useEffect, useState } from 'react';
+
+// Keep the card output bounded for chatty plugins
+const MAX_LINES = 200;
+
+export interface PluginStream {
+  isRunning: boolean;
+  lines: string[];
+  lastEvent: RunEvent | null;
+  // state holds button state updates received since the page loaded
+  state: ButtonState;
+}
+
+// usePluginStream subscribes to the live run events of a plugin so every
+// open deck shows the same run as it happens.
+export function usePluginStream(pluginId: number, enabled = true) {
+  const [stream, setStream] = useState<PluginStream>({
+    isRunning: false,
+    lines: [],
+    lastEvent: null,
+    state: {},
+  });
+
+  useEffect(() => {
+    if (!enabled) return;
+
+    const source = new EventSource(`/api/plugins/${pluginId}/stream`);
+
+    source.addEventListener('run-started', (e) => {
+      const event = JSON.parse((e as MessageEvent).data) as RunEvent;
+      setStream((prev) => ({
+        ...prev,
+        isRunning: true,
+        lines: [],
+        lastEvent: event,
+      }));
+    });
+
+    source.addEventListener('output-line', (e) => {
+      const event = JSON.parse((e as MessageEvent).data) as RunEvent;
+      setStream((prev) => ({
+        ...prev,
+        lines: [...prev.lines, event.line ?? ''].slice(-MAX_LINES),
+        lastEvent: event,
+      }));
+    });
+
+    source.addEventListener('state', (e) => {
+      const event = JSON.parse((e as MessageEvent).data) as RunEvent;
+      setStream((prev) => ({
+        ...prev,
+        state: { ...prev.state, ...event.state },
+        lastEvent: event,
+      }));
+    });
+
+    source.addEventListener('run-finished', (e) => {
+      const event = JSON.parse((e as MessageEvent).data) as RunEvent;
+      setStream((prev) => ({ ...prev, isRunning: false, lastEvent: event }));
+    });
+
+    return () => source.close();
+  }, [pluginId, enabled]);
+
+  return stream;
+}
@@ -12,6 +12,7 @@
 
 import { Route as rootRoute } from './routes/__root'
 import { Route as IndexImport } from './routes/index'
+import { Route as PairCodeImport } from './routes/pair.$code'
 import { Route as QrCodeImport } from './routes/qr.$code'
 
 // Create/Update Routes
@@ -22,6 +23,12 @@ const IndexRoute = IndexImport.update({
   getParentRoute: () => rootRoute,
 } as any)
 
+const PairCodeRoute = PairCodeImport.update({
+  id: '/pair/$code',
+  path: '/pair/$code',
+  getParentRoute: () => rootRoute,
+} as any)
+
 const QrCodeRoute = QrCodeImport.update({
   id: '/qr/$code',
   path: '/qr/$code',
@@ -39,6 +46,13 @@ declare module '@tanstack/react-router' {
       preLoaderRoute: typeof IndexImport
       parentRoute: typeof rootRoute
     }
+    '/pair/$code': {
+      id: '/pair/$code'
+      path: '/pair/$code'
+      fullPath: '/pair/$code'
+      preLoaderRoute: typeof PairCodeImport
+      parentRoute: typeof rootRoute
+    }
     '/qr/$code': {
       id: '/qr/$code'
       path: '/qr/$code'
@@ -53,36 +67,41 @@ declare module '@tanstack/react-router' {
 
 export interface FileRoutesByFullPath {
   '/': typeof IndexRoute
+  '/pair/$code': typeof PairCodeRoute
   '/qr/$code': typeof QrCodeRoute
 }
 
 export interface FileRoutesByTo {
   '/': typeof IndexRoute
+  '/pair/$code': typeof PairCodeRoute
   '/qr/$code': typeof QrCodeRoute
 }
 
 export interface FileRoutesById {
   __root__: typeof rootRoute
   '/': typeof IndexRoute
+  '/pair/$code': typeof PairCodeRoute
   '/qr/$code': typeof QrCodeRoute
 }
 
 export interface FileRouteTypes {
   fileRoutesByFullPath: FileRoutesByFullPath
-  fullPaths: '/' | '/qr/$code'
+  fullPaths: '/' | '/pair/$code' | '/qr/$code'
   fileRoutesByTo: FileRoutesByTo
-  to: '/' | '/qr/$code'
-  id: '__root__' | '/' | '/qr/$code'
+  to: '/' | '/pair/$code' | '/qr/$code'
+  id: '__root__' | '/' | '/pair/$code' | '/qr/$code'
   fileRoutesById: FileRoutesById
 }
 
 export interface RootRouteChildren {
   IndexRoute: typeof IndexRoute
+  PairCodeRoute: typeof PairCodeRoute
   QrCodeRoute: typeof QrCodeRoute
 }
 
 const rootRouteChildren: RootRouteChildren = {
   IndexRoute: IndexRoute,
+  PairCodeRoute: PairCodeRoute,
   QrCodeRoute: QrCodeRoute,
 }
 
@@ -97,12 +116,16 @@ export const routeTree = rootRoute
       "filePath": "__root.tsx",
       "children": [
         "/",
+        "/pair/$code",
         "/qr/$code"
       ]
     },
     "/": {
       "filePath": "index.tsx"
     },
+    "/pair/$code": {
+      "filePath": "pair.$code.tsx"
+    },
     "/qr/$code": {
       "filePath": "qr.$code.tsx"
     }
@@ -1,5 +1,12 @@
+import { DevicesDialog } from "@/components/devices-dialog";
+import { ImportDialog } from "@/components/import-dialog";
 import { AddPluginDialog } from "@/components/plugins/add-plugin-dialog";
 import { EditPluginDialog } from "@/components/plugins/edit-dialog";
+import { MacroDialog } from "@/components/plugins/macro-dialog";
+import {
+	SortableFolderCard,
+	folderSortId,
+} from "@/components/plugins/folder-card";
 import { SortablePluginCard } from "@/components/plugins/plugin-card";
 import { Button } from "@/components/ui/button";
 import {
@@ -14,6 +21,13 @@ import {
 import { useConfirmDialog } from "@/hooks/use-confirm-dialog";
 import { useToast } from "@/hooks/use-toast";
 
+import type { CurrentDevice } from "@/types/auth";
+import type {
+	ActiveProfile,
+	Page,
+	PageContents,
+	Profile,
+} from "@/types/page";
 import type { Plugin } from "@/types/plugin";
 import {
 	DndContext,
@@ -29,29 +43,72 @@ import {
 	rectSortingStrategy,
 } from "@dnd-kit/sortable";
 import { useMutation } from "@tanstack/react-query";
-import { createFileRoute, useRouter } from "@tanstack/react-router";
 import {
+	createFileRoute,
+	useNavigate,
+	useRouter,
+} from "@tanstack/react-router";
+import {
+	ArchiveIcon,
 	Blocks,
+	CheckIcon,
+	DownloadIcon,
+	FolderPlusIcon,
+	FilePlusIcon,
 	FullscreenIcon,
+	ListOrderedIcon,
 	PencilIcon,
 	Plug2Icon,
 	PlugZap2Icon,
 	RefreshCwIcon,
 	SettingsIcon,
+	SmartphoneIcon,
+	UploadIcon,
+	UsersIcon,
 } from "lucide-react";
 import { useEffect, useState } from "react";
 
 export const Route = createFileRoute("/")({
 	component: HomeComponent,
-	loader: async () => {
-		const response = await fetch("/api/plugins");
-		const plugins = (await response.json()) as Plugin[];
-		return plugins;
+	validateSearch: (search: Record<string, unknown>): { page?: number } => ({
+		page: search.page ? Number(search.page) : undefined,
+	}),
+	loaderDeps: ({ search }) => ({ page: search.page }),
+	loader: async ({ deps }) => {
+		const meResponse = await fetch("/api/auth/me");
+		if (meResponse.status === 401) {
+			return { me: null, profiles: [], active: null, contents: null };
+		}
+		const me = (await meResponse.json()) as CurrentDevice;
+		const [profiles, active] = await Promise.all([
+			fetch("/api/profiles").then((r) => r.json() as Promise<Profile[]>),
+			fetch("/api/profiles/active").then(
+				(r) => r.json() as Promise<ActiveProfile>,
+			),
+		]);
+
+		// Show the first page of the active profile unless another page was
+		// opened and still exists
+		const firstPage = active.pages.find((page) => page.parent_id === null);
+		let response = await fetch(`/api/pages/${deps.page ?? firstPage?.id}`);
+		if (!response.ok && firstPage) {
+			response = await fetch(`/api/pages/${firstPage.id}`);
+		}
+		const contents = (await response.json()) as PageContents;
+		return { me, profiles, active, contents };
 	},
 });
 
+// Stable fallbacks so effects depending on the loader data do not rerun
+const noPlugins: Plugin[] = [];
+const noFolders: Page[] = [];
+
 function HomeComponent() {
-	const plugins = Route.useLoaderData();
+	const { me, profiles, active, contents } = Route.useLoaderData();
+	const plugins = contents?.plugins ?? noPlugins;
+	const folders = contents?.folders ?? noFolders;
+	const isAdmin = me?.role === "admin";
+	const navigate = useNavigate();
 	const router = useRouter();
 	const { toast } = useToast();
 	const [pluginsCopy, setPluginsCopy] = useState(plugins);
@@ -62,6 +119,9 @@ function HomeComponent() {
 	);
 	const [isEditDialogOpen, setIsEditDialogOpen] = useState(false);
 	const [isAddDialogOpen, setIsAddDialogOpen] = useState(false);
+	const [isMacroDialogOpen, setIsMacroDialogOpen] = useState(false);
+	const [isDevicesDialogOpen, setIsDevicesDialogOpen] = useState(false);
+	const [isImportDialogOpen, setIsImportDialogOpen] = useState(false);
 
 	const { confirm: confirmDelete, ConfirmDialog: DeleteConfirmDialog } =
 		useConfirmDialog({
@@ -72,6 +132,17 @@ function HomeComponent() {
 			confirmVariant: "destructive",
 		});
 
+	const {
+		confirm: confirmDeleteFolder,
+		ConfirmDialog: DeleteFolderConfirmDialog,
+	} = useConfirmDialog({
+		title: "Delete Folder",
+		description:
+			"Are you sure you want to delete this folder? It must not contain any plugins.",
+		confirmText: "Delete",
+		confirmVariant: "destructive",
+	});
+
 	const mouseSensor = useSensor(MouseSensor, {
 		activationConstraint: {
 			distance: 40,
@@ -85,12 +156,17 @@ function HomeComponent() {
 	const sensors = useSensors(mouseSensor, touchSensor);
 
 	const { mutate: setPlugins } = useMutation({
-		mutationFn: (plugins: Pick<Plugin, "id" | "order_num">[]) => {
-			return fetch("/api/plugins/reorder", {
+		mutationFn: async (
+			plugins: (Pick<Plugin, "id" | "order_num"> & { page_id?: number })[],
+		) => {
+			const response = await fetch("/api/plugins/reorder", {
 				method: "PUT",
 				headers: { "Content-Type": "application/json" },
 				body: JSON.stringify(plugins),
 			});
+			if (!response.ok) {
+				throw new Error((await response.json()).error);
+			}
 		},
 		onSuccess: () => {
 			router.invalidate();
@@ -105,9 +181,81 @@ function HomeComponent() {
 		},
 	});
 
+	// sendJSON calls the page and profile API and reloads the deck
+	const sendJSON = async (method: string, url: string, body?: unknown) => {
+		const response = await fetch(url, {
+			method,
+			headers: { "Content-Type": "application/json" },
+			body: body === undefined ? undefined : JSON.stringify(body),
+		});
+		if (!response.ok) {
+			const { error } = await response.json();
+			toast({
+				title: "Error",
+				description: error,
+				variant: "destructive",
+			});
+			return null;
+		}
+		router.invalidate();
+		return response;
+	};
+
+	const openPage = (page: Page) => {
+		navigate({ to: "/", search: { page: page.id } });
+	};
+
+	const handleCreatePage = async (asFolder: boolean) => {
+		const name = window.prompt(asFolder ? "Folder name" : "Page name");
+		if (!name || !contents) return;
+		await sendJSON("POST", "/api/pages", {
+			name,
+			profile_id: contents.page.profile_id,
+			parent_id: asFolder ? contents.page.id : null,
+			order_num: asFolder ? folders.length : (active?.pages.length ?? 0),
+		});
+	};
+
+	const handleRenameFolder = async (folder: Page) => {
+		const name = window.prompt("Folder name", folder.name);
+		if (!name) return;
+		await sendJSON("PUT", `/api/pages/${folder.id}`, {
+			name,
+			parent_id: folder.parent_id,
+			order_num: folder.order_num,
+		});
+	};
+
+	const handleDeleteFolder = (folder: Page) => {
+		confirmDeleteFolder(() => sendJSON("DELETE", `/api/pages/${folder.id}`));
+	};
+
+	const handleCreateProfile = async () => {
+		const name = window.prompt("Profile name");
+		if (!name) return;
+		await sendJSON("POST", "/api/profiles", { name });
+	};
+
+	const handleActivateProfile = async (profile: Profile) => {
+		if (await sendJSON("POST", `/api/profiles/${profile.id}/activate`)) {
+			navigate({ to: "/", search: {} });
+		}
+	};
+
 	const handleDragEnd = async (event: DragEndEvent) => {
 		const { active, over } = event;
 
+		// Dropping a plugin on a folder moves it into the folder
+		const folder = folders.find((f) => folderSortId(f) === over?.id);
+		if (folder && typeof active.id === "number") {
+			setPluginsCopy(pluginsCopy.filter((plugin) => plugin.id !== active.id));
+			setPlugins([{ id: active.id, order_num: 999, page_id: folder.id }]);
+			return;
+		}
+		if (typeof active.id !== "number" || typeof over?.id !== "number") {
+			return;
+		}
+
 		if (over && active.id !== over.id) {
 			const oldIndex = pluginsCopy.findIndex((item) => item.id === active.id);
 			const newIndex = pluginsCopy.findIndex((item) => item.id === over.id);
@@ -148,6 +296,9 @@ function HomeComponent() {
 		});
 	};
 
+	const topLevelPages =
+		active?.pages.filter((page) => page.parent_id === null) ?? [];
+
 	const handleSave = async () => {
 		router.invalidate();
 	};
@@ -156,11 +307,45 @@ function HomeComponent() {
 		setPluginsCopy(plugins);
 	}, [plugins]);
 
+	if (!me) {
+		return (
+			<div className="container mx-auto p-6">
+				<h1 className="text-3xl font-bold mb-2">BunDeck</h1>
+				<p>
+					This device is not paired. Choose "Show QR Code" in the BunDeck tray
+					menu and scan the code to connect.
+				</p>
+			</div>
+		);
+	}
+
 	return (
 		<div className="container mx-auto p-6">
 			<div className="flex justify-between items-center mb-6">
 				<h1 className="text-3xl font-bold">BunDeck</h1>
 				<div className="flex flex-col gap-2 md:flex-row">
+					{profiles.length > 1 && (
+						<DropdownMenu>
+							<DropdownMenuTrigger asChild>
+								<Button variant="outline">
+									<UsersIcon />
+									{active?.profile.name}
+								</Button>
+							</DropdownMenuTrigger>
+							<DropdownMenuContent>
+								{profiles.map((profile) => (
+									<DropdownMenuItem
+										key={profile.id}
+										disabled={!isAdmin}
+										onClick={() => handleActivateProfile(profile)}
+									>
+										{profile.active ? <CheckIcon /> : <span className="w-4" />}
+										{profile.name}
+									</DropdownMenuItem>
+								))}
+							</DropdownMenuContent>
+						</DropdownMenu>
+					)}
 					<DropdownMenu>
 						<DropdownMenuTrigger asChild>
 							<Button variant="outline">
@@ -190,41 +375,148 @@ function HomeComponent() {
 								<FullscreenIcon />
 								Fullscreen
 							</DropdownMenuItem>
-							<DropdownMenuItem
-								onClick={() => {
-									// toggle edit mode
-									setIsEditMode(!isEditMode);
-								}}
-							>
-								<PencilIcon />
-								{isEditMode ? "Done" : "Edit"}
-							</DropdownMenuItem>
-							<DropdownMenuSub>
-								<DropdownMenuSubTrigger>
-									<Plug2Icon />
-									Plugins
-								</DropdownMenuSubTrigger>
-								<DropdownMenuSubContent>
-									<DropdownMenuItem onClick={() => setIsAddDialogOpen(true)}>
-										<PlugZap2Icon />
-										Pre-made Plugin
-									</DropdownMenuItem>
-									<DropdownMenuItem onClick={() => setIsAddDialogOpen(true)}>
-										<Blocks />
-										Custom Plugin
-									</DropdownMenuItem>
-								</DropdownMenuSubContent>
-							</DropdownMenuSub>
+							{isAdmin && (
+								<DropdownMenuItem
+									onClick={() => {
+										// toggle edit mode
+										setIsEditMode(!isEditMode);
+									}}
+								>
+									<PencilIcon />
+									{isEditMode ? "Done" : "Edit"}
+								</DropdownMenuItem>
+							)}
+							{isAdmin && (
+								<DropdownMenuSub>
+									<DropdownMenuSubTrigger>
+										<FolderPlusIcon />
+										Pages
+									</DropdownMenuSubTrigger>
+									<DropdownMenuSubContent>
+										<DropdownMenuItem onClick={() => handleCreatePage(false)}>
+											<FilePlusIcon />
+											New Page
+										</DropdownMenuItem>
+										<DropdownMenuItem onClick={() => handleCreatePage(true)}>
+											<FolderPlusIcon />
+											New Folder
+										</DropdownMenuItem>
+										<DropdownMenuItem onClick={handleCreateProfile}>
+											<UsersIcon />
+											New Profile
+										</DropdownMenuItem>
+									</DropdownMenuSubContent>
+								</DropdownMenuSub>
+							)}
+							{isAdmin && (
+								<DropdownMenuSub>
+									<DropdownMenuSubTrigger>
+										<ArchiveIcon />
+										Backup
+									</DropdownMenuSubTrigger>
+									<DropdownMenuSubContent>
+										<DropdownMenuItem asChild>
+											<a href="/api/export?format=zip" download>
+												<DownloadIcon />
+												Export Deck
+											</a>
+										</DropdownMenuItem>
+										{contents && (
+											<DropdownMenuItem asChild>
+												<a
+													href={`/api/export?format=zip&pages=${contents.page.id}`}
+													download
+												>
+													<DownloadIcon />
+													Export This Page
+												</a>
+											</DropdownMenuItem>
+										)}
+										<DropdownMenuItem onClick={() => setIsImportDialogOpen(true)}>
+											<UploadIcon />
+											Import
+										</DropdownMenuItem>
+									</DropdownMenuSubContent>
+								</DropdownMenuSub>
+							)}
+							{isAdmin && (
+								<DropdownMenuItem onClick={() => setIsDevicesDialogOpen(true)}>
+									<SmartphoneIcon />
+									Devices
+								</DropdownMenuItem>
+							)}
+							{isAdmin && (
+								<DropdownMenuSub>
+									<DropdownMenuSubTrigger>
+										<Plug2Icon />
+										Plugins
+									</DropdownMenuSubTrigger>
+									<DropdownMenuSubContent>
+										<DropdownMenuItem onClick={() => setIsAddDialogOpen(true)}>
+											<PlugZap2Icon />
+											Pre-made Plugin
+										</DropdownMenuItem>
+										<DropdownMenuItem onClick={() => setIsAddDialogOpen(true)}>
+											<Blocks />
+											Custom Plugin
+										</DropdownMenuItem>
+										<DropdownMenuItem onClick={() => setIsMacroDialogOpen(true)}>
+											<ListOrderedIcon />
+											Macro
+										</DropdownMenuItem>
+									</DropdownMenuSubContent>
+								</DropdownMenuSub>
+							)}
 						</DropdownMenuContent>
 					</DropdownMenu>
 				</div>
 			</div>
 
+			{(topLevelPages.length > 1 || (contents?.path.length ?? 0) > 1) && (
+				<nav className="flex flex-wrap items-center gap-2 mb-4">
+					{topLevelPages.map((page) => (
+						<Button
+							key={page.id}
+							variant={
+								contents?.path[0]?.id === page.id ? "default" : "outline"
+							}
+							size="sm"
+							onClick={() => openPage(page)}
+						>
+							{page.name}
+						</Button>
+					))}
+					{contents?.path.slice(1).map((page) => (
+						<Button
+							key={page.id}
+							variant="ghost"
+							size="sm"
+							onClick={() => openPage(page)}
+						>
+							/ {page.name}
+						</Button>
+					))}
+				</nav>
+			)}
+
 			{pluginsCopy &&
 				(isEditMode ? (
 					<DndContext sensors={sensors} onDragEnd={handleDragEnd}>
-						<SortableContext items={pluginsCopy} strategy={rectSortingStrategy}>
+						<SortableContext
+							items={[...folders.map(folderSortId), ...pluginsCopy]}
+							strategy={rectSortingStrategy}
+						>
 							<div className="grid grid-cols-1 md:grid-cols-2 lg:grid-cols-3 gap-4 auto-rows-fr">
+								{folders.map((folder) => (
+									<SortableFolderCard
+										key={folderSortId(folder)}
+										folder={folder}
+										onOpen={openPage}
+										onRename={handleRenameFolder}
+										onDelete={handleDeleteFolder}
+										isEditMode={isEditMode}
+									/>
+								))}
 								{pluginsCopy.map((plugin) => (
 									<SortablePluginCard
 										key={plugin.id}
@@ -239,6 +531,16 @@ function HomeComponent() {
 					</DndContext>
 				) : (
 					<div className="grid grid-cols-1 md:grid-cols-2 lg:grid-cols-3 gap-4 auto-rows-fr">
+						{folders.map((folder) => (
+							<SortableFolderCard
+								key={folderSortId(folder)}
+								folder={folder}
+								onOpen={openPage}
+								onRename={handleRenameFolder}
+								onDelete={handleDeleteFolder}
+								isEditMode={isEditMode}
+							/>
+						))}
 						{pluginsCopy.map((plugin) => (
 							<SortablePluginCard
 								key={plugin.id}
@@ -256,15 +558,35 @@ function HomeComponent() {
 				isOpen={isEditDialogOpen}
 				onOpenChange={setIsEditDialogOpen}
 				onSave={handleSave}
+				pageId={contents?.page.id}
 			/>
 
 			<AddPluginDialog
 				isOpen={isAddDialogOpen}
 				onOpenChange={setIsAddDialogOpen}
 				onSave={handleSave}
+				pageId={contents?.page.id}
+			/>
+
+			<MacroDialog
+				isOpen={isMacroDialogOpen}
+				onOpenChange={setIsMacroDialogOpen}
+				onSave={handleSave}
+				pageId={contents?.page.id}
+			/>
+
+			<DevicesDialog
+				isOpen={isDevicesDialogOpen}
+				onOpenChange={setIsDevicesDialogOpen}
+			/>
+
+			<ImportDialog
+				isOpen={isImportDialogOpen}
+				onOpenChange={setIsImportDialogOpen}
 			/>
 
 			<DeleteConfirmDialog />
+			<DeleteFolderConfirmDialog />
 		</div>
 	);
 }
@@ -0,0 +1,37 @@
+import { createFileRoute, redirect } from '@tanstack/react-router';
+
+export const Route = createFileRoute('/pair/$code')({
+  loader: async ({ params: { code } }) => {
+    const response = await fetch('/api/auth/pair', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ code, name: deviceName() }),
+    });
+    if (!response.ok) {
+      const { error } = (await response.json()) as { error: string };
+      return error;
+    }
+    // The device token is now stored in a cookie
+    throw redirect({ to: '/' });
+  },
+  component: RouteComponent,
+});
+
+function deviceName() {
+  const agent = navigator.userAgent;
+  const match = agent.match(/\(([^;)]+)/);
+  return match ? match[1] : 'Browser';
+}
+
+function RouteComponent() {
+  const error = Route.useLoaderData();
+  return (
+    <div className='flex flex-col justify-center items-center mt-4 w-full gap-2'>
+      <h2 className='text-3xl font-bold'>Pairing failed</h2>
+      <p>{error}</p>
+      <p className='text-sm text-muted-foreground'>
+        Scan a new QR code from the BunDeck tray menu.
+      </p>
+    </div>
+  );
+}
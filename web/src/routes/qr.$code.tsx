@@ -1,19 +1,60 @@
+import type { Pairing, Role } from '@/types/auth';
+import { useQuery } from '@tanstack/react-query';
 import { createFileRoute } from '@tanstack/react-router';
 import { toDataURL } from 'qrcode';
+import { useState } from 'react';
 
 export const Route = createFileRoute('/qr/$code')({
-  loader: ({ params: { code } }) => {
-    return toDataURL(code);
-  },
   component: RouteComponent,
 });
 
 function RouteComponent() {
-  const data = Route.useLoaderData();
+  const { code: baseUrl } = Route.useParams();
+  const [role, setRole] = useState<Role>('press');
+
+  // Each pairing code works once, so a new one is created for every role
+  const { data, refetch } = useQuery({
+    queryKey: ['pairing', role],
+    queryFn: async () => {
+      const response = await fetch('/api/auth/pairings', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ role }),
+      });
+      if (!response.ok) {
+        throw new Error('Failed to create pairing code');
+      }
+      const pairing = (await response.json()) as Pairing;
+      return {
+        image: await toDataURL(`${baseUrl}/pair/${pairing.code}`),
+        expiresAt: new Date(pairing.expires_at),
+      };
+    },
+    staleTime: Number.POSITIVE_INFINITY,
+  });
+
   return (
-    <div className='flex  flex-col justify-center items-center mt-4 w-full'>
+    <div className='flex  flex-col justify-center items-center mt-4 w-full gap-2'>
       <h2 className='text-3xl font-bold'>Scan QR code on your device</h2>
-      <img src={data} className='size-48' alt='qr-code' />
+      {data && <img src={data.image} className='size-48' alt='qr-code' />}
+      <label className='flex items-center gap-2 text-sm'>
+        Access
+        <select
+          className='h-9 rounded-md border border-input bg-transparent px-3 text-sm'
+          value={role}
+          onChange={(e) => setRole(e.target.value as Role)}
+        >
+          <option value='press'>Press buttons only</option>
+          <option value='admin'>Full access</option>
+        </select>
+      </label>
+      <p className='text-sm text-muted-foreground'>
+        The code can be used once
+        {data && ` and expires at ${data.expiresAt.toLocaleTimeString()}`}.{' '}
+        <button type='button' className='underline' onClick={() => refetch()}>
+          New code
+        </button>
+      </p>
     </div>
   );
 }
@@ -0,0 +1,21 @@
+export type Role = 'admin' | 'press';
+
+export interface Device {
+  id: number;
+  name: string;
+  role: Role;
+  created_at: string;
+  last_seen_at: string | null;
+}
+
+export interface Pairing {
+  code: string;
+  role: Role;
+  expires_at: string;
+}
+
+// CurrentDevice describes the caller, device is null on the host machine
+export interface CurrentDevice {
+  role: Role;
+  device: Device | null;
+}
@@ -0,0 +1,23 @@
+export type ImportMode = 'merge' | 'replace';
+
+export type ConflictResolution = 'skip' | 'rename';
+
+// ImportConflict is a bundled plugin whose name is already used on its page
+export interface ImportConflict {
+  plugin: string;
+  page: string;
+  resolution: ConflictResolution;
+  renamed_to?: string;
+}
+
+export interface ImportReport {
+  mode: ImportMode;
+  dry_run: boolean;
+  profiles_created: number;
+  pages_created: number;
+  plugins_created: number;
+  plugins_removed: number;
+  conflicts: ImportConflict[];
+  created: number[];
+  removed: number[];
+}
@@ -0,0 +1,29 @@
+export type MacroAction = 'run' | 'delay';
+
+// MacroCondition is checked against the exit code of the previous run step,
+// an empty condition always runs the step
+export type MacroCondition = '' | 'success' | 'failure' | 'exit_code';
+
+export interface MacroStep {
+  id?: number;
+  action: MacroAction;
+  target_plugin_id: number | null;
+  input?: unknown;
+  delay_ms: number;
+  condition: MacroCondition;
+  exit_code: number;
+  continue_on_failure: boolean;
+}
+
+// StepResult is kept in the run history of a macro
+export interface StepResult {
+  step: number;
+  action: MacroAction;
+  plugin_id?: number;
+  run_id?: number;
+  status: string;
+  exit_code?: number;
+  error?: string;
+  started_at: string;
+  finished_at: string;
+}
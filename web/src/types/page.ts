@@ -0,0 +1,32 @@
+import type { Plugin } from './plugin';
+
+export interface Profile {
+  id: number;
+  name: string;
+  active: boolean;
+  created_at: string;
+  updated_at: string;
+}
+
+// Page is a grid of buttons, pages with a parent are shown as folders
+export interface Page {
+  id: number;
+  profile_id: number;
+  parent_id: number | null;
+  name: string;
+  order_num: number;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface PageContents {
+  page: Page;
+  plugins: Plugin[];
+  folders: Page[];
+  path: Page[];
+}
+
+export interface ActiveProfile {
+  profile: Profile;
+  pages: Page[];
+}
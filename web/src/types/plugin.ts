@@ -1,12 +1,129 @@
 export interface Plugin {
   id: number;
   name: string;
+  // kind is macro for plugins running other plugins as steps instead of code
+  kind: PluginKind;
   code: string;
   image: string;
   image_type: string;
   order_num: number;
+  page_id: number;
   created_at: string;
   updated_at: string;
   run_continuously: boolean;
   interval_seconds: number;
+  // cron_expression replaces the interval when set
+  cron_expression: string;
+  time_zone: string;
+  missed_run_policy: MissedRunPolicy;
+  timeout_seconds: number;
+  concurrency_policy: ConcurrencyPolicy;
+  runtime: Runtime;
+  resident: boolean;
+  state: ButtonState | null;
+  // template_id is set for plugins created from a template
+  template_id: string | null;
+  template_version: number;
+  template_update_available: boolean;
+}
+
+export type PluginKind = 'script' | 'macro';
+
+export type ConcurrencyPolicy = 'parallel' | 'skip' | 'queue' | 'restart';
+
+export type Runtime =
+  | 'bun'
+  | 'node'
+  | 'deno'
+  | 'shell'
+  | 'python'
+  | 'executable';
+
+// RuntimeStatus describes a runtime and whether it is installed on the server
+export interface RuntimeStatus {
+  id: Runtime;
+  name: string;
+  extension: string;
+  // resident runtimes can keep a plugin running between presses
+  resident: boolean;
+  available: boolean;
+  path?: string;
+  version?: string;
+  error?: string;
+}
+
+export type MissedRunPolicy = 'skip' | 'run_once';
+
+// ButtonState is reported by plugins via ::bundeck:: output lines
+export interface ButtonState {
+  title?: string;
+  badge?: string;
+  color?: string;
+  toggle?: boolean;
+  image?: string;
+}
+
+export interface ScheduleStatus {
+  plugin_id: number;
+  running: boolean;
+  interval_seconds: number;
+  cron_expression?: string;
+  time_zone?: string;
+  missed_run_policy?: MissedRunPolicy;
+  missed_runs: number;
+  last_run: string | null;
+  next_run: string | null;
+  last_result: string;
+  last_error: string;
+}
+
+export interface RunEvent {
+  type: 'run-started' | 'output-line' | 'state' | 'run-finished';
+  plugin_id: number;
+  run_id: number;
+  trigger?: string;
+  stream?: 'stdout' | 'stderr';
+  line?: string;
+  status?: string;
+  exit_code?: number;
+  error?: string;
+  state?: ButtonState;
+  time: string;
+}
+
+// PluginRevision is a saved version of a plugin's code, name and settings
+export interface PluginRevision {
+  id: number;
+  plugin_id: number;
+  revision: number;
+  name: string;
+  code: string;
+  run_continuously: boolean;
+  interval_seconds: number;
+  cron_expression: string;
+  time_zone: string;
+  missed_run_policy: MissedRunPolicy;
+  timeout_seconds: number;
+  concurrency_policy: ConcurrencyPolicy;
+  runtime: Runtime;
+  resident: boolean;
+  restored_from: number | null;
+  created_at: string;
+}
+
+export interface RevisionDiff {
+  from: PluginRevision;
+  to: PluginRevision;
+  diff: string;
+  changes: { field: string; from: unknown; to: unknown }[];
+}
+
+// Preset is a named input a plugin can be run with
+export interface Preset {
+  id: number;
+  plugin_id: number;
+  name: string;
+  input: unknown;
+  created_at: string;
+  updated_at: string;
 }
@@ -0,0 +1,48 @@
+export type VariableValue =
+  | string
+  | number
+  | boolean
+  | string[]
+  | number[]
+  | boolean[];
+
+export interface Variable {
+  type: string;
+  default: VariableValue;
+  description: string;
+  label: string;
+  required?: boolean;
+  // min and max bound numbers and the length of strings
+  min?: number;
+  max?: number;
+  enum?: (string | number | boolean)[];
+  pattern?: string;
+  secret?: boolean;
+}
+
+export interface PluginTemplate {
+  id: string;
+  title: string;
+  description: string;
+  file: string;
+  category: string;
+  label: string;
+  version: number;
+  variables: Record<string, Variable>;
+  // source is the template directory the template was loaded from, or
+  // "builtin" for the templates shipped with BunDeck
+  source: string;
+  overrides?: string;
+}
+
+// PluginVariables are the template values of a plugin created from a
+// template
+export interface PluginVariables {
+  template_id: string;
+  template_version: number;
+  // variables are the values the code was rendered with, without secrets
+  variables: Record<string, VariableValue>;
+  // template is null when the template is no longer available
+  template: PluginTemplate | null;
+  update_available: boolean;
+}
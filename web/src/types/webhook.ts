@@ -0,0 +1,18 @@
+export type WebhookMode = 'sync' | 'async';
+
+export interface Webhook {
+  id: number;
+  plugin_id: number;
+  name: string;
+  mode: WebhookMode;
+  signed: boolean;
+  created_at: string;
+  last_used_at: string | null;
+}
+
+// WebhookCredentials are only returned when a webhook is created or rotated
+export interface WebhookCredentials extends Webhook {
+  token: string;
+  url: string;
+  signing_secret?: string;
+}